@@ -3,6 +3,7 @@ package suzu
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"time"
@@ -13,6 +14,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/transcribestreaming"
 	"github.com/aws/aws-sdk-go-v2/service/transcribestreaming/types"
 	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/logging"
 
 	zlog "github.com/rs/zerolog/log"
 )
@@ -32,10 +34,18 @@ type AmazonTranscribeV2 struct {
 }
 
 func NewAmazonTranscribeV2(c Config, languageCode string, sampleRateHertz, audioChannelCount int64) *AmazonTranscribeV2 {
+	mediaEncoding := types.MediaEncodingOggOpus
+	// PCM の場合は変換後のサンプリングレートとチャネル数を指定する
+	if c.AwsAudioFormat == audioFormatPCM {
+		mediaEncoding = types.MediaEncodingPcm
+		sampleRateHertz = int64(c.PCMSampleRate)
+		audioChannelCount = int64(c.PCMChannelCount)
+	}
+
 	return &AmazonTranscribeV2{
 		Region:                            c.AwsRegion,
 		LanguageCode:                      languageCode,
-		MediaEncoding:                     types.MediaEncodingOggOpus,
+		MediaEncoding:                     mediaEncoding,
 		MediaSampleRateHertz:              sampleRateHertz,
 		EnablePartialResultsStabilization: c.AwsEnablePartialResultsStabilization,
 		PartialResultsStability:           c.AwsPartialResultsStability,
@@ -67,6 +77,13 @@ func NewStartStreamTranscriptionInputV2(at *AmazonTranscribeV2) transcribestream
 		input.PartialResultsStability = types.PartialResultsStability(at.PartialResultsStability)
 	}
 
+	if at.Config.AwsContentRedactionType != "" {
+		input.ContentRedactionType = types.ContentRedactionType(at.Config.AwsContentRedactionType)
+		if at.Config.AwsPiiEntityTypes != "" {
+			input.PiiEntityTypes = &at.Config.AwsPiiEntityTypes
+		}
+	}
+
 	return input
 }
 
@@ -96,6 +113,14 @@ func NewAmazonTranscribeClientV2(c Config) (*transcribestreaming.Client, error)
 		config.WithClientLogMode(clientLogMode),
 	}
 
+	// デバッグログに含まれる変換結果にも個人情報のマスクを適用する
+	if c.Debug && c.Redactor != nil {
+		loadOptions = append(loadOptions, config.WithLogger(logging.LoggerFunc(func(classification logging.Classification, format string, v ...any) {
+			message, _ := c.Redactor.Redact(fmt.Sprintf(format, v...))
+			zlog.Debug().Str("classification", string(classification)).Msg(message)
+		})))
+	}
+
 	if c.AwsProfile != "" {
 		if c.AwsCredentialFile != "" {
 			loadOptions = append(loadOptions, config.WithSharedCredentialsFiles([]string{c.AwsCredentialFile}))
@@ -112,7 +137,7 @@ func NewAmazonTranscribeClientV2(c Config) (*transcribestreaming.Client, error)
 	return client, nil
 }
 
-func (at *AmazonTranscribeV2) Start(ctx context.Context, r io.ReadCloser, header soraHeader) (*transcribestreaming.StartStreamTranscriptionEventStream, error) {
+func (at *AmazonTranscribeV2) Start(ctx context.Context, r io.ReadCloser, header SoraHeader) (*transcribestreaming.StartStreamTranscriptionEventStream, error) {
 	config := at.Config
 
 	audioData, err := receiveFirstAudioData(r)
@@ -143,17 +168,32 @@ func (at *AmazonTranscribeV2) Start(ctx context.Context, r io.ReadCloser, header
 				retry = true
 			}
 
-			return nil, &SuzuError{
+			suzuErr := &SuzuError{
 				Code:    code,
 				Message: message,
 				Retry:   retry,
+				Err:     err,
+			}
+			var apiErr smithy.APIError
+			if errors.As(err, &apiErr) {
+				if errorCode, ok := awsErrorCode(apiErr.ErrorCode()); ok {
+					suzuErr.ErrorCode = errorCode
+				}
 			}
+			return nil, suzuErr
 		}
 
 		var oe *smithy.OperationError
 		if errors.As(err, &oe) {
 			// smithy.OperationError の場合は、リトライしない
-			return nil, NewSuzuConfError(oe)
+			confErr := NewSuzuConfError(oe)
+			var apiErr smithy.APIError
+			if errors.As(err, &apiErr) {
+				if errorCode, ok := awsErrorCode(apiErr.ErrorCode()); ok {
+					confErr.ErrorCode = errorCode
+				}
+			}
+			return nil, confErr
 		}
 
 		return nil, err
@@ -9,17 +9,11 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/transcribestreaming/types"
 	zlog "github.com/rs/zerolog/log"
 )
 
-func init() {
-	NewServiceHandlerFuncs.register("aws", NewAmazonTranscribeV2Handler)
-	// aws と awsv2 は同じハンドラを使用する
-	// awsv1 と明示的に区別するために awsv2 を追加したため、awsv1 廃止時に不要になったが、後方互換性のために残す
-	NewServiceHandlerFuncs.register("awsv2", NewAmazonTranscribeV2Handler)
-}
-
 type AmazonTranscribeV2Handler struct {
 	Config Config
 
@@ -29,12 +23,14 @@ type AmazonTranscribeV2Handler struct {
 	ChannelCount uint16
 	LanguageCode string
 	RetryCount   int
-	mu           sync.Mutex
+	// 外部サービス側のセッション ID
+	SessionID string
+	mu        sync.Mutex
 
-	OnResultFunc func(context.Context, io.WriteCloser, string, string, string, any) error
+	OnResultFunc OnResultFunc
 }
 
-func NewAmazonTranscribeV2Handler(config Config, channelID, connectionID string, sampleRate uint32, channelCount uint16, languageCode string, onResultFunc any) serviceHandlerInterface {
+func NewAmazonTranscribeV2Handler(config Config, channelID, connectionID string, sampleRate uint32, channelCount uint16, languageCode string, onResultFunc OnResultFunc) ServiceHandler {
 	return &AmazonTranscribeV2Handler{
 		Config:       config,
 		ChannelID:    channelID,
@@ -43,7 +39,7 @@ func NewAmazonTranscribeV2Handler(config Config, channelID, connectionID string,
 		ChannelCount: channelCount,
 		LanguageCode: languageCode,
 		RetryCount:   0,
-		OnResultFunc: onResultFunc.(func(context.Context, io.WriteCloser, string, string, string, any) error),
+		OnResultFunc: onResultFunc,
 	}
 }
 
@@ -100,6 +96,18 @@ func (h *AmazonTranscribeV2Handler) ResetRetryCount() int {
 	return h.RetryCount
 }
 
+func (h *AmazonTranscribeV2Handler) GetSessionID() string {
+	defer h.mu.Unlock()
+	h.mu.Lock()
+	return h.SessionID
+}
+
+func (h *AmazonTranscribeV2Handler) setSessionID(sessionID string) {
+	defer h.mu.Unlock()
+	h.mu.Lock()
+	h.SessionID = sessionID
+}
+
 func (h *AmazonTranscribeV2Handler) IsRetryTarget(args any) bool {
 	switch err := args.(type) {
 	case *types.LimitExceededException,
@@ -121,10 +129,10 @@ func (h *AmazonTranscribeV2Handler) IsRetryTarget(args any) bool {
 	return false
 }
 
-func (h *AmazonTranscribeV2Handler) Handle(ctx context.Context, opusCh chan opus, header soraHeader) (*io.PipeReader, error) {
+func (h *AmazonTranscribeV2Handler) Handle(ctx context.Context, opusCh chan Opus, header SoraHeader) (*io.PipeReader, error) {
 	at := NewAmazonTranscribeV2(h.Config, h.LanguageCode, int64(h.SampleRate), int64(h.ChannelCount))
 
-	packetReader, err := opus2ogg(ctx, opusCh, h.SampleRate, h.ChannelCount, h.Config, header)
+	packetReader, err := newAudioReader(ctx, opusCh, h.Config.AwsAudioFormat, h.SampleRate, h.ChannelCount, h.Config, header)
 	if err != nil {
 		return nil, err
 	}
@@ -136,6 +144,7 @@ func (h *AmazonTranscribeV2Handler) Handle(ctx context.Context, opusCh chan opus
 
 	// リクエストが成功した時点でリトライカウントをリセットする
 	h.ResetRetryCount()
+	h.setSessionID(at.SessionID)
 
 	r, w := io.Pipe()
 
@@ -143,6 +152,7 @@ func (h *AmazonTranscribeV2Handler) Handle(ctx context.Context, opusCh chan opus
 		defer stream.Close()
 
 		encoder := json.NewEncoder(w)
+		partialResults := newPartialResultTracker()
 
 	L:
 		for {
@@ -155,8 +165,8 @@ func (h *AmazonTranscribeV2Handler) Handle(ctx context.Context, opusCh chan opus
 				switch e := event.(type) {
 				case *types.TranscriptResultStreamMemberTranscriptEvent:
 					if h.OnResultFunc != nil {
-						if err := h.OnResultFunc(ctx, w, h.ChannelID, h.ConnectionID, h.LanguageCode, e.Value.Transcript.Results); err != nil {
-							if err := encoder.Encode(NewSuzuErrorResponse(err)); err != nil {
+						if err := h.OnResultFunc(ctx, w, h.ChannelID, h.ConnectionID, h.LanguageCode, ServiceResult{Provider: "aws", Aws: e.Value.Transcript.Results}); err != nil {
+							if err := encoder.Encode(newServiceErrorResponse(err, "aws", at.SessionID)); err != nil {
 								zlog.Error().
 									Err(err).
 									Str("channel_id", h.ChannelID).
@@ -181,10 +191,10 @@ func (h *AmazonTranscribeV2Handler) Handle(ctx context.Context, opusCh chan opus
 								result.WithIsPartial(res.IsPartial)
 							}
 							if at.Config.AwsResultChannelID {
-								result.WithChannelID(*res.ChannelId)
+								result.WithChannelID(aws.ToString(res.ChannelId))
 							}
 							if at.Config.AwsResultID {
-								result.WithResultID(*res.ResultId)
+								result.WithResultID(aws.ToString(res.ResultId))
 							}
 
 							for _, alt := range res.Alternatives {
@@ -193,11 +203,28 @@ func (h *AmazonTranscribeV2Handler) Handle(ctx context.Context, opusCh chan opus
 									continue
 								}
 
-								result.SetMessage(message)
+								// text_processor_rules_file で指定した加工を適用する
+								message, ok = processResultMessage(at.Config, message)
+								if !ok {
+									continue
+								}
+
+								// 個人情報をマスクする
+								message, redactions := at.Config.Redactor.Redact(message)
+								result.Redactions = append(awsPIIRedactionsV2(alt), redactions...)
+
+								// partial_result_mode が diff の場合は途中結果の変化した部分のみを送信する
+								// ResultId が無い場合は発話を識別できないため、途中結果の全体を送信する
+								if ok := partialResults.Apply(&result.TranscriptionResult, at.Config, aws.ToString(res.ChannelId), aws.ToString(res.ResultId), message, !res.IsPartial); !ok {
+									continue
+								}
+
 								if err := encoder.Encode(result); err != nil {
 									w.CloseWithError(err)
 									return
 								}
+								countResult("aws", h.LanguageCode, !res.IsPartial)
+								roomSpeakerFromContext(ctx).AddResult("aws", h.LanguageCode, result.Message, !res.IsPartial)
 							}
 						}
 					}
@@ -230,6 +257,18 @@ func (h *AmazonTranscribeV2Handler) Handle(ctx context.Context, opusCh chan opus
 	return r, nil
 }
 
+// Amazon Transcribe がマスクした個人情報の種類ごとの件数を返す
+func awsPIIRedactionsV2(alt types.Alternative) []Redaction {
+	var redactions []Redaction
+	for _, entity := range alt.Entities {
+		if entity.Category == nil || *entity.Category != "PII" || entity.Type == nil {
+			continue
+		}
+		redactions = appendRedaction(redactions, *entity.Type, 1)
+	}
+	return redactions
+}
+
 func contentFilterByTranscribedTimeV2(config Config, item types.Item) bool {
 	minimumTranscribedTime := config.MinimumTranscribedTime
 
@@ -796,7 +796,7 @@ func TestIsRetryTargetForAmazonTranscribeV2(t *testing.T) {
 	sampleRate := uint32(48000)
 	channelCount := uint16(2)
 	languageCode := "ja-JP"
-	onResultFunc := func(context.Context, io.WriteCloser, string, string, string, any) error { return nil }
+	onResultFunc := func(context.Context, io.WriteCloser, string, string, string, ServiceResult) error { return nil }
 
 	testCases := []struct {
 		Name         string
@@ -848,7 +848,7 @@ func TestIsRetryTargetForAmazonTranscribeV2(t *testing.T) {
 				RetryTargets: tc.RetryTargets,
 			}
 
-			serviceHandler, err := getServiceHandler("awsv2", config, channelID, connectionID, sampleRate, channelCount, languageCode, onResultFunc)
+			serviceHandler, err := getServiceHandler(NewDefaultServiceHandlers(), "awsv2", config, channelID, connectionID, sampleRate, channelCount, languageCode, onResultFunc)
 			assert.NoError(t, err)
 
 			assert.Equal(t, tc.Expect, serviceHandler.IsRetryTarget(tc.Error))
@@ -0,0 +1,221 @@
+package suzu
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	pionopus "github.com/pion/opus"
+)
+
+const (
+	// サービスに送信する音声の形式
+	audioFormatOgg = "ogg"
+	audioFormatPCM = "pcm"
+
+	// Opus のデコード時の最大のサンプリングレート
+	opusMaxSampleRate = 48000
+)
+
+var (
+	ErrUnsupportedAudioFormat = fmt.Errorf("UNSUPPORTED-AUDIO-FORMAT")
+)
+
+// aws_audio_format などの値を確認する
+func validateAudioFormat(format string) error {
+	switch format {
+	case audioFormatOgg, audioFormatPCM:
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrUnsupportedAudioFormat, format)
+}
+
+// サービスに送信する音声の形式に変換した音声を読み出す io.ReadCloser を返す
+// pcm の場合は pcm_sample_rate と pcm_channel_count に変換する
+func newAudioReader(ctx context.Context, opusCh chan Opus, format string, sampleRate uint32, channelCount uint16, c Config, header SoraHeader) (io.ReadCloser, error) {
+	switch format {
+	case audioFormatPCM:
+		return opus2pcm(ctx, opusCh, uint32(c.PCMSampleRate), uint16(c.PCMChannelCount))
+	case audioFormatOgg, "":
+		return opus2ogg(ctx, opusCh, sampleRate, channelCount)
+	}
+	return nil, NewSuzuConfError(fmt.Errorf("%w: %s", ErrUnsupportedAudioFormat, format))
+}
+
+// Opus のパケットを指定したサンプリングレートとチャネル数の 16 bit PCM に変換する
+// チャネル数の変換はデコード時に行う
+// Opus のデコーダが対応していないサンプリングレートの場合は 48kHz でデコードした後に変換する
+type audioProcessor struct {
+	decoder pionopus.Decoder
+	samples []int16
+
+	sampleRate   int
+	channelCount int
+	// nil の場合は変換しない
+	resampler *linearResampler
+
+	// LPCM の音声のサンプリングレートの変換に使用する
+	pcmSampleRate int
+	pcmResampler  *linearResampler
+	pcmSamples    []int16
+}
+
+func newAudioProcessor(sampleRate uint32, channelCount uint16) (*audioProcessor, error) {
+	decodeSampleRate := int(sampleRate)
+	var resampler *linearResampler
+	if !isOpusDecodeSampleRate(decodeSampleRate) {
+		decodeSampleRate = opusMaxSampleRate
+		resampler = newLinearResampler(opusMaxSampleRate, int(sampleRate), int(channelCount))
+	}
+
+	decoder, err := pionopus.NewDecoderWithOutput(decodeSampleRate, int(channelCount))
+	if err != nil {
+		return nil, err
+	}
+
+	return &audioProcessor{
+		decoder:      decoder,
+		samples:      make([]int16, maxOpusPacketSamples),
+		sampleRate:   int(sampleRate),
+		channelCount: int(channelCount),
+		resampler:    resampler,
+	}, nil
+}
+
+// Opus のデコーダが出力できるサンプリングレートかどうかを返す
+func isOpusDecodeSampleRate(sampleRate int) bool {
+	switch sampleRate {
+	case 8000, 12000, 16000, 24000, 48000:
+		return true
+	}
+	return false
+}
+
+// Opus のパケットをデコードして、インターリーブした PCM のサンプルを返す
+// 返り値は次の呼び出しまで有効
+func (p *audioProcessor) Process(payload []byte) ([]int16, error) {
+	n, err := p.decoder.DecodeToInt16(payload, p.samples)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := p.samples[:n*p.channelCount]
+	if p.resampler != nil {
+		samples = p.resampler.Resample(samples)
+	}
+	return samples, nil
+}
+
+// LPCM の音声のサンプリングレートとチャネル数を変換して返す
+// 返り値は次の呼び出しまで有効
+func (p *audioProcessor) ProcessPCM(frame *PCMFrame) []int16 {
+	samples := frame.Samples
+	if frame.ChannelCount != p.channelCount {
+		frames := len(samples) / frame.ChannelCount
+		p.pcmSamples = p.pcmSamples[:0]
+		for i := range frames {
+			if p.channelCount == 1 {
+				// ステレオからモノラルへは平均する
+				v := (int32(samples[i*2]) + int32(samples[i*2+1])) / 2
+				p.pcmSamples = append(p.pcmSamples, int16(v))
+			} else {
+				// モノラルからステレオへは複製する
+				p.pcmSamples = append(p.pcmSamples, samples[i], samples[i])
+			}
+		}
+		samples = p.pcmSamples
+	}
+
+	if frame.SampleRate == p.sampleRate {
+		return samples
+	}
+	if p.pcmResampler == nil || p.pcmSampleRate != frame.SampleRate {
+		p.pcmSampleRate = frame.SampleRate
+		p.pcmResampler = newLinearResampler(frame.SampleRate, p.sampleRate, p.channelCount)
+	}
+	return p.pcmResampler.Resample(samples)
+}
+
+// 線形補間でサンプリングレートを変換する
+// パケットの境界で音声が途切れないように、直前のパケットの最後のサンプルと位置を保持する
+type linearResampler struct {
+	// 出力の 1 サンプルあたりの入力のサンプル数
+	step         float64
+	channelCount int
+
+	// 次に出力するサンプルの入力上の位置、0 は直前のパケットの最後のサンプル
+	position float64
+	previous []int16
+	output   []int16
+}
+
+func newLinearResampler(inputSampleRate, outputSampleRate, channelCount int) *linearResampler {
+	return &linearResampler{
+		step:         float64(inputSampleRate) / float64(outputSampleRate),
+		channelCount: channelCount,
+		position:     1,
+		previous:     make([]int16, channelCount),
+	}
+}
+
+// 返り値は次の呼び出しまで有効
+func (r *linearResampler) Resample(input []int16) []int16 {
+	frames := len(input) / r.channelCount
+	if frames == 0 {
+		return nil
+	}
+
+	// 直前のパケットの最後のサンプルを 0、入力の i 番目のサンプルを i+1 とする
+	sample := func(i, channel int) float64 {
+		if i == 0 {
+			return float64(r.previous[channel])
+		}
+		return float64(input[(i-1)*r.channelCount+channel])
+	}
+
+	r.output = r.output[:0]
+	for ; r.position < float64(frames); r.position += r.step {
+		i := int(r.position)
+		f := r.position - float64(i)
+		for channel := range r.channelCount {
+			v := sample(i, channel)*(1-f) + sample(i+1, channel)*f
+			r.output = append(r.output, int16(v))
+		}
+	}
+
+	r.position -= float64(frames)
+	copy(r.previous, input[(frames-1)*r.channelCount:])
+	return r.output
+}
+
+// 16 bit リトルエンディアンの PCM に変換する
+func pcmBytes(samples []int16) []byte {
+	b := make([]byte, len(samples)*2)
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(b[i*2:], uint16(s))
+	}
+	return b
+}
+
+// ストリーミングで送信する 16 bit PCM の WAV ヘッダを返す
+// 長さが決まらないため、RIFF と data のサイズは 0 にする
+func wavHeader(sampleRate uint32, channelCount uint16) []byte {
+	const bitsPerSample = 16
+	blockAlign := channelCount * bitsPerSample / 8
+
+	b := make([]byte, 44)
+	copy(b[0:], "RIFF")
+	copy(b[8:], "WAVE")
+	copy(b[12:], "fmt ")
+	binary.LittleEndian.PutUint32(b[16:], 16)
+	// PCM
+	binary.LittleEndian.PutUint16(b[20:], 1)
+	binary.LittleEndian.PutUint16(b[22:], channelCount)
+	binary.LittleEndian.PutUint32(b[24:], sampleRate)
+	binary.LittleEndian.PutUint32(b[28:], sampleRate*uint32(blockAlign))
+	binary.LittleEndian.PutUint16(b[32:], blockAlign)
+	binary.LittleEndian.PutUint16(b[34:], bitsPerSample)
+	copy(b[36:], "data")
+	return b
+}
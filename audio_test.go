@@ -0,0 +1,148 @@
+package suzu
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+
+	speechpb "cloud.google.com/go/speech/apiv1/speechpb"
+	"github.com/aws/aws-sdk-go-v2/service/transcribestreaming/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinearResampler(t *testing.T) {
+	t.Run("downsample", func(t *testing.T) {
+		r := newLinearResampler(2, 1, 1)
+		assert.Equal(t, []int16{10, 30}, r.Resample([]int16{10, 20, 30, 40}))
+		// パケットの境界をまたいで位置を引き継ぐ
+		assert.Equal(t, []int16{50, 70}, r.Resample([]int16{50, 60, 70, 80}))
+	})
+
+	t.Run("upsample", func(t *testing.T) {
+		r := newLinearResampler(1, 2, 1)
+		assert.Equal(t, []int16{0, 5}, r.Resample([]int16{0, 10}))
+		// 直前のパケットの最後のサンプルとの間を補間する
+		assert.Equal(t, []int16{10, 15}, r.Resample([]int16{20}))
+	})
+
+	t.Run("stereo", func(t *testing.T) {
+		r := newLinearResampler(2, 1, 2)
+		assert.Equal(t, []int16{10, -10, 30, -30}, r.Resample([]int16{10, -10, 20, -20, 30, -30, 40, -40}))
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		r := newLinearResampler(48000, 44100, 1)
+		assert.Empty(t, r.Resample(nil))
+	})
+}
+
+func TestAudioProcessor(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		SampleRate   uint32
+		ChannelCount uint16
+		// 20ms のパケットを 50 個デコードした場合のサンプル数
+		Samples int
+	}{
+		{Name: "16kHz mono", SampleRate: 16000, ChannelCount: 1, Samples: 16000},
+		{Name: "48kHz stereo", SampleRate: 48000, ChannelCount: 2, Samples: 96000},
+		{Name: "44.1kHz mono", SampleRate: 44100, ChannelCount: 1, Samples: 44100},
+		{Name: "22.05kHz stereo", SampleRate: 22050, ChannelCount: 2, Samples: 44100},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			p, err := newAudioProcessor(tc.SampleRate, tc.ChannelCount)
+			require.NoError(t, err)
+
+			total := 0
+			for range 50 {
+				samples, err := p.Process(silentPacket())
+				require.NoError(t, err)
+				total += len(samples)
+			}
+			// 線形補間の位置の端数により 1 フレームずれる場合がある
+			assert.InDelta(t, tc.Samples, total, float64(tc.ChannelCount))
+		})
+	}
+}
+
+func TestWavHeader(t *testing.T) {
+	b := wavHeader(16000, 2)
+	require.Len(t, b, 44)
+	assert.Equal(t, "RIFF", string(b[0:4]))
+	assert.Equal(t, "WAVE", string(b[8:12]))
+	assert.Equal(t, "fmt ", string(b[12:16]))
+	assert.Equal(t, uint16(1), binary.LittleEndian.Uint16(b[20:]))
+	assert.Equal(t, uint16(2), binary.LittleEndian.Uint16(b[22:]))
+	assert.Equal(t, uint32(16000), binary.LittleEndian.Uint32(b[24:]))
+	assert.Equal(t, uint32(64000), binary.LittleEndian.Uint32(b[28:]))
+	assert.Equal(t, uint16(4), binary.LittleEndian.Uint16(b[32:]))
+	assert.Equal(t, uint16(16), binary.LittleEndian.Uint16(b[34:]))
+	assert.Equal(t, "data", string(b[36:40]))
+}
+
+func TestNewAudioReader(t *testing.T) {
+	config := Config{PCMSampleRate: 22050, PCMChannelCount: 2}
+
+	t.Run("pcm", func(t *testing.T) {
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(t.Context(), opusCh, 10)
+
+		r, err := newAudioReader(t.Context(), opusCh, audioFormatPCM, 48000, 1, config, SoraHeader{})
+		require.NoError(t, err)
+		defer r.Close()
+
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		// 200ms の 22.05kHz ステレオ 16 bit
+		assert.InDelta(t, 4410*2*2, len(b), 4)
+	})
+
+	t.Run("ogg", func(t *testing.T) {
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(t.Context(), opusCh, 10)
+
+		r, err := newAudioReader(t.Context(), opusCh, audioFormatOgg, 48000, 1, config, SoraHeader{})
+		require.NoError(t, err)
+		defer r.Close()
+
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, "OggS", string(b[:4]))
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := newAudioReader(t.Context(), make(chan Opus), "mp3", 48000, 1, config, SoraHeader{})
+		var suzuConfErr *SuzuConfError
+		if assert.ErrorAs(t, err, &suzuConfErr) {
+			assert.Contains(t, suzuConfErr.Error(), ErrUnsupportedAudioFormat.Error())
+		}
+	})
+}
+
+func TestProviderAudioFormat(t *testing.T) {
+	config := Config{
+		AwsAudioFormat:  audioFormatPCM,
+		GcpAudioFormat:  audioFormatPCM,
+		PCMSampleRate:   16000,
+		PCMChannelCount: 1,
+	}
+
+	at := NewAmazonTranscribeV2(config, "ja-JP", 48000, 2)
+	assert.Equal(t, types.MediaEncodingPcm, at.MediaEncoding)
+	assert.Equal(t, int64(16000), at.MediaSampleRateHertz)
+	assert.Equal(t, int64(1), at.NumberOfChannels)
+
+	rc := NewRecognitionConfig(config, "ja-JP", 48000, 2)
+	assert.Equal(t, speechpb.RecognitionConfig_LINEAR16, rc.Encoding)
+	assert.Equal(t, int32(16000), rc.SampleRateHertz)
+	assert.Equal(t, int32(1), rc.AudioChannelCount)
+
+	// 指定しない場合は Ogg で送信する
+	at = NewAmazonTranscribeV2(Config{}, "ja-JP", 48000, 2)
+	assert.Equal(t, types.MediaEncodingOggOpus, at.MediaEncoding)
+	rc = NewRecognitionConfig(Config{}, "ja-JP", 48000, 2)
+	assert.Equal(t, speechpb.RecognitionConfig_OGG_OPUS, rc.Encoding)
+}
@@ -0,0 +1,368 @@
+package suzu
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	zlog "github.com/rs/zerolog/log"
+)
+
+const (
+	// Speech SDK の WebSocket プロトコルで利用するパス
+	azurePathSpeechConfig     = "speech.config"
+	azurePathAudio            = "audio"
+	azurePathTurnStart        = "turn.start"
+	azurePathTurnEnd          = "turn.end"
+	azurePathSpeechHypothesis = "speech.hypothesis"
+	azurePathSpeechPhrase     = "speech.phrase"
+
+	azureAudioContentType    = "audio/ogg"
+	azureAudioContentTypeWav = "audio/x-wav"
+
+	azureRecognitionStatusSuccess = "Success"
+
+	azureEndpointFormat = "wss://%s.stt.speech.microsoft.com/speech/recognition/conversation/cognitiveservices/v1"
+)
+
+var (
+	ErrAzureUnsupportedLanguageCode = fmt.Errorf("AZURE-UNSUPPORTED-LANGUAGE-CODE")
+	ErrAzureInvalidMessage          = fmt.Errorf("AZURE-INVALID-MESSAGE")
+)
+
+type AzureSpeech struct {
+	Endpoint        string
+	Region          string
+	SubscriptionKey string
+	LanguageCode    string
+	Profanity       string
+	ConnectionID    string
+	Config          Config
+}
+
+func NewAzureSpeech(c Config, languageCode string) *AzureSpeech {
+	return &AzureSpeech{
+		Endpoint:        c.AzureEndpoint,
+		Region:          c.AzureRegion,
+		SubscriptionKey: c.AzureSubscriptionKey,
+		LanguageCode:    languageCode,
+		Profanity:       c.AzureProfanity,
+		Config:          c,
+	}
+}
+
+// Azure から受信するメッセージ
+type AzureSpeechMessage struct {
+	Path      string
+	RequestID string
+	Body      []byte
+}
+
+// speech.hypothesis と speech.phrase の JSON
+type AzureSpeechResult struct {
+	RecognitionStatus string `json:"RecognitionStatus,omitempty"`
+	Text              string `json:"Text,omitempty"`
+	DisplayText       string `json:"DisplayText,omitempty"`
+	Offset            int64  `json:"Offset"`
+	Duration          int64  `json:"Duration"`
+}
+
+// 接続先の URL を生成する
+// azure_endpoint が指定されている場合は azure_region よりも優先する
+func (az *AzureSpeech) URL() (string, error) {
+	endpoint := az.Endpoint
+	if endpoint == "" {
+		if az.Region == "" {
+			return "", fmt.Errorf("azure_region or azure_endpoint is required")
+		}
+		endpoint = fmt.Sprintf(azureEndpointFormat, az.Region)
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	q := u.Query()
+	q.Set("language", az.LanguageCode)
+	q.Set("format", "simple")
+	if az.Profanity != "" {
+		q.Set("profanity", az.Profanity)
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+func (az *AzureSpeech) Start(ctx context.Context, r io.ReadCloser, header SoraHeader) (*AzureSpeechConn, error) {
+	if !az.isSupportedLanguageCode() {
+		return nil, NewSuzuConfError(fmt.Errorf("%w: %s", ErrAzureUnsupportedLanguageCode, az.LanguageCode))
+	}
+
+	audioData, err := receiveFirstAudioData(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// PCM の場合は最初の音声データに WAV ヘッダを付与する
+	contentType := azureAudioContentType
+	if az.Config.AzureAudioFormat == audioFormatPCM {
+		contentType = azureAudioContentTypeWav
+		audioData = append(wavHeader(uint32(az.Config.PCMSampleRate), uint16(az.Config.PCMChannelCount)), audioData...)
+	}
+
+	zlog.Info().
+		Str("channel_id", header.SoraChannelID).
+		Str("connection_id", header.SoraConnectionID).
+		Msg("Starting Azure Speech stream")
+
+	u, err := az.URL()
+	if err != nil {
+		return nil, NewSuzuConfError(err)
+	}
+
+	az.ConnectionID = newAzureID()
+
+	h := http.Header{}
+	h.Set("Ocp-Apim-Subscription-Key", az.SubscriptionKey)
+	h.Set("X-ConnectionId", az.ConnectionID)
+
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, u, h)
+	if err != nil {
+		if resp != nil {
+			code := resp.StatusCode
+
+			var retry bool
+			if code == http.StatusTooManyRequests {
+				retry = true
+			}
+
+			return nil, &SuzuError{
+				Code:    code,
+				Message: err.Error(),
+				Retry:   retry,
+			}
+		}
+		return nil, err
+	}
+
+	zlog.Info().
+		Str("channel_id", header.SoraChannelID).
+		Str("connection_id", header.SoraConnectionID).
+		Str("azure_connection_id", az.ConnectionID).
+		Msg("Started Azure Speech stream")
+
+	c := &AzureSpeechConn{
+		conn:        conn,
+		requestID:   newAzureID(),
+		contentType: contentType,
+	}
+
+	// コンテキストが閉じられたときに WebSocket を閉じる
+	closeOnDone(ctx, c)
+
+	if err := c.writeSpeechConfig(az.Config); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	// サーバに接続したので、音声データを送信する
+	if err := c.writeAudio(audioData); err != nil {
+		r.Close()
+		c.Close()
+		return nil, err
+	}
+
+	go func() {
+		defer r.Close()
+
+		frame := make([]byte, FrameSize)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+
+			n, err := r.Read(frame)
+			if err != nil {
+				if !errors.Is(err, io.EOF) && !errors.Is(err, context.Canceled) {
+					zlog.Error().Err(err).Str("azure_connection_id", az.ConnectionID).Send()
+				}
+
+				// 音声の終了を通知する
+				if err := c.writeAudio(nil); err != nil {
+					zlog.Error().Err(err).Str("azure_connection_id", az.ConnectionID).Send()
+				}
+				return
+			}
+			if n > 0 {
+				if err := c.writeAudio(frame[:n]); err != nil {
+					zlog.Error().Err(err).Str("azure_connection_id", az.ConnectionID).Send()
+					return
+				}
+			}
+		}
+	}()
+
+	return c, nil
+}
+
+func (az *AzureSpeech) isSupportedLanguageCode() bool {
+	languageCodes := az.Config.AzureLanguageCodes
+
+	// azure_language_codes が設定されていない場合は全ての言語コードを許可する
+	if len(languageCodes) == 0 {
+		return true
+	}
+
+	for _, lc := range languageCodes {
+		if strings.EqualFold(lc, az.LanguageCode) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Azure Speech の WebSocket 接続
+type AzureSpeechConn struct {
+	conn      *websocket.Conn
+	requestID string
+	// 最初の音声データに付与する Content-Type
+	contentType string
+
+	// 音声データの送信は goroutine から行うため、書き込みを排他制御する
+	mu             sync.Mutex
+	sentFirstAudio bool
+}
+
+func (c *AzureSpeechConn) Close() error {
+	return c.conn.Close()
+}
+
+// メッセージを 1 つ受信する
+func (c *AzureSpeechConn) Recv() (*AzureSpeechMessage, error) {
+	for {
+		messageType, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		// Azure からのテキストメッセージ以外は無視する
+		if messageType != websocket.TextMessage {
+			continue
+		}
+
+		return parseAzureSpeechMessage(data)
+	}
+}
+
+func (c *AzureSpeechConn) writeSpeechConfig(config Config) error {
+	body, err := json.Marshal(map[string]any{
+		"context": map[string]any{
+			"system": map[string]string{
+				"name":    "suzu",
+				"version": strings.TrimSpace(config.Version),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	headers := azureHeaders(azurePathSpeechConfig, "", "application/json; charset=utf-8")
+	message := append([]byte(headers+"\r\n"), body...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.conn.WriteMessage(websocket.TextMessage, message)
+}
+
+// 音声データを送信する
+// payload が空の場合は音声の終了を意味する
+func (c *AzureSpeechConn) writeAudio(payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Content-Type は最初の音声データにのみ付与する
+	var contentType string
+	if !c.sentFirstAudio {
+		contentType = c.contentType
+		c.sentFirstAudio = true
+	}
+
+	headers := azureHeaders(azurePathAudio, c.requestID, contentType)
+
+	// header length(16), header, payload
+	message := make([]byte, 2+len(headers)+len(payload))
+	binary.BigEndian.PutUint16(message[:2], uint16(len(headers)))
+	copy(message[2:], headers)
+	copy(message[2+len(headers):], payload)
+
+	return c.conn.WriteMessage(websocket.BinaryMessage, message)
+}
+
+func azureHeaders(path, requestID, contentType string) string {
+	var sb strings.Builder
+	sb.WriteString("Path: " + path + "\r\n")
+	if requestID != "" {
+		sb.WriteString("X-RequestId: " + requestID + "\r\n")
+	}
+	sb.WriteString("X-Timestamp: " + time.Now().UTC().Format("2006-01-02T15:04:05.000Z") + "\r\n")
+	if contentType != "" {
+		sb.WriteString("Content-Type: " + contentType + "\r\n")
+	}
+	return sb.String()
+}
+
+// ヘッダとボディが空行で区切られたテキストメッセージを解析する
+func parseAzureSpeechMessage(data []byte) (*AzureSpeechMessage, error) {
+	headers, body, found := bytes.Cut(data, []byte("\r\n\r\n"))
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrAzureInvalidMessage, string(data))
+	}
+
+	message := &AzureSpeechMessage{
+		Body: body,
+	}
+	for _, line := range strings.Split(string(headers), "\r\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "path":
+			message.Path = strings.TrimSpace(value)
+		case "x-requestid":
+			message.RequestID = strings.TrimSpace(value)
+		}
+	}
+
+	if message.Path == "" {
+		return nil, fmt.Errorf("%w: %s", ErrAzureInvalidMessage, string(data))
+	}
+
+	return message, nil
+}
+
+// X-ConnectionId と X-RequestId に使用する、ハイフン無しの UUID 形式の ID を生成する
+func newAzureID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,278 @@
+package suzu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	zlog "github.com/rs/zerolog/log"
+)
+
+type AzureSpeechHandler struct {
+	Config Config
+
+	ChannelID    string
+	ConnectionID string
+	SampleRate   uint32
+	ChannelCount uint16
+	LanguageCode string
+	RetryCount   int
+	// 外部サービス側のセッション ID
+	SessionID string
+	mu        sync.Mutex
+
+	OnResultFunc OnResultFunc
+}
+
+func NewAzureSpeechHandler(config Config, channelID, connectionID string, sampleRate uint32, channelCount uint16, languageCode string, onResultFunc OnResultFunc) ServiceHandler {
+	return &AzureSpeechHandler{
+		Config:       config,
+		ChannelID:    channelID,
+		ConnectionID: connectionID,
+		SampleRate:   sampleRate,
+		ChannelCount: channelCount,
+		LanguageCode: languageCode,
+		OnResultFunc: onResultFunc,
+	}
+}
+
+type AzureResult struct {
+	IsFinal  *bool   `json:"is_final,omitempty"`
+	ResultID *string `json:"result_id,omitempty"`
+	TranscriptionResult
+}
+
+func NewAzureResult() AzureResult {
+	return AzureResult{
+		TranscriptionResult: TranscriptionResult{
+			Type: "azure",
+		},
+	}
+}
+
+func (ar *AzureResult) WithIsFinal(isFinal bool) *AzureResult {
+	ar.IsFinal = &isFinal
+	return ar
+}
+
+func (ar *AzureResult) WithResultID(resultID string) *AzureResult {
+	ar.ResultID = &resultID
+	return ar
+}
+
+func (ar *AzureResult) SetMessage(message string) *AzureResult {
+	ar.Message = message
+	return ar
+}
+
+func (h *AzureSpeechHandler) UpdateRetryCount() int {
+	defer h.mu.Unlock()
+	h.mu.Lock()
+	h.RetryCount++
+	return h.RetryCount
+}
+
+func (h *AzureSpeechHandler) GetRetryCount() int {
+	return h.RetryCount
+}
+
+func (h *AzureSpeechHandler) ResetRetryCount() int {
+	defer h.mu.Unlock()
+	h.mu.Lock()
+	h.RetryCount = 0
+	return h.RetryCount
+}
+
+func (h *AzureSpeechHandler) GetSessionID() string {
+	defer h.mu.Unlock()
+	h.mu.Lock()
+	return h.SessionID
+}
+
+func (h *AzureSpeechHandler) setSessionID(sessionID string) {
+	defer h.mu.Unlock()
+	h.mu.Lock()
+	h.SessionID = sessionID
+}
+
+func (h *AzureSpeechHandler) IsRetryTarget(args any) bool {
+	switch err := args.(type) {
+	case error:
+		// サーバ側の都合で切断された場合は再接続を試みる
+		if websocket.IsCloseError(err,
+			websocket.CloseAbnormalClosure,
+			websocket.CloseInternalServerErr,
+			websocket.CloseServiceRestart,
+			websocket.CloseTryAgainLater) {
+			return true
+		}
+
+		// retry_targets に設定されているエラーの場合はリトライする
+		if isRetryTargetByConfig(h.Config, err.Error()) {
+			return true
+		}
+	default:
+	}
+
+	return false
+}
+
+func (h *AzureSpeechHandler) Handle(ctx context.Context, opusCh chan Opus, header SoraHeader) (*io.PipeReader, error) {
+	az := NewAzureSpeech(h.Config, h.LanguageCode)
+
+	packetReader, err := newAudioReader(ctx, opusCh, h.Config.AzureAudioFormat, h.SampleRate, h.ChannelCount, h.Config, header)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := az.Start(ctx, packetReader, header)
+	if err != nil {
+		return nil, err
+	}
+
+	// リクエストが成功した時点でリトライカウントをリセットする
+	h.ResetRetryCount()
+	h.setSessionID(az.ConnectionID)
+
+	r, w := io.Pipe()
+
+	go func() {
+		defer conn.Close()
+
+		encoder := json.NewEncoder(w)
+
+		for {
+			message, err := conn.Recv()
+			if err != nil {
+				// context がキャンセルされた場合は終了
+				if ctx.Err() != nil {
+					w.Close()
+					return
+				}
+
+				// turn.end を受信する前に正常に切断された場合も終了
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+					w.Close()
+					return
+				}
+
+				zlog.Error().
+					Err(err).
+					Str("channel_id", h.ChannelID).
+					Str("connection_id", h.ConnectionID).
+					Str("azure_connection_id", az.ConnectionID).
+					Int("retry_count", h.GetRetryCount()).
+					Send()
+
+				if ok := h.IsRetryTarget(err); ok {
+					errWithConnectionID := fmt.Errorf("%w (azure_connection_id: %s)", err, az.ConnectionID)
+					err = errors.Join(errWithConnectionID, ErrServerDisconnected)
+				}
+
+				w.CloseWithError(err)
+				return
+			}
+
+			var isFinal bool
+			switch message.Path {
+			case azurePathSpeechHypothesis:
+				isFinal = false
+			case azurePathSpeechPhrase:
+				isFinal = true
+			case azurePathTurnEnd:
+				// 音声の終了を通知した後のターンの終了
+				w.Close()
+				return
+			default:
+				// turn.start, speech.startDetected, speech.endDetected などは結果を含まないため無視する
+				continue
+			}
+
+			var res AzureSpeechResult
+			if err := json.Unmarshal(message.Body, &res); err != nil {
+				zlog.Error().
+					Err(err).
+					Str("channel_id", h.ChannelID).
+					Str("connection_id", h.ConnectionID).
+					Str("azure_connection_id", az.ConnectionID).
+					Send()
+				w.CloseWithError(err)
+				return
+			}
+
+			if h.OnResultFunc != nil {
+				if err := h.OnResultFunc(ctx, w, h.ChannelID, h.ConnectionID, h.LanguageCode, ServiceResult{Provider: "azure", Azure: &res}); err != nil {
+					if err := encoder.Encode(newServiceErrorResponse(err, "azure", az.ConnectionID)); err != nil {
+						zlog.Error().
+							Err(err).
+							Str("channel_id", h.ChannelID).
+							Str("connection_id", h.ConnectionID).
+							Str("azure_connection_id", az.ConnectionID).
+							Send()
+					}
+					w.CloseWithError(err)
+					return
+				}
+				continue
+			}
+
+			if az.Config.FinalResultOnly {
+				// speech.hypothesis の場合は結果を返さない
+				if !isFinal {
+					continue
+				}
+			}
+
+			text, ok := buildAzureMessage(res, isFinal)
+			if !ok {
+				continue
+			}
+			// text_processor_rules_file で指定した加工を適用する
+			text, ok = processResultMessage(az.Config, text)
+			if !ok {
+				continue
+			}
+
+			result := NewAzureResult()
+			if az.Config.AzureResultIsFinal {
+				result.WithIsFinal(isFinal)
+			}
+			if az.Config.AzureResultID {
+				// 同じ発話の結果は同じ Offset になるため、Offset を結果の識別子として使用する
+				result.WithResultID(strconv.FormatInt(res.Offset, 10))
+			}
+			// 個人情報をマスクする
+			text, redactions := az.Config.Redactor.Redact(text)
+			result.Redactions = redactions
+			result.SetMessage(text)
+
+			if err := encoder.Encode(result); err != nil {
+				w.CloseWithError(err)
+				return
+			}
+			countResult("azure", h.LanguageCode, isFinal)
+			roomSpeakerFromContext(ctx).AddResult("azure", h.LanguageCode, result.Message, isFinal)
+		}
+	}()
+
+	return r, nil
+}
+
+// 結果のメッセージを組み立てる
+// speech.phrase は Success 以外（NoMatch や InitialSilenceTimeout など）の場合は結果を返さない
+func buildAzureMessage(res AzureSpeechResult, isFinal bool) (string, bool) {
+	if !isFinal {
+		return res.Text, res.Text != ""
+	}
+
+	if res.RecognitionStatus != azureRecognitionStatusSuccess {
+		return "", false
+	}
+
+	return res.DisplayText, res.DisplayText != ""
+}
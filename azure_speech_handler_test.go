@@ -0,0 +1,432 @@
+package suzu
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type azureFakeServerOption struct {
+	// 音声データの受信後に返すメッセージ
+	Messages []string
+	// 音声データの受信後に指定したコードで切断する
+	CloseCode int
+	// 受信する音声の形式、空の場合は ogg
+	AudioFormat string
+}
+
+func azureFakeMessage(path, body string) string {
+	return fmt.Sprintf("X-RequestId: 0123456789abcdef\r\nPath: %s\r\nContent-Type: application/json; charset=utf-8\r\n\r\n%s", path, body)
+}
+
+// Speech SDK の WebSocket プロトコルを模したテスト用サーバ
+func newAzureFakeServer(t *testing.T, opt azureFakeServerOption) *httptest.Server {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Ocp-Apim-Subscription-Key") != "test-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("language") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		// speech.config
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		assert.Equal(t, websocket.TextMessage, messageType)
+		assert.Contains(t, string(data), "Path: speech.config\r\n")
+
+		sent := false
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if !assert.Equal(t, websocket.BinaryMessage, messageType) {
+				return
+			}
+
+			headerLength := int(binary.BigEndian.Uint16(data[:2]))
+			headers := string(data[2 : 2+headerLength])
+			assert.Contains(t, headers, "Path: audio\r\n")
+			payload := data[2+headerLength:]
+
+			if !sent {
+				if opt.AudioFormat == audioFormatPCM {
+					assert.Contains(t, headers, "Content-Type: audio/x-wav\r\n")
+					// WAV ヘッダから始まる
+					assert.Equal(t, "RIFF", string(payload[:4]))
+				} else {
+					assert.Contains(t, headers, "Content-Type: audio/ogg\r\n")
+					// Ogg ヘッダから始まる
+					assert.Equal(t, "OggS", string(payload[:4]))
+				}
+
+				if opt.CloseCode != 0 {
+					conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(opt.CloseCode, "closed by test"))
+					return
+				}
+
+				for _, m := range opt.Messages {
+					if err := conn.WriteMessage(websocket.TextMessage, []byte(m)); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+				sent = true
+			}
+
+			// 音声の終了
+			if len(payload) == 0 {
+				conn.WriteMessage(websocket.TextMessage, []byte(azureFakeMessage("turn.end", "{}")))
+				return
+			}
+		}
+	}))
+}
+
+func azureEndpoint(s *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+func sendTestOpusPackets(ctx context.Context, opusCh chan Opus, count int) {
+	defer close(opusCh)
+	for range count {
+		select {
+		case <-ctx.Done():
+			return
+		case opusCh <- Opus{Payload: []byte{252, 255, 254}}:
+		}
+	}
+}
+
+func TestAzureSpeechHandler(t *testing.T) {
+	channelID := "test-channel-id"
+	connectionID := "test-connection-id"
+	sampleRate := uint32(48000)
+	channelCount := uint16(1)
+	languageCode := "ja-JP"
+	header := SoraHeader{
+		SoraChannelID:    channelID,
+		SoraConnectionID: connectionID,
+	}
+	var noResultFunc func(context.Context, io.WriteCloser, string, string, string, ServiceResult) error
+
+	messages := []string{
+		azureFakeMessage("turn.start", `{"context":{"serviceTag":"test"}}`),
+		azureFakeMessage("speech.startDetected", `{"Offset":100}`),
+		azureFakeMessage("speech.hypothesis", `{"Text":"こんにちは","Offset":100,"Duration":500}`),
+		azureFakeMessage("speech.phrase", `{"RecognitionStatus":"Success","DisplayText":"こんにちは。","Offset":100,"Duration":800}`),
+		azureFakeMessage("speech.phrase", `{"RecognitionStatus":"InitialSilenceTimeout","Offset":900,"Duration":0}`),
+		azureFakeMessage("speech.endDetected", `{"Offset":900}`),
+	}
+
+	t.Run("success", func(t *testing.T) {
+		s := newAzureFakeServer(t, azureFakeServerOption{Messages: messages})
+		defer s.Close()
+
+		config := Config{
+			AzureEndpoint:        azureEndpoint(s),
+			AzureSubscriptionKey: "test-key",
+			AzureResultIsFinal:   true,
+			AzureResultID:        true,
+		}
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 10)
+
+		h := NewAzureSpeechHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		r, err := h.Handle(ctx, opusCh, header)
+		require.NoError(t, err)
+		defer r.Close()
+
+		results := []AzureResult{}
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			var result AzureResult
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &result))
+			results = append(results, result)
+		}
+		require.NoError(t, scanner.Err())
+
+		if assert.Len(t, results, 2) {
+			assert.Equal(t, "azure", results[0].Type)
+			assert.Equal(t, "こんにちは", results[0].Message)
+			assert.False(t, *results[0].IsFinal)
+			assert.Equal(t, "100", *results[0].ResultID)
+
+			assert.Equal(t, "azure", results[1].Type)
+			assert.Equal(t, "こんにちは。", results[1].Message)
+			assert.True(t, *results[1].IsFinal)
+			assert.Equal(t, "100", *results[1].ResultID)
+		}
+	})
+
+	t.Run("text processors", func(t *testing.T) {
+		s := newAzureFakeServer(t, azureFakeServerOption{Messages: messages})
+		defer s.Close()
+
+		regex, err := NewRegexTextProcessor(`^こんにちは$`, "")
+		require.NoError(t, err)
+
+		config := Config{
+			AzureEndpoint:        azureEndpoint(s),
+			AzureSubscriptionKey: "test-key",
+			TextProcessors:       TextProcessors{regex, NewDictionaryTextProcessor(map[string]string{"こんにちは": "Hello"})},
+		}
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 10)
+
+		h := NewAzureSpeechHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		r, err := h.Handle(ctx, opusCh, header)
+		require.NoError(t, err)
+		defer r.Close()
+
+		// 加工後に空になった途中結果は送らない
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, `{"message":"Hello。","type":"azure"}`+"\n", string(b))
+	})
+
+	t.Run("pcm", func(t *testing.T) {
+		s := newAzureFakeServer(t, azureFakeServerOption{Messages: messages, AudioFormat: audioFormatPCM})
+		defer s.Close()
+
+		config := Config{
+			AzureEndpoint:        azureEndpoint(s),
+			AzureSubscriptionKey: "test-key",
+			AzureAudioFormat:     audioFormatPCM,
+			PCMSampleRate:        16000,
+			PCMChannelCount:      1,
+			FinalResultOnly:      true,
+		}
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 10)
+
+		h := NewAzureSpeechHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		r, err := h.Handle(ctx, opusCh, header)
+		require.NoError(t, err)
+		defer r.Close()
+
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, `{"message":"こんにちは。","type":"azure"}`+"\n", string(b))
+	})
+
+	t.Run("final result only", func(t *testing.T) {
+		s := newAzureFakeServer(t, azureFakeServerOption{Messages: messages})
+		defer s.Close()
+
+		config := Config{
+			AzureEndpoint:        azureEndpoint(s),
+			AzureSubscriptionKey: "test-key",
+			FinalResultOnly:      true,
+		}
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 10)
+
+		h := NewAzureSpeechHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		r, err := h.Handle(ctx, opusCh, header)
+		require.NoError(t, err)
+		defer r.Close()
+
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, `{"message":"こんにちは。","type":"azure"}`+"\n", string(b))
+	})
+
+	t.Run("on result func", func(t *testing.T) {
+		s := newAzureFakeServer(t, azureFakeServerOption{Messages: messages})
+		defer s.Close()
+
+		config := Config{
+			AzureEndpoint:        azureEndpoint(s),
+			AzureSubscriptionKey: "test-key",
+		}
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 10)
+
+		onResultFunc := func(ctx context.Context, w io.WriteCloser, chID, connID, lang string, result ServiceResult) error {
+			if result.Provider != "azure" || result.Azure == nil {
+				return errors.New("unexpected result type")
+			}
+			_, err := fmt.Fprintf(w, "%d\n", result.Azure.Offset)
+			return err
+		}
+
+		h := NewAzureSpeechHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, onResultFunc)
+		r, err := h.Handle(ctx, opusCh, header)
+		require.NoError(t, err)
+		defer r.Close()
+
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, "100\n100\n900\n", string(b))
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		s := newAzureFakeServer(t, azureFakeServerOption{Messages: messages})
+		defer s.Close()
+
+		config := Config{
+			AzureEndpoint:        azureEndpoint(s),
+			AzureSubscriptionKey: "invalid-key",
+		}
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 10)
+
+		h := NewAzureSpeechHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		_, err := h.Handle(ctx, opusCh, header)
+
+		var suzuErr *SuzuError
+		if assert.ErrorAs(t, err, &suzuErr) {
+			assert.Equal(t, http.StatusUnauthorized, suzuErr.Code)
+			assert.False(t, suzuErr.IsRetry())
+		}
+	})
+
+	t.Run("unsupported language code", func(t *testing.T) {
+		config := Config{
+			AzureRegion:          "japaneast",
+			AzureSubscriptionKey: "test-key",
+			AzureLanguageCodes:   []string{"en-US"},
+		}
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 10)
+
+		h := NewAzureSpeechHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		_, err := h.Handle(ctx, opusCh, header)
+
+		var suzuConfErr *SuzuConfError
+		if assert.ErrorAs(t, err, &suzuConfErr) {
+			assert.Contains(t, suzuConfErr.Error(), ErrAzureUnsupportedLanguageCode.Error())
+		}
+	})
+
+	t.Run("server disconnected", func(t *testing.T) {
+		s := newAzureFakeServer(t, azureFakeServerOption{CloseCode: websocket.CloseInternalServerErr})
+		defer s.Close()
+
+		config := Config{
+			AzureEndpoint:        azureEndpoint(s),
+			AzureSubscriptionKey: "test-key",
+		}
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 10)
+
+		h := NewAzureSpeechHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		r, err := h.Handle(ctx, opusCh, header)
+		require.NoError(t, err)
+		defer r.Close()
+
+		_, err = io.ReadAll(r)
+		assert.ErrorIs(t, err, ErrServerDisconnected)
+	})
+
+	t.Run("policy violation", func(t *testing.T) {
+		s := newAzureFakeServer(t, azureFakeServerOption{CloseCode: websocket.ClosePolicyViolation})
+		defer s.Close()
+
+		config := Config{
+			AzureEndpoint:        azureEndpoint(s),
+			AzureSubscriptionKey: "test-key",
+		}
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 10)
+
+		h := NewAzureSpeechHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		r, err := h.Handle(ctx, opusCh, header)
+		require.NoError(t, err)
+		defer r.Close()
+
+		_, err = io.ReadAll(r)
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrServerDisconnected)
+	})
+}
+
+func TestParseAzureSpeechMessage(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		message, err := parseAzureSpeechMessage([]byte(azureFakeMessage("speech.phrase", `{"DisplayText":"test"}`)))
+		require.NoError(t, err)
+		assert.Equal(t, "speech.phrase", message.Path)
+		assert.Equal(t, "0123456789abcdef", message.RequestID)
+		assert.Equal(t, `{"DisplayText":"test"}`, string(message.Body))
+	})
+
+	t.Run("no body separator", func(t *testing.T) {
+		_, err := parseAzureSpeechMessage([]byte("Path: speech.phrase\r\n"))
+		assert.ErrorIs(t, err, ErrAzureInvalidMessage)
+	})
+
+	t.Run("no path", func(t *testing.T) {
+		_, err := parseAzureSpeechMessage([]byte("X-RequestId: 0123\r\n\r\n{}"))
+		assert.ErrorIs(t, err, ErrAzureInvalidMessage)
+	})
+}
+
+func TestAzureSpeechURL(t *testing.T) {
+	t.Run("region", func(t *testing.T) {
+		az := NewAzureSpeech(Config{AzureRegion: "japaneast", AzureProfanity: "masked"}, "ja-JP")
+		u, err := az.URL()
+		require.NoError(t, err)
+		assert.Equal(t, "wss://japaneast.stt.speech.microsoft.com/speech/recognition/conversation/cognitiveservices/v1?format=simple&language=ja-JP&profanity=masked", u)
+	})
+
+	t.Run("endpoint", func(t *testing.T) {
+		az := NewAzureSpeech(Config{AzureRegion: "japaneast", AzureEndpoint: "ws://127.0.0.1:8080/stt"}, "en-US")
+		u, err := az.URL()
+		require.NoError(t, err)
+		assert.Equal(t, "ws://127.0.0.1:8080/stt?format=simple&language=en-US", u)
+	})
+
+	t.Run("missing region", func(t *testing.T) {
+		az := NewAzureSpeech(Config{}, "ja-JP")
+		_, err := az.URL()
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,185 @@
+package suzu
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+const (
+	// enable_channel_split で分割するチャネル数
+	splitChannelCount = 2
+)
+
+// サービスへの接続ごとの情報
+// enable_channel_split が有効な場合はチャネルごとに異なるサービスと言語コードで接続する
+type speechStream struct {
+	serviceType string
+	// sora-audio-streaming-language-code ヘッダ、または、channel_split_language_codes に指定した言語コード
+	requestedLanguageCode string
+	// サービスごとの言語コードに変換した値
+	languageCode string
+	// チャネルを分割しない場合は nil
+	channelIndex *int
+
+	usage *sessionUsage
+}
+
+func newSpeechStreams(c Config, serviceType string, h SoraHeader) []*speechStream {
+	if !c.EnableChannelSplit {
+		return []*speechStream{{
+			serviceType:           serviceType,
+			requestedLanguageCode: h.SoraAudioStreamingLanguageCode,
+		}}
+	}
+
+	streams := make([]*speechStream, splitChannelCount)
+	for i := range streams {
+		st := &speechStream{
+			serviceType:           serviceType,
+			requestedLanguageCode: h.SoraAudioStreamingLanguageCode,
+			channelIndex:          &i,
+		}
+		if len(c.ChannelSplitServices) > 0 {
+			st.serviceType = c.ChannelSplitServices[i]
+		}
+		if len(c.ChannelSplitLanguageCodes) > 0 {
+			st.requestedLanguageCode = c.ChannelSplitLanguageCodes[i]
+		}
+		streams[i] = st
+	}
+	return streams
+}
+
+// 受信した 2 チャネルの音声をデコードし、チャネルごとのモノラルの PCM に分割した channel を返す
+// streamCtxs はチャネルごとの context で、閉じられたチャネルには以降の音声を送信しない
+func splitChannels(ctx context.Context, opusCh chan Opus, streamCtxs []context.Context) ([]chan Opus, error) {
+	processor, err := newAudioProcessor(opusMaxSampleRate, splitChannelCount)
+	if err != nil {
+		return nil, err
+	}
+
+	chs := make([]chan Opus, len(streamCtxs))
+	for i := range chs {
+		chs[i] = make(chan Opus)
+	}
+
+	go func() {
+		defer func() {
+			for _, ch := range chs {
+				close(ch)
+			}
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case req, ok := <-opusCh:
+				if !ok {
+					return
+				}
+
+				packets := make([]Opus, len(chs))
+				if req.Err != nil {
+					for i := range packets {
+						packets[i] = Opus{Err: req.Err}
+					}
+				} else {
+					frames, err := splitFrame(processor, req)
+					for i := range packets {
+						if err != nil {
+							packets[i] = Opus{Err: err}
+						} else {
+							packets[i] = Opus{PCM: frames[i]}
+						}
+					}
+				}
+
+				for i, ch := range chs {
+					select {
+					case <-ctx.Done():
+						return
+					case <-streamCtxs[i].Done():
+					case ch <- packets[i]:
+					}
+				}
+			}
+		}
+	}()
+
+	return chs, nil
+}
+
+// 2 チャネルの音声をチャネルごとのモノラルの PCM に分割する
+func splitFrame(processor *audioProcessor, opus Opus) ([]*PCMFrame, error) {
+	var samples []int16
+	sampleRate := opusMaxSampleRate
+	if opus.PCM != nil {
+		// LPCM の音声はデコードせずに分割する
+		if opus.PCM.ChannelCount != splitChannelCount {
+			return nil, fmt.Errorf("%w: channels=%d", ErrUnsupportedInputFormat, opus.PCM.ChannelCount)
+		}
+		samples = opus.PCM.Samples
+		sampleRate = opus.PCM.SampleRate
+	} else {
+		var err error
+		samples, err = processor.Process(opus.Payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	frames := make([]*PCMFrame, splitChannelCount)
+	for channel := range frames {
+		mono := make([]int16, len(samples)/splitChannelCount)
+		for i := range mono {
+			mono[i] = samples[i*splitChannelCount+channel]
+		}
+		frames[channel] = &PCMFrame{
+			Samples:      mono,
+			SampleRate:   sampleRate,
+			ChannelCount: 1,
+		}
+	}
+	return frames, nil
+}
+
+// サービスのハンドラが送信するメッセージに channel_index を付与した io.ReadCloser を返す
+func withChannelIndex(r io.Reader, channelIndex int) io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	go func() {
+		decoder := json.NewDecoder(r)
+		for {
+			var message json.RawMessage
+			if err := decoder.Decode(&message); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+
+			if _, err := pw.Write(append(addChannelIndex(message, channelIndex), '\n')); err != nil {
+				return
+			}
+		}
+	}()
+
+	return pr
+}
+
+// JSON のオブジェクトの先頭に channel_index を追加する
+func addChannelIndex(message json.RawMessage, channelIndex int) []byte {
+	message = bytes.TrimSpace(message)
+	if len(message) < 2 || message[0] != '{' {
+		return message
+	}
+
+	field := fmt.Sprintf(`{"channel_index":%d`, channelIndex)
+	rest := bytes.TrimSpace(message[1:])
+	if rest[0] == '}' {
+		return append([]byte(field), '}')
+	}
+	return append(append([]byte(field), ','), rest...)
+}
@@ -0,0 +1,186 @@
+package suzu
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSpeechStreams(t *testing.T) {
+	h := SoraHeader{SoraAudioStreamingLanguageCode: "ja-JP"}
+
+	t.Run("disabled", func(t *testing.T) {
+		streams := newSpeechStreams(Config{}, "aws", h)
+		require.Len(t, streams, 1)
+		assert.Equal(t, "aws", streams[0].serviceType)
+		assert.Equal(t, "ja-JP", streams[0].requestedLanguageCode)
+		assert.Nil(t, streams[0].channelIndex)
+	})
+
+	t.Run("default", func(t *testing.T) {
+		streams := newSpeechStreams(Config{EnableChannelSplit: true}, "aws", h)
+		require.Len(t, streams, 2)
+		for i, st := range streams {
+			assert.Equal(t, "aws", st.serviceType)
+			assert.Equal(t, "ja-JP", st.requestedLanguageCode)
+			require.NotNil(t, st.channelIndex)
+			assert.Equal(t, i, *st.channelIndex)
+		}
+	})
+
+	t.Run("services and language codes", func(t *testing.T) {
+		c := Config{
+			EnableChannelSplit:        true,
+			ChannelSplitServices:      []string{"gcp", "aws"},
+			ChannelSplitLanguageCodes: []string{"ja-JP", "en-US"},
+		}
+		streams := newSpeechStreams(c, "aws", h)
+		require.Len(t, streams, 2)
+		assert.Equal(t, "gcp", streams[0].serviceType)
+		assert.Equal(t, "ja-JP", streams[0].requestedLanguageCode)
+		assert.Equal(t, "aws", streams[1].serviceType)
+		assert.Equal(t, "en-US", streams[1].requestedLanguageCode)
+	})
+}
+
+func TestSplitFrame(t *testing.T) {
+	processor, err := newAudioProcessor(opusMaxSampleRate, splitChannelCount)
+	require.NoError(t, err)
+
+	t.Run("lpcm", func(t *testing.T) {
+		frame := &PCMFrame{Samples: []int16{1, -1, 2, -2, 3, -3}, SampleRate: 16000, ChannelCount: 2}
+		frames, err := splitFrame(processor, Opus{PCM: frame})
+		require.NoError(t, err)
+
+		assert.Equal(t, &PCMFrame{Samples: []int16{1, 2, 3}, SampleRate: 16000, ChannelCount: 1}, frames[0])
+		assert.Equal(t, &PCMFrame{Samples: []int16{-1, -2, -3}, SampleRate: 16000, ChannelCount: 1}, frames[1])
+	})
+
+	t.Run("lpcm mono", func(t *testing.T) {
+		frame := &PCMFrame{Samples: []int16{1, 2}, SampleRate: 16000, ChannelCount: 1}
+		_, err := splitFrame(processor, Opus{PCM: frame})
+		assert.ErrorIs(t, err, ErrUnsupportedInputFormat)
+	})
+
+	t.Run("opus", func(t *testing.T) {
+		// 20ms の無音パケットは 48kHz で 960 サンプル
+		frames, err := splitFrame(processor, Opus{Payload: silentPacket()})
+		require.NoError(t, err)
+		for _, frame := range frames {
+			assert.Len(t, frame.Samples, 960)
+			assert.Equal(t, opusMaxSampleRate, frame.SampleRate)
+			assert.Equal(t, 1, frame.ChannelCount)
+		}
+	})
+}
+
+func TestAddChannelIndex(t *testing.T) {
+	assert.Equal(t, `{"channel_index":1,"type":"test"}`, string(addChannelIndex([]byte(`{"type":"test"}`), 1)))
+	assert.Equal(t, `{"channel_index":0}`, string(addChannelIndex([]byte(` { } `), 0)))
+	// オブジェクト以外は変更しない
+	assert.Equal(t, `[1]`, string(addChannelIndex([]byte(`[1]`), 0)))
+}
+
+func TestChannelSplitWithSpeechHandler(t *testing.T) {
+	type message struct {
+		Type         string `json:"type"`
+		Status       string `json:"status"`
+		Message      string `json:"message"`
+		ChannelIndex *int   `json:"channel_index"`
+	}
+
+	config := Config{
+		ListenAddr:                "127.0.0.1",
+		TimeToWaitForOpusPacketMs: 500,
+		SampleRate:                48000,
+		ChannelCount:              2,
+		EnableChannelSplit:        true,
+		EnableStatusEvent:         true,
+	}
+	s, err := NewServer(&config, "test")
+	require.NoError(t, err)
+
+	// 16kHz ステレオの 20ms を 2 回
+	req := httptest.NewRequest(http.MethodPost, "/speech", bytes.NewReader(make([]byte, 320*2*2*2)))
+	req.Header.Set("sora-audio-streaming-language-code", "ja-JP")
+	req.Header.Set(echo.HeaderContentType, "audio/pcm; rate=16000; channels=2")
+	req.Proto = "HTTP/2.0"
+	req.ProtoMajor = 2
+	req.ProtoMinor = 0
+
+	rec := httptest.NewRecorder()
+	s.echo.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	results := map[int][]string{}
+	statuses := map[int][]string{}
+	decoder := json.NewDecoder(bytes.NewReader(rec.Body.Bytes()))
+	for decoder.More() {
+		var m message
+		require.NoError(t, decoder.Decode(&m))
+		require.NotNil(t, m.ChannelIndex, m)
+
+		switch m.Type {
+		case "test":
+			results[*m.ChannelIndex] = append(results[*m.ChannelIndex], m.Message)
+		case "status":
+			statuses[*m.ChannelIndex] = append(statuses[*m.ChannelIndex], m.Status)
+		}
+	}
+
+	// チャネルごとにモノラルの 16 bit PCM を送信する
+	for _, channelIndex := range []int{0, 1} {
+		assert.Equal(t, []string{"n: 640", "n: 640"}, results[channelIndex])
+		assert.Equal(t, []string{statusConnected, statusEndOfStream}, statuses[channelIndex])
+	}
+}
+
+func TestChannelSplitSessionLimit(t *testing.T) {
+	serve := func(t *testing.T, config Config) int {
+		t.Helper()
+
+		s, err := NewServer(&config, "test")
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/speech", bytes.NewReader(make([]byte, 320*2*2)))
+		req.Header.Set("sora-channel-id", "split")
+		req.Header.Set("sora-audio-streaming-language-code", "ja-JP")
+		req.Header.Set(echo.HeaderContentType, "audio/pcm; rate=16000; channels=2")
+		req.Proto = "HTTP/2.0"
+		req.ProtoMajor = 2
+		req.ProtoMinor = 0
+
+		rec := httptest.NewRecorder()
+		s.echo.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	config := Config{
+		ListenAddr:                "127.0.0.1",
+		TimeToWaitForOpusPacketMs: 500,
+		SampleRate:                48000,
+		ChannelCount:              2,
+		EnableChannelSplit:        true,
+	}
+
+	// max_sessions と max_sessions_per_channel は 1 つのセッションとして数える
+	c := config
+	c.MaxSessions = 1
+	c.MaxSessionsPerChannel = 1
+	assert.Equal(t, http.StatusOK, serve(t, c))
+
+	// max_sessions_per_provider はチャネルごとの接続を数える
+	c = config
+	c.MaxSessionsPerProvider = []string{"test:1"}
+	assert.Equal(t, http.StatusServiceUnavailable, serve(t, c))
+
+	c = config
+	c.MaxSessionsPerProvider = []string{"test:2"}
+	assert.Equal(t, http.StatusOK, serve(t, c))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/shiguredo/suzu"
+)
+
+// suzu decrypt -k key.txt [-o output] input
+// 暗号化した Ogg ファイルや dump_file を復号する
+func runDecrypt(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	keyFilePath := fs.String("k", "", "encryption_key_file に指定した鍵ファイルへのパス")
+	outputPath := fs.String("o", "", "出力先のファイルへのパス、指定しない場合は入力ファイルから .enc を除いたパス、- の場合は標準出力")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: suzu decrypt -k key.txt [-o output] input\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *keyFilePath == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("key file and input file are required")
+	}
+	inputPath := fs.Arg(0)
+
+	output := *outputPath
+	if output == "" {
+		if !strings.HasSuffix(inputPath, ".enc") {
+			return fmt.Errorf("-o is required when input file does not end with .enc")
+		}
+		output = strings.TrimSuffix(inputPath, ".enc")
+	}
+
+	key, err := suzu.LoadEncryptionKey(*keyFilePath)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var out io.WriteCloser = os.Stdout
+	if output != "-" {
+		// 既存のファイルを上書きしない
+		f, err := os.OpenFile(output, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		out = f
+	}
+
+	err = suzu.DecryptFile(key, in, out)
+	if output != "-" {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if errors.Is(err, suzu.ErrEncryptedFileTruncated) {
+		// 書き込み中に終了した場合も、復号できたデータは出力する
+		return fmt.Errorf("%w: decrypted data was written to %s, but the file may be incomplete", err, output)
+	}
+	if err != nil && output != "-" {
+		// 鍵が異なる場合などは、途中まで復号したファイルを残さない
+		os.Remove(output)
+	}
+	return err
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/shiguredo/suzu"
+	"golang.org/x/net/http2"
+)
+
+// ヘッダを複数指定するためのフラグ
+type headerFlag http.Header
+
+func (h headerFlag) String() string {
+	return ""
+}
+
+func (h headerFlag) Set(value string) error {
+	name, v, ok := strings.Cut(value, ":")
+	if !ok {
+		return fmt.Errorf("header must be name: value: %s", value)
+	}
+	http.Header(h).Add(strings.TrimSpace(name), strings.TrimSpace(v))
+	return nil
+}
+
+// ダンプを読み込む
+// keyFilePath を指定した場合は、encryption_key_file で暗号化したダンプを復号しながら読み込む
+func readPacketDump(path, keyFilePath, connectionID string) ([]suzu.PacketDumpResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := decryptReader(f, keyFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return suzu.ReadPacketDump(r, connectionID)
+}
+
+// keyFilePath を指定した場合は、f を復号しながら読み込む io.ReadCloser を返す
+// 指定しない場合は f をそのまま読み込む
+func decryptReader(f io.Reader, keyFilePath string) (io.ReadCloser, error) {
+	if keyFilePath == "" {
+		return io.NopCloser(f), nil
+	}
+
+	key, err := suzu.LoadEncryptionKey(keyFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(suzu.DecryptFile(key, f, pw))
+	}()
+	return pr, nil
+}
+
+// suzu dump2ogg [-k key.txt] [-connection-id id] [-o output.ogg] dump.jsonl
+// ダンプの Opus のパケットを Ogg ファイルに変換する
+func runDump2Ogg(args []string) error {
+	fs := flag.NewFlagSet("dump2ogg", flag.ExitOnError)
+	keyFilePath := fs.String("k", "", "暗号化したダンプの場合に、encryption_key_file に指定した鍵ファイルへのパス")
+	connectionID := fs.String("connection-id", "", "変換する接続の sora-connection-id、ダンプに複数の接続が含まれる場合は必須")
+	outputPath := fs.String("o", "", "出力先のファイルへのパス、指定しない場合は <sora-connection-id>.ogg")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: suzu dump2ogg [-k key.txt] [-connection-id id] [-o output.ogg] dump.jsonl\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("dump file is required")
+	}
+
+	dumps, err := readPacketDump(fs.Arg(0), *keyFilePath, *connectionID)
+	if err != nil {
+		return err
+	}
+
+	output := *outputPath
+	if output == "" {
+		output = url.PathEscape(dumps[0].ConnectionID) + ".ogg"
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := suzu.WritePacketDumpOgg(f, dumps); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "wrote %d packets to %s\n", len(dumps), output)
+	return nil
+}
+
+// suzu replay [-k key.txt] [-connection-id id] [-header] [-speed 1.0] [-H "name: value"] [-insecure] url dump.jsonl
+// ダンプのパケットを元のタイミングで Suzu に送信し、受信した結果を標準出力に書き込む
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	keyFilePath := fs.String("k", "", "暗号化したダンプの場合に、encryption_key_file に指定した鍵ファイルへのパス")
+	connectionID := fs.String("connection-id", "", "送信する接続の sora-connection-id、ダンプに複数の接続が含まれる場合は必須")
+	audioStreamingHeader := fs.Bool("header", false, "audio_streaming_header が有効なサーバに送信する場合に、パケットにヘッダを付与する")
+	speed := fs.Float64("speed", 1.0, "ダンプのタイムスタンプの間隔に対する送信の速度、0 の場合は待たずに送信する")
+	insecure := fs.Bool("insecure", false, "サーバ証明書を検証しない")
+	header := http.Header{}
+	fs.Var(headerFlag(header), "H", "追加するヘッダ（name: value）、複数指定できる")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: suzu replay [-k key.txt] [-connection-id id] [-header] [-speed 1.0] [-H \"name: value\"] [-insecure] url dump.jsonl\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("url and dump file are required")
+	}
+	if *speed < 0 {
+		return fmt.Errorf("speed must be greater than or equal to 0")
+	}
+
+	u, err := url.Parse(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	dumps, err := readPacketDump(fs.Arg(1), *keyFilePath, *connectionID)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	replay := suzu.PacketDumpReplay{
+		URL:                  u.String(),
+		Header:               header,
+		AudioStreamingHeader: *audioStreamingHeader,
+		Speed:                *speed,
+	}
+	return replay.Do(ctx, newHTTP2Client(u, *insecure), dumps, os.Stdout)
+}
+
+// Suzu は HTTP/2 のみ受け付けるため、http の場合も HTTP/2 (h2c) で接続する
+func newHTTP2Client(u *url.URL, insecure bool) *http.Client {
+	transport := &http2.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: insecure,
+		},
+	}
+	if u.Scheme == "http" {
+		transport.AllowHTTP = true
+		transport.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+	return &http.Client{Transport: transport}
+}
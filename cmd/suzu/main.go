@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"sort"
 	"strings"
 
@@ -13,7 +14,28 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+// サーバを起動せずに実行するサブコマンド
+var subcommands = map[string]func(args []string) error{
+	"decrypt":    runDecrypt,
+	"dump2ogg":   runDump2Ogg,
+	"replay":     runReplay,
+	"transcribe": runTranscribe,
+}
+
 func main() {
+	// bin/suzu decrypt -k key.txt dump.jsonl.enc
+	// bin/suzu dump2ogg dump.jsonl
+	// bin/suzu replay http://127.0.0.1:48080/speech dump.jsonl
+	// bin/suzu transcribe -C config.ini -service aws -lang ja-JP recording.ogg
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	// /bin/suzu -V
 	showVersion := flag.Bool("V", false, "バージョン")
 
@@ -46,6 +68,12 @@ func main() {
 
 	suzu.ShowConfig(config)
 
+	// otlp_endpoint が指定されている場合はトレースを送信する
+	shutdownTracer, err := suzu.InitTracer(context.Background(), config)
+	if err != nil {
+		log.Fatal("cannot initialize tracer:", err)
+	}
+
 	server, err := suzu.NewServer(config, *serviceType)
 	if err != nil {
 		log.Fatal("cannot create server:", err)
@@ -61,13 +89,20 @@ func main() {
 		return server.StartExporter(ctx)
 	})
 
-	if err := g.Wait(); err != nil {
+	err = g.Wait()
+
+	// 送信していないトレースを送信する
+	if err := shutdownTracer(context.Background()); err != nil {
+		zlog.Error().Err(err).Send()
+	}
+
+	if err != nil {
 		log.Fatal(err)
 	}
 }
 
 func serviceNames() []string {
-	names := suzu.NewServiceHandlerFuncs.GetNames([]string{"test", "dump"})
+	names := suzu.NewDefaultServiceHandlers().GetNames([]string{"test", "dump"})
 	sort.Strings(names)
 	return names
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+	"github.com/shiguredo/suzu"
+)
+
+// suzu transcribe [-C config.ini] [-service aws] -lang ja-JP [-format jsonl] [-speed 1.0] [-o output] input.ogg
+// 保存した Ogg ファイルを、サーバと同じ処理で文字起こしする
+func runTranscribe(args []string) error {
+	fs := flag.NewFlagSet("transcribe", flag.ExitOnError)
+	configFilePath := fs.String("C", "./config.ini", "設定ファイルへのパス")
+	serviceType := fs.String("service", "aws", fmt.Sprintf("音声文字変換のサービス（%s）", strings.Join(serviceNames(), ", ")))
+	languageCode := fs.String("lang", "", "sora-audio-streaming-language-code ヘッダに指定する言語コード")
+	channelID := fs.String("channel-id", "suzu-transcribe", "sora-channel-id ヘッダの値")
+	connectionID := fs.String("connection-id", "suzu-transcribe", "sora-connection-id ヘッダの値")
+	format := fs.String("format", suzu.TranscribeFormatJSONL, fmt.Sprintf("出力の形式（%s, %s）", suzu.TranscribeFormatJSONL, suzu.TranscribeFormatWebVTT))
+	speed := fs.Float64("speed", 1.0, "音声の長さに対する送信の速度、0 の場合は待たずに送信する")
+	outputPath := fs.String("o", "-", "出力先のファイルへのパス、- の場合は標準出力")
+	keyFilePath := fs.String("k", "", "暗号化した Ogg ファイルの場合に、encryption_key_file に指定した鍵ファイルへのパス")
+	header := http.Header{}
+	fs.Var(headerFlag(header), "H", "追加するヘッダ（name: value）、複数指定できる")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: suzu transcribe [-C config.ini] [-service aws] -lang ja-JP [-format jsonl] [-speed 1.0] [-o output] input.ogg\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *languageCode == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("language code and input file are required")
+	}
+	if *speed < 0 {
+		return fmt.Errorf("speed must be greater than or equal to 0")
+	}
+
+	config, err := suzu.NewConfig(*configFilePath)
+	if err != nil {
+		return err
+	}
+
+	suzu.InitLogger(config)
+	if config.LogStdout {
+		// 結果を標準出力に書き込むため、ログは標準エラー出力に書き込む
+		zlog.Logger = zerolog.New(os.Stderr).With().Timestamp().Str("domain", "suzu").Logger()
+	} else {
+		logger, err := suzu.NewLogger(config, config.LogName, "suzu")
+		if err != nil {
+			return err
+		}
+		zlog.Logger = *logger
+	}
+
+	ogg, err := readOggOpus(fs.Arg(0), *keyFilePath)
+	if err != nil {
+		return err
+	}
+	// サービスには Ogg ファイルのチャネル数で送信する
+	config.ChannelCount = ogg.ChannelCount
+
+	server, err := suzu.NewServer(config, *serviceType)
+	if err != nil {
+		return err
+	}
+
+	header.Set("sora-channel-id", *channelID)
+	header.Set("sora-connection-id", *connectionID)
+	header.Set("sora-audio-streaming-language-code", *languageCode)
+
+	var out io.WriteCloser = os.Stdout
+	if *outputPath != "-" {
+		f, err := os.Create(*outputPath)
+		if err != nil {
+			return err
+		}
+		out = f
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err = server.Transcribe(ctx, ogg.Packets, suzu.TranscribeOptions{
+		Header: header,
+		Speed:  *speed,
+		Format: *format,
+	}, out)
+	if *outputPath != "-" {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Ogg ファイルを読み込む
+// keyFilePath を指定した場合は、encryption_key_file で暗号化した Ogg ファイルを復号しながら読み込む
+func readOggOpus(path, keyFilePath string) (*suzu.OggOpus, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := decryptReader(f, keyFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return suzu.ReadOggOpus(r)
+}
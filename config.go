@@ -3,7 +3,9 @@ package suzu
 import (
 	_ "embed"
 	"fmt"
+	"net"
 	"net/netip"
+	"time"
 
 	zlog "github.com/rs/zerolog/log"
 	"gopkg.in/ini.v1"
@@ -26,8 +28,25 @@ const (
 	// 10s
 	defaultTimeToWaitForOpusPacketMs = 10000
 
+	defaultVADThresholdDB       = -50
+	defaultVADSilenceDurationMs = 1000
+	defaultVADPreRollMs         = 300
+
 	// リトライ間隔 100ms
 	defaultRetryIntervalMs = 100
+
+	// セッション数の上限を超えた場合に Retry-After ヘッダで返す秒数
+	defaultSessionLimitRetryAfterSec = 5
+
+	defaultPCMSampleRate   = 16000
+	defaultPCMChannelCount = 1
+
+	defaultLocalAudioFormat      = "ogg"
+	defaultLocalPCMSampleRate    = 16000
+	defaultLocalPartialResultKey = "partial"
+	defaultLocalFinalResultKey   = "text"
+
+	defaultPluginAudioFormat = "opus"
 )
 
 type Config struct {
@@ -52,11 +71,45 @@ type Config struct {
 	MaxRetry        int      `ini:"max_retry"`
 	RetryIntervalMs int      `ini:"retry_interval_ms"`
 	RetryTargets    []string `ini:"retry_targets"`
+	// エラーごとのリトライの指定を記述したルールファイル
+	RetryRulesFile string `ini:"retry_rules_file"`
+	// retry_rules_file から読み込んだリトライのルール
+	RetryRules RetryRules `ini:"-"`
+
+	// 同時に接続するセッション数の上限、0 の場合は制限しない
+	MaxSessions int `ini:"max_sessions"`
+	// サービスごとのセッション数の上限（サービス名:上限）
+	MaxSessionsPerProvider []string `ini:"max_sessions_per_provider"`
+	// sora-channel-id ごとのセッション数の上限
+	MaxSessionsPerChannel int `ini:"max_sessions_per_channel"`
+	// 上限を超えた場合に Retry-After ヘッダで返す秒数
+	SessionLimitRetryAfterSec int `ini:"session_limit_retry_after_sec"`
+
+	// サービスに送信した音声の長さを記録する JSONL ファイル
+	UsageLedgerFile string `ini:"usage_ledger_file"`
+	// sora-channel-id ごとの 1 日と 1 か月の利用量の上限（秒）、0 の場合は制限しない
+	ChannelDailyQuotaSec   int `ini:"channel_daily_quota_sec"`
+	ChannelMonthlyQuotaSec int `ini:"channel_monthly_quota_sec"`
+
+	// セッションの最大の長さ、0 の場合は制限しない
+	MaxSessionDuration time.Duration `ini:"max_session_duration"`
+	// 音声を受信せずに無音パケットのみを送信する状態の最大の長さ、0 の場合は制限しない
+	MaxIdleDuration time.Duration `ini:"max_idle_duration"`
+
+	// 接続状態を type: status のメッセージで通知する指定
+	EnableStatusEvent bool `ini:"enable_status_event"`
+	// type: heartbeat のメッセージを送信する間隔、0 の場合は送信しない
+	HeartbeatIntervalMs int `ini:"heartbeat_interval_ms"`
 
 	ExporterHTTPS      bool   `ini:"exporter_https"`
 	ExporterListenAddr string `ini:"exporter_listen_addr"`
 	ExporterListenPort int    `ini:"exporter_listen_port"`
 
+	// トレースを送信する OpenTelemetry Collector のアドレス（host:port）、空の場合は送信しない
+	OTLPEndpoint string `ini:"otlp_endpoint"`
+	// OpenTelemetry Collector との接続に TLS を利用する指定
+	OTLPTLS bool `ini:"otlp_tls"`
+
 	SkipBasicAuth     bool   `ini:"skip_basic_auth"`
 	BasicAuthUsername string `ini:"basic_auth_username"`
 	BasicAuthPassword string `ini:"basic_auth_password"`
@@ -64,8 +117,47 @@ type Config struct {
 	SampleRate   int `ini:"audio_sample_rate"`
 	ChannelCount int `ini:"audio_channel_count"`
 
+	// サービスに PCM で送信する場合のサンプリングレートとチャネル数
+	PCMSampleRate   int `ini:"pcm_sample_rate"`
+	PCMChannelCount int `ini:"pcm_channel_count"`
+
+	// sora-channel-id が同じ接続の最終結果を 1 つの文字起こしにまとめる指定
+	EnableRoomMode bool `ini:"enable_room_mode"`
+	// ルームの文字起こしを書き込むディレクトリ
+	RoomTranscriptDir string `ini:"room_transcript_dir"`
+
+	// 2 チャネルの音声をチャネルごとに分割して、別々にサービスに接続する指定
+	EnableChannelSplit bool `ini:"enable_channel_split"`
+	// チャネルごとに接続するサービスと言語コード、指定しない場合はエンドポイントのサービスと sora-audio-streaming-language-code ヘッダを使用する
+	ChannelSplitServices      []string `ini:"channel_split_services"`
+	ChannelSplitLanguageCodes []string `ini:"channel_split_language_codes"`
+
 	EnableOggFileOutput bool   `ini:"enable_ogg_file_output"`
 	OggDir              string `ini:"ogg_dir"`
+	// Ogg ファイルのファイル名、{channel_id}、{session_id}、{connection_id}、{date}、{time} を置換する
+	OggFileNameTemplate string `ini:"ogg_file_name_template"`
+
+	// Ogg ファイルとルームの文字起こしの保存先（local, s3）
+	StorageType string `ini:"storage_type"`
+	// 保存期間（日）、0 の場合は削除しない
+	StorageRetentionDays int `ini:"storage_retention_days"`
+
+	// storage_type が s3 の場合のみ使用する
+	// S3 互換のストレージを利用する場合は s3_endpoint を指定する
+	S3Endpoint        string `ini:"s3_endpoint"`
+	S3Region          string `ini:"s3_region"`
+	S3Bucket          string `ini:"s3_bucket"`
+	S3UsePathStyle    bool   `ini:"s3_use_path_style"`
+	S3AccessKeyID     string `ini:"s3_access_key_id"`
+	S3SecretAccessKey string `ini:"s3_secret_access_key"`
+	// マルチパートアップロードのパートのサイズ（MB）
+	S3PartSizeMB int `ini:"s3_part_size_mb"`
+	// サーバ側の暗号化（AES256, aws:kms）
+	S3ServerSideEncryption string `ini:"s3_server_side_encryption"`
+	S3SSEKMSKeyID          string `ini:"s3_sse_kms_key_id"`
+
+	// Ogg ファイルと dump_file を暗号化する鍵ファイル、指定しない場合は暗号化しない
+	EncryptionKeyFile string `ini:"encryption_key_file"`
 
 	DumpFile string `ini:"dump_file"`
 
@@ -87,9 +179,33 @@ type Config struct {
 	DisableSilentPacket       bool `ini:"disable_silent_packet"`
 	TimeToWaitForOpusPacketMs int  `ini:"time_to_wait_for_opus_packet_ms"`
 
+	// 無音が続く間は音声をサービスに送信しない指定
+	EnableVAD bool `ini:"enable_vad"`
+	// この値（dBFS）未満の音声を無音と判定する
+	VADThresholdDB float64 `ini:"vad_threshold_db"`
+	// 無音がこの長さ続いた場合に送信を停止する
+	VADSilenceDurationMs int `ini:"vad_silence_duration_ms"`
+	// 発話の開始時に送信する直前の音声の長さ
+	VADPreRollMs int `ini:"vad_pre_roll_ms"`
+
 	// aws の場合は IsPartial が false, gcp の場合は IsFinal が true の場合にのみ結果を返す指定
 	FinalResultOnly bool `ini:"final_result_only"`
 
+	// 途中結果の送信方法（full, diff）
+	PartialResultMode string `ini:"partial_result_mode"`
+
+	// 変換結果のテキストを加工するルールファイル
+	TextProcessorRulesFile string `ini:"text_processor_rules_file"`
+	// text_processor_rules_file から読み込んだテキスト処理
+	TextProcessors TextProcessors `ini:"-"`
+
+	// 変換結果に含まれる個人情報をマスクする指定
+	RedactionTypes     []string `ini:"redaction_types"`
+	RedactionRulesFile string   `ini:"redaction_rules_file"`
+	RedactionMask      string   `ini:"redaction_mask"`
+	// redaction_types と redaction_rules_file から生成したマスク処理
+	Redactor *Redactor `ini:"-"`
+
 	MinimumConfidenceScore float64 `ini:"minimum_confidence_score"`
 	MinimumTranscribedTime float64 `ini:"minimum_transcribed_time"`
 
@@ -100,6 +216,10 @@ type Config struct {
 	AwsEnablePartialResultsStabilization bool   `ini:"aws_enable_partial_results_stabilization"`
 	AwsPartialResultsStability           string `ini:"aws_partial_results_stability"`
 	AwsEnableChannelIdentification       bool   `ini:"aws_enable_channel_identification"`
+	AwsContentRedactionType              string `ini:"aws_content_redaction_type"`
+	AwsPiiEntityTypes                    string `ini:"aws_pii_entity_types"`
+	// サービスに送信する音声の形式（ogg, pcm）
+	AwsAudioFormat string `ini:"aws_audio_format"`
 	// 変換結果に含める項目の有無の指定
 	AwsResultChannelID bool `ini:"aws_result_channel_id"`
 	AwsResultIsPartial bool `ini:"aws_result_is_partial"`
@@ -129,9 +249,41 @@ type Config struct {
 	GcpUseEnhanced                         bool     `ini:"gcp_use_enhanced"`
 	GcpSingleUtterance                     bool     `ini:"gcp_single_utterance"`
 	GcpInterimResults                      bool     `ini:"gcp_interim_results"`
+	// サービスに送信する音声の形式（ogg, pcm）
+	GcpAudioFormat string `ini:"gcp_audio_format"`
 	// 変換結果に含める項目の有無の指定
 	GcpResultIsFinal   bool `ini:"gcp_result_is_final"`
 	GcpResultStability bool `ini:"gcp_result_stability"`
+
+	// Microsoft Azure
+	AzureEndpoint        string   `ini:"azure_endpoint"`
+	AzureRegion          string   `ini:"azure_region"`
+	AzureSubscriptionKey string   `ini:"azure_subscription_key"`
+	AzureLanguageCodes   []string `ini:"azure_language_codes"`
+	AzureProfanity       string   `ini:"azure_profanity"`
+	// サービスに送信する音声の形式（ogg, pcm）
+	AzureAudioFormat string `ini:"azure_audio_format"`
+	// 変換結果に含める項目の有無の指定
+	AzureResultIsFinal bool `ini:"azure_result_is_final"`
+	AzureResultID      bool `ini:"azure_result_id"`
+
+	// 自前で運用する音声認識サーバ
+	LocalURL              string `ini:"local_url"`
+	LocalAudioFormat      string `ini:"local_audio_format"`
+	LocalPCMSampleRate    int    `ini:"local_pcm_sample_rate"`
+	LocalConfigMessage    string `ini:"local_config_message"`
+	LocalEOFMessage       string `ini:"local_eof_message"`
+	LocalPartialResultKey string `ini:"local_partial_result_key"`
+	LocalFinalResultKey   string `ini:"local_final_result_key"`
+	// 変換結果に含める項目の有無の指定
+	LocalResultIsFinal bool `ini:"local_result_is_final"`
+
+	// 別プロセスで起動する音声文字変換プラグイン
+	PluginAddress     string `ini:"plugin_address"`
+	PluginAudioFormat string `ini:"plugin_audio_format"`
+	// 変換結果に含める項目の有無の指定
+	PluginResultIsFinal bool `ini:"plugin_result_is_final"`
+	PluginResultID      bool `ini:"plugin_result_id"`
 }
 
 func NewConfig(configFilePath string) (*Config, error) {
@@ -152,6 +304,28 @@ func NewConfig(configFilePath string) (*Config, error) {
 		return nil, err
 	}
 
+	if config.TextProcessorRulesFile != "" {
+		textProcessors, err := LoadTextProcessors(config.TextProcessorRulesFile)
+		if err != nil {
+			return nil, err
+		}
+		config.TextProcessors = textProcessors
+	}
+
+	if config.RetryRulesFile != "" {
+		retryRules, err := LoadRetryRules(config.RetryRulesFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RetryRules = retryRules
+	}
+
+	redactor, err := NewRedactorFromConfig(*config)
+	if err != nil {
+		return nil, err
+	}
+	config.Redactor = redactor
+
 	return config, nil
 }
 
@@ -190,13 +364,93 @@ func setDefaultsConfig(config *Config) {
 		config.TimeToWaitForOpusPacketMs = defaultTimeToWaitForOpusPacketMs
 	}
 
+	if config.VADThresholdDB == 0 {
+		config.VADThresholdDB = defaultVADThresholdDB
+	}
+
+	if config.VADSilenceDurationMs == 0 {
+		config.VADSilenceDurationMs = defaultVADSilenceDurationMs
+	}
+
+	if config.VADPreRollMs == 0 {
+		config.VADPreRollMs = defaultVADPreRollMs
+	}
+
 	if config.RetryIntervalMs == 0 {
 		config.RetryIntervalMs = defaultRetryIntervalMs
 	}
 
+	if config.SessionLimitRetryAfterSec == 0 {
+		config.SessionLimitRetryAfterSec = defaultSessionLimitRetryAfterSec
+	}
+
+	if config.PartialResultMode == "" {
+		config.PartialResultMode = partialResultModeFull
+	}
+
 	if config.OggDir == "" {
 		config.OggDir = "."
 	}
+
+	if config.OggFileNameTemplate == "" {
+		config.OggFileNameTemplate = defaultOggFileNameTemplate
+	}
+
+	if config.StorageType == "" {
+		config.StorageType = storageTypeLocal
+	}
+
+	if config.S3Region == "" {
+		config.S3Region = config.AwsRegion
+	}
+
+	if config.S3PartSizeMB == 0 {
+		config.S3PartSizeMB = s3MinPartSizeMB
+	}
+
+	if config.RoomTranscriptDir == "" {
+		config.RoomTranscriptDir = "."
+	}
+
+	if config.PCMSampleRate == 0 {
+		config.PCMSampleRate = defaultPCMSampleRate
+	}
+
+	if config.PCMChannelCount == 0 {
+		config.PCMChannelCount = defaultPCMChannelCount
+	}
+
+	if config.AwsAudioFormat == "" {
+		config.AwsAudioFormat = audioFormatOgg
+	}
+
+	if config.GcpAudioFormat == "" {
+		config.GcpAudioFormat = audioFormatOgg
+	}
+
+	if config.AzureAudioFormat == "" {
+		config.AzureAudioFormat = audioFormatOgg
+	}
+
+	if config.LocalAudioFormat == "" {
+		config.LocalAudioFormat = defaultLocalAudioFormat
+	}
+
+	if config.LocalPCMSampleRate == 0 {
+		config.LocalPCMSampleRate = defaultLocalPCMSampleRate
+	}
+
+	if config.LocalPartialResultKey == "" {
+		config.LocalPartialResultKey = defaultLocalPartialResultKey
+	}
+
+	if config.LocalFinalResultKey == "" {
+		config.LocalFinalResultKey = defaultLocalFinalResultKey
+	}
+
+	if config.PluginAudioFormat == "" {
+		config.PluginAudioFormat = defaultPluginAudioFormat
+	}
 }
 
 func validateConfig(config *Config) error {
@@ -213,6 +467,87 @@ func validateConfig(config *Config) error {
 		return err
 	}
 
+	if config.OTLPEndpoint != "" {
+		// host:port の形式であることを確認する
+		if _, _, err := net.SplitHostPort(config.OTLPEndpoint); err != nil {
+			return fmt.Errorf("invalid otlp_endpoint: %w", err)
+		}
+	}
+
+	if config.MaxSessions < 0 || config.MaxSessionsPerChannel < 0 {
+		return fmt.Errorf("max_sessions and max_sessions_per_channel must be greater than or equal to 0")
+	}
+
+	if _, err := parseMaxSessionsPerProvider(config.MaxSessionsPerProvider); err != nil {
+		return err
+	}
+
+	if config.ChannelDailyQuotaSec < 0 || config.ChannelMonthlyQuotaSec < 0 {
+		return fmt.Errorf("channel_daily_quota_sec and channel_monthly_quota_sec must be greater than or equal to 0")
+	}
+
+	if config.MaxSessionDuration < 0 || config.MaxIdleDuration < 0 {
+		return fmt.Errorf("max_session_duration and max_idle_duration must be greater than or equal to 0")
+	}
+
+	if config.VADThresholdDB > 0 {
+		return fmt.Errorf("vad_threshold_db must be less than or equal to 0")
+	}
+
+	if config.VADSilenceDurationMs < 0 || config.VADPreRollMs < 0 {
+		return fmt.Errorf("vad_silence_duration_ms and vad_pre_roll_ms must be greater than or equal to 0")
+	}
+
+	if config.HeartbeatIntervalMs < 0 {
+		return fmt.Errorf("heartbeat_interval_ms must be greater than or equal to 0")
+	}
+
+	for _, format := range []string{config.AwsAudioFormat, config.GcpAudioFormat, config.AzureAudioFormat} {
+		if err := validateAudioFormat(format); err != nil {
+			return err
+		}
+	}
+
+	if config.PCMSampleRate < 8000 || config.PCMSampleRate > opusMaxSampleRate {
+		return fmt.Errorf("pcm_sample_rate must be between 8000 and %d", opusMaxSampleRate)
+	}
+
+	if config.PCMChannelCount != 1 && config.PCMChannelCount != 2 {
+		return fmt.Errorf("pcm_channel_count must be 1 or 2")
+	}
+
+	if config.EnableChannelSplit && config.ChannelCount != splitChannelCount {
+		return fmt.Errorf("audio_channel_count must be %d when enable_channel_split is true", splitChannelCount)
+	}
+
+	if n := len(config.ChannelSplitServices); n != 0 && n != splitChannelCount {
+		return fmt.Errorf("channel_split_services must have %d services", splitChannelCount)
+	}
+
+	if n := len(config.ChannelSplitLanguageCodes); n != 0 && n != splitChannelCount {
+		return fmt.Errorf("channel_split_language_codes must have %d language codes", splitChannelCount)
+	}
+
+	if err := validateOggFileNameTemplate(config.OggFileNameTemplate); err != nil {
+		return err
+	}
+
+	if err := validateStorageConfig(config); err != nil {
+		return err
+	}
+
+	if config.EncryptionKeyFile != "" {
+		if _, err := LoadEncryptionKey(config.EncryptionKeyFile); err != nil {
+			return err
+		}
+	}
+
+	switch config.PartialResultMode {
+	case partialResultModeFull, partialResultModeDiff:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedPartialResultMode, config.PartialResultMode)
+	}
+
 	if config.HTTPS || config.ExporterHTTPS {
 		if config.TLSFullchainFile == "" {
 			return fmt.Errorf("tls_fullchain_file is required")
@@ -249,8 +584,53 @@ func ShowConfig(config *Config) {
 	zlog.Info().Str("exporter_listen_addr", config.ExporterListenAddr).Msg("CONF")
 	zlog.Info().Int("exporter_listen_port", config.ExporterListenPort).Msg("CONF")
 
+	zlog.Info().Str("otlp_endpoint", config.OTLPEndpoint).Msg("CONF")
+	zlog.Info().Bool("otlp_tls", config.OTLPTLS).Msg("CONF")
+
+	zlog.Info().Str("partial_result_mode", config.PartialResultMode).Msg("CONF")
+	zlog.Info().Str("text_processor_rules_file", config.TextProcessorRulesFile).Msg("CONF")
+	zlog.Info().Strs("redaction_types", config.RedactionTypes).Msg("CONF")
+	zlog.Info().Str("redaction_rules_file", config.RedactionRulesFile).Msg("CONF")
+
 	zlog.Info().Int("max_retry", config.MaxRetry).Msg("CONF")
 	zlog.Info().Int("retry_interval_ms", config.RetryIntervalMs).Msg("CONF")
+	zlog.Info().Str("retry_rules_file", config.RetryRulesFile).Msg("CONF")
+	zlog.Info().Int("max_sessions", config.MaxSessions).Msg("CONF")
+	zlog.Info().Strs("max_sessions_per_provider", config.MaxSessionsPerProvider).Msg("CONF")
+	zlog.Info().Int("max_sessions_per_channel", config.MaxSessionsPerChannel).Msg("CONF")
+	zlog.Info().Int("session_limit_retry_after_sec", config.SessionLimitRetryAfterSec).Msg("CONF")
+	zlog.Info().Str("usage_ledger_file", config.UsageLedgerFile).Msg("CONF")
+	zlog.Info().Int("channel_daily_quota_sec", config.ChannelDailyQuotaSec).Msg("CONF")
+	zlog.Info().Int("channel_monthly_quota_sec", config.ChannelMonthlyQuotaSec).Msg("CONF")
+	zlog.Info().Bool("enable_vad", config.EnableVAD).Msg("CONF")
+	zlog.Info().Float64("vad_threshold_db", config.VADThresholdDB).Msg("CONF")
+	zlog.Info().Int("vad_silence_duration_ms", config.VADSilenceDurationMs).Msg("CONF")
+	zlog.Info().Int("vad_pre_roll_ms", config.VADPreRollMs).Msg("CONF")
+	zlog.Info().Str("max_session_duration", config.MaxSessionDuration.String()).Msg("CONF")
+	zlog.Info().Str("max_idle_duration", config.MaxIdleDuration.String()).Msg("CONF")
+	zlog.Info().Bool("enable_status_event", config.EnableStatusEvent).Msg("CONF")
+	zlog.Info().Int("heartbeat_interval_ms", config.HeartbeatIntervalMs).Msg("CONF")
+
+	zlog.Info().Str("aws_audio_format", config.AwsAudioFormat).Msg("CONF")
+	zlog.Info().Str("gcp_audio_format", config.GcpAudioFormat).Msg("CONF")
+	zlog.Info().Str("azure_audio_format", config.AzureAudioFormat).Msg("CONF")
+	zlog.Info().Int("pcm_sample_rate", config.PCMSampleRate).Msg("CONF")
+	zlog.Info().Int("pcm_channel_count", config.PCMChannelCount).Msg("CONF")
+	zlog.Info().Str("storage_type", config.StorageType).Msg("CONF")
+	zlog.Info().Int("storage_retention_days", config.StorageRetentionDays).Msg("CONF")
+	zlog.Info().Str("s3_endpoint", config.S3Endpoint).Msg("CONF")
+	zlog.Info().Str("s3_region", config.S3Region).Msg("CONF")
+	zlog.Info().Str("s3_bucket", config.S3Bucket).Msg("CONF")
+	zlog.Info().Bool("s3_use_path_style", config.S3UsePathStyle).Msg("CONF")
+	zlog.Info().Int("s3_part_size_mb", config.S3PartSizeMB).Msg("CONF")
+	zlog.Info().Str("s3_server_side_encryption", config.S3ServerSideEncryption).Msg("CONF")
+	zlog.Info().Str("encryption_key_file", config.EncryptionKeyFile).Msg("CONF")
+
+	zlog.Info().Bool("enable_room_mode", config.EnableRoomMode).Msg("CONF")
+	zlog.Info().Str("room_transcript_dir", config.RoomTranscriptDir).Msg("CONF")
+	zlog.Info().Bool("enable_channel_split", config.EnableChannelSplit).Msg("CONF")
+	zlog.Info().Strs("channel_split_services", config.ChannelSplitServices).Msg("CONF")
+	zlog.Info().Strs("channel_split_language_codes", config.ChannelSplitLanguageCodes).Msg("CONF")
 
 	zlog.Info().Bool("aws_http_disable_keep_alives", config.AwsHTTPDisableKeepAlives).Msg("CONF")
 	zlog.Info().Int("aws_http_idle_conn_timeout_sec", config.AwsHTTPIdleConnTimeoutSec).Msg("CONF")
@@ -0,0 +1,337 @@
+package suzu
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// 暗号化したファイルの形式
+//
+// ファイルはレコードの連続で、レコードは 1 回の Write で書き込む
+// dump_file は複数のセッションが追記するため、セッションごとにセグメント ID とデータキーを生成し、
+// 異なるセグメントのレコードが混在しても復号できるようにする
+//
+//	レコード: type (1) | segment id (8) | length (4, big endian) | body (length)
+//	header:   magic (4) | version (1) | key id (8) | nonce (12) | 暗号化したデータキー (32 + 16)
+//	data:     暗号化したデータ（nonce はセグメント内の通し番号）
+//	end:      空のデータを暗号化した値、途中で書き込みが終了していないことの確認に使用する
+const (
+	// 暗号化したファイルの拡張子
+	encryptedFileSuffix = ".enc"
+
+	encryptedFileMagic   = "SZEC"
+	encryptedFileVersion = 1
+
+	// 鍵ファイルとデータキーの長さ（AES-256）
+	encryptionKeySize = 32
+
+	encryptedRecordTypeHeader = 1
+	encryptedRecordTypeData   = 2
+	encryptedRecordTypeEnd    = 3
+
+	encryptedRecordHeaderSize = 1 + 8 + 4
+	encryptedKeyIDSize        = 8
+
+	// 1 つのレコードの最大の長さ、壊れたファイルを読み込んだ場合に確保するメモリを制限する
+	maxEncryptedRecordSize = 16 * 1024 * 1024
+)
+
+var (
+	ErrEncryptionKeyMismatch  = fmt.Errorf("ENCRYPTION-KEY-MISMATCH")
+	ErrEncryptedFileTruncated = fmt.Errorf("ENCRYPTED-FILE-TRUNCATED")
+)
+
+// 鍵ファイルを読み込む
+// 鍵ファイルには 32 バイトの鍵を base64 でエンコードした値を書き込む
+func LoadEncryptionKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key file: %s: %w", path, err)
+	}
+	if len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("invalid encryption key file: %s: key must be %d bytes", path, encryptionKeySize)
+	}
+	return key, nil
+}
+
+// 鍵ファイルの鍵を識別する値、復号時に鍵が異なることを確認するために使用する
+func encryptionKeyID(key []byte) []byte {
+	sum := sha256.Sum256(key)
+	return sum[:encryptedKeyIDSize]
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// セグメント内の通し番号から nonce を生成する
+// データキーはセグメントごとに生成するため、同じ nonce を同じデータキーで使用することはない
+func encryptedRecordNonce(aead cipher.AEAD, sequence uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], sequence)
+	return nonce
+}
+
+// レコードの種類とセグメント ID を認証の対象にして、他のセグメントや種類のレコードとの入れ替えを検出する
+func encryptedRecordAdditionalData(recordType byte, segmentID []byte) []byte {
+	return append([]byte{recordType}, segmentID...)
+}
+
+func appendEncryptedRecord(dst []byte, recordType byte, segmentID []byte, body []byte) []byte {
+	dst = append(dst, recordType)
+	dst = append(dst, segmentID...)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(body)))
+	return append(dst, body...)
+}
+
+// 書き込んだデータを暗号化する io.WriteCloser
+// 鍵ファイルの鍵で暗号化したデータキーをヘッダに書き込み、データはデータキーで暗号化する
+type encryptWriter struct {
+	w         io.WriteCloser
+	aead      cipher.AEAD
+	segmentID []byte
+	sequence  uint64
+	closed    bool
+}
+
+func newEncryptWriter(w io.WriteCloser, key []byte) (*encryptWriter, error) {
+	dataKey := make([]byte, encryptionKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	segmentID := make([]byte, 8)
+	if _, err := rand.Read(segmentID); err != nil {
+		return nil, err
+	}
+
+	keyEncryption, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, keyEncryption.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	keyID := encryptionKeyID(key)
+	body := []byte(encryptedFileMagic)
+	body = append(body, encryptedFileVersion)
+	body = append(body, keyID...)
+	body = append(body, nonce...)
+	additionalData := append(encryptedRecordAdditionalData(encryptedRecordTypeHeader, segmentID), keyID...)
+	body = keyEncryption.Seal(body, nonce, dataKey, additionalData)
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(appendEncryptedRecord(nil, encryptedRecordTypeHeader, segmentID, body)); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		w:         w,
+		aead:      aead,
+		segmentID: segmentID,
+	}, nil
+}
+
+func (e *encryptWriter) seal(recordType byte, p []byte) []byte {
+	nonce := encryptedRecordNonce(e.aead, e.sequence)
+	e.sequence++
+	body := e.aead.Seal(nil, nonce, p, encryptedRecordAdditionalData(recordType, e.segmentID))
+	return appendEncryptedRecord(nil, recordType, e.segmentID, body)
+}
+
+// p を 1 つのレコードとして書き込む
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errFileNotOpened
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if len(p) > maxEncryptedRecordSize-e.aead.Overhead() {
+		return 0, fmt.Errorf("encrypted record too large: %d", len(p))
+	}
+
+	if _, err := e.w.Write(e.seal(encryptedRecordTypeData, p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// end のレコードを書き込んで閉じる
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+
+	_, err := e.w.Write(e.seal(encryptedRecordTypeEnd, nil))
+	if closeErr := e.w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// 復号中のセグメント
+type decryptSegment struct {
+	aead     cipher.AEAD
+	sequence uint64
+	ended    bool
+}
+
+// 暗号化したファイルを復号して w に書き込む
+// 複数のセグメントのレコードが混在する場合は、ファイル内の順番で書き込む
+// end のレコードがないセグメントがある場合は、復号できたデータをすべて書き込んだ後に ErrEncryptedFileTruncated を返す
+func DecryptFile(key []byte, r io.Reader, w io.Writer) error {
+	keyEncryption, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	keyID := encryptionKeyID(key)
+
+	reader := bufio.NewReader(r)
+	segments := map[string]*decryptSegment{}
+	header := make([]byte, encryptedRecordHeaderSize)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return ErrEncryptedFileTruncated
+			}
+			return err
+		}
+
+		recordType := header[0]
+		segmentID := header[1:9]
+		length := binary.BigEndian.Uint32(header[9:])
+		if length > maxEncryptedRecordSize {
+			return fmt.Errorf("encrypted record too large: %d", length)
+		}
+
+		body := make([]byte, length)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return ErrEncryptedFileTruncated
+			}
+			return err
+		}
+
+		if recordType == encryptedRecordTypeHeader {
+			segment, err := decryptHeaderRecord(keyEncryption, keyID, segmentID, body)
+			if err != nil {
+				return err
+			}
+			segments[string(segmentID)] = segment
+			continue
+		}
+
+		segment, ok := segments[string(segmentID)]
+		if !ok {
+			return fmt.Errorf("encrypted record without header: %x", segmentID)
+		}
+		if segment.ended {
+			return fmt.Errorf("encrypted record after end: %x", segmentID)
+		}
+
+		switch recordType {
+		case encryptedRecordTypeData, encryptedRecordTypeEnd:
+		default:
+			return fmt.Errorf("unknown encrypted record type: %d", recordType)
+		}
+
+		nonce := encryptedRecordNonce(segment.aead, segment.sequence)
+		segment.sequence++
+		// 通し番号が nonce のため、レコードの欠落や入れ替えは復号の失敗になる
+		plaintext, err := segment.aead.Open(nil, nonce, body, encryptedRecordAdditionalData(recordType, segmentID))
+		if err != nil {
+			return fmt.Errorf("cannot decrypt record: %x: %w", segmentID, err)
+		}
+
+		if recordType == encryptedRecordTypeEnd {
+			segment.ended = true
+			continue
+		}
+
+		if _, err := w.Write(plaintext); err != nil {
+			return err
+		}
+	}
+
+	for _, segment := range segments {
+		if !segment.ended {
+			return ErrEncryptedFileTruncated
+		}
+	}
+	return nil
+}
+
+func decryptHeaderRecord(keyEncryption cipher.AEAD, keyID, segmentID, body []byte) (*decryptSegment, error) {
+	prefixSize := len(encryptedFileMagic) + 1 + encryptedKeyIDSize + keyEncryption.NonceSize()
+	if len(body) < prefixSize || string(body[:len(encryptedFileMagic)]) != encryptedFileMagic {
+		return nil, fmt.Errorf("invalid encrypted file header")
+	}
+	body = body[len(encryptedFileMagic):]
+
+	if version := body[0]; version != encryptedFileVersion {
+		return nil, fmt.Errorf("unsupported encrypted file version: %d", version)
+	}
+	body = body[1:]
+
+	if !bytes.Equal(body[:encryptedKeyIDSize], keyID) {
+		return nil, ErrEncryptionKeyMismatch
+	}
+	body = body[encryptedKeyIDSize:]
+
+	nonce := body[:keyEncryption.NonceSize()]
+	additionalData := append(encryptedRecordAdditionalData(encryptedRecordTypeHeader, segmentID), keyID...)
+	dataKey, err := keyEncryption.Open(nil, nonce, body[keyEncryption.NonceSize():], additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt data key: %w", err)
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptSegment{aead: aead}, nil
+}
+
+// encryption_key_file を指定した場合は、鍵ファイルを読み込んで w を暗号化する io.WriteCloser を返す
+// 指定しない場合は w をそのまま返す
+func newEncryptWriterFromConfig(c Config, w io.WriteCloser) (io.WriteCloser, error) {
+	if c.EncryptionKeyFile == "" {
+		return w, nil
+	}
+
+	// 鍵ファイルを差し替えた場合に再起動せずに反映するため、ファイルごとに読み込む
+	key, err := LoadEncryptionKey(c.EncryptionKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptWriter(w, key)
+}
@@ -0,0 +1,222 @@
+package suzu
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func writeEncryptionKeyFile(t *testing.T) (string, []byte) {
+	t.Helper()
+
+	key := make([]byte, encryptionKeySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "key.txt")
+	require.NoError(t, os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600))
+	return path, key
+}
+
+func TestLoadEncryptionKey(t *testing.T) {
+	path, key := writeEncryptionKeyFile(t)
+
+	loaded, err := LoadEncryptionKey(path)
+	require.NoError(t, err)
+	assert.Equal(t, key, loaded)
+
+	invalid := filepath.Join(t.TempDir(), "invalid.txt")
+	require.NoError(t, os.WriteFile(invalid, []byte(base64.StdEncoding.EncodeToString([]byte("short"))), 0600))
+	_, err = LoadEncryptionKey(invalid)
+	assert.Error(t, err)
+
+	_, err = LoadEncryptionKey(filepath.Join(t.TempDir(), "not-found.txt"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestEncryptWriter(t *testing.T) {
+	_, key := writeEncryptionKeyFile(t)
+
+	t.Run("success", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, err := newEncryptWriter(nopWriteCloser{&buf}, key)
+		require.NoError(t, err)
+
+		_, err = w.Write([]byte("hello "))
+		require.NoError(t, err)
+		_, err = w.Write([]byte("world"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		assert.NotContains(t, buf.String(), "hello")
+
+		var out bytes.Buffer
+		require.NoError(t, DecryptFile(key, bytes.NewReader(buf.Bytes()), &out))
+		assert.Equal(t, "hello world", out.String())
+	})
+
+	t.Run("interleaved segments", func(t *testing.T) {
+		// dump_file に複数のセッションが追記する場合
+		var buf bytes.Buffer
+		w1, err := newEncryptWriter(nopWriteCloser{&buf}, key)
+		require.NoError(t, err)
+		w2, err := newEncryptWriter(nopWriteCloser{&buf}, key)
+		require.NoError(t, err)
+
+		for _, line := range []string{"1-1\n", "2-1\n", "1-2\n"} {
+			w := w1
+			if line[0] == '2' {
+				w = w2
+			}
+			_, err := w.Write([]byte(line))
+			require.NoError(t, err)
+		}
+		require.NoError(t, w1.Close())
+		require.NoError(t, w2.Close())
+
+		var out bytes.Buffer
+		require.NoError(t, DecryptFile(key, bytes.NewReader(buf.Bytes()), &out))
+		assert.Equal(t, "1-1\n2-1\n1-2\n", out.String())
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, err := newEncryptWriter(nopWriteCloser{&buf}, key)
+		require.NoError(t, err)
+		_, err = w.Write([]byte("hello"))
+		require.NoError(t, err)
+
+		// Close していない場合も、復号できたデータは書き込む
+		var out bytes.Buffer
+		assert.ErrorIs(t, DecryptFile(key, bytes.NewReader(buf.Bytes()), &out), ErrEncryptedFileTruncated)
+		assert.Equal(t, "hello", out.String())
+
+		// レコードの途中で終了した場合
+		out.Reset()
+		assert.ErrorIs(t, DecryptFile(key, bytes.NewReader(buf.Bytes()[:buf.Len()-1]), &out), ErrEncryptedFileTruncated)
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, err := newEncryptWriter(nopWriteCloser{&buf}, key)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		_, otherKey := writeEncryptionKeyFile(t)
+		assert.ErrorIs(t, DecryptFile(otherKey, bytes.NewReader(buf.Bytes()), io.Discard), ErrEncryptionKeyMismatch)
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, err := newEncryptWriter(nopWriteCloser{&buf}, key)
+		require.NoError(t, err)
+		_, err = w.Write([]byte("hello"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		data := buf.Bytes()
+		// 最初のデータのレコードの最後のバイトを変更する
+		headerRecordSize := encryptedRecordHeaderSize + len(encryptedFileMagic) + 1 + encryptedKeyIDSize + 12 + encryptionKeySize + 16
+		data[headerRecordSize+encryptedRecordHeaderSize+len("hello")+16-1] ^= 0xff
+		assert.Error(t, DecryptFile(key, bytes.NewReader(data), io.Discard))
+	})
+}
+
+func TestOggRecorderWithEncryption(t *testing.T) {
+	keyFile, key := writeEncryptionKeyFile(t)
+	oggDir := t.TempDir()
+	c := Config{
+		EnableOggFileOutput: true,
+		OggDir:              oggDir,
+		SampleRate:          48000,
+		ChannelCount:        1,
+		EncryptionKeyFile:   keyFile,
+	}
+	header := SoraHeader{
+		SoraSessionID:    "C2TFB1QBDS4WD5SX317SWMJ6FM",
+		SoraConnectionID: "1X0Z8JXZAD5A93X68M2S9NTC4G",
+	}
+
+	r, err := newOggRecorder(t.Context(), c, NewLocalStorage(), header, time.Now())
+	require.NoError(t, err)
+	for range 3 {
+		require.NoError(t, r.Write(silentPacket()))
+	}
+	require.NoError(t, r.Close())
+
+	// 暗号化したファイルは .enc を付与する
+	name := filepath.Join(oggDir, "C2TFB1QBDS4WD5SX317SWMJ6FM-1X0Z8JXZAD5A93X68M2S9NTC4G.ogg.enc")
+	encrypted, err := os.Open(name)
+	require.NoError(t, err)
+	defer encrypted.Close()
+
+	decrypted := filepath.Join(t.TempDir(), "decrypted.ogg")
+	f, err := os.Create(decrypted)
+	require.NoError(t, err)
+	require.NoError(t, DecryptFile(key, encrypted, f))
+	require.NoError(t, f.Close())
+
+	pages := readOggFileForTest(t, decrypted)
+	require.Len(t, pages, 5)
+	assert.Equal(t, silentPacket(), pages[4].Payload)
+}
+
+func TestPacketDumpHandlerWithEncryption(t *testing.T) {
+	keyFile, key := writeEncryptionKeyFile(t)
+	dumpFile := filepath.Join(t.TempDir(), "dump.jsonl")
+	c := Config{
+		DumpFile:          dumpFile,
+		EncryptionKeyFile: keyFile,
+	}
+
+	for _, connectionID := range []string{"conn-1", "conn-2"} {
+		h := NewPacketDumpHandler(c, "sora", connectionID, 48000, 1, "ja-JP", nil)
+
+		opusCh := make(chan Opus)
+		r, err := h.Handle(t.Context(), opusCh, SoraHeader{})
+		require.NoError(t, err)
+
+		go func() {
+			opusCh <- Opus{Payload: silentPacket()}
+			close(opusCh)
+		}()
+		_, err = io.ReadAll(r)
+		require.NoError(t, err)
+	}
+
+	// 暗号化していないダンプには書き込まない
+	_, err := os.Stat(dumpFile)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	encrypted, err := os.ReadFile(dumpFile + encryptedFileSuffix)
+	require.NoError(t, err)
+	assert.NotContains(t, string(encrypted), "conn-1")
+
+	var out bytes.Buffer
+	require.NoError(t, DecryptFile(key, bytes.NewReader(encrypted), &out))
+
+	decoder := json.NewDecoder(&out)
+	for _, connectionID := range []string{"conn-1", "conn-2"} {
+		var dump PacketDumpResult
+		require.NoError(t, decoder.Decode(&dump))
+		assert.Equal(t, connectionID, dump.ConnectionID)
+		assert.Equal(t, silentPacket(), dump.Payload)
+	}
+}
@@ -1,29 +1,255 @@
 package suzu
 
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/aws/smithy-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// クライアントに返すエラーの種類
+// type: error のメッセージの code とメトリクスのラベルに使用するため、値は変更しない
+type ErrorCode string
+
+const (
+	// 外部サービスの認証に失敗した
+	ErrorCodeProviderAuthFailed ErrorCode = "PROVIDER-AUTH-FAILED"
+	// 外部サービスの利用上限に達した
+	ErrorCodeQuotaExceeded ErrorCode = "QUOTA-EXCEEDED"
+	// チャネルの利用量の上限に達した
+	ErrorCodeUsageQuotaExceeded ErrorCode = "USAGE-QUOTA-EXCEEDED"
+	// 対応していない言語コードが指定された
+	ErrorCodeUnsupportedLanguage ErrorCode = "UNSUPPORTED-LANGUAGE"
+	// 受信したデータが大きすぎる
+	ErrorCodePayloadTooLarge ErrorCode = "PAYLOAD-TOO-LARGE"
+	// 外部サービスから切断された
+	ErrorCodeProviderDisconnected ErrorCode = "PROVIDER-DISCONNECTED"
+	// 外部サービスが利用できない
+	ErrorCodeProviderUnavailable ErrorCode = "PROVIDER-UNAVAILABLE"
+	// クライアントからのデータの受信がタイムアウトした
+	ErrorCodeClientTimeout ErrorCode = "CLIENT-TIMEOUT"
+	// 外部サービスへのリクエストの内容が不正
+	ErrorCodeInvalidRequest ErrorCode = "INVALID-REQUEST"
+	// 設定の不備
+	ErrorCodeConfiguration ErrorCode = "CONFIGURATION-ERROR"
+	// 上記以外のエラー
+	ErrorCodeInternal ErrorCode = "INTERNAL-ERROR"
+)
+
 type SuzuError struct {
 	Code    int
 	Message string
 	Retry   bool
+	// 未指定の場合は Code から判定する
+	ErrorCode ErrorCode
+	// 外部サービスから受信した元のエラー
+	Err error
 }
 
 func (e *SuzuError) Error() string {
 	return e.Message
 }
 
+func (e *SuzuError) Unwrap() error {
+	return e.Err
+}
+
 func (e *SuzuError) IsRetry() bool {
 	return e.Retry
 }
 
 type SuzuConfError struct {
-	Message string
+	Message   string
+	ErrorCode ErrorCode
 }
 
 func NewSuzuConfError(err error) *SuzuConfError {
+	errorCode := ErrorCodeConfiguration
+	if isUnsupportedLanguageError(err) {
+		errorCode = ErrorCodeUnsupportedLanguage
+	}
+
 	return &SuzuConfError{
-		Message: err.Error(),
+		Message:   err.Error(),
+		ErrorCode: errorCode,
 	}
 }
 
 func (e *SuzuConfError) Error() string {
 	return e.Message
 }
+
+// エラーメッセージを変更せずに ErrorCode を付与したエラー
+type codedError struct {
+	err  error
+	code ErrorCode
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// err に ErrorCode を付与する
+func WithErrorCode(err error, code ErrorCode) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{
+		err:  err,
+		code: code,
+	}
+}
+
+// エラーに対応する ErrorCode を返す
+func ErrorCodeOf(err error) ErrorCode {
+	if err == nil {
+		return ""
+	}
+
+	var ce *codedError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+
+	var suzuErr *SuzuError
+	if errors.As(err, &suzuErr) && suzuErr.ErrorCode != "" {
+		return suzuErr.ErrorCode
+	}
+
+	var suzuConfErr *SuzuConfError
+	if errors.As(err, &suzuConfErr) && suzuConfErr.ErrorCode != "" {
+		return suzuConfErr.ErrorCode
+	}
+
+	if isUnsupportedLanguageError(err) {
+		return ErrorCodeUnsupportedLanguage
+	}
+
+	if errors.Is(err, errPayloadTooLarge) {
+		return ErrorCodePayloadTooLarge
+	}
+
+	if errors.Is(err, ErrUsageQuotaExceeded) {
+		return ErrorCodeUsageQuotaExceeded
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return ErrorCodeClientTimeout
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		if code, ok := awsErrorCode(apiErr.ErrorCode()); ok {
+			return code
+		}
+	}
+
+	if st, ok := status.FromError(err); ok && st.Code() != codes.OK && st.Code() != codes.Unknown {
+		return grpcErrorCode(st.Code())
+	}
+
+	if suzuErr != nil {
+		return httpStatusErrorCode(suzuErr.Code)
+	}
+
+	if errors.Is(err, ErrServerDisconnected) {
+		return ErrorCodeProviderDisconnected
+	}
+
+	return ErrorCodeInternal
+}
+
+// クライアントが再接続することで復帰できる可能性があるエラーかどうかを返す
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// チャネルの利用量の上限に達した場合は、期間が変わるまで復帰しない
+	if errors.Is(err, ErrUsageQuotaExceeded) {
+		return false
+	}
+
+	var suzuErr *SuzuError
+	if errors.As(err, &suzuErr) && suzuErr.Retry {
+		return true
+	}
+
+	if errors.Is(err, ErrServerDisconnected) {
+		return true
+	}
+
+	switch ErrorCodeOf(err) {
+	case ErrorCodeQuotaExceeded, ErrorCodeProviderDisconnected, ErrorCodeProviderUnavailable:
+		return true
+	}
+
+	return false
+}
+
+func isUnsupportedLanguageError(err error) bool {
+	return errors.Is(err, ErrUnsupportedLanguageCode) ||
+		errors.Is(err, ErrMissingAudioStreamingLanguageCode) ||
+		errors.Is(err, ErrAzureUnsupportedLanguageCode)
+}
+
+// HTTP のステータスコードに対応する ErrorCode を返す
+func httpStatusErrorCode(code int) ErrorCode {
+	switch {
+	case code == http.StatusUnauthorized, code == http.StatusForbidden:
+		return ErrorCodeProviderAuthFailed
+	case code == http.StatusTooManyRequests:
+		return ErrorCodeQuotaExceeded
+	case code == http.StatusRequestEntityTooLarge:
+		return ErrorCodePayloadTooLarge
+	case code == http.StatusRequestTimeout:
+		return ErrorCodeClientTimeout
+	case code >= 400 && code < 500:
+		return ErrorCodeInvalidRequest
+	case code >= 500:
+		return ErrorCodeProviderUnavailable
+	}
+	return ErrorCodeInternal
+}
+
+// gRPC のステータスコードに対応する ErrorCode を返す
+func grpcErrorCode(code codes.Code) ErrorCode {
+	switch code {
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return ErrorCodeProviderAuthFailed
+	case codes.ResourceExhausted:
+		return ErrorCodeQuotaExceeded
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.NotFound:
+		return ErrorCodeInvalidRequest
+	case codes.OutOfRange, codes.Aborted:
+		// Speech-to-Text の場合は音声の長さの上限に達した場合に OutOfRange で切断される
+		return ErrorCodeProviderDisconnected
+	case codes.Unavailable, codes.Internal, codes.DeadlineExceeded:
+		return ErrorCodeProviderUnavailable
+	}
+	return ErrorCodeInternal
+}
+
+// Amazon Transcribe のエラーコードに対応する ErrorCode を返す
+func awsErrorCode(code string) (ErrorCode, bool) {
+	switch code {
+	case "UnrecognizedClientException", "AccessDeniedException", "InvalidSignatureException",
+		"ExpiredTokenException", "MissingAuthenticationTokenException":
+		return ErrorCodeProviderAuthFailed, true
+	case "LimitExceededException":
+		return ErrorCodeQuotaExceeded, true
+	case "BadRequestException", "ConflictException":
+		return ErrorCodeInvalidRequest, true
+	case "InternalFailureException", "ServiceUnavailableException":
+		return ErrorCodeProviderUnavailable, true
+	}
+	return "", false
+}
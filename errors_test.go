@@ -0,0 +1,68 @@
+package suzu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/transcribestreaming/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrorCodeOf(t *testing.T) {
+	testCases := []struct {
+		Name      string
+		Error     error
+		ErrorCode ErrorCode
+		Retryable bool
+	}{
+		{Name: "nil", Error: nil, ErrorCode: ""},
+		{Name: "unexpected error", Error: errors.New("UNEXPECTED-ERROR"), ErrorCode: ErrorCodeInternal},
+		{Name: "server disconnected", Error: errors.Join(errors.New("GOAWAY"), ErrServerDisconnected), ErrorCode: ErrorCodeProviderDisconnected, Retryable: true},
+		{Name: "payload too large", Error: fmt.Errorf("%w: %d", errPayloadTooLarge, MaxPayloadLength+1), ErrorCode: ErrorCodePayloadTooLarge},
+		{Name: "client timeout", Error: context.DeadlineExceeded, ErrorCode: ErrorCodeClientTimeout},
+		{Name: "unsupported language", Error: fmt.Errorf("%w: xx-XX", ErrUnsupportedLanguageCode), ErrorCode: ErrorCodeUnsupportedLanguage},
+		{Name: "conf error", Error: NewSuzuConfError(errors.New("INVALID-CONFIG")), ErrorCode: ErrorCodeConfiguration},
+		{Name: "conf error with unsupported language", Error: NewSuzuConfError(fmt.Errorf("%w: xx-XX", ErrAzureUnsupportedLanguageCode)), ErrorCode: ErrorCodeUnsupportedLanguage},
+		{Name: "http 401", Error: &SuzuError{Code: http.StatusUnauthorized}, ErrorCode: ErrorCodeProviderAuthFailed},
+		{Name: "http 429", Error: &SuzuError{Code: http.StatusTooManyRequests, Retry: true}, ErrorCode: ErrorCodeQuotaExceeded, Retryable: true},
+		{Name: "http 400", Error: &SuzuError{Code: http.StatusBadRequest}, ErrorCode: ErrorCodeInvalidRequest},
+		{Name: "http 503", Error: &SuzuError{Code: http.StatusServiceUnavailable}, ErrorCode: ErrorCodeProviderUnavailable, Retryable: true},
+		{Name: "suzu error with error code", Error: &SuzuError{Code: http.StatusInternalServerError, ErrorCode: ErrorCodeQuotaExceeded}, ErrorCode: ErrorCodeQuotaExceeded, Retryable: true},
+		{Name: "grpc unauthenticated", Error: status.Error(codes.Unauthenticated, "unauthenticated"), ErrorCode: ErrorCodeProviderAuthFailed},
+		{Name: "grpc resource exhausted", Error: status.Error(codes.ResourceExhausted, "quota"), ErrorCode: ErrorCodeQuotaExceeded, Retryable: true},
+		{Name: "grpc out of range", Error: status.Error(codes.OutOfRange, "too long"), ErrorCode: ErrorCodeProviderDisconnected, Retryable: true},
+		{Name: "aws limit exceeded", Error: &types.LimitExceededException{}, ErrorCode: ErrorCodeQuotaExceeded, Retryable: true},
+		{Name: "aws bad request", Error: &types.BadRequestException{}, ErrorCode: ErrorCodeInvalidRequest},
+		{Name: "usage quota exceeded", Error: fmt.Errorf("%w: daily", ErrUsageQuotaExceeded), ErrorCode: ErrorCodeUsageQuotaExceeded},
+		{Name: "with error code", Error: WithErrorCode(errors.New("message"), ErrorCodeProviderAuthFailed), ErrorCode: ErrorCodeProviderAuthFailed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.Equal(t, tc.ErrorCode, ErrorCodeOf(tc.Error))
+			assert.Equal(t, tc.Retryable, IsRetryableError(tc.Error))
+		})
+	}
+}
+
+func TestNewSuzuErrorResponse(t *testing.T) {
+	t.Run("error", func(t *testing.T) {
+		b, err := json.Marshal(NewSuzuErrorResponse(errors.New("UNEXPECTED-ERROR")))
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"code":"INTERNAL-ERROR","retryable":false,"reason":"UNEXPECTED-ERROR","type":"error"}`, string(b))
+	})
+
+	t.Run("provider and session id", func(t *testing.T) {
+		err := WithErrorCode(errors.New("DISCONNECTED"), ErrorCodeProviderDisconnected)
+		b, err := json.Marshal(newServiceErrorResponse(err, "aws", "session-id"))
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"code":"PROVIDER-DISCONNECTED","retryable":true,"provider":"aws","session_id":"session-id","reason":"DISCONNECTED","type":"error"}`, string(b))
+	})
+}
@@ -7,14 +7,17 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
-	"path"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/pion/rtp/codecs"
 	zlog "github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -31,32 +34,78 @@ type TranscriptionResult struct {
 	Message string `json:"message,omitempty"`
 	Reason  string `json:"reason,omitempty"`
 	Type    string `json:"type"`
+	// 個人情報をマスクした場合の種類と件数
+	Redactions []Redaction `json:"redactions,omitempty"`
+	// partial_result_mode が diff の場合の、前回の途中結果から置き換える位置（文字数）
+	ReplaceFrom *int `json:"replace_from,omitempty"`
+	// partial_result_mode が diff の場合の、置き換える途中結果の識別子
+	PartialID string `json:"partial_id,omitempty"`
 }
 
-func NewSuzuErrorResponse(err error) TranscriptionResult {
-	return TranscriptionResult{
-		Type:   "error",
-		Reason: err.Error(),
+// クライアントに返すエラーのメッセージ
+type ErrorResult struct {
+	Code      ErrorCode `json:"code"`
+	Retryable bool      `json:"retryable"`
+	// エラーが発生したサービス
+	Provider string `json:"provider,omitempty"`
+	// 外部サービス側のセッション ID
+	SessionID string `json:"session_id,omitempty"`
+	// enable_channel_split が有効な場合のチャネルの番号
+	ChannelIndex *int `json:"channel_index,omitempty"`
+	TranscriptionResult
+}
+
+func NewSuzuErrorResponse(err error) ErrorResult {
+	return ErrorResult{
+		Code:      ErrorCodeOf(err),
+		Retryable: IsRetryableError(err),
+		TranscriptionResult: TranscriptionResult{
+			Type:   "error",
+			Reason: err.Error(),
+		},
 	}
 }
 
-type soraHeader struct {
+// サービスのハンドラで発生したエラーのメッセージを返す
+func newServiceErrorResponse(err error, provider, sessionID string) ErrorResult {
+	errorResponse := NewSuzuErrorResponse(err)
+	errorResponse.WithProvider(provider)
+	errorResponse.WithSessionID(sessionID)
+	return errorResponse
+}
+
+func (er *ErrorResult) WithProvider(provider string) *ErrorResult {
+	er.Provider = provider
+	return er
+}
+
+func (er *ErrorResult) WithSessionID(sessionID string) *ErrorResult {
+	er.SessionID = sessionID
+	return er
+}
+
+func (er *ErrorResult) WithChannelIndex(channelIndex *int) *ErrorResult {
+	er.ChannelIndex = channelIndex
+	return er
+}
+
+type SoraHeader struct {
 	SoraChannelID string `header:"sora-channel-id"`
 	SoraSessionID string `header:"sora-session-id"`
 	// SoraClientID        string `header:"sora-client-id"`
-	SoraConnectionID string `header:"sora-connection-id"`
-	// SoraAudioCodecType  string `header:"sora-audio-codec-type"`
-	// SoraAudioSampleRate int64  `header:"sora-audio-sample-rate"`
+	SoraConnectionID               string `header:"sora-connection-id"`
+	SoraAudioCodecType             string `header:"sora-audio-codec-type"`
+	SoraAudioSampleRate            int64  `header:"sora-audio-sample-rate"`
 	SoraAudioStreamingLanguageCode string `header:"sora-audio-streaming-language-code"`
 }
 
-func getServiceHandler(serviceType string, config Config, channelID, connectionID string, sampleRate uint32, channelCount uint16, languageCode string, onResultFunc any) (serviceHandlerInterface, error) {
-	newHandlerFunc, err := NewServiceHandlerFuncs.get(serviceType)
+func getServiceHandler(serviceHandlers ServiceHandlers, serviceType string, config Config, channelID, connectionID string, sampleRate uint32, channelCount uint16, languageCode string, onResultFunc OnResultFunc) (ServiceHandler, error) {
+	newHandlerFunc, err := serviceHandlers.Get(serviceType)
 	if err != nil {
 		return nil, err
 	}
 
-	return (*newHandlerFunc)(config, channelID, connectionID, sampleRate, channelCount, languageCode, onResultFunc), nil
+	return newHandlerFunc(config, channelID, connectionID, sampleRate, channelCount, languageCode, onResultFunc), nil
 }
 
 // https://echo.labstack.com/cookbook/streaming-response/
@@ -64,8 +113,8 @@ func getServiceHandler(serviceType string, config Config, channelID, connectionI
 
 // https://github.com/herrberk/go-http2-streaming/blob/master/http2/server.go
 // 受信時はくるくるループを回す
-func (s *Server) createSpeechHandler(serviceType string, onResultFunc func(context.Context, io.WriteCloser, string, string, string, any) error) echo.HandlerFunc {
-	return func(c echo.Context) error {
+func (s *Server) createSpeechHandler(serviceType string, onResultFunc OnResultFunc) echo.HandlerFunc {
+	return func(c echo.Context) (retErr error) {
 		zlog.Debug().Msg("CONNECTING")
 		// http/2 じゃなかったらエラー
 		if c.Request().ProtoMajor != 2 {
@@ -74,246 +123,662 @@ func (s *Server) createSpeechHandler(serviceType string, onResultFunc func(conte
 			return echo.NewHTTPError(http.StatusBadRequest)
 		}
 
-		h := soraHeader{}
+		h := SoraHeader{}
 		if err := (&echo.DefaultBinder{}).BindHeaders(c, &h); err != nil {
 			zlog.Error().
 				Err(err).
 				Msg("INVALID-HEADER")
 			return echo.NewHTTPError(http.StatusBadRequest)
 		}
+
+		// Sora から受信した traceparent ヘッダを引き継いでセッションのスパンを開始する
+		sessionStartedAt := time.Now()
+		ctx := tracePropagator.Extract(c.Request().Context(), propagation.HeaderCarrier(c.Request().Header))
+		ctx, sessionSpan := tracer.Start(ctx, "session",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithTimestamp(sessionStartedAt),
+			trace.WithAttributes(
+				attribute.String("suzu.channel_id", h.SoraChannelID),
+				attribute.String("suzu.session_id", h.SoraSessionID),
+				attribute.String("suzu.connection_id", h.SoraConnectionID),
+				attribute.String("suzu.provider", serviceType),
+			),
+		)
 		defer func() {
-			zlog.Debug().
+			if retErr != nil {
+				recordSpanError(sessionSpan, retErr)
+			}
+			sessionSpan.End()
+		}()
+
+		// トレース ID が存在する場合はログに出力する
+		logger := zlog.Logger
+		if sc := sessionSpan.SpanContext(); sc.HasTraceID() {
+			logger = logger.With().Str("trace_id", sc.TraceID().String()).Logger()
+		}
+
+		defer func() {
+			logger.Debug().
 				Str("channel_id", h.SoraChannelID).
 				Str("connection_id", h.SoraConnectionID).
 				Msg("DISCONNECTED")
 		}()
 
-		languageCode, err := GetLanguageCode(serviceType, h.SoraAudioStreamingLanguageCode, nil)
+		// チャネルごとに接続するサービスと言語コード
+		// enable_channel_split が無効な場合は 1 つのみ
+		streams := newSpeechStreams(*s.config, serviceType, h)
+
+		// Content-Type ヘッダ、または、sora-audio-codec-type ヘッダから受信する音声の形式を決定する
+		format, err := negotiateInputFormat(c.Request().Header.Get(echo.HeaderContentType), h, *s.config)
+		// チャネルを分割する場合は PCM でサービスに送信する
+		pcmInput := format.Name == inputFormatLPCM || s.config.EnableChannelSplit
+		if err == nil && pcmInput {
+			for _, st := range streams {
+				if !acceptsLPCMInput(st.serviceType, *s.config) {
+					err = fmt.Errorf("%w: %s to %s", ErrUnsupportedInputFormat, format.Name, st.serviceType)
+					break
+				}
+			}
+		}
 		if err != nil {
-			zlog.Error().
+			logger.Warn().
 				Err(err).
 				Str("channel_id", h.SoraChannelID).
 				Str("connection_id", h.SoraConnectionID).
-				Send()
-			return echo.NewHTTPError(http.StatusInternalServerError)
+				Msg("UNSUPPORTED-INPUT-FORMAT")
+			return echo.NewHTTPError(http.StatusUnsupportedMediaType)
+		}
+		sessionSpan.SetAttributes(attribute.String("suzu.input_format", format.Name))
+
+		// サービスに接続する前にセッション数の上限を確認する
+		// チャネルを分割する場合も 1 つのセッションとして数え、サービスごとの上限のみ接続ごとに数える
+		providers := make([]string, 0, len(streams))
+		for _, st := range streams {
+			providers = append(providers, st.serviceType)
+		}
+		lease, err := s.sessionLimiter.Acquire(providers, h.SoraChannelID)
+		if err != nil {
+			logger.Warn().
+				Err(err).
+				Str("channel_id", h.SoraChannelID).
+				Str("connection_id", h.SoraConnectionID).
+				Strs("services", providers).
+				Msg("SESSION-LIMIT-EXCEEDED")
+			c.Response().Header().Set("Retry-After", strconv.Itoa(s.config.SessionLimitRetryAfterSec))
+			return echo.NewHTTPError(http.StatusServiceUnavailable)
+		}
+		defer lease.Release()
+
+		for _, st := range streams {
+			languageCode, err := GetLanguageCode(st.serviceType, st.requestedLanguageCode, s.languageCodeFuncs[st.serviceType])
+			if err != nil {
+				logger.Error().
+					Err(err).
+					Str("channel_id", h.SoraChannelID).
+					Str("connection_id", h.SoraConnectionID).
+					Send()
+				return echo.NewHTTPError(http.StatusInternalServerError)
+			}
+			st.languageCode = languageCode
 		}
+		languageCode := streams[0].languageCode
 
-		zlog.Debug().
+		logger.Debug().
 			Str("channel_id", h.SoraChannelID).
 			Str("connection_id", h.SoraConnectionID).
 			Str("language_code", h.SoraAudioStreamingLanguageCode).
 			Msg("CONNECTED")
 
+		sessionSpan.SetAttributes(attribute.String("suzu.language_code", languageCode))
+
+		metrics := newSessionMetrics(serviceType, languageCode)
+		defer metrics.Close()
+
 		c.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		w := newResponseWriter(c.Response())
+		// ハンドラの終了後は echo.Response に書き込まない
+		defer w.Close()
 
-		ctx := c.Request().Context()
 		// TODO: context.WithCancelCause(ctx) に変更する
 		ctx, cancel := context.WithCancel(ctx)
 		defer cancel()
 
-		// TODO: ヘッダから取得する
-		sampleRate := uint32(s.config.SampleRate)
-		channelCount := uint16(s.config.ChannelCount)
+		// チャネルの利用量が上限に達した場合に type: error のメッセージを返して終了する
+		usageQuotaExceeded := func(err error) error {
+			logger.Warn().
+				Err(err).
+				Str("channel_id", h.SoraChannelID).
+				Str("connection_id", h.SoraConnectionID).
+				Msg("USAGE-QUOTA-EXCEEDED")
 
-		// 読み込み時の追加処理のオプション関数指定
-		packetReaderOptions := newPacketReaderOptions(*s.config)
+			if err := w.WriteJSON(NewSuzuErrorResponse(err)); err != nil {
+				logger.Error().
+					Err(err).
+					Str("channel_id", h.SoraChannelID).
+					Str("connection_id", h.SoraConnectionID).
+					Send()
+				return err
+			}
+			return w.NoContent(http.StatusOK)
+		}
 
-		opusCh := newOpusChannel(ctx, *s.config, c.Request().Body, packetReaderOptions)
+		// サービスに接続する前にチャネルの利用量の上限を確認する
+		if err := s.usageLedger.CheckQuota(h.SoraChannelID); err != nil {
+			return usageQuotaExceeded(err)
+		}
+
+		// チャネルを分割する場合は、サービスへの接続ごとに利用量を記録する
+		for _, st := range streams {
+			usage := newSessionUsage(s.usageLedger, h, st.serviceType, st.languageCode)
+			defer func() {
+				// セッションの終了時に usage_ledger_file に利用量を書き込む
+				if err := usage.Close(); err != nil {
+					logger.Error().
+						Err(err).
+						Str("channel_id", h.SoraChannelID).
+						Str("connection_id", h.SoraConnectionID).
+						Send()
+				}
+			}()
+			st.usage = usage
+
+			// 利用量が上限に達した場合は、サービスとの接続を切断する
+			go func() {
+				select {
+				case <-ctx.Done():
+				case <-usage.Exceeded():
+					cancel()
+				}
+			}()
+		}
 
-		serviceHandler, err := getServiceHandler(serviceType, *s.config, h.SoraChannelID, h.SoraConnectionID, sampleRate, channelCount, languageCode, onResultFunc)
+		// sora-channel-id が同じ接続の最終結果を 1 つの文字起こしにまとめる
+		rm, err := s.rooms.Join(h.SoraChannelID, h.SoraConnectionID)
 		if err != nil {
-			zlog.Error().
+			logger.Error().
 				Err(err).
 				Str("channel_id", h.SoraChannelID).
 				Str("connection_id", h.SoraConnectionID).
 				Send()
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
+		defer func() {
+			if err := s.rooms.Leave(rm); err != nil {
+				logger.Error().
+					Err(err).
+					Str("channel_id", h.SoraChannelID).
+					Str("connection_id", h.SoraConnectionID).
+					Send()
+			}
+		}()
 
-		// サーバへの接続・結果の送信処理
-		// サーバへの再接続が期待できる限りは、再接続を試みる
-		for {
-			zlog.Info().
-				Str("channel_id", h.SoraChannelID).
-				Str("connection_id", h.SoraConnectionID).
-				Int("retry_count", serviceHandler.GetRetryCount()).
-				Msg("NEW-REQUEST")
+		// サービスへの再接続に関わらず、受信した音声をセッションごとに 1 つの Ogg ファイルに保存する
+		// LPCM の音声は Opus に変換できないため保存しない
+		var recorder *oggRecorder
+		if format.Name != inputFormatLPCM {
+			recorder, err = newOggRecorder(ctx, *s.config, s.storage, h, sessionStartedAt)
+			if err != nil {
+				logger.Error().
+					Err(err).
+					Str("channel_id", h.SoraChannelID).
+					Str("connection_id", h.SoraConnectionID).
+					Send()
+				return echo.NewHTTPError(http.StatusInternalServerError)
+			}
+		}
+		defer func() {
+			if err := recorder.Close(); err != nil {
+				logger.Error().
+					Err(err).
+					Str("channel_id", h.SoraChannelID).
+					Str("connection_id", h.SoraConnectionID).
+					Send()
+			}
+		}()
+
+		// TODO: ヘッダから取得する
+		sampleRate := uint32(s.config.SampleRate)
+		channelCount := uint16(s.config.ChannelCount)
+
+		// type: heartbeat での通知とメトリクスのため、受信した音声の長さを数える
+		counter := &audioCounter{metrics: metrics}
 
-			// リトライ時にこれ以降の処理のみを cancel する
-			serviceHandlerCtx, cancelServiceHandler := context.WithCancel(ctx)
-			defer cancelServiceHandler()
+		// 読み込み時の追加処理のオプション関数指定
+		receivedOptions := []packetReaderOption{}
+		// Opus のパケット以外の形式の場合は、音声の長さを数える前に Opus のパケットまたは PCM に変換する
+		if f := optionInputFormat(format); f != nil {
+			receivedOptions = append(receivedOptions, f)
+		}
+		// 受信した音声は無音の判定やサービスへの送信に関わらずすべて保存する
+		if f := optionRecordOgg(recorder); f != nil {
+			receivedOptions = append(receivedOptions, f)
+		}
+		receivedOptions = append(receivedOptions, optionCountAudio(counter))
+		if s.config.EnableVAD {
+			// 受信した音声の長さには無音と判定した音声も含めるため、音声の長さを数えた後に適用する
+			receivedOptions = append(receivedOptions, optionVoiceActivityDetection(metrics))
+		}
+		packetReaderOptions := newPacketReaderOptions(*s.config, receivedOptions...)
+		// 音声の受信から、最初のパケットをサービスに渡すまでをトレースに記録する
+		packetReaderOptions = append(packetReaderOptions, optionOnFirstPacket(func() {
+			start, ok := counter.FirstReceivedAt()
+			if !ok {
+				// 音声の受信前に無音パケットを渡した場合
+				start = sessionStartedAt
+			}
+			recordSpan(ctx, "first_audio_sent", start)
+		}))
+		// max_session_duration または max_idle_duration を超えた場合は音声の送信を終了する
+		timeout := newSessionTimeout(*s.config, sessionStartedAt, counter)
+		packetReaderOptions = append(packetReaderOptions, optionSessionTimeout(timeout))
+
+		opusCh := newOpusChannel(ctx, *s.config, c.Request().Body, packetReaderOptions)
 
-			reader, err := serviceHandler.Handle(serviceHandlerCtx, opusCh, h)
+		// ハートビートはセッションの終了まで送信する
+		// ハンドラの終了後にクライアントへ書き込まないよう、終了前にハートビートを止めて終了を待つ
+		heartbeatCtx, stopHeartbeat := context.WithCancel(ctx)
+		var heartbeatOnce sync.Once
+		var heartbeatWg sync.WaitGroup
+		defer func() {
+			stopHeartbeat()
+			heartbeatWg.Wait()
+		}()
+
+		// チャネルを分割する場合はモノラルの音声をサービスに送信する
+		streamChannelCount := channelCount
+		if s.config.EnableChannelSplit {
+			streamChannelCount = 1
+		}
+
+		// サービスに接続して結果をクライアントに送信する
+		// チャネルを分割する場合はチャネルごとに並行して呼び出す
+		serveStream := func(ctx context.Context, st *speechStream, opusCh chan Opus) error {
+			// サービスのハンドラで送信した音声の長さを記録するため、context に格納する
+			ctx = withSessionUsage(ctx, st.usage)
+			// サービスのハンドラで最終結果をルームの文字起こしに書き込むため、context に格納する
+			ctx = withRoomSpeaker(ctx, newRoomSpeaker(rm, h.SoraConnectionID, st.channelIndex))
+
+			serviceHandler, err := getServiceHandler(s.serviceHandlers, st.serviceType, *s.config, h.SoraChannelID, h.SoraConnectionID, sampleRate, streamChannelCount, st.languageCode, onResultFunc)
 			if err != nil {
-				// EOF の場合は、クライアントとの接続が切れたため終了
-				if errors.Is(err, io.EOF) {
-					return c.NoContent(http.StatusOK)
+				logger.Error().
+					Err(err).
+					Str("channel_id", h.SoraChannelID).
+					Str("connection_id", h.SoraConnectionID).
+					Send()
+				return echo.NewHTTPError(http.StatusInternalServerError)
+			}
+
+			// 現在接続しているサービス
+			currentServiceType := st.serviceType
+			firstResultSent := false
+
+			// enable_status_event が有効な場合に type: status のメッセージを送信する
+			sendStatus := func(status StatusResult) {
+				if !s.config.EnableStatusEvent {
+					return
+				}
+				status.WithChannelIndex(st.channelIndex)
+				// 接続前は HTTP ステータスコードで結果を返すため送信しない
+				if !w.Committed() {
+					return
 				}
+				if err := w.WriteJSON(status); err != nil {
+					logger.Error().
+						Err(err).
+						Str("channel_id", h.SoraChannelID).
+						Str("connection_id", h.SoraConnectionID).
+						Send()
+				}
+			}
 
-				// StopAudioStreaming API を実行せずに接続が切れた場合など
-				if errors.Is(err, context.Canceled) {
-					return c.NoContent(http.StatusOK)
+			// max_session_duration または max_idle_duration を超えて終了した場合は、
+			// enable_status_event の指定に関わらず type: status のメッセージで理由を通知する
+			sessionTimedOut := func() bool {
+				reason, ok := timeout.Reason()
+				if !ok {
+					return false
 				}
 
-				zlog.Error().
-					Err(err).
+				logger.Info().
 					Str("channel_id", h.SoraChannelID).
 					Str("connection_id", h.SoraConnectionID).
-					Send()
+					Str("service", currentServiceType).
+					Str("reason", reason).
+					Msg("SESSION-TIMEOUT")
+
+				status := NewStatusResult(statusSessionTimeout, currentServiceType)
+				status.WithReason(reason)
+				status.WithChannelIndex(st.channelIndex)
+				if err := w.WriteJSON(status); err != nil {
+					logger.Error().
+						Err(err).
+						Str("channel_id", h.SoraChannelID).
+						Str("connection_id", h.SoraConnectionID).
+						Send()
+				}
+				return true
+			}
 
-				if err, ok := err.(*SuzuError); ok {
-					if err.IsRetry() {
-						if s.config.MaxRetry > serviceHandler.GetRetryCount() {
-							serviceHandler.UpdateRetryCount()
+			// クライアントに返す type: error のメッセージに、接続しているサービスとセッション ID を付与する
+			newErrorResponse := func(err error) *ErrorResult {
+				errorResponse := NewSuzuErrorResponse(err)
+				errorResponse.WithProvider(currentServiceType)
+				errorResponse.WithChannelIndex(st.channelIndex)
+				if g, ok := serviceHandler.(SessionIDGetter); ok {
+					errorResponse.WithSessionID(g.GetSessionID())
+				}
+				return &errorResponse
+			}
 
-							// リトライ対象のエラーのため、クライアントとの接続は切らずにリトライする
-							retryTimer := time.NewTimer(time.Duration(s.config.RetryIntervalMs) * time.Millisecond)
+			// 再接続の回数をメトリクスとトレースに記録する
+			recordRetry := func(attempt int, code ErrorCode) {
+				metrics.CountRetry(currentServiceType, code)
+				sessionSpan.AddEvent("reconnecting", trace.WithAttributes(
+					attribute.String("suzu.provider", currentServiceType),
+					attribute.Int("suzu.attempt", attempt),
+					attribute.String("suzu.error_code", string(code)),
+				))
+			}
 
-						retry:
-							select {
-							case <-retryTimer.C:
-								zlog.Debug().
-									Err(err).
-									Str("channel_id", h.SoraChannelID).
-									Str("connection_id", h.SoraConnectionID).
-									Msg("retry")
-								reader.Close()
-								cancelServiceHandler()
-								continue
-							case _, ok := <-opusCh:
-								if ok {
-									// channel が閉じるか、または、リトライのタイマーが発火するまで繰り返す
-									goto retry
+			// サーバへの接続・結果の送信処理
+			// サーバへの再接続が期待できる限りは、再接続を試みる
+			for {
+				logger.Info().
+					Str("channel_id", h.SoraChannelID).
+					Str("connection_id", h.SoraConnectionID).
+					Int("retry_count", serviceHandler.GetRetryCount()).
+					Msg("NEW-REQUEST")
+
+				// リトライ時にこれ以降の処理のみを cancel する
+				serviceHandlerCtx, cancelServiceHandler := context.WithCancel(ctx)
+				defer cancelServiceHandler()
+
+				// サービスへの接続ごとにスパンを記録する
+				attemptCtx, attemptSpan := tracer.Start(serviceHandlerCtx, "provider.connect", trace.WithAttributes(
+					attribute.String("suzu.provider", currentServiceType),
+					attribute.Int("suzu.retry_count", serviceHandler.GetRetryCount()),
+				))
+				connectStartedAt := time.Now()
+				reader, err := serviceHandler.Handle(attemptCtx, opusCh, h)
+				if err == nil {
+					if g, ok := serviceHandler.(SessionIDGetter); ok {
+						attemptSpan.SetAttributes(attribute.String("suzu.provider_session_id", g.GetSessionID()))
+					}
+				} else if !errors.Is(err, io.EOF) && !errors.Is(err, context.Canceled) {
+					recordSpanError(attemptSpan, err)
+				}
+				attemptSpan.End()
+				if err != nil {
+					if err := st.usage.Err(); err != nil {
+						return usageQuotaExceeded(err)
+					}
+
+					// EOF の場合は、クライアントとの接続が切れたため終了
+					if errors.Is(err, io.EOF) {
+						sessionTimedOut()
+						return w.NoContent(http.StatusOK)
+					}
+
+					// StopAudioStreaming API を実行せずに接続が切れた場合など
+					if errors.Is(err, context.Canceled) {
+						return w.NoContent(http.StatusOK)
+					}
+
+					logger.Error().
+						Err(err).
+						Str("channel_id", h.SoraChannelID).
+						Str("connection_id", h.SoraConnectionID).
+						Send()
+					countError(currentServiceType, err)
+
+					if err, ok := err.(*SuzuError); ok {
+						// retry_rules_file のルールに一致した場合は、ルールに従ってリトライする
+						policy := newRetryPolicy(*s.config, currentServiceType, err, err.IsRetry())
+						if policy.Retry {
+							if policy.CanRetry(serviceHandler.GetRetryCount()) {
+								attempt := serviceHandler.UpdateRetryCount()
+								recordRetry(attempt, ErrorCodeOf(err))
+								status := NewStatusResult(statusReconnecting, currentServiceType)
+								status.WithAttempt(attempt)
+								sendStatus(status)
+
+								// リトライ対象のエラーのため、クライアントとの接続は切らずにリトライする
+								retryTimer := time.NewTimer(policy.Interval(attempt))
+
+							retry:
+								select {
+								case <-retryTimer.C:
+									logger.Debug().
+										Err(err).
+										Str("channel_id", h.SoraChannelID).
+										Str("connection_id", h.SoraConnectionID).
+										Msg("retry")
+									reader.Close()
+									cancelServiceHandler()
+									continue
+								case _, ok := <-opusCh:
+									if ok {
+										// channel が閉じるか、または、リトライのタイマーが発火するまで繰り返す
+										goto retry
+									}
+									retryTimer.Stop()
+									if sessionTimedOut() {
+										return w.NoContent(http.StatusOK)
+									}
+									logger.Debug().
+										Err(err).
+										Str("channel_id", h.SoraChannelID).
+										Str("connection_id", h.SoraConnectionID).
+										Msg("retry interrupted")
+									// リトライする前にクライアントとの接続でエラーが発生した場合は終了する
+									return fmt.Errorf("%s", "retry interrupted")
 								}
-								retryTimer.Stop()
-								zlog.Debug().
-									Err(err).
-									Str("channel_id", h.SoraChannelID).
-									Str("connection_id", h.SoraConnectionID).
-									Msg("retry interrupted")
-								// リトライする前にクライアントとの接続でエラーが発生した場合は終了する
-								return fmt.Errorf("%s", "retry interrupted")
 							}
 						}
+						// SuzuError の場合はその Status Code を返す
+						statusCode := err.Code
+						// Status Code として不正な値が設定されている場合は 500 にする
+						// 許容する範囲は 3 桁の整数とする（net/http の許容範囲）
+						if statusCode < 100 || statusCode > 999 {
+							logger.Error().
+								Int("status_code", statusCode).
+								Str("channel_id", h.SoraChannelID).
+								Str("connection_id", h.SoraConnectionID).
+								Msg("INVALID-STATUS-CODE")
+							statusCode = http.StatusInternalServerError
+						}
+						return w.NoContent(statusCode)
 					}
-					// SuzuError の場合はその Status Code を返す
-					statusCode := err.Code
-					// Status Code として不正な値が設定されている場合は 500 にする
-					// 許容する範囲は 3 桁の整数とする（net/http の許容範囲）
-					if statusCode < 100 || statusCode > 999 {
-						zlog.Error().
-							Int("status_code", statusCode).
-							Str("channel_id", h.SoraChannelID).
-							Str("connection_id", h.SoraConnectionID).
-							Msg("INVALID-STATUS-CODE")
-						statusCode = http.StatusInternalServerError
-					}
-					return c.NoContent(statusCode)
-				}
 
-				// SuzuConfError の場合は、設定不備等で復帰が困難な場合を想定しているため、
-				// type: error のエラーメッセージをクライアントに返して、リトライ対象から外す
-				var suzuConfErr *SuzuConfError
-				if errors.As(err, &suzuConfErr) {
-					errMessage, err := json.Marshal(NewSuzuErrorResponse(suzuConfErr))
-					if err != nil {
-						zlog.Error().
-							Err(err).
-							Str("channel_id", h.SoraChannelID).
-							Str("connection_id", h.SoraConnectionID).
-							Send()
-						return err
-					}
+					// SuzuConfError の場合は、設定不備等で復帰が困難な場合を想定しているため、
+					// type: error のエラーメッセージをクライアントに返して、リトライ対象から外す
+					var suzuConfErr *SuzuConfError
+					if errors.As(err, &suzuConfErr) {
+						errMessage, err := json.Marshal(newErrorResponse(suzuConfErr))
+						if err != nil {
+							logger.Error().
+								Err(err).
+								Str("channel_id", h.SoraChannelID).
+								Str("connection_id", h.SoraConnectionID).
+								Send()
+							return err
+						}
 
-					// 切断前にクライアントに type: error のエラーメッセージを返す
-					if _, err := c.Response().Write(errMessage); err != nil {
-						zlog.Error().
-							Err(err).
-							Str("channel_id", h.SoraChannelID).
-							Str("connection_id", h.SoraConnectionID).
-							Send()
-						return err
+						// 切断前にクライアントに type: error のエラーメッセージを返す
+						if _, err := w.Write(errMessage); err != nil {
+							logger.Error().
+								Err(err).
+								Str("channel_id", h.SoraChannelID).
+								Str("connection_id", h.SoraConnectionID).
+								Send()
+							return err
+						}
 					}
-					c.Response().Flush()
+
+					// SuzuError 以外の場合は 500 を返す
+					return echo.NewHTTPError(http.StatusInternalServerError, err)
+				}
+				defer reader.Close()
+				metrics.ObserveConnect(currentServiceType, time.Since(connectStartedAt))
+				// 外部サービスへの接続成功後にヘッダを送信する
+				w.Flush()
+
+				status := NewStatusResult(statusConnected, currentServiceType)
+				if g, ok := serviceHandler.(SessionIDGetter); ok {
+					status.WithSessionID(g.GetSessionID())
+				}
+				sendStatus(status)
+
+				if s.config.HeartbeatIntervalMs > 0 {
+					// チャネルを分割する場合も、セッションごとに 1 つのみ送信する
+					heartbeatOnce.Do(func() {
+						heartbeatWg.Add(1)
+						go func() {
+							defer heartbeatWg.Done()
+							sendHeartbeat(heartbeatCtx, w, counter, time.Duration(s.config.HeartbeatIntervalMs)*time.Millisecond)
+						}()
+					})
 				}
 
-				// SuzuError 以外の場合は 500 を返す
-				return echo.NewHTTPError(http.StatusInternalServerError, err)
-			}
-			defer reader.Close()
-			// 外部サービスへの接続成功後にヘッダを送信する
-			c.Response().Flush()
+				// チャネルを分割する場合は、サービスのハンドラが送信するメッセージに channel_index を付与する
+				results := io.ReadCloser(reader)
+				if st.channelIndex != nil {
+					results = withChannelIndex(reader, *st.channelIndex)
+					defer results.Close()
+				}
 
-			for {
-				buf := make([]byte, FrameSize)
-				n, err := reader.Read(buf)
-				if err != nil {
-					if errors.Is(err, io.EOF) {
-						return c.NoContent(http.StatusOK)
-					} else if strings.Contains(err.Error(), "client disconnected") {
-						// http.http2errClientDisconnected を使用したエラーの場合は、クライアントから切断されたため終了
-						// TODO: エラーレベルを見直す
-						zlog.Error().
-							Err(err).
-							Str("channel_id", h.SoraChannelID).
-							Str("connection_id", h.SoraConnectionID).
-							Send()
-						return err
-					} else if errors.Is(err, ErrServerDisconnected) {
-						errs := err.(interface{ Unwrap() []error }).Unwrap()
-						// 元の err を取得する
-						err := errs[0]
-
-						if s.config.MaxRetry < 1 {
-							// サーバから切断されたが再接続させない設定の場合
-							zlog.Error().
-								Err(ErrServerDisconnected).
+				for {
+					buf := make([]byte, FrameSize)
+					n, err := results.Read(buf)
+					if err != nil {
+						if err := st.usage.Err(); err != nil {
+							return usageQuotaExceeded(err)
+						}
+
+						// ErrServerDisconnected の場合、または、retry_rules_file のルールに一致した場合は再接続する
+						policy := newRetryPolicy(*s.config, currentServiceType, err, errors.Is(err, ErrServerDisconnected))
+
+						if errors.Is(err, io.EOF) {
+							// 上限を超えた場合は、最後の結果を送信した後に終了した理由を通知する
+							if !sessionTimedOut() {
+								sendStatus(NewStatusResult(statusEndOfStream, currentServiceType))
+							}
+							return w.NoContent(http.StatusOK)
+						} else if strings.Contains(err.Error(), "client disconnected") {
+							// http.http2errClientDisconnected を使用したエラーの場合は、クライアントから切断されたため終了
+							// TODO: エラーレベルを見直す
+							logger.Error().
 								Err(err).
 								Str("channel_id", h.SoraChannelID).
 								Str("connection_id", h.SoraConnectionID).
 								Send()
+							return err
+						} else if policy.Retry {
+							countError(currentServiceType, err)
+							// 元の err ではなく ErrServerDisconnected を含めて判定した ErrorCode をクライアントに返す
+							errorCode := ErrorCodeOf(err)
+
+							// 元の err を取得する
+							if errs, ok := err.(interface{ Unwrap() []error }); ok && errors.Is(err, ErrServerDisconnected) {
+								err = errs.Unwrap()[0]
+							}
 
-							errMessage, err := json.Marshal(NewSuzuErrorResponse(err))
-							if err != nil {
-								zlog.Error().
+							if policy.MaxRetry < 1 {
+								// サーバから切断されたが再接続させない設定の場合
+								logger.Error().
+									Err(ErrServerDisconnected).
 									Err(err).
 									Str("channel_id", h.SoraChannelID).
 									Str("connection_id", h.SoraConnectionID).
 									Send()
-								return err
+
+								errMessage, err := json.Marshal(newErrorResponse(WithErrorCode(err, errorCode)))
+								if err != nil {
+									logger.Error().
+										Err(err).
+										Str("channel_id", h.SoraChannelID).
+										Str("connection_id", h.SoraConnectionID).
+										Send()
+									return err
+								}
+
+								if _, err := w.Write(errMessage); err != nil {
+									logger.Error().
+										Err(err).
+										Str("channel_id", h.SoraChannelID).
+										Str("connection_id", h.SoraConnectionID).
+										Send()
+									return err
+								}
+								return ErrServerDisconnected
 							}
 
-							if _, err := c.Response().Write(errMessage); err != nil {
-								zlog.Error().
+							if policy.CanRetry(serviceHandler.GetRetryCount()) {
+								// サーバから切断されたが再度接続できる可能性があるため、接続を試みる
+
+								attempt := serviceHandler.UpdateRetryCount()
+								recordRetry(attempt, errorCode)
+								status := NewStatusResult(statusReconnecting, currentServiceType)
+								status.WithAttempt(attempt)
+								sendStatus(status)
+
+								reader.Close()
+								cancelServiceHandler()
+
+								// retry_rules_file でリトライ間隔が指定されている場合は待ってから再接続する
+								if policy.HasBackoff() {
+									retryTimer := time.NewTimer(policy.Interval(attempt))
+									select {
+									case <-ctx.Done():
+										retryTimer.Stop()
+										return w.NoContent(http.StatusOK)
+									case <-retryTimer.C:
+									}
+								}
+								break
+							} else {
+								logger.Error().
 									Err(err).
 									Str("channel_id", h.SoraChannelID).
 									Str("connection_id", h.SoraConnectionID).
 									Send()
-								return err
-							}
-							c.Response().Flush()
-							return ErrServerDisconnected
-						}
 
-						if s.config.MaxRetry > serviceHandler.GetRetryCount() {
-							// サーバから切断されたが再度接続できる可能性があるため、接続を試みる
+								errMessage, err := json.Marshal(newErrorResponse(WithErrorCode(err, errorCode)))
+								if err != nil {
+									logger.Error().
+										Err(err).
+										Str("channel_id", h.SoraChannelID).
+										Str("connection_id", h.SoraConnectionID).
+										Send()
+									return err
+								}
 
-							serviceHandler.UpdateRetryCount()
+								if _, err := w.Write(errMessage); err != nil {
+									logger.Error().
+										Err(err).
+										Str("channel_id", h.SoraChannelID).
+										Str("connection_id", h.SoraConnectionID).
+										Send()
+									return err
+								}
 
-							// TODO: 必要な場合は連続のリトライを避けるために少し待つ処理を追加する
-							reader.Close()
-							cancelServiceHandler()
-							break
+								// max_retry を超えた場合は終了
+								return w.NoContent(http.StatusOK)
+							}
 						} else {
-							zlog.Error().
+							logger.Debug().
 								Err(err).
 								Str("channel_id", h.SoraChannelID).
 								Str("connection_id", h.SoraConnectionID).
 								Send()
 
-							errMessage, err := json.Marshal(NewSuzuErrorResponse(err))
+							orgErr := err
+							countError(currentServiceType, err)
+
+							// サーバから切断されたが再度の接続が期待できないため type: error のエラーメッセージをクライアントに送信する
+							errMessage, err := json.Marshal(newErrorResponse(err))
 							if err != nil {
-								zlog.Error().
+								logger.Error().
 									Err(err).
 									Str("channel_id", h.SoraChannelID).
 									Str("connection_id", h.SoraConnectionID).
@@ -321,32 +786,23 @@ func (s *Server) createSpeechHandler(serviceType string, onResultFunc func(conte
 								return err
 							}
 
-							if _, err := c.Response().Write(errMessage); err != nil {
-								zlog.Error().
+							if _, err := w.Write(errMessage); err != nil {
+								logger.Error().
 									Err(err).
 									Str("channel_id", h.SoraChannelID).
 									Str("connection_id", h.SoraConnectionID).
 									Send()
 								return err
 							}
-							c.Response().Flush()
 
-							// max_retry を超えた場合は終了
-							return c.NoContent(http.StatusOK)
+							return orgErr
 						}
-					} else {
-						zlog.Debug().
-							Err(err).
-							Str("channel_id", h.SoraChannelID).
-							Str("connection_id", h.SoraConnectionID).
-							Send()
-
-						orgErr := err
+					}
 
-						// サーバから切断されたが再度の接続が期待できないため type: error のエラーメッセージをクライアントに送信する
-						errMessage, err := json.Marshal(NewSuzuErrorResponse(err))
-						if err != nil {
-							zlog.Error().
+					// メッセージが空でない場合はクライアントに結果を送信する
+					if n > 0 {
+						if _, err := w.Write(buf[:n]); err != nil {
+							logger.Error().
 								Err(err).
 								Str("channel_id", h.SoraChannelID).
 								Str("connection_id", h.SoraConnectionID).
@@ -354,73 +810,90 @@ func (s *Server) createSpeechHandler(serviceType string, onResultFunc func(conte
 							return err
 						}
 
-						if _, err := c.Response().Write(errMessage); err != nil {
-							zlog.Error().
-								Err(err).
-								Str("channel_id", h.SoraChannelID).
-								Str("connection_id", h.SoraConnectionID).
-								Send()
-							return err
+						// 音声の受信開始から最初の結果を送信するまでの時間を記録する
+						if !firstResultSent {
+							if receivedAt, ok := counter.FirstReceivedAt(); ok {
+								firstResultSent = true
+								metrics.ObserveFirstResult(currentServiceType, time.Since(receivedAt))
+								recordSpan(ctx, "first_result", receivedAt, attribute.String("suzu.provider", currentServiceType))
+							}
 						}
-						c.Response().Flush()
-
-						return orgErr
 					}
 				}
+			}
+		}
 
-				// メッセージが空でない場合はクライアントに結果を送信する
-				if n > 0 {
-					if _, err := c.Response().Write(buf[:n]); err != nil {
-						zlog.Error().
-							Err(err).
-							Str("channel_id", h.SoraChannelID).
-							Str("connection_id", h.SoraConnectionID).
-							Send()
-						return err
-					}
-					c.Response().Flush()
+		if !s.config.EnableChannelSplit {
+			return serveStream(ctx, streams[0], opusCh)
+		}
+
+		// チャネルごとにサービスに接続し、いずれかのチャネルでエラーが発生した場合は他のチャネルも終了する
+		streamCtxs := make([]context.Context, len(streams))
+		for i := range streams {
+			streamCtx, cancelStream := context.WithCancel(ctx)
+			defer cancelStream()
+			streamCtxs[i] = streamCtx
+		}
+
+		channelChs, err := splitChannels(ctx, opusCh, streamCtxs)
+		if err != nil {
+			logger.Error().
+				Err(err).
+				Str("channel_id", h.SoraChannelID).
+				Str("connection_id", h.SoraConnectionID).
+				Send()
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		errs := make([]error, len(streams))
+		var wg sync.WaitGroup
+		for i, st := range streams {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = serveStream(streamCtxs[i], st, channelChs[i])
+				if errs[i] != nil {
+					cancel()
 				}
+			}()
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			if err != nil {
+				return err
 			}
 		}
+		return nil
 	}
 }
 
-func opus2ogg(ctx context.Context, opusCh chan opus, sampleRate uint32, channelCount uint16, c Config, header soraHeader) (io.ReadCloser, error) {
+func opus2ogg(ctx context.Context, opusCh chan Opus, sampleRate uint32, channelCount uint16) (io.ReadCloser, error) {
+	// 最初の音声データを Ogg に変換するまでをトレースに記録する
+	_, oggSpan := tracer.Start(ctx, "ogg_conversion.start", trace.WithAttributes(
+		attribute.Int("suzu.sample_rate", int(sampleRate)),
+		attribute.Int("suzu.channel_count", int(channelCount)),
+	))
+
 	oggReader, oggWriter := io.Pipe()
 
 	// コンテキストが閉じられたときに oggWriter を閉じる
 	closeOnDone(ctx, oggWriter)
 
-	writers := []io.Writer{}
-
-	var f *os.File
-	if c.EnableOggFileOutput {
-		fileName := fmt.Sprintf("%s-%s.ogg", header.SoraSessionID, header.SoraConnectionID)
-		filePath := path.Join(c.OggDir, fileName)
-
-		var err error
-		f, err = os.Create(filePath)
-		if err != nil {
-			return nil, err
-		}
-		writers = append(writers, f)
-	}
-	writers = append(writers, oggWriter)
-
-	multiWriter := io.MultiWriter(writers...)
+	// サービスに送信した音声の長さを利用量として記録する
+	usage := sessionUsageFromContext(ctx)
 
 	go func() {
-		o, err := NewWithoutHeader(multiWriter, sampleRate, channelCount)
+		// 最初の音声データを書き込む前に終了した場合
+		defer oggSpan.End()
+
+		o, err := NewWithoutHeader(oggWriter, sampleRate, channelCount)
 		if err != nil {
 			oggWriter.CloseWithError(err)
 			return
 		}
 		defer o.Close()
 
-		if c.EnableOggFileOutput {
-			o.fd = f
-		}
-
 		// 最初の音声データの受信時に、Ogg ヘッダを書き込み、その後に音声データを書き込む
 		select {
 		case <-ctx.Done():
@@ -454,6 +927,8 @@ func opus2ogg(ctx context.Context, opusCh chan opus, sampleRate uint32, channelC
 				oggWriter.CloseWithError(err)
 				return
 			}
+			oggSpan.End()
+			usage.AddSamples(o.Samples())
 		}
 
 		// 以降は受信した音声データを書き込む
@@ -480,10 +955,12 @@ func opus2ogg(ctx context.Context, opusCh chan opus, sampleRate uint32, channelC
 					return
 				}
 
+				samples := o.Samples()
 				if err := o.Write(&opusPacket); err != nil {
 					oggWriter.CloseWithError(err)
 					return
 				}
+				usage.AddSamples(o.Samples() - samples)
 			}
 		}
 	}()
@@ -491,14 +968,16 @@ func opus2ogg(ctx context.Context, opusCh chan opus, sampleRate uint32, channelC
 	return oggReader, nil
 }
 
-// opus データを格納する構造体
-type opus struct {
+// Opus データを格納する構造体
+type Opus struct {
 	Payload []byte
-	Err     error
+	// LPCM の音声を受信した場合は Payload の代わりに格納する
+	PCM *PCMFrame
+	Err error
 }
 
 // 受信した Payload を読み込み、オプション関数に従った opus データを受け取る channel を返す
-func newOpusChannel(ctx context.Context, c Config, r io.ReadCloser, fs []packetReaderOption) chan opus {
+func newOpusChannel(ctx context.Context, c Config, r io.ReadCloser, fs []packetReaderOption) chan Opus {
 	// 受信した Payload を読み込み、読み込んだデータを受け取る channel を返す
 	packetCh := readPacket(ctx, r)
 	opusCh := packetCh
@@ -512,8 +991,8 @@ func newOpusChannel(ctx context.Context, c Config, r io.ReadCloser, fs []packetR
 }
 
 // 受信した Payload を読み込み、読み込んだデータを受け取る channel を返す
-func readPacket(ctx context.Context, opusReader io.ReadCloser) chan opus {
-	ch := make(chan opus)
+func readPacket(ctx context.Context, opusReader io.ReadCloser) chan Opus {
+	ch := make(chan Opus)
 
 	// コンテキストが閉じられたときに opusReader を閉じる
 	closeOnDone(ctx, opusReader)
@@ -534,7 +1013,7 @@ func readPacket(ctx context.Context, opusReader io.ReadCloser) chan opus {
 				select {
 				case <-ctx.Done():
 					return
-				case ch <- opus{Err: err}:
+				case ch <- Opus{Err: err}:
 					return
 				}
 			}
@@ -545,7 +1024,7 @@ func readPacket(ctx context.Context, opusReader io.ReadCloser) chan opus {
 				select {
 				case <-ctx.Done():
 					return
-				case ch <- opus{Payload: payload}:
+				case ch <- Opus{Payload: payload}:
 				}
 			}
 		}
@@ -558,9 +1037,22 @@ func silentPacket() []byte {
 	return []byte{252, 255, 254}
 }
 
-func opusChannelToIOReadCloser(ctx context.Context, ch <-chan opus) io.ReadCloser {
+// 無音パケットと同じ 20ms の LPCM の無音を返す
+func silentPCMFrame(sampleRate, channelCount int) *PCMFrame {
+	frames := sampleRate * int(lpcmFrameDuration/time.Millisecond) / 1000
+	return &PCMFrame{
+		Samples:      make([]int16, frames*channelCount),
+		SampleRate:   sampleRate,
+		ChannelCount: channelCount,
+	}
+}
+
+func opusChannelToIOReadCloser(ctx context.Context, ch <-chan Opus) io.ReadCloser {
 	r, w := io.Pipe()
 
+	// サービスに送信した音声の長さを利用量として記録する
+	usage := sessionUsageFromContext(ctx)
+
 	// コンテキストが閉じられたときに writer を閉じる
 	closeOnDone(ctx, w)
 
@@ -582,11 +1074,22 @@ func opusChannelToIOReadCloser(ctx context.Context, ch <-chan opus) io.ReadClose
 					return
 				}
 
+				// LPCM の音声は 16 bit リトルエンディアンの PCM を書き込む
+				if opus.PCM != nil {
+					if _, err := w.Write(pcmBytes(opus.PCM.Samples)); err != nil {
+						w.CloseWithError(err)
+						return
+					}
+					usage.AddPCM(opus.PCM)
+					continue
+				}
+
 				_, err := w.Write(opus.Payload)
 				if err != nil {
 					w.CloseWithError(err)
 					return
 				}
+				usage.AddOpusPacket(opus.Payload)
 			}
 		}
 	}()
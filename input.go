@@ -0,0 +1,352 @@
+package suzu
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+const (
+	// クライアントから受信する音声の形式
+	inputFormatOpus = "opus"
+	inputFormatOgg  = "ogg"
+	inputFormatRTP  = "rtp"
+	inputFormatLPCM = "lpcm"
+
+	// LPCM の音声を区切る長さ
+	lpcmFrameDuration = 20 * time.Millisecond
+
+	// RFC 4571 のフレームの長さのフィールドのバイト数
+	rtpFrameLengthSize = 2
+)
+
+var (
+	ErrUnsupportedInputFormat = fmt.Errorf("UNSUPPORTED-INPUT-FORMAT")
+)
+
+// クライアントから受信する音声の形式
+type inputFormat struct {
+	Name string
+
+	// LPCM の場合のみ使用する
+	SampleRate   int
+	ChannelCount int
+	BigEndian    bool
+}
+
+// Content-Type ヘッダ、または、sora-audio-codec-type ヘッダから受信する音声の形式を決定する
+// Content-Type ヘッダを優先し、指定がない場合や application/octet-stream の場合は sora-audio-codec-type ヘッダを使用する
+// 以前は Content-Type ヘッダを参照していなかったため、対応していない Content-Type の場合も sora-audio-codec-type ヘッダを使用する
+func negotiateInputFormat(contentType string, h SoraHeader, c Config) (inputFormat, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if contentType != "" && err == nil {
+		switch strings.ToLower(mediaType) {
+		case "audio/opus":
+			return inputFormat{Name: inputFormatOpus}, nil
+		case "audio/ogg", "application/ogg":
+			if codecs, ok := params["codecs"]; ok && !strings.EqualFold(codecs, "opus") {
+				return inputFormat{}, fmt.Errorf("%w: %s", ErrUnsupportedInputFormat, contentType)
+			}
+			return inputFormat{Name: inputFormatOgg}, nil
+		case "application/rtp":
+			return inputFormat{Name: inputFormatRTP}, nil
+		case "audio/pcm":
+			// 指定がない場合はリトルエンディアンとする
+			bigEndian := false
+			switch strings.ToLower(params["encoding"]) {
+			case "", "s16le":
+			case "s16be":
+				bigEndian = true
+			default:
+				return inputFormat{}, fmt.Errorf("%w: %s", ErrUnsupportedInputFormat, contentType)
+			}
+			return newLPCMInputFormat(params, bigEndian, h, c)
+		case "audio/l16":
+			// audio/L16 はビッグエンディアン
+			// https://datatracker.ietf.org/doc/html/rfc2586
+			return newLPCMInputFormat(params, true, h, c)
+		}
+	}
+
+	switch strings.ToUpper(h.SoraAudioCodecType) {
+	case "", "OPUS":
+		return inputFormat{Name: inputFormatOpus}, nil
+	case "LPCM":
+		return newLPCMInputFormat(nil, false, h, c)
+	}
+	return inputFormat{}, fmt.Errorf("%w: %s", ErrUnsupportedInputFormat, h.SoraAudioCodecType)
+}
+
+// rate と channels の指定がない場合は sora-audio-sample-rate ヘッダ、または、audio_sample_rate と audio_channel_count を使用する
+func newLPCMInputFormat(params map[string]string, bigEndian bool, h SoraHeader, c Config) (inputFormat, error) {
+	format := inputFormat{
+		Name:         inputFormatLPCM,
+		SampleRate:   c.SampleRate,
+		ChannelCount: c.ChannelCount,
+		BigEndian:    bigEndian,
+	}
+	if h.SoraAudioSampleRate > 0 {
+		format.SampleRate = int(h.SoraAudioSampleRate)
+	}
+
+	if v, ok := params["rate"]; ok {
+		rate, err := strconv.Atoi(v)
+		if err != nil {
+			return inputFormat{}, fmt.Errorf("%w: rate=%s", ErrUnsupportedInputFormat, v)
+		}
+		format.SampleRate = rate
+	}
+	if v, ok := params["channels"]; ok {
+		channels, err := strconv.Atoi(v)
+		if err != nil {
+			return inputFormat{}, fmt.Errorf("%w: channels=%s", ErrUnsupportedInputFormat, v)
+		}
+		format.ChannelCount = channels
+	}
+
+	if format.SampleRate < 8000 || format.SampleRate > opusMaxSampleRate {
+		return inputFormat{}, fmt.Errorf("%w: rate=%d", ErrUnsupportedInputFormat, format.SampleRate)
+	}
+	if format.ChannelCount != 1 && format.ChannelCount != 2 {
+		return inputFormat{}, fmt.Errorf("%w: channels=%d", ErrUnsupportedInputFormat, format.ChannelCount)
+	}
+
+	return format, nil
+}
+
+// LPCM の音声は Opus に変換できないため、PCM の音声を送信するサービスのみ受け付ける
+func acceptsLPCMInput(serviceType string, c Config) bool {
+	switch serviceType {
+	case "aws", "awsv2":
+		return c.AwsAudioFormat == audioFormatPCM
+	case "gcp":
+		return c.GcpAudioFormat == audioFormatPCM
+	case "azure":
+		return c.AzureAudioFormat == audioFormatPCM
+	case "local":
+		return c.LocalAudioFormat == localAudioFormatPCM
+	case "test":
+		return true
+	}
+	return false
+}
+
+// LPCM の音声
+// Opus の PCM に格納して、サービスのハンドラに渡す
+type PCMFrame struct {
+	// インターリーブした 16 bit PCM
+	Samples      []int16
+	SampleRate   int
+	ChannelCount int
+}
+
+// 音声の長さを返す
+func (o Opus) Duration() time.Duration {
+	if o.PCM != nil {
+		frames := len(o.PCM.Samples) / o.PCM.ChannelCount
+		return time.Duration(frames) * time.Second / time.Duration(o.PCM.SampleRate)
+	}
+	return opusPacketDuration(o.Payload)
+}
+
+// 受信した音声のバイト数を返す
+func (o Opus) Size() int {
+	if o.PCM != nil {
+		return len(o.PCM.Samples) * 2
+	}
+	return len(o.Payload)
+}
+
+// 受信したデータから音声を取り出す
+type inputParser interface {
+	// 終端に達した場合は io.EOF を返す
+	Next() (Opus, error)
+}
+
+func (f inputFormat) newParser(r io.Reader) inputParser {
+	switch f.Name {
+	case inputFormatOgg:
+		return &oggInputParser{r: newOggReader(r)}
+	case inputFormatRTP:
+		return &rtpInputParser{r: r}
+	case inputFormatLPCM:
+		return newLPCMInputParser(r, f)
+	}
+	return nil
+}
+
+// 受信した音声の形式が Opus のパケット以外の場合に、Opus のパケットまたは PCM に変換するオプション関数を返す
+// Opus のパケットの場合は nil を返す
+func optionInputFormat(f inputFormat) packetReaderOption {
+	if f.Name == inputFormatOpus || f.Name == "" {
+		return nil
+	}
+
+	return func(ctx context.Context, c Config, opusCh chan Opus) chan Opus {
+		r, w := io.Pipe()
+
+		// コンテキストが閉じられたときに読み込みを終了する
+		closeOnDone(ctx, r)
+
+		// 受信したデータは読み込みの区切りに関わらず連続したデータとして扱う
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					w.CloseWithError(ctx.Err())
+					return
+				case req, ok := <-opusCh:
+					if !ok {
+						w.CloseWithError(io.EOF)
+						return
+					}
+					if req.Err != nil {
+						w.CloseWithError(req.Err)
+						return
+					}
+					if _, err := w.Write(req.Payload); err != nil {
+						return
+					}
+				}
+			}
+		}()
+
+		ch := make(chan Opus)
+		parser := f.newParser(r)
+
+		go func() {
+			defer close(ch)
+			// 変換できないデータを受信した場合に、受信したデータの書き込みを終了する
+			defer r.Close()
+
+			for {
+				opus, err := parser.Next()
+				if err != nil {
+					// 途中で終了したデータは破棄する
+					if errors.Is(err, io.ErrUnexpectedEOF) {
+						err = io.EOF
+					}
+					opus = Opus{Err: err}
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case ch <- opus:
+				}
+
+				if err != nil {
+					return
+				}
+			}
+		}()
+
+		return ch
+	}
+}
+
+type oggInputParser struct {
+	r *oggReader
+}
+
+func (p *oggInputParser) Next() (Opus, error) {
+	packet, err := p.r.ReadPacket()
+	if err != nil {
+		return Opus{}, err
+	}
+	return Opus{Payload: packet}, nil
+}
+
+// RFC 4571 の 2 バイトの長さに続く RTP パケットを読み込み、Opus のパケットを取り出す
+// 順序が入れ替わったパケットと重複したパケットは破棄する
+// https://datatracker.ietf.org/doc/html/rfc4571
+type rtpInputParser struct {
+	r io.Reader
+
+	started        bool
+	sequenceNumber uint16
+}
+
+func (p *rtpInputParser) Next() (Opus, error) {
+	for {
+		length := make([]byte, rtpFrameLengthSize)
+		if _, err := io.ReadFull(p.r, length); err != nil {
+			return Opus{}, err
+		}
+
+		buf := make([]byte, binary.BigEndian.Uint16(length))
+		if _, err := io.ReadFull(p.r, buf); err != nil {
+			return Opus{}, err
+		}
+
+		packet := rtp.Packet{}
+		if err := packet.Unmarshal(buf); err != nil {
+			return Opus{}, err
+		}
+
+		if p.started && int16(packet.SequenceNumber-p.sequenceNumber) <= 0 {
+			continue
+		}
+		p.started = true
+		p.sequenceNumber = packet.SequenceNumber
+
+		if len(packet.Payload) == 0 {
+			continue
+		}
+		return Opus{Payload: packet.Payload}, nil
+	}
+}
+
+// LPCM の音声を lpcmFrameDuration ごとに区切る
+type lpcmInputParser struct {
+	r      io.Reader
+	format inputFormat
+	buf    []byte
+}
+
+func newLPCMInputParser(r io.Reader, f inputFormat) *lpcmInputParser {
+	frames := f.SampleRate * int(lpcmFrameDuration/time.Millisecond) / 1000
+	return &lpcmInputParser{
+		r:      r,
+		format: f,
+		buf:    make([]byte, frames*f.ChannelCount*2),
+	}
+}
+
+func (p *lpcmInputParser) Next() (Opus, error) {
+	n, err := io.ReadFull(p.r, p.buf)
+	// 最後の区切りに満たない音声はサンプル単位で返す
+	n -= n % (p.format.ChannelCount * 2)
+	if n == 0 {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = io.EOF
+		}
+		return Opus{}, err
+	}
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return Opus{}, err
+	}
+
+	samples := make([]int16, n/2)
+	for i := range samples {
+		if p.format.BigEndian {
+			samples[i] = int16(binary.BigEndian.Uint16(p.buf[i*2:]))
+		} else {
+			samples[i] = int16(binary.LittleEndian.Uint16(p.buf[i*2:]))
+		}
+	}
+
+	return Opus{PCM: &PCMFrame{
+		Samples:      samples,
+		SampleRate:   p.format.SampleRate,
+		ChannelCount: p.format.ChannelCount,
+	}}, nil
+}
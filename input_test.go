@@ -0,0 +1,334 @@
+package suzu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateInputFormat(t *testing.T) {
+	config := Config{SampleRate: 48000, ChannelCount: 1}
+
+	testCases := []struct {
+		Name        string
+		ContentType string
+		Header      SoraHeader
+		Expect      inputFormat
+	}{
+		{Name: "none", Expect: inputFormat{Name: inputFormatOpus}},
+		{Name: "octet-stream", ContentType: "application/octet-stream", Expect: inputFormat{Name: inputFormatOpus}},
+		{Name: "opus", ContentType: "audio/opus", Expect: inputFormat{Name: inputFormatOpus}},
+		{Name: "ogg", ContentType: "audio/ogg; codecs=opus", Expect: inputFormat{Name: inputFormatOgg}},
+		{Name: "rtp", ContentType: "application/rtp", Expect: inputFormat{Name: inputFormatRTP}},
+		{
+			Name:        "pcm",
+			ContentType: "audio/pcm; rate=16000; channels=2",
+			Expect:      inputFormat{Name: inputFormatLPCM, SampleRate: 16000, ChannelCount: 2},
+		},
+		{
+			Name:        "pcm s16be",
+			ContentType: "audio/pcm; encoding=s16be; rate=8000",
+			Expect:      inputFormat{Name: inputFormatLPCM, SampleRate: 8000, ChannelCount: 1, BigEndian: true},
+		},
+		{
+			Name:        "l16",
+			ContentType: "audio/L16; rate=16000",
+			Expect:      inputFormat{Name: inputFormatLPCM, SampleRate: 16000, ChannelCount: 1, BigEndian: true},
+		},
+		{
+			Name:   "codec type",
+			Header: SoraHeader{SoraAudioCodecType: "OPUS"},
+			Expect: inputFormat{Name: inputFormatOpus},
+		},
+		{
+			// 対応していない Content-Type の場合は sora-audio-codec-type ヘッダを使用する
+			Name:        "unknown",
+			ContentType: "audio/mpeg",
+			Expect:      inputFormat{Name: inputFormatOpus},
+		},
+		{
+			Name:        "invalid",
+			ContentType: "audio/",
+			Header:      SoraHeader{SoraAudioCodecType: "LPCM"},
+			Expect:      inputFormat{Name: inputFormatLPCM, SampleRate: 48000, ChannelCount: 1},
+		},
+		{
+			// Content-Type の指定がない場合は sora-audio-sample-rate ヘッダを使用する
+			Name:        "codec type lpcm",
+			ContentType: "application/octet-stream",
+			Header:      SoraHeader{SoraAudioCodecType: "LPCM", SoraAudioSampleRate: 24000},
+			Expect:      inputFormat{Name: inputFormatLPCM, SampleRate: 24000, ChannelCount: 1},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			f, err := negotiateInputFormat(tc.ContentType, tc.Header, config)
+			require.NoError(t, err)
+			assert.Equal(t, tc.Expect, f)
+		})
+	}
+
+	errorCases := []struct {
+		Name        string
+		ContentType string
+		Header      SoraHeader
+	}{
+		{Name: "unknown with codec type", ContentType: "audio/mpeg", Header: SoraHeader{SoraAudioCodecType: "H264"}},
+		{Name: "ogg vorbis", ContentType: "audio/ogg; codecs=vorbis"},
+		{Name: "pcm encoding", ContentType: "audio/pcm; encoding=f32le"},
+		{Name: "pcm rate", ContentType: "audio/pcm; rate=96000"},
+		{Name: "pcm channels", ContentType: "audio/pcm; channels=6"},
+		{Name: "codec type", Header: SoraHeader{SoraAudioCodecType: "H264"}},
+	}
+
+	for _, tc := range errorCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := negotiateInputFormat(tc.ContentType, tc.Header, config)
+			assert.ErrorIs(t, err, ErrUnsupportedInputFormat)
+		})
+	}
+}
+
+func TestAcceptsLPCMInput(t *testing.T) {
+	config := Config{AwsAudioFormat: audioFormatOgg, GcpAudioFormat: audioFormatPCM, LocalAudioFormat: localAudioFormatOgg}
+
+	assert.False(t, acceptsLPCMInput("aws", config))
+	assert.True(t, acceptsLPCMInput("gcp", config))
+	assert.False(t, acceptsLPCMInput("local", config))
+	assert.False(t, acceptsLPCMInput("plugin", config))
+	assert.False(t, acceptsLPCMInput("dump", config))
+	assert.True(t, acceptsLPCMInput("test", config))
+}
+
+// Opus のパケットを Ogg に変換する
+func newTestOggStream(t *testing.T, packets ...[]byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	o, err := NewWith(&buf, 48000, 1)
+	require.NoError(t, err)
+	for _, packet := range packets {
+		require.NoError(t, o.Write(&codecs.OpusPacket{Payload: packet}))
+	}
+	return buf.Bytes()
+}
+
+// RFC 4571 の形式の RTP パケットを返す
+func newTestRTPFrame(t *testing.T, sequenceNumber uint16, payload []byte) []byte {
+	t.Helper()
+
+	packet := rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    111,
+			SequenceNumber: sequenceNumber,
+			Timestamp:      uint32(sequenceNumber) * 960,
+			SSRC:           1,
+		},
+		Payload: payload,
+	}
+	b, err := packet.Marshal()
+	require.NoError(t, err)
+
+	frame := make([]byte, rtpFrameLengthSize)
+	binary.BigEndian.PutUint16(frame, uint16(len(b)))
+	return append(frame, b...)
+}
+
+func TestOggReader(t *testing.T) {
+	long := bytes.Repeat([]byte{31 << 3, 1}, 300)
+
+	t.Run("packets", func(t *testing.T) {
+		r := newOggReader(bytes.NewReader(newTestOggStream(t, silentPacket(), long, silentPacket())))
+
+		// OpusHead と OpusTags は返さない
+		for _, expect := range [][]byte{silentPacket(), long, silentPacket()} {
+			packet, err := r.ReadPacket()
+			require.NoError(t, err)
+			assert.Equal(t, expect, packet)
+		}
+
+		_, err := r.ReadPacket()
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("checksum", func(t *testing.T) {
+		stream := newTestOggStream(t, silentPacket())
+		stream[len(stream)-1] ^= 0xff
+
+		r := newOggReader(bytes.NewReader(stream))
+		_, err := r.ReadPacket()
+		assert.ErrorIs(t, err, ErrInvalidOggPage)
+	})
+
+	t.Run("capture pattern", func(t *testing.T) {
+		r := newOggReader(bytes.NewReader(bytes.Repeat([]byte{0}, pageHeaderSize)))
+		_, err := r.ReadPacket()
+		assert.ErrorIs(t, err, ErrInvalidOggPage)
+	})
+}
+
+func TestRTPInputParser(t *testing.T) {
+	var stream []byte
+	stream = append(stream, newTestRTPFrame(t, 65535, []byte{1})...)
+	// 順序が入れ替わったパケットと重複したパケットは破棄する
+	stream = append(stream, newTestRTPFrame(t, 65534, []byte{2})...)
+	stream = append(stream, newTestRTPFrame(t, 65535, []byte{3})...)
+	// シーケンス番号の一巡
+	stream = append(stream, newTestRTPFrame(t, 0, []byte{4})...)
+	// 空のパケットは破棄する
+	stream = append(stream, newTestRTPFrame(t, 1, nil)...)
+	stream = append(stream, newTestRTPFrame(t, 2, []byte{5})...)
+
+	p := &rtpInputParser{r: bytes.NewReader(stream)}
+	for _, expect := range []byte{1, 4, 5} {
+		opus, err := p.Next()
+		require.NoError(t, err)
+		assert.Equal(t, []byte{expect}, opus.Payload)
+	}
+
+	_, err := p.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestLPCMInputParser(t *testing.T) {
+	f := inputFormat{Name: inputFormatLPCM, SampleRate: 8000, ChannelCount: 1, BigEndian: true}
+
+	// 20ms 分と 3 サンプル、最後の 1 バイトはサンプルに満たない
+	stream := make([]byte, 160*2+3*2+1)
+	binary.BigEndian.PutUint16(stream, 1000)
+
+	p := newLPCMInputParser(bytes.NewReader(stream), f)
+
+	opus, err := p.Next()
+	require.NoError(t, err)
+	require.NotNil(t, opus.PCM)
+	assert.Len(t, opus.PCM.Samples, 160)
+	assert.Equal(t, int16(1000), opus.PCM.Samples[0])
+	assert.Equal(t, 20*time.Millisecond, opus.Duration())
+	assert.Equal(t, 320, opus.Size())
+
+	opus, err = p.Next()
+	require.NoError(t, err)
+	assert.Len(t, opus.PCM.Samples, 3)
+
+	_, err = p.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestAudioProcessorProcessPCM(t *testing.T) {
+	t.Run("stereo to mono", func(t *testing.T) {
+		p, err := newAudioProcessor(16000, 1)
+		require.NoError(t, err)
+
+		samples := p.ProcessPCM(&PCMFrame{Samples: []int16{100, 300, -100, -300}, SampleRate: 16000, ChannelCount: 2})
+		assert.Equal(t, []int16{200, -200}, samples)
+	})
+
+	t.Run("mono to stereo", func(t *testing.T) {
+		p, err := newAudioProcessor(16000, 2)
+		require.NoError(t, err)
+
+		samples := p.ProcessPCM(&PCMFrame{Samples: []int16{100, -100}, SampleRate: 16000, ChannelCount: 1})
+		assert.Equal(t, []int16{100, 100, -100, -100}, samples)
+	})
+
+	t.Run("resample", func(t *testing.T) {
+		p, err := newAudioProcessor(16000, 1)
+		require.NoError(t, err)
+
+		// 8kHz の 60ms は 16kHz の 960 サンプルに変換する
+		var total int
+		for range 3 {
+			total += len(p.ProcessPCM(&PCMFrame{Samples: make([]int16, 160), SampleRate: 8000, ChannelCount: 1}))
+		}
+		assert.InDelta(t, 960, total, 2)
+	})
+}
+
+func TestInputFormatWithSpeechHandler(t *testing.T) {
+	type message struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	}
+
+	serve := func(t *testing.T, contentType string, body []byte) (int, []message) {
+		t.Helper()
+
+		config := Config{
+			ListenAddr:                "127.0.0.1",
+			TimeToWaitForOpusPacketMs: 500,
+			SampleRate:                48000,
+			ChannelCount:              1,
+		}
+		s, err := NewServer(&config, "test")
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/speech", bytes.NewReader(body))
+		req.Header.Set("sora-audio-streaming-language-code", "ja-JP")
+		req.Header.Set(echo.HeaderContentType, contentType)
+		req.Proto = "HTTP/2.0"
+		req.ProtoMajor = 2
+		req.ProtoMinor = 0
+
+		rec := httptest.NewRecorder()
+		s.echo.ServeHTTP(rec, req)
+
+		var messages []message
+		decoder := json.NewDecoder(bytes.NewReader(rec.Body.Bytes()))
+		for decoder.More() {
+			var m message
+			if err := decoder.Decode(&m); err != nil {
+				break
+			}
+			messages = append(messages, m)
+		}
+		return rec.Code, messages
+	}
+
+	t.Run("ogg", func(t *testing.T) {
+		code, messages := serve(t, "audio/ogg", newTestOggStream(t, silentPacket(), silentPacket()))
+		assert.Equal(t, http.StatusOK, code)
+		// OpusHead と OpusTags を除いた Opus のパケットのみを送信する
+		assert.Equal(t, []message{{Type: "test", Message: "n: 3"}, {Type: "test", Message: "n: 3"}}, messages)
+	})
+
+	t.Run("rtp", func(t *testing.T) {
+		body := append(newTestRTPFrame(t, 1, silentPacket()), newTestRTPFrame(t, 2, silentPacket())...)
+		code, messages := serve(t, "application/rtp", body)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, []message{{Type: "test", Message: "n: 3"}, {Type: "test", Message: "n: 3"}}, messages)
+	})
+
+	t.Run("lpcm", func(t *testing.T) {
+		// 16kHz モノラルの 40ms
+		code, messages := serve(t, "audio/pcm; rate=16000; channels=1", make([]byte, 640*2))
+		assert.Equal(t, http.StatusOK, code)
+		// 20ms ごとに区切った 16 bit PCM を送信する
+		assert.Equal(t, []message{{Type: "test", Message: "n: 640"}, {Type: "test", Message: "n: 640"}}, messages)
+	})
+
+	t.Run("unknown content type", func(t *testing.T) {
+		// sora-audio-codec-type ヘッダで判定する
+		code, messages := serve(t, "text/plain", silentPacket())
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, []message{{Type: "test", Message: "n: 3"}}, messages)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		code, _ := serve(t, "audio/ogg; codecs=vorbis", []byte{0})
+		assert.Equal(t, http.StatusUnsupportedMediaType, code)
+	})
+}
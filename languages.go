@@ -29,7 +29,7 @@ func GetLanguageCode(serviceType, lang string, f func(string) (string, error)) (
 			return lang, nil
 		}
 		return "", fmt.Errorf("%w: %s", ErrUnsupportedLanguageCode, lang)
-	case "gcp", "test", "dump":
+	case "gcp", "azure", "local", "plugin", "test", "dump":
 		return lang, nil
 	}
 
@@ -0,0 +1,205 @@
+package suzu
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	ErrSessionLimitExceeded = fmt.Errorf("SESSION-LIMIT-EXCEEDED")
+)
+
+const (
+	// 上限の種類、メトリクスの limit ラベルに使用する
+	sessionLimitGlobal   = "global"
+	sessionLimitProvider = "provider"
+	sessionLimitChannel  = "channel"
+)
+
+var (
+	// 上限に対する接続中のセッション数の割合
+	// channel の場合は、接続中のセッション数が最も多いチャネルの割合
+	sessionLimitUtilization = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "suzu",
+		Name:      "session_limit_utilization",
+		Help:      "Ratio of active sessions to the session limit.",
+	}, []string{"limit", "provider"})
+
+	// 上限を超えたため拒否したリクエストの数
+	sessionLimitRejectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "suzu",
+		Name:      "session_limit_rejections_total",
+		Help:      "Number of requests rejected by the session limit.",
+	}, []string{"limit", "provider"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		sessionLimitUtilization,
+		sessionLimitRejectionsTotal,
+	)
+}
+
+// max_sessions_per_provider の値（サービス名:上限）を読み込む
+func parseMaxSessionsPerProvider(values []string) (map[string]int, error) {
+	result := make(map[string]int, len(values))
+	for _, v := range values {
+		provider, limit, ok := strings.Cut(strings.TrimSpace(v), ":")
+		if !ok || provider == "" {
+			return nil, fmt.Errorf("invalid max_sessions_per_provider: %s", v)
+		}
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid max_sessions_per_provider: %s", v)
+		}
+		result[provider] = n
+	}
+	return result, nil
+}
+
+// 同時に接続するセッション数を制限する
+// 上限が 0 の場合は制限しない
+type sessionLimiter struct {
+	maxSessions            int
+	maxSessionsPerProvider map[string]int
+	maxSessionsPerChannel  int
+
+	mu        sync.Mutex
+	total     int
+	providers map[string]int
+	channels  map[string]int
+}
+
+func newSessionLimiter(c Config) (*sessionLimiter, error) {
+	maxSessionsPerProvider, err := parseMaxSessionsPerProvider(c.MaxSessionsPerProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessionLimiter{
+		maxSessions:            c.MaxSessions,
+		maxSessionsPerProvider: maxSessionsPerProvider,
+		maxSessionsPerChannel:  c.MaxSessionsPerChannel,
+		providers:              make(map[string]int),
+		channels:               make(map[string]int),
+	}, nil
+}
+
+// 上限を超えない場合はセッション数を加算する
+// providers は接続するサービス名で、enable_channel_split の場合はチャネルごとに指定する
+// max_sessions と max_sessions_per_channel は HTTP のセッションごと、max_sessions_per_provider はサービスへの接続ごとに数える
+// 上限を超える場合は ErrSessionLimitExceeded を返す
+func (l *sessionLimiter) Acquire(providers []string, channelID string) (*sessionLease, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxSessions > 0 && l.total >= l.maxSessions {
+		return nil, l.reject(sessionLimitGlobal, "")
+	}
+	if err := l.checkProviders(providers); err != nil {
+		return nil, err
+	}
+	// sora-channel-id ヘッダが無い場合はチャネルごとの上限を適用しない
+	if l.maxSessionsPerChannel > 0 && channelID != "" && l.channels[channelID] >= l.maxSessionsPerChannel {
+		return nil, l.reject(sessionLimitChannel, providers[0])
+	}
+
+	l.total++
+	for _, provider := range providers {
+		l.providers[provider]++
+	}
+	if channelID != "" {
+		l.channels[channelID]++
+	}
+	for _, provider := range providers {
+		l.updateMetrics(provider)
+	}
+
+	return &sessionLease{
+		limiter:   l,
+		providers: providers,
+		channelID: channelID,
+	}, nil
+}
+
+// 同じサービスに複数接続する場合は、接続の数を加算しても上限を超えないかを確認する
+func (l *sessionLimiter) checkProviders(providers []string) error {
+	counts := make(map[string]int, len(providers))
+	for _, provider := range providers {
+		counts[provider]++
+	}
+
+	for _, provider := range providers {
+		limit := l.maxSessionsPerProvider[provider]
+		if limit > 0 && l.providers[provider]+counts[provider] > limit {
+			return l.reject(sessionLimitProvider, provider)
+		}
+	}
+	return nil
+}
+
+func (l *sessionLimiter) reject(limit, provider string) error {
+	sessionLimitRejectionsTotal.WithLabelValues(limit, provider).Inc()
+	return fmt.Errorf("%w: %s", ErrSessionLimitExceeded, limit)
+}
+
+// 上限に対する割合をメトリクスに記録する
+// mu を取得した状態で呼び出す
+func (l *sessionLimiter) updateMetrics(provider string) {
+	if l.maxSessions > 0 {
+		sessionLimitUtilization.WithLabelValues(sessionLimitGlobal, "").Set(float64(l.total) / float64(l.maxSessions))
+	}
+
+	if limit := l.maxSessionsPerProvider[provider]; limit > 0 {
+		sessionLimitUtilization.WithLabelValues(sessionLimitProvider, provider).Set(float64(l.providers[provider]) / float64(limit))
+	}
+
+	if l.maxSessionsPerChannel > 0 {
+		busiest := 0
+		for _, n := range l.channels {
+			busiest = max(busiest, n)
+		}
+		sessionLimitUtilization.WithLabelValues(sessionLimitChannel, "").Set(float64(busiest) / float64(l.maxSessionsPerChannel))
+	}
+}
+
+// Acquire で加算したセッション数
+type sessionLease struct {
+	limiter   *sessionLimiter
+	providers []string
+	channelID string
+	released  bool
+}
+
+// セッション数を減算する、複数回呼び出した場合は 2 回目以降は何もしない
+func (s *sessionLease) Release() {
+	l := s.limiter
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if s.released {
+		return
+	}
+	s.released = true
+
+	l.total--
+	for _, provider := range s.providers {
+		l.providers[provider]--
+		if l.providers[provider] == 0 {
+			delete(l.providers, provider)
+		}
+	}
+	if s.channelID != "" {
+		l.channels[s.channelID]--
+		if l.channels[s.channelID] == 0 {
+			delete(l.channels, s.channelID)
+		}
+	}
+	for _, provider := range s.providers {
+		l.updateMetrics(provider)
+	}
+}
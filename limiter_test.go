@@ -0,0 +1,178 @@
+package suzu
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMaxSessionsPerProvider(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Values []string
+		Expect map[string]int
+		Error  bool
+	}{
+		{Name: "empty", Values: nil, Expect: map[string]int{}},
+		{Name: "providers", Values: []string{"aws:10", " gcp:0"}, Expect: map[string]int{"aws": 10, "gcp": 0}},
+		{Name: "without limit", Values: []string{"aws"}, Error: true},
+		{Name: "without provider", Values: []string{":10"}, Error: true},
+		{Name: "not a number", Values: []string{"aws:ten"}, Error: true},
+		{Name: "negative", Values: []string{"aws:-1"}, Error: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			actual, err := parseMaxSessionsPerProvider(tc.Values)
+			if tc.Error {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.Expect, actual)
+		})
+	}
+}
+
+func TestSessionLimiter(t *testing.T) {
+	t.Run("global", func(t *testing.T) {
+		l, err := newSessionLimiter(Config{MaxSessions: 2})
+		require.NoError(t, err)
+
+		lease1, err := l.Acquire([]string{"aws"}, "ch1")
+		require.NoError(t, err)
+		_, err = l.Acquire([]string{"gcp"}, "ch2")
+		require.NoError(t, err)
+
+		_, err = l.Acquire([]string{"aws"}, "ch3")
+		assert.ErrorIs(t, err, ErrSessionLimitExceeded)
+		assert.Equal(t, 1.0, testutil.ToFloat64(sessionLimitUtilization.WithLabelValues(sessionLimitGlobal, "")))
+
+		lease1.Release()
+		// 複数回呼び出してもセッション数は 1 回だけ減算する
+		lease1.Release()
+		assert.Equal(t, 0.5, testutil.ToFloat64(sessionLimitUtilization.WithLabelValues(sessionLimitGlobal, "")))
+
+		_, err = l.Acquire([]string{"aws"}, "ch3")
+		assert.NoError(t, err)
+	})
+
+	t.Run("provider", func(t *testing.T) {
+		l, err := newSessionLimiter(Config{MaxSessionsPerProvider: []string{"limiter-aws:1"}})
+		require.NoError(t, err)
+
+		lease, err := l.Acquire([]string{"limiter-aws"}, "ch1")
+		require.NoError(t, err)
+
+		_, err = l.Acquire([]string{"limiter-aws"}, "ch2")
+		assert.ErrorIs(t, err, ErrSessionLimitExceeded)
+
+		// 上限を指定していないサービスは制限しない
+		_, err = l.Acquire([]string{"limiter-gcp"}, "ch2")
+		require.NoError(t, err)
+
+		lease.Release()
+		assert.Equal(t, 0.0, testutil.ToFloat64(sessionLimitUtilization.WithLabelValues(sessionLimitProvider, "limiter-aws")))
+		_, err = l.Acquire([]string{"limiter-aws"}, "ch3")
+		assert.NoError(t, err)
+	})
+
+	t.Run("channel split", func(t *testing.T) {
+		l, err := newSessionLimiter(Config{
+			MaxSessions:            1,
+			MaxSessionsPerChannel:  1,
+			MaxSessionsPerProvider: []string{"split-aws:2", "split-gcp:1"},
+		})
+		require.NoError(t, err)
+
+		// チャネルを分割した接続は 1 つのセッションとして数える
+		lease, err := l.Acquire([]string{"split-aws", "split-aws"}, "ch1")
+		require.NoError(t, err)
+		assert.Equal(t, 1.0, testutil.ToFloat64(sessionLimitUtilization.WithLabelValues(sessionLimitProvider, "split-aws")))
+		lease.Release()
+		assert.Equal(t, 0.0, testutil.ToFloat64(sessionLimitUtilization.WithLabelValues(sessionLimitProvider, "split-aws")))
+
+		// サービスごとの上限は接続ごとに数える
+		_, err = l.Acquire([]string{"split-gcp", "split-gcp"}, "ch1")
+		assert.ErrorIs(t, err, ErrSessionLimitExceeded)
+		_, err = l.Acquire([]string{"split-gcp", "split-aws"}, "ch1")
+		assert.NoError(t, err)
+	})
+
+	t.Run("channel", func(t *testing.T) {
+		l, err := newSessionLimiter(Config{MaxSessionsPerChannel: 1})
+		require.NoError(t, err)
+
+		lease, err := l.Acquire([]string{"aws"}, "ch1")
+		require.NoError(t, err)
+
+		_, err = l.Acquire([]string{"aws"}, "ch1")
+		assert.ErrorIs(t, err, ErrSessionLimitExceeded)
+
+		_, err = l.Acquire([]string{"aws"}, "ch2")
+		assert.NoError(t, err)
+
+		// sora-channel-id ヘッダが無い場合は制限しない
+		_, err = l.Acquire([]string{"aws"}, "")
+		assert.NoError(t, err)
+		_, err = l.Acquire([]string{"aws"}, "")
+		assert.NoError(t, err)
+
+		lease.Release()
+		_, err = l.Acquire([]string{"aws"}, "ch1")
+		assert.NoError(t, err)
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		l, err := newSessionLimiter(Config{})
+		require.NoError(t, err)
+
+		for range 100 {
+			_, err := l.Acquire([]string{"aws"}, "ch1")
+			require.NoError(t, err)
+		}
+	})
+}
+
+func TestSessionLimitExceeded(t *testing.T) {
+	config := Config{
+		ListenAddr:                "127.0.0.1",
+		TimeToWaitForOpusPacketMs: 500,
+		MaxSessionsPerProvider:    []string{"test:1"},
+		SessionLimitRetryAfterSec: 3,
+	}
+	s, err := NewServer(&config, "test")
+	require.NoError(t, err)
+
+	rejections := sessionLimitRejectionsTotal.WithLabelValues(sessionLimitProvider, "test")
+	before := testutil.ToFloat64(rejections)
+
+	// 接続中のセッションがある状態にする
+	lease, err := s.sessionLimiter.Acquire([]string{"test"}, "ch1")
+	require.NoError(t, err)
+
+	r := readDumpFile(t, "testdata/dump.jsonl", 0)
+	defer r.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/speech", r)
+	req.Header.Set("sora-audio-streaming-language-code", "ja-JP")
+	req.Proto = "HTTP/2.0"
+	req.ProtoMajor = 2
+	req.ProtoMinor = 0
+
+	rec := httptest.NewRecorder()
+	s.echo.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "3", rec.Header().Get("Retry-After"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(rejections)-before)
+
+	// セッションが終了した後は接続できる
+	lease.Release()
+
+	messages := serveSpeech[map[string]any](t, s, "/speech")
+	assert.NotEmpty(t, messages)
+}
@@ -0,0 +1,361 @@
+package suzu
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	zlog "github.com/rs/zerolog/log"
+)
+
+const (
+	// 音声認識サーバに送信する音声データの形式
+	localAudioFormatOgg  = "ogg"
+	localAudioFormatOpus = "opus"
+	localAudioFormatPCM  = "pcm"
+)
+
+var (
+	ErrLocalUnsupportedURLScheme   = fmt.Errorf("LOCAL-UNSUPPORTED-URL-SCHEME")
+	ErrLocalUnsupportedAudioFormat = fmt.Errorf("LOCAL-UNSUPPORTED-AUDIO-FORMAT")
+)
+
+// 自前で運用する音声認識サーバとのストリーム
+type localASRStream interface {
+	// 音声データを送信する
+	Send([]byte) error
+	// 音声データの終了を通知する
+	CloseSend() error
+	// 音声認識結果を 1 つ受信する
+	Recv() ([]byte, error)
+	Close() error
+}
+
+type LocalASR struct {
+	URL          string
+	AudioFormat  string
+	SampleRate   uint32
+	ChannelCount uint16
+	Config       Config
+}
+
+func NewLocalASR(c Config, sampleRate uint32, channelCount uint16) *LocalASR {
+	return &LocalASR{
+		URL:          c.LocalURL,
+		AudioFormat:  c.LocalAudioFormat,
+		SampleRate:   sampleRate,
+		ChannelCount: channelCount,
+		Config:       c,
+	}
+}
+
+// 音声データの形式に応じた Content-Type を返す
+func (l *LocalASR) ContentType() string {
+	switch l.AudioFormat {
+	case localAudioFormatPCM:
+		return fmt.Sprintf("audio/pcm; encoding=s16le; rate=%d; channels=1", l.Config.LocalPCMSampleRate)
+	case localAudioFormatOpus:
+		return "audio/opus"
+	default:
+		return "audio/ogg"
+	}
+}
+
+// 音声認識サーバに送信する音声データを読み出す io.ReadCloser を返す
+func (l *LocalASR) NewAudioReader(ctx context.Context, opusCh chan Opus, header SoraHeader) (io.ReadCloser, error) {
+	switch l.AudioFormat {
+	case localAudioFormatOgg:
+		return opus2ogg(ctx, opusCh, l.SampleRate, l.ChannelCount)
+	case localAudioFormatOpus:
+		return opusChannelToIOReadCloser(ctx, opusCh), nil
+	case localAudioFormatPCM:
+		// PCM はモノラルで送信する
+		return opus2pcm(ctx, opusCh, uint32(l.Config.LocalPCMSampleRate), 1)
+	}
+
+	return nil, NewSuzuConfError(fmt.Errorf("%w: %s", ErrLocalUnsupportedAudioFormat, l.AudioFormat))
+}
+
+func (l *LocalASR) Start(ctx context.Context, r io.ReadCloser, header SoraHeader) (localASRStream, error) {
+	u, err := url.Parse(l.URL)
+	if err != nil {
+		return nil, NewSuzuConfError(err)
+	}
+
+	audioData, err := receiveFirstAudioData(r)
+	if err != nil {
+		return nil, err
+	}
+
+	zlog.Info().
+		Str("channel_id", header.SoraChannelID).
+		Str("connection_id", header.SoraConnectionID).
+		Str("url", u.Redacted()).
+		Msg("Starting local ASR stream")
+
+	var stream localASRStream
+	switch u.Scheme {
+	case "ws", "wss":
+		stream, err = l.dialWebSocket(ctx)
+	case "http", "https":
+		if l.AudioFormat == localAudioFormatOpus {
+			// HTTP の場合はパケットの区切りを表現できないため opus は指定できない
+			return nil, NewSuzuConfError(fmt.Errorf("%w: %s over %s", ErrLocalUnsupportedAudioFormat, l.AudioFormat, u.Scheme))
+		}
+		stream, err = l.postHTTP(ctx)
+	default:
+		return nil, NewSuzuConfError(fmt.Errorf("%w: %s", ErrLocalUnsupportedURLScheme, u.Scheme))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	zlog.Info().
+		Str("channel_id", header.SoraChannelID).
+		Str("connection_id", header.SoraConnectionID).
+		Str("url", u.Redacted()).
+		Msg("Started local ASR stream")
+
+	// コンテキストが閉じられたときにストリームを閉じる
+	closeOnDone(ctx, stream)
+
+	// サーバに接続したので、音声データを送信する
+	if err := stream.Send(audioData); err != nil {
+		r.Close()
+		stream.Close()
+		return nil, err
+	}
+
+	go func() {
+		defer r.Close()
+
+		frame := make([]byte, FrameSize)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+
+			n, err := r.Read(frame)
+			if err != nil {
+				if !errors.Is(err, io.EOF) && !errors.Is(err, context.Canceled) {
+					zlog.Error().Err(err).Send()
+				}
+
+				if err := stream.CloseSend(); err != nil {
+					zlog.Error().Err(err).Send()
+				}
+				return
+			}
+			if n > 0 {
+				if err := stream.Send(frame[:n]); err != nil {
+					zlog.Error().Err(err).Send()
+					return
+				}
+			}
+		}
+	}()
+
+	return stream, nil
+}
+
+func (l *LocalASR) dialWebSocket(ctx context.Context) (localASRStream, error) {
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, l.URL, nil)
+	if err != nil {
+		if resp != nil {
+			return nil, newLocalASRStatusError(resp.StatusCode, err.Error())
+		}
+		return nil, err
+	}
+
+	s := &localASRWebSocketStream{
+		conn:       conn,
+		eofMessage: l.Config.LocalEOFMessage,
+	}
+
+	// 音声データの前に設定を送信するサーバ向け
+	if l.Config.LocalConfigMessage != "" {
+		if err := s.write(websocket.TextMessage, []byte(l.Config.LocalConfigMessage)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func (l *LocalASR) postHTTP(ctx context.Context) (localASRStream, error) {
+	bodyReader, bodyWriter := io.Pipe()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.URL, bodyReader)
+	if err != nil {
+		return nil, NewSuzuConfError(err)
+	}
+	req.Header.Set("Content-Type", l.ContentType())
+
+	// 音声データの送信中にレスポンスを受信するため、リクエストは別の goroutine で送信する
+	respCh := make(chan *http.Response, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		respCh <- resp
+	}()
+
+	s := &localASRHTTPStream{
+		bodyWriter: bodyWriter,
+		respCh:     respCh,
+		errCh:      errCh,
+	}
+
+	return s, nil
+}
+
+func newLocalASRStatusError(code int, message string) error {
+	var retry bool
+	if code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable {
+		retry = true
+	}
+
+	return &SuzuError{
+		Code:    code,
+		Message: message,
+		Retry:   retry,
+	}
+}
+
+type localASRWebSocketStream struct {
+	conn       *websocket.Conn
+	eofMessage string
+
+	mu sync.Mutex
+}
+
+func (s *localASRWebSocketStream) write(messageType int, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.conn.WriteMessage(messageType, data)
+}
+
+func (s *localASRWebSocketStream) Send(data []byte) error {
+	return s.write(websocket.BinaryMessage, data)
+}
+
+func (s *localASRWebSocketStream) CloseSend() error {
+	if s.eofMessage != "" {
+		return s.write(websocket.TextMessage, []byte(s.eofMessage))
+	}
+
+	return s.write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
+
+func (s *localASRWebSocketStream) Recv() ([]byte, error) {
+	for {
+		messageType, data, err := s.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+
+		if messageType == websocket.TextMessage {
+			return data, nil
+		}
+	}
+}
+
+func (s *localASRWebSocketStream) Close() error {
+	return s.conn.Close()
+}
+
+// HTTP の場合は音声データをリクエストボディで送信し、
+// JSON Lines 形式のレスポンスボディで結果を受信する
+type localASRHTTPStream struct {
+	bodyWriter *io.PipeWriter
+	respCh     chan *http.Response
+	errCh      chan error
+
+	once    sync.Once
+	mu      sync.Mutex
+	resp    *http.Response
+	respErr error
+	scanner *bufio.Scanner
+}
+
+func (s *localASRHTTPStream) Send(data []byte) error {
+	_, err := s.bodyWriter.Write(data)
+	return err
+}
+
+func (s *localASRHTTPStream) CloseSend() error {
+	return s.bodyWriter.Close()
+}
+
+// レスポンスヘッダを受信するまで待つ
+func (s *localASRHTTPStream) response() (*http.Response, error) {
+	s.once.Do(func() {
+		select {
+		case resp := <-s.respCh:
+			if resp.StatusCode != http.StatusOK {
+				resp.Body.Close()
+				s.respErr = newLocalASRStatusError(resp.StatusCode, resp.Status)
+				return
+			}
+			s.mu.Lock()
+			s.resp = resp
+			s.mu.Unlock()
+			s.scanner = bufio.NewScanner(resp.Body)
+		case err := <-s.errCh:
+			s.respErr = err
+		}
+	})
+
+	return s.resp, s.respErr
+}
+
+func (s *localASRHTTPStream) Recv() ([]byte, error) {
+	if _, err := s.response(); err != nil {
+		return nil, err
+	}
+
+	for s.scanner.Scan() {
+		line := s.scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		data := make([]byte, len(line))
+		copy(data, line)
+		return data, nil
+	}
+
+	if err := s.scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, io.EOF
+}
+
+func (s *localASRHTTPStream) Close() error {
+	s.bodyWriter.CloseWithError(io.ErrClosedPipe)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.resp != nil {
+		return s.resp.Body.Close()
+	}
+	return nil
+}
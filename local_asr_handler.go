@@ -0,0 +1,246 @@
+package suzu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	zlog "github.com/rs/zerolog/log"
+)
+
+type LocalASRHandler struct {
+	Config Config
+
+	ChannelID    string
+	ConnectionID string
+	SampleRate   uint32
+	ChannelCount uint16
+	LanguageCode string
+	RetryCount   int
+	mu           sync.Mutex
+
+	OnResultFunc OnResultFunc
+}
+
+func NewLocalASRHandler(config Config, channelID, connectionID string, sampleRate uint32, channelCount uint16, languageCode string, onResultFunc OnResultFunc) ServiceHandler {
+	return &LocalASRHandler{
+		Config:       config,
+		ChannelID:    channelID,
+		ConnectionID: connectionID,
+		SampleRate:   sampleRate,
+		ChannelCount: channelCount,
+		LanguageCode: languageCode,
+		OnResultFunc: onResultFunc,
+	}
+}
+
+type LocalResult struct {
+	IsFinal *bool `json:"is_final,omitempty"`
+	TranscriptionResult
+}
+
+func NewLocalResult() LocalResult {
+	return LocalResult{
+		TranscriptionResult: TranscriptionResult{
+			Type: "local",
+		},
+	}
+}
+
+func (lr *LocalResult) WithIsFinal(isFinal bool) *LocalResult {
+	lr.IsFinal = &isFinal
+	return lr
+}
+
+func (lr *LocalResult) SetMessage(message string) *LocalResult {
+	lr.Message = message
+	return lr
+}
+
+func (h *LocalASRHandler) UpdateRetryCount() int {
+	defer h.mu.Unlock()
+	h.mu.Lock()
+	h.RetryCount++
+	return h.RetryCount
+}
+
+func (h *LocalASRHandler) GetRetryCount() int {
+	return h.RetryCount
+}
+
+func (h *LocalASRHandler) ResetRetryCount() int {
+	defer h.mu.Unlock()
+	h.mu.Lock()
+	h.RetryCount = 0
+	return h.RetryCount
+}
+
+func (h *LocalASRHandler) IsRetryTarget(args any) bool {
+	switch err := args.(type) {
+	case error:
+		// サーバが再起動した場合などは再接続を試みる
+		if websocket.IsCloseError(err,
+			websocket.CloseAbnormalClosure,
+			websocket.CloseGoingAway,
+			websocket.CloseInternalServerErr,
+			websocket.CloseServiceRestart,
+			websocket.CloseTryAgainLater) {
+			return true
+		}
+
+		// HTTP レスポンスボディの受信中に切断された場合
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return true
+		}
+
+		var netErr *net.OpError
+		if errors.As(err, &netErr) {
+			return true
+		}
+
+		// 混雑している場合など、ステータスコードからリトライ対象と判定した場合
+		var suzuErr *SuzuError
+		if errors.As(err, &suzuErr) && suzuErr.IsRetry() {
+			return true
+		}
+
+		// retry_targets に設定されているエラーの場合はリトライする
+		if isRetryTargetByConfig(h.Config, err.Error()) {
+			return true
+		}
+	default:
+	}
+
+	return false
+}
+
+func (h *LocalASRHandler) Handle(ctx context.Context, opusCh chan Opus, header SoraHeader) (*io.PipeReader, error) {
+	l := NewLocalASR(h.Config, h.SampleRate, h.ChannelCount)
+
+	packetReader, err := l.NewAudioReader(ctx, opusCh, header)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := l.Start(ctx, packetReader, header)
+	if err != nil {
+		return nil, err
+	}
+
+	// リクエストが成功した時点でリトライカウントをリセットする
+	h.ResetRetryCount()
+
+	r, w := io.Pipe()
+
+	go func() {
+		defer stream.Close()
+
+		encoder := json.NewEncoder(w)
+
+		for {
+			data, err := stream.Recv()
+			if err != nil {
+				// context がキャンセルされた場合、または、サーバが正常に終了した場合は終了
+				if ctx.Err() != nil || errors.Is(err, io.EOF) {
+					w.Close()
+					return
+				}
+
+				zlog.Error().
+					Err(err).
+					Str("channel_id", h.ChannelID).
+					Str("connection_id", h.ConnectionID).
+					Int("retry_count", h.GetRetryCount()).
+					Send()
+
+				if ok := h.IsRetryTarget(err); ok {
+					err = errors.Join(err, ErrServerDisconnected)
+				}
+
+				w.CloseWithError(err)
+				return
+			}
+
+			var res map[string]any
+			if err := json.Unmarshal(data, &res); err != nil {
+				// 結果以外のメッセージが送られてくる場合があるため、JSON ではない場合は無視する
+				zlog.Debug().
+					Err(err).
+					Str("channel_id", h.ChannelID).
+					Str("connection_id", h.ConnectionID).
+					Str("data", redactLog(h.Config, string(data))).
+					Send()
+				continue
+			}
+
+			if h.OnResultFunc != nil {
+				if err := h.OnResultFunc(ctx, w, h.ChannelID, h.ConnectionID, h.LanguageCode, ServiceResult{Provider: "local", Local: res}); err != nil {
+					if err := encoder.Encode(newServiceErrorResponse(err, "local", "")); err != nil {
+						zlog.Error().
+							Err(err).
+							Str("channel_id", h.ChannelID).
+							Str("connection_id", h.ConnectionID).
+							Send()
+					}
+					w.CloseWithError(err)
+					return
+				}
+				continue
+			}
+
+			message, isFinal, ok := buildLocalMessage(h.Config, res)
+			if !ok {
+				continue
+			}
+
+			if h.Config.FinalResultOnly {
+				if !isFinal {
+					continue
+				}
+			}
+
+			// text_processor_rules_file で指定した加工を適用する
+			message, ok = processResultMessage(h.Config, message)
+			if !ok {
+				continue
+			}
+
+			result := NewLocalResult()
+			if h.Config.LocalResultIsFinal {
+				result.WithIsFinal(isFinal)
+			}
+			// 個人情報をマスクする
+			message, redactions := h.Config.Redactor.Redact(message)
+			result.Redactions = redactions
+			result.SetMessage(message)
+
+			if err := encoder.Encode(result); err != nil {
+				w.CloseWithError(err)
+				return
+			}
+			countResult("local", h.LanguageCode, isFinal)
+			roomSpeakerFromContext(ctx).AddResult("local", h.LanguageCode, result.Message, isFinal)
+		}
+	}()
+
+	return r, nil
+}
+
+// local_final_result_key と local_partial_result_key に従って結果を取り出す
+// 両方のキーが含まれている場合は最終結果を優先する
+func buildLocalMessage(config Config, res map[string]any) (string, bool, bool) {
+	if message, ok := res[config.LocalFinalResultKey].(string); ok {
+		// 発話が無い場合は空文字の最終結果が送られてくるため、結果を返さない
+		return message, true, message != ""
+	}
+
+	if message, ok := res[config.LocalPartialResultKey].(string); ok {
+		return message, false, message != ""
+	}
+
+	return "", false, false
+}
@@ -0,0 +1,351 @@
+package suzu
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Vosk の WebSocket サーバを模したテスト用サーバ
+func newVoskFakeServer(t *testing.T, closeCode int) *httptest.Server {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		// 設定
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		assert.Equal(t, websocket.TextMessage, messageType)
+		assert.Equal(t, `{"config" : {"sample_rate" : 16000}}`, string(data))
+
+		count := 0
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			if messageType == websocket.TextMessage {
+				// 音声の終了
+				assert.Equal(t, `{"eof" : 1}`, string(data))
+				conn.WriteMessage(websocket.TextMessage, []byte(`{"text" : "おわり"}`))
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+
+			// 16 kHz モノラル 20 ms の PCM
+			assert.Equal(t, 640, len(data))
+
+			if closeCode != 0 {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, ""))
+				return
+			}
+
+			count++
+			switch count {
+			case 1:
+				conn.WriteMessage(websocket.TextMessage, []byte(`{"partial" : ""}`))
+			case 2:
+				conn.WriteMessage(websocket.TextMessage, []byte(`{"partial" : "こんにち"}`))
+			case 3:
+				conn.WriteMessage(websocket.TextMessage, []byte(`{"result" : [], "text" : "こんにちは"}`))
+			}
+		}
+	}))
+}
+
+// 音声データを受信しながら JSON Lines で結果を返すテスト用サーバ
+func newLocalHTTPFakeServer(t *testing.T, statusCode int) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if statusCode != http.StatusOK {
+			w.WriteHeader(statusCode)
+			return
+		}
+
+		assert.Equal(t, "audio/ogg", r.Header.Get("Content-Type"))
+
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		assert.Equal(t, "OggS", string(b[:4]))
+
+		w.Header().Set("Content-Type", "application/jsonl")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, `{"partial":"テスト"}`)
+		fmt.Fprintln(w, `{"text":"テストです"}`)
+	}))
+}
+
+func TestLocalASRHandler(t *testing.T) {
+	channelID := "test-channel-id"
+	connectionID := "test-connection-id"
+	sampleRate := uint32(48000)
+	channelCount := uint16(1)
+	languageCode := "ja-JP"
+	header := SoraHeader{
+		SoraChannelID:    channelID,
+		SoraConnectionID: connectionID,
+	}
+	var noResultFunc func(context.Context, io.WriteCloser, string, string, string, ServiceResult) error
+
+	baseConfig := Config{
+		LocalAudioFormat:      "pcm",
+		LocalPCMSampleRate:    16000,
+		LocalConfigMessage:    `{"config" : {"sample_rate" : 16000}}`,
+		LocalEOFMessage:       `{"eof" : 1}`,
+		LocalPartialResultKey: "partial",
+		LocalFinalResultKey:   "text",
+		LocalResultIsFinal:    true,
+	}
+
+	readResults := func(t *testing.T, r io.Reader) []LocalResult {
+		t.Helper()
+
+		results := []LocalResult{}
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			var result LocalResult
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &result))
+			results = append(results, result)
+		}
+		require.NoError(t, scanner.Err())
+		return results
+	}
+
+	t.Run("websocket pcm", func(t *testing.T) {
+		s := newVoskFakeServer(t, 0)
+		defer s.Close()
+
+		config := baseConfig
+		config.LocalURL = "ws" + strings.TrimPrefix(s.URL, "http")
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 5)
+
+		h := NewLocalASRHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		r, err := h.Handle(ctx, opusCh, header)
+		require.NoError(t, err)
+		defer r.Close()
+
+		results := readResults(t, r)
+		if assert.Len(t, results, 3) {
+			assert.Equal(t, "local", results[0].Type)
+			assert.Equal(t, "こんにち", results[0].Message)
+			assert.False(t, *results[0].IsFinal)
+			assert.Equal(t, "こんにちは", results[1].Message)
+			assert.True(t, *results[1].IsFinal)
+			assert.Equal(t, "おわり", results[2].Message)
+			assert.True(t, *results[2].IsFinal)
+		}
+	})
+
+	t.Run("websocket final result only", func(t *testing.T) {
+		s := newVoskFakeServer(t, 0)
+		defer s.Close()
+
+		config := baseConfig
+		config.LocalURL = "ws" + strings.TrimPrefix(s.URL, "http")
+		config.LocalResultIsFinal = false
+		config.FinalResultOnly = true
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 5)
+
+		h := NewLocalASRHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		r, err := h.Handle(ctx, opusCh, header)
+		require.NoError(t, err)
+		defer r.Close()
+
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, `{"message":"こんにちは","type":"local"}`+"\n"+`{"message":"おわり","type":"local"}`+"\n", string(b))
+	})
+
+	t.Run("websocket text processors", func(t *testing.T) {
+		s := newVoskFakeServer(t, 0)
+		defer s.Close()
+
+		regex, err := NewRegexTextProcessor(`^おわり$`, "")
+		require.NoError(t, err)
+
+		config := baseConfig
+		config.LocalURL = "ws" + strings.TrimPrefix(s.URL, "http")
+		config.LocalResultIsFinal = false
+		config.TextProcessors = TextProcessors{regex, NewDictionaryTextProcessor(map[string]string{"こんにちは": "Hello"})}
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 5)
+
+		h := NewLocalASRHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		r, err := h.Handle(ctx, opusCh, header)
+		require.NoError(t, err)
+		defer r.Close()
+
+		// 加工後に空になった結果は送らない
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, `{"message":"こんにち","type":"local"}`+"\n"+`{"message":"Hello","type":"local"}`+"\n", string(b))
+	})
+
+	t.Run("websocket server disconnected", func(t *testing.T) {
+		s := newVoskFakeServer(t, websocket.CloseServiceRestart)
+		defer s.Close()
+
+		config := baseConfig
+		config.LocalURL = "ws" + strings.TrimPrefix(s.URL, "http")
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 5)
+
+		h := NewLocalASRHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		r, err := h.Handle(ctx, opusCh, header)
+		require.NoError(t, err)
+		defer r.Close()
+
+		_, err = io.ReadAll(r)
+		assert.ErrorIs(t, err, ErrServerDisconnected)
+	})
+
+	t.Run("http ogg", func(t *testing.T) {
+		s := newLocalHTTPFakeServer(t, http.StatusOK)
+		defer s.Close()
+
+		config := baseConfig
+		config.LocalURL = s.URL
+		config.LocalAudioFormat = "ogg"
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 5)
+
+		h := NewLocalASRHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		r, err := h.Handle(ctx, opusCh, header)
+		require.NoError(t, err)
+		defer r.Close()
+
+		results := readResults(t, r)
+		if assert.Len(t, results, 2) {
+			assert.Equal(t, "テスト", results[0].Message)
+			assert.False(t, *results[0].IsFinal)
+			assert.Equal(t, "テストです", results[1].Message)
+			assert.True(t, *results[1].IsFinal)
+		}
+	})
+
+	t.Run("http service unavailable", func(t *testing.T) {
+		s := newLocalHTTPFakeServer(t, http.StatusServiceUnavailable)
+		defer s.Close()
+
+		config := baseConfig
+		config.LocalURL = s.URL
+		config.LocalAudioFormat = "ogg"
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 5)
+
+		h := NewLocalASRHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		r, err := h.Handle(ctx, opusCh, header)
+		require.NoError(t, err)
+		defer r.Close()
+
+		_, err = io.ReadAll(r)
+		assert.ErrorIs(t, err, ErrServerDisconnected)
+	})
+
+	t.Run("http opus", func(t *testing.T) {
+		config := baseConfig
+		config.LocalURL = "http://127.0.0.1:0"
+		config.LocalAudioFormat = "opus"
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 5)
+
+		h := NewLocalASRHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		_, err := h.Handle(ctx, opusCh, header)
+
+		var suzuConfErr *SuzuConfError
+		if assert.ErrorAs(t, err, &suzuConfErr) {
+			assert.Contains(t, suzuConfErr.Error(), ErrLocalUnsupportedAudioFormat.Error())
+		}
+	})
+
+	t.Run("unsupported url scheme", func(t *testing.T) {
+		config := baseConfig
+		config.LocalURL = "tcp://127.0.0.1:0"
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 5)
+
+		h := NewLocalASRHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		_, err := h.Handle(ctx, opusCh, header)
+
+		var suzuConfErr *SuzuConfError
+		if assert.ErrorAs(t, err, &suzuConfErr) {
+			assert.Contains(t, suzuConfErr.Error(), ErrLocalUnsupportedURLScheme.Error())
+		}
+	})
+}
+
+func TestBuildLocalMessage(t *testing.T) {
+	config := Config{
+		LocalPartialResultKey: "partial",
+		LocalFinalResultKey:   "text",
+	}
+
+	testCases := []struct {
+		Name    string
+		Result  map[string]any
+		Message string
+		IsFinal bool
+		Ok      bool
+	}{
+		{Name: "partial", Result: map[string]any{"partial": "a"}, Message: "a", IsFinal: false, Ok: true},
+		{Name: "final", Result: map[string]any{"text": "b"}, Message: "b", IsFinal: true, Ok: true},
+		{Name: "final priority", Result: map[string]any{"partial": "a", "text": "b"}, Message: "b", IsFinal: true, Ok: true},
+		{Name: "empty partial", Result: map[string]any{"partial": ""}, Message: "", IsFinal: false, Ok: false},
+		{Name: "empty final", Result: map[string]any{"text": ""}, Message: "", IsFinal: true, Ok: false},
+		{Name: "not string", Result: map[string]any{"text": 1}, Message: "", IsFinal: false, Ok: false},
+		{Name: "unknown key", Result: map[string]any{"result": "c"}, Message: "", IsFinal: false, Ok: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			message, isFinal, ok := buildLocalMessage(config, tc.Result)
+			assert.Equal(t, tc.Message, message)
+			assert.Equal(t, tc.IsFinal, isFinal)
+			assert.Equal(t, tc.Ok, ok)
+		})
+	}
+}
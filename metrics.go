@@ -0,0 +1,180 @@
+package suzu
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/transcribestreaming/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// suzu_results_total の result_type ラベル
+	resultTypePartial = "partial"
+	resultTypeFinal   = "final"
+
+	// 言語コードの一覧にない場合の language ラベル
+	metricsLanguageOther = "other"
+)
+
+var (
+	// エラーの種類ごとの発生回数
+	errorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "suzu",
+		Name:      "errors_total",
+		Help:      "Number of errors by provider and error code.",
+	}, []string{"provider", "code"})
+
+	// 接続中のセッション数
+	activeSessions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "suzu",
+		Name:      "active_sessions",
+		Help:      "Number of active speech sessions.",
+	}, []string{"provider", "language"})
+
+	// セッションの接続時間
+	sessionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "suzu",
+		Name:      "session_duration_seconds",
+		Help:      "Duration of speech sessions.",
+		Buckets:   []float64{1, 5, 10, 30, 60, 300, 600, 1800, 3600, 7200, 14400},
+	}, []string{"provider", "language"})
+
+	// クライアントから受信した音声の長さ
+	audioReceivedSeconds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "suzu",
+		Name:      "audio_received_seconds_total",
+		Help:      "Total duration of audio received from clients.",
+	}, []string{"provider", "language"})
+
+	// クライアントから受信した音声のバイト数
+	audioReceivedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "suzu",
+		Name:      "audio_received_bytes_total",
+		Help:      "Total bytes of audio received from clients.",
+	}, []string{"provider", "language"})
+
+	// 無音と判定したためサービスに送信しなかった音声の長さ
+	vadSuppressedSeconds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "suzu",
+		Name:      "vad_suppressed_seconds_total",
+		Help:      "Total duration of silent audio not sent to providers.",
+	}, []string{"provider", "language"})
+
+	// クライアントに送信した結果の数
+	resultsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "suzu",
+		Name:      "results_total",
+		Help:      "Number of results sent to clients.",
+	}, []string{"provider", "language", "result_type"})
+
+	// エラーの種類ごとの再接続の回数
+	retriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "suzu",
+		Name:      "retries_total",
+		Help:      "Number of reconnections to providers by error code.",
+	}, []string{"provider", "language", "code"})
+
+	// 外部サービスへの接続にかかった時間
+	providerConnectDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "suzu",
+		Name:      "provider_connect_duration_seconds",
+		Help:      "Time taken to connect to providers.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"provider", "language"})
+
+	// 音声の受信を開始してから最初の結果を送信するまでの時間
+	firstResultLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "suzu",
+		Name:      "first_result_latency_seconds",
+		Help:      "Time from the first audio received to the first result sent.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2, 5, 10, 30, 60},
+	}, []string{"provider", "language"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		errorsTotal,
+		activeSessions,
+		sessionDuration,
+		audioReceivedSeconds,
+		audioReceivedBytes,
+		vadSuppressedSeconds,
+		resultsTotal,
+		retriesTotal,
+		providerConnectDuration,
+		firstResultLatency,
+	)
+}
+
+// エラーの発生回数をメトリクスに記録する
+func countError(provider string, err error) {
+	errorsTotal.WithLabelValues(provider, string(ErrorCodeOf(err))).Inc()
+}
+
+// language ラベルの値を返す
+// aws 以外は sora-audio-streaming-language-code ヘッダの値をそのまま使用するため、
+// ラベルの値が増え続けないように Amazon Transcribe の言語コードの一覧にない値は other にする
+func metricsLanguage(language string) string {
+	for _, lc := range new(types.LanguageCode).Values() {
+		if strings.EqualFold(string(lc), language) {
+			return string(lc)
+		}
+	}
+	return metricsLanguageOther
+}
+
+// クライアントに送信した結果の数をメトリクスに記録する
+func countResult(provider, language string, isFinal bool) {
+	resultType := resultTypePartial
+	if isFinal {
+		resultType = resultTypeFinal
+	}
+	resultsTotal.WithLabelValues(provider, metricsLanguage(language), resultType).Inc()
+}
+
+// セッション単位のメトリクスを記録する
+type sessionMetrics struct {
+	provider string
+	language string
+	start    time.Time
+}
+
+// セッションの開始を記録する
+// セッションの終了時に Close を呼び出す
+func newSessionMetrics(provider, language string) *sessionMetrics {
+	language = metricsLanguage(language)
+	activeSessions.WithLabelValues(provider, language).Inc()
+
+	return &sessionMetrics{
+		provider: provider,
+		language: language,
+		start:    time.Now(),
+	}
+}
+
+func (m *sessionMetrics) Close() {
+	activeSessions.WithLabelValues(m.provider, m.language).Dec()
+	sessionDuration.WithLabelValues(m.provider, m.language).Observe(time.Since(m.start).Seconds())
+}
+
+func (m *sessionMetrics) ObserveConnect(provider string, d time.Duration) {
+	providerConnectDuration.WithLabelValues(provider, m.language).Observe(d.Seconds())
+}
+
+func (m *sessionMetrics) ObserveFirstResult(provider string, d time.Duration) {
+	firstResultLatency.WithLabelValues(provider, m.language).Observe(d.Seconds())
+}
+
+func (m *sessionMetrics) CountRetry(provider string, code ErrorCode) {
+	retriesTotal.WithLabelValues(provider, m.language, string(code)).Inc()
+}
+
+func (m *sessionMetrics) AddAudio(size int, d time.Duration) {
+	audioReceivedSeconds.WithLabelValues(m.provider, m.language).Add(d.Seconds())
+	audioReceivedBytes.WithLabelValues(m.provider, m.language).Add(float64(size))
+}
+
+func (m *sessionMetrics) AddSuppressedAudio(d time.Duration) {
+	vadSuppressedSeconds.WithLabelValues(m.provider, m.language).Add(d.Seconds())
+}
@@ -0,0 +1,143 @@
+package suzu
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ヒストグラムの観測回数を返す
+func histogramSampleCount(t *testing.T, observer prometheus.Observer) uint64 {
+	t.Helper()
+
+	m := &dto.Metric{}
+	require.NoError(t, observer.(prometheus.Metric).Write(m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+// 音声を 1 パケット受信してから statusTestHandler と同じ処理を行うハンドラ
+type firstAudioTestHandler struct {
+	*statusTestHandler
+}
+
+func newFirstAudioTestHandlerFactory(disconnects int, message string) NewServiceHandlerFunc {
+	return func(Config, string, string, uint32, uint16, string, OnResultFunc) ServiceHandler {
+		return &firstAudioTestHandler{
+			statusTestHandler: &statusTestHandler{
+				disconnects: disconnects,
+				message:     message,
+			},
+		}
+	}
+}
+
+func (h *firstAudioTestHandler) Handle(ctx context.Context, opusCh chan Opus, header SoraHeader) (*io.PipeReader, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-opusCh:
+	}
+	return h.statusTestHandler.Handle(ctx, opusCh, header)
+}
+
+func TestMetricsLanguage(t *testing.T) {
+	assert.Equal(t, "ja-JP", metricsLanguage("ja-JP"))
+	assert.Equal(t, "en-US", metricsLanguage("en-us"))
+	// 一覧にない値や不正な値は other にする
+	assert.Equal(t, metricsLanguageOther, metricsLanguage("xx-XX"))
+	assert.Equal(t, metricsLanguageOther, metricsLanguage("ja-JP\n"))
+	assert.Equal(t, metricsLanguageOther, metricsLanguage(""))
+
+	final := resultsTotal.WithLabelValues("metrics-language", metricsLanguageOther, resultTypeFinal)
+	before := testutil.ToFloat64(final)
+	countResult("metrics-language", "random-1", true)
+	countResult("metrics-language", "random-2", true)
+	assert.Equal(t, 2.0, testutil.ToFloat64(final)-before)
+}
+
+func TestCountResult(t *testing.T) {
+	partial := resultsTotal.WithLabelValues("metrics-test", "ja-JP", resultTypePartial)
+	final := resultsTotal.WithLabelValues("metrics-test", "ja-JP", resultTypeFinal)
+	partialBefore := testutil.ToFloat64(partial)
+	finalBefore := testutil.ToFloat64(final)
+
+	countResult("metrics-test", "ja-JP", false)
+	countResult("metrics-test", "ja-JP", true)
+	countResult("metrics-test", "ja-JP", true)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(partial)-partialBefore)
+	assert.Equal(t, 2.0, testutil.ToFloat64(final)-finalBefore)
+}
+
+func TestSessionMetrics(t *testing.T) {
+	// メトリクスはテスト間で共有されるため、実行前後の差分を確認する
+	type snapshot struct {
+		sessions       uint64
+		connects       uint64
+		firstResults   uint64
+		retries        float64
+		errors         float64
+		audioSeconds   float64
+		audioBytes     float64
+		activeSessions float64
+	}
+
+	take := func(t *testing.T, provider string) snapshot {
+		t.Helper()
+
+		code := string(ErrorCodeProviderDisconnected)
+		return snapshot{
+			sessions:       histogramSampleCount(t, sessionDuration.WithLabelValues(provider, "ja-JP")),
+			connects:       histogramSampleCount(t, providerConnectDuration.WithLabelValues(provider, "ja-JP")),
+			firstResults:   histogramSampleCount(t, firstResultLatency.WithLabelValues(provider, "ja-JP")),
+			retries:        testutil.ToFloat64(retriesTotal.WithLabelValues(provider, "ja-JP", code)),
+			errors:         testutil.ToFloat64(errorsTotal.WithLabelValues(provider, code)),
+			audioSeconds:   testutil.ToFloat64(audioReceivedSeconds.WithLabelValues(provider, "ja-JP")),
+			audioBytes:     testutil.ToFloat64(audioReceivedBytes.WithLabelValues(provider, "ja-JP")),
+			activeSessions: testutil.ToFloat64(activeSessions.WithLabelValues(provider, "ja-JP")),
+		}
+	}
+
+	serve := func(t *testing.T, config Config, serviceHandlers ServiceHandlers, service string) {
+		t.Helper()
+
+		builder := NewServerBuilder(&config, service).WithServiceHandlers(serviceHandlers)
+		for name := range serviceHandlers {
+			builder.WithLanguageCodeFunc(name, func(lang string) (string, error) { return lang, nil })
+		}
+		s, err := builder.Build()
+		require.NoError(t, err)
+
+		serveSpeech[map[string]any](t, s, "/speech")
+	}
+
+	t.Run("retry", func(t *testing.T) {
+		config := Config{
+			ListenAddr:                "127.0.0.1",
+			TimeToWaitForOpusPacketMs: 500,
+			MaxRetry:                  1,
+		}
+
+		serviceHandlers := NewServiceHandlers()
+		serviceHandlers.Register("metrics-retry", newFirstAudioTestHandlerFactory(1, "primary"))
+
+		before := take(t, "metrics-retry")
+		serve(t, config, serviceHandlers, "metrics-retry")
+		after := take(t, "metrics-retry")
+
+		assert.Equal(t, before.activeSessions, after.activeSessions)
+		assert.Equal(t, uint64(1), after.sessions-before.sessions)
+		assert.Equal(t, uint64(2), after.connects-before.connects)
+		assert.Equal(t, uint64(1), after.firstResults-before.firstResults)
+		assert.Equal(t, 1.0, after.retries-before.retries)
+		assert.Equal(t, 1.0, after.errors-before.errors)
+		assert.Positive(t, after.audioSeconds-before.audioSeconds)
+		assert.Positive(t, after.audioBytes-before.audioBytes)
+	})
+}
@@ -0,0 +1,127 @@
+package suzu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrInvalidOggPage = fmt.Errorf("INVALID-OGG-PAGE")
+)
+
+// Ogg のページを読み込み、Opus のパケットを返す
+// Opus のヘッダ（OpusHead と OpusTags）は返さない
+// https://datatracker.ietf.org/doc/html/rfc3533
+// https://datatracker.ietf.org/doc/html/rfc7845
+type oggReader struct {
+	r             io.Reader
+	checksumTable *[256]uint32
+
+	// 読み込んだページに含まれるパケット
+	packets [][]byte
+	// 次のページに続くパケット
+	partial []byte
+
+	// OpusHead のチャネル数、OpusHead を読み込むまでは 0
+	channelCount int
+}
+
+func newOggReader(r io.Reader) *oggReader {
+	return &oggReader{
+		r:             r,
+		checksumTable: generateChecksumTable(),
+	}
+}
+
+// Opus のパケットを返す、終端に達した場合は io.EOF を返す
+func (o *oggReader) ReadPacket() ([]byte, error) {
+	for {
+		for len(o.packets) > 0 {
+			packet := o.packets[0]
+			o.packets = o.packets[1:]
+
+			if bytes.HasPrefix(packet, []byte(idPageSignature)) {
+				// https://datatracker.ietf.org/doc/html/rfc7845#section-5.1
+				if len(packet) > 9 {
+					o.channelCount = int(packet[9])
+				}
+				continue
+			}
+			if bytes.HasPrefix(packet, []byte(commentPageSignature)) {
+				continue
+			}
+			return packet, nil
+		}
+
+		if err := o.readPage(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (o *oggReader) readPage() error {
+	header := make([]byte, pageHeaderSize)
+	if _, err := io.ReadFull(o.r, header); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(header[:4], []byte(pageHeaderSignature)) {
+		return fmt.Errorf("%w: capture pattern", ErrInvalidOggPage)
+	}
+	if header[4] != 0 {
+		return fmt.Errorf("%w: version %d", ErrInvalidOggPage, header[4])
+	}
+
+	segmentTable := make([]byte, header[26])
+	if _, err := io.ReadFull(o.r, segmentTable); err != nil {
+		return err
+	}
+
+	length := 0
+	for _, s := range segmentTable {
+		length += int(s)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(o.r, data); err != nil {
+		return err
+	}
+
+	// チェックサムは 0 にした状態で計算する
+	checksum := binary.LittleEndian.Uint32(header[22:26])
+	binary.LittleEndian.PutUint32(header[22:26], 0)
+	if o.checksum(header, segmentTable, data) != checksum {
+		return fmt.Errorf("%w: checksum", ErrInvalidOggPage)
+	}
+
+	// 前のページから続くパケットではない場合は、途中までのパケットを破棄する
+	const continuedPacket = 0x01
+	if header[5]&continuedPacket == 0 {
+		o.partial = nil
+	}
+
+	// 255 未満の lacing value でパケットが終了する
+	offset := 0
+	for _, s := range segmentTable {
+		o.partial = append(o.partial, data[offset:offset+int(s)]...)
+		offset += int(s)
+
+		if s < 255 {
+			o.packets = append(o.packets, o.partial)
+			o.partial = nil
+		}
+	}
+
+	return nil
+}
+
+func (o *oggReader) checksum(chunks ...[]byte) uint32 {
+	var checksum uint32
+	for _, chunk := range chunks {
+		for _, b := range chunk {
+			checksum = (checksum << 8) ^ o.checksumTable[byte(checksum>>24)^b]
+		}
+	}
+	return checksum
+}
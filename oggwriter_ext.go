@@ -4,6 +4,27 @@ import "github.com/pion/rtp/codecs"
 
 func (i *OggWriter) Write(opusPacket *codecs.OpusPacket) error {
 	payload := opusPacket.Payload[0:]
+
+	i.previousGranulePosition += opusPacketSamples(payload)
+
+	data := i.createPage(payload, pageHeaderTypeContinuationOfStream, i.previousGranulePosition, i.pageIndex)
+	i.pageIndex++
+	return i.writeToStream(data)
+}
+
+// 書き込んだ音声のサンプル数（48kHz）を返す
+// granule position は 1 から始まるため 1 を引く
+func (i *OggWriter) Samples() uint64 {
+	return i.previousGranulePosition - 1
+}
+
+// Opus パケットの TOC バイトから、含まれるサンプル数（48kHz）を返す
+// https://datatracker.ietf.org/doc/html/rfc6716#section-3.1
+func opusPacketSamples(payload []byte) uint64 {
+	if len(payload) == 0 {
+		return 0
+	}
+
 	toc := payload[0]
 	config := (toc & 0xf8) >> 3
 	c := toc & 0x03
@@ -15,18 +36,15 @@ func (i *OggWriter) Write(opusPacket *codecs.OpusPacket) error {
 	case 1, 2:
 		count = 2
 	case 3:
+		if len(payload) < 2 {
+			return 0
+		}
 		m := payload[1] & 0x3f
 		count = uint64(m)
 	}
 
 	// TODO: 値の決定に他の要素が必要ないか確認する
-	granulePosition := GranulePosition(config)
-
-	i.previousGranulePosition += count * granulePosition
-
-	data := i.createPage(payload, pageHeaderTypeContinuationOfStream, i.previousGranulePosition, i.pageIndex)
-	i.pageIndex++
-	return i.writeToStream(data)
+	return count * GranulePosition(config)
 }
 
 // config         | frame size | PCM samples
@@ -15,18 +15,26 @@ const (
 	ErrPayloadTooLarge = "PAYLOAD-TOO-LARGE: %d"
 )
 
+var (
+	// ErrPayloadTooLarge のエラーを判定するためのエラー
+	errPayloadTooLarge = fmt.Errorf("PAYLOAD-TOO-LARGE")
+)
+
 // パケット読み込み時のオプション関数の型定義
 // opus channel を受け取り、オプション処理を行った後の opus channel を返す
-type packetReaderOption func(ctx context.Context, c Config, ch chan opus) chan opus
+type packetReaderOption func(ctx context.Context, c Config, ch chan Opus) chan Opus
 
 // パケット読み込み時のオプション関数群を生成する
-func newPacketReaderOptions(c Config) []packetReaderOption {
+// receivedOptions はヘッダー処理の後、無音パケットを挿入する前に適用する
+func newPacketReaderOptions(c Config, receivedOptions ...packetReaderOption) []packetReaderOption {
 	options := []packetReaderOption{}
 
 	if c.AudioStreamingHeader {
 		options = append(options, optionReadPacketWithHeader)
 	}
 
+	options = append(options, receivedOptions...)
+
 	if !c.DisableSilentPacket {
 		options = append(options, optionSilentPacket)
 	}
@@ -34,8 +42,8 @@ func newPacketReaderOptions(c Config) []packetReaderOption {
 	return options
 }
 
-func optionSilentPacket(ctx context.Context, c Config, opusCh chan opus) chan opus {
-	ch := make(chan opus)
+func optionSilentPacket(ctx context.Context, c Config, opusCh chan Opus) chan Opus {
+	ch := make(chan Opus)
 
 	go func() {
 		defer close(ch)
@@ -44,18 +52,28 @@ func optionSilentPacket(ctx context.Context, c Config, opusCh chan opus) chan op
 		d := time.Duration(c.TimeToWaitForOpusPacketMs) * time.Millisecond
 		timer := time.NewTimer(d)
 
+		// LPCM の音声を受信している場合は、同じ形式の無音を送出する
+		var pcm *PCMFrame
+
 		for {
-			var opusPacket opus
+			var opusPacket Opus
 			select {
 			case <-timer.C:
+				if pcm != nil {
+					opusPacket = Opus{PCM: silentPCMFrame(pcm.SampleRate, pcm.ChannelCount)}
+					break
+				}
 				// サイレントパケットはヘッダー無しで送出する
 				payload := silentPacket()
-				opusPacket = opus{Payload: payload}
+				opusPacket = Opus{Payload: payload}
 			case req, ok := <-opusCh:
 				if !ok {
 					return
 				}
 
+				if req.PCM != nil {
+					pcm = req.PCM
+				}
 				opusPacket = req
 			}
 
@@ -75,8 +93,8 @@ func optionSilentPacket(ctx context.Context, c Config, opusCh chan opus) chan op
 }
 
 // パケット読み込み時のヘッダー処理オプション関数
-func optionReadPacketWithHeader(ctx context.Context, c Config, opusCh chan opus) chan opus {
-	ch := make(chan opus)
+func optionReadPacketWithHeader(ctx context.Context, c Config, opusCh chan Opus) chan Opus {
+	ch := make(chan Opus)
 
 	go func() {
 		defer close(ch)
@@ -97,7 +115,7 @@ func optionReadPacketWithHeader(ctx context.Context, c Config, opusCh chan opus)
 					select {
 					case <-ctx.Done():
 						return
-					case ch <- opus{Err: req.Err}:
+					case ch <- Opus{Err: req.Err}:
 						return
 					}
 				}
@@ -121,7 +139,7 @@ func optionReadPacketWithHeader(ctx context.Context, c Config, opusCh chan opus)
 					select {
 					case <-ctx.Done():
 						return
-					case ch <- opus{Err: fmt.Errorf(ErrPayloadTooLarge, payloadLength)}:
+					case ch <- Opus{Err: fmt.Errorf("%w: %d", errPayloadTooLarge, payloadLength)}:
 						return
 					}
 				}
@@ -134,7 +152,7 @@ func optionReadPacketWithHeader(ctx context.Context, c Config, opusCh chan opus)
 				select {
 				case <-ctx.Done():
 					return
-				case ch <- opus{Payload: p[:payloadLength]}:
+				case ch <- Opus{Payload: p[:payloadLength]}:
 				}
 
 				payload = p[payloadLength:]
@@ -161,7 +179,7 @@ func optionReadPacketWithHeader(ctx context.Context, c Config, opusCh chan opus)
 						select {
 						case <-ctx.Done():
 							return
-						case ch <- opus{Err: fmt.Errorf(ErrPayloadTooLarge, payloadLength)}:
+						case ch <- Opus{Err: fmt.Errorf("%w: %d", errPayloadTooLarge, payloadLength)}:
 							return
 						}
 					}
@@ -175,7 +193,7 @@ func optionReadPacketWithHeader(ctx context.Context, c Config, opusCh chan opus)
 					select {
 					case <-ctx.Done():
 						return
-					case ch <- opus{Payload: p[:payloadLength]}:
+					case ch <- Opus{Payload: p[:payloadLength]}:
 					}
 
 					// 残りの処理へ
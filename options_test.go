@@ -40,7 +40,7 @@ func TestPacketReaderOptionsOrder_SilentAfterHeader(t *testing.T) {
 		TimeToWaitForOpusPacketMs: 20,
 	}
 
-	in := make(chan opus)
+	in := make(chan Opus)
 	out := in
 	for _, opt := range newPacketReaderOptions(c) {
 		out = opt(ctx, c, out)
@@ -56,7 +56,7 @@ func TestPacketReaderOptionsOrder_SilentAfterHeader(t *testing.T) {
 				close(in)
 				return
 			case <-ticker.C:
-				in <- opus{Payload: []byte{0x01}}
+				in <- Opus{Payload: []byte{0x01}}
 			}
 		}
 	}()
@@ -80,7 +80,7 @@ func TestPacketReaderOptionsOrder_SilentAfterHeader(t *testing.T) {
 func TestOptionReadPacketWithHeader_PayloadTooLarge(t *testing.T) {
 	ctx := t.Context()
 
-	in := make(chan opus, 1)
+	in := make(chan Opus, 1)
 	out := optionReadPacketWithHeader(ctx, Config{}, in)
 
 	// ヘッダ内のペイロード長が最大値を超えているケース
@@ -88,7 +88,7 @@ func TestOptionReadPacketWithHeader_PayloadTooLarge(t *testing.T) {
 	header := make([]byte, HeaderLength)
 	binary.BigEndian.PutUint32(header[16:HeaderLength], uint32(payloadLen))
 
-	in <- opus{Payload: header}
+	in <- Opus{Payload: header}
 	close(in)
 
 	got, ok := <-out
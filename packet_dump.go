@@ -0,0 +1,167 @@
+package suzu
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrEmptyPacketDump                 = fmt.Errorf("EMPTY-PACKET-DUMP")
+	ErrMultipleConnectionsInPacketDump = fmt.Errorf("MULTIPLE-CONNECTIONS-IN-PACKET-DUMP")
+)
+
+// PacketDumpHandler が書き込んだダンプを読み込む
+// dump_file には複数の接続のパケットが混在するため、connectionID の接続のパケットのみを返す
+// connectionID が空の場合は、ダンプに含まれる接続が 1 つの場合のみ、その接続のパケットを返す
+func ReadPacketDump(r io.Reader, connectionID string) ([]PacketDumpResult, error) {
+	decoder := json.NewDecoder(r)
+
+	dumps := []PacketDumpResult{}
+	connectionIDs := []string{}
+	for {
+		var dump PacketDumpResult
+		if err := decoder.Decode(&dump); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+
+		if !slices.Contains(connectionIDs, dump.ConnectionID) {
+			connectionIDs = append(connectionIDs, dump.ConnectionID)
+		}
+		if connectionID != "" && dump.ConnectionID != connectionID {
+			continue
+		}
+		dumps = append(dumps, dump)
+	}
+
+	if connectionID == "" && len(connectionIDs) > 1 {
+		return nil, fmt.Errorf("%w: %s", ErrMultipleConnectionsInPacketDump, strings.Join(connectionIDs, ", "))
+	}
+	if len(dumps) == 0 {
+		return nil, ErrEmptyPacketDump
+	}
+	return dumps, nil
+}
+
+// ダンプの Opus のパケットを Ogg に書き込んで f を閉じる
+// サンプリングレートとチャネル数は最初のパケットの値を使用する
+func WritePacketDumpOgg(f io.WriteCloser, dumps []PacketDumpResult) error {
+	if len(dumps) == 0 {
+		f.Close()
+		return ErrEmptyPacketDump
+	}
+
+	r, err := newOggRecorderWith("", f, dumps[0].SampleRate, dumps[0].ChannelCount)
+	if err != nil {
+		return err
+	}
+
+	for _, dump := range dumps {
+		if err := r.Write(dump.Payload); err != nil {
+			r.Close()
+			return err
+		}
+	}
+	return r.Close()
+}
+
+// ダンプを Suzu の /speech などに送信する際の指定
+type PacketDumpReplay struct {
+	URL string
+	// 追加するヘッダ
+	// sora-channel-id、sora-connection-id、sora-audio-streaming-language-code は指定しない場合はダンプの値を使用する
+	Header http.Header
+	// audio_streaming_header が有効なサーバに送信する場合に、パケットにヘッダを付与する指定
+	AudioStreamingHeader bool
+	// ダンプのタイムスタンプの間隔に対する送信の速度、0 の場合は待たずに送信する
+	Speed float64
+}
+
+// ダンプのパケットをタイムスタンプの間隔で送信し、受信した結果を w に書き込む
+// client は HTTP/2 で接続する必要がある
+func (p PacketDumpReplay) Do(ctx context.Context, client *http.Client, dumps []PacketDumpResult, w io.Writer) error {
+	if len(dumps) == 0 {
+		return ErrEmptyPacketDump
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	body, bodyWriter := io.Pipe()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("sora-channel-id", dumps[0].ChannelID)
+	req.Header.Set("sora-connection-id", dumps[0].ConnectionID)
+	req.Header.Set("sora-audio-streaming-language-code", dumps[0].LanguageCode)
+	for name, values := range p.Header {
+		req.Header[name] = values
+	}
+
+	go func() {
+		bodyWriter.CloseWithError(p.writePackets(ctx, bodyWriter, dumps))
+	}()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code: %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// パケットを 1 つずつ書き込む
+func (p PacketDumpReplay) writePackets(ctx context.Context, w io.Writer, dumps []PacketDumpResult) error {
+	startedAt := time.Now()
+	for i, dump := range dumps {
+		if p.Speed > 0 {
+			elapsed := time.Duration(float64(time.Duration(dump.Timestamp-dumps[0].Timestamp)*time.Millisecond) / p.Speed)
+			timer := time.NewTimer(time.Until(startedAt.Add(elapsed)))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		packet := dump.Payload
+		if p.AudioStreamingHeader {
+			packet = packetWithHeader(dump.Timestamp, uint64(i), dump.Payload)
+		}
+		if _, err := w.Write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// audio_streaming_header のヘッダを付与する
+// timestamp はマイクロ秒
+func packetWithHeader(timestampMs int64, sequence uint64, payload []byte) []byte {
+	packet := make([]byte, HeaderLength, HeaderLength+len(payload))
+	// timestamp(64), sequence number(64), length(32)
+	binary.BigEndian.PutUint64(packet[0:8], uint64(timestampMs*1000))
+	binary.BigEndian.PutUint64(packet[8:16], sequence)
+	binary.BigEndian.PutUint32(packet[16:HeaderLength], uint32(len(payload)))
+	return append(packet, payload...)
+}
@@ -9,10 +9,6 @@ import (
 	"time"
 )
 
-func init() {
-	NewServiceHandlerFuncs.register("dump", NewPacketDumpHandler)
-}
-
 type PacketDumpHandler struct {
 	Config Config
 
@@ -24,10 +20,10 @@ type PacketDumpHandler struct {
 	RetryCount   int
 	mu           sync.Mutex
 
-	OnResultFunc func(context.Context, io.WriteCloser, string, string, string, any) error
+	OnResultFunc OnResultFunc
 }
 
-func NewPacketDumpHandler(config Config, channelID, connectionID string, sampleRate uint32, channelCount uint16, languageCode string, onResultFunc any) serviceHandlerInterface {
+func NewPacketDumpHandler(config Config, channelID, connectionID string, sampleRate uint32, channelCount uint16, languageCode string, onResultFunc OnResultFunc) ServiceHandler {
 	return &PacketDumpHandler{
 		Config:       config,
 		ChannelID:    channelID,
@@ -35,7 +31,7 @@ func NewPacketDumpHandler(config Config, channelID, connectionID string, sampleR
 		SampleRate:   sampleRate,
 		ChannelCount: channelCount,
 		LanguageCode: languageCode,
-		OnResultFunc: onResultFunc.(func(context.Context, io.WriteCloser, string, string, string, any) error),
+		OnResultFunc: onResultFunc,
 	}
 }
 
@@ -72,7 +68,7 @@ func (h *PacketDumpHandler) IsRetryTarget(any) bool {
 	return false
 }
 
-func (h *PacketDumpHandler) Handle(ctx context.Context, opusCh chan opus, header soraHeader) (*io.PipeReader, error) {
+func (h *PacketDumpHandler) Handle(ctx context.Context, opusCh chan Opus, header SoraHeader) (*io.PipeReader, error) {
 	c := h.Config
 	filename := c.DumpFile
 	channelID := h.ChannelID
@@ -83,13 +79,25 @@ func (h *PacketDumpHandler) Handle(ctx context.Context, opusCh chan opus, header
 	reader := opusChannelToIOReadCloser(ctx, opusCh)
 
 	go func() {
-		f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		// 暗号化する場合は、暗号化していないダンプに追記しないように別のファイルに書き込む
+		if c.EncryptionKeyFile != "" {
+			filename += encryptedFileSuffix
+		}
+
+		file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
 			w.CloseWithError(err)
 			return
 		}
-		defer f.Close()
+		f, err := newEncryptWriterFromConfig(c, file)
+		if err != nil {
+			file.Close()
+			w.CloseWithError(err)
+			return
+		}
+		// レスポンスの終了時にはファイルへの書き込みを完了させる
 		defer w.Close()
+		defer f.Close()
 
 		mw := io.MultiWriter(f, w)
 		encoder := json.NewEncoder(mw)
@@ -112,7 +120,7 @@ func (h *PacketDumpHandler) Handle(ctx context.Context, opusCh chan opus, header
 				}
 
 				if h.OnResultFunc != nil {
-					if err := h.OnResultFunc(ctx, w, h.ChannelID, h.ConnectionID, h.LanguageCode, dump); err != nil {
+					if err := h.OnResultFunc(ctx, w, h.ChannelID, h.ConnectionID, h.LanguageCode, ServiceResult{Provider: "dump", PacketDump: dump}); err != nil {
 						w.CloseWithError(err)
 						return
 					}
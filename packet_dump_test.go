@@ -0,0 +1,175 @@
+package suzu
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readPacketDumpForTest(t *testing.T, filename string) []PacketDumpResult {
+	t.Helper()
+
+	f, err := os.Open(filename)
+	require.NoError(t, err)
+	defer f.Close()
+
+	dumps, err := ReadPacketDump(f, "")
+	require.NoError(t, err)
+	return dumps
+}
+
+func TestReadPacketDump(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		dumps := readPacketDumpForTest(t, "testdata/dump.jsonl")
+		require.Len(t, dumps, 9)
+		assert.Equal(t, "JG6CSF8P6D3PS61FW1S4KGK8FM", dumps[0].ConnectionID)
+		assert.Equal(t, uint32(48000), dumps[0].SampleRate)
+		assert.Equal(t, uint16(2), dumps[0].ChannelCount)
+	})
+
+	// dump_file に複数の接続のパケットが混在する場合
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, connectionID := range []string{"conn-1", "conn-2", "conn-1"} {
+		require.NoError(t, encoder.Encode(PacketDumpResult{ConnectionID: connectionID, Payload: silentPacket()}))
+	}
+
+	t.Run("multiple connections", func(t *testing.T) {
+		_, err := ReadPacketDump(bytes.NewReader(buf.Bytes()), "")
+		assert.ErrorIs(t, err, ErrMultipleConnectionsInPacketDump)
+		assert.ErrorContains(t, err, "conn-1, conn-2")
+	})
+
+	t.Run("connection id", func(t *testing.T) {
+		dumps, err := ReadPacketDump(bytes.NewReader(buf.Bytes()), "conn-1")
+		require.NoError(t, err)
+		assert.Len(t, dumps, 2)
+
+		_, err = ReadPacketDump(bytes.NewReader(buf.Bytes()), "conn-3")
+		assert.ErrorIs(t, err, ErrEmptyPacketDump)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := ReadPacketDump(strings.NewReader("{"), "")
+		assert.Error(t, err)
+	})
+}
+
+func TestWritePacketDumpOgg(t *testing.T) {
+	dumps := readPacketDumpForTest(t, "testdata/dump.jsonl")
+
+	filename := filepath.Join(t.TempDir(), "dump.ogg")
+	f, err := os.Create(filename)
+	require.NoError(t, err)
+	require.NoError(t, WritePacketDumpOgg(f, dumps))
+
+	pages := readOggFileForTest(t, filename)
+	audioPages := pages[2:]
+	require.Len(t, audioPages, 9)
+	for i, page := range audioPages {
+		assert.Equal(t, dumps[i].Payload, page.Payload)
+		assert.Equal(t, uint64(960*(i+1)), page.GranulePosition)
+	}
+}
+
+func TestPacketDumpReplay(t *testing.T) {
+	dumps := readPacketDumpForTest(t, "testdata/dump.jsonl")
+
+	newTestServer := func(t *testing.T, audioStreamingHeader bool) *httptest.Server {
+		t.Helper()
+
+		config := Config{
+			ListenAddr:                "127.0.0.1",
+			TimeToWaitForOpusPacketMs: 500,
+			DisableSilentPacket:       true,
+			AudioStreamingHeader:      audioStreamingHeader,
+		}
+		s, err := NewServer(&config, "test")
+		require.NoError(t, err)
+
+		ts := httptest.NewUnstartedServer(s.echo)
+		ts.EnableHTTP2 = true
+		ts.StartTLS()
+		t.Cleanup(ts.Close)
+		return ts
+	}
+
+	t.Run("success", func(t *testing.T) {
+		ts := newTestServer(t, false)
+
+		var out bytes.Buffer
+		replay := PacketDumpReplay{
+			URL:   ts.URL + "/speech",
+			Speed: 1,
+		}
+		startedAt := time.Now()
+		require.NoError(t, replay.Do(t.Context(), ts.Client(), dumps, &out))
+
+		// ダンプのタイムスタンプの間隔で送信する
+		assert.GreaterOrEqual(t, time.Since(startedAt), time.Duration(dumps[len(dumps)-1].Timestamp-dumps[0].Timestamp)*time.Millisecond)
+
+		var result TestResult
+		require.NoError(t, json.NewDecoder(&out).Decode(&result))
+		assert.Equal(t, "n: 3", result.Message)
+	})
+
+	t.Run("audio streaming header", func(t *testing.T) {
+		ts := newTestServer(t, true)
+
+		var out bytes.Buffer
+		replay := PacketDumpReplay{
+			URL:                  ts.URL + "/speech",
+			AudioStreamingHeader: true,
+		}
+		require.NoError(t, replay.Do(t.Context(), ts.Client(), dumps, &out))
+
+		// ヘッダを取り除いた Opus のパケットのみをサービスに送信する
+		var result TestResult
+		require.NoError(t, json.NewDecoder(&out).Decode(&result))
+		assert.Equal(t, "n: 3", result.Message)
+	})
+
+	t.Run("header", func(t *testing.T) {
+		received := make(chan http.Header, 1)
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received <- r.Header.Clone()
+			http.Error(w, "UNSUPPORTED-LANGUAGE-CODE", http.StatusBadRequest)
+		}))
+		ts.EnableHTTP2 = true
+		ts.StartTLS()
+		defer ts.Close()
+
+		replay := PacketDumpReplay{
+			URL: ts.URL + "/speech",
+			// ダンプの値を上書きする
+			Header: http.Header{"Sora-Audio-Streaming-Language-Code": []string{"xx-XX"}},
+		}
+		err := replay.Do(t.Context(), ts.Client(), dumps, &bytes.Buffer{})
+		assert.ErrorContains(t, err, "unexpected status code: 400: UNSUPPORTED-LANGUAGE-CODE")
+
+		header := <-received
+		assert.Equal(t, "sora", header.Get("sora-channel-id"))
+		assert.Equal(t, "JG6CSF8P6D3PS61FW1S4KGK8FM", header.Get("sora-connection-id"))
+		assert.Equal(t, "xx-XX", header.Get("sora-audio-streaming-language-code"))
+	})
+}
+
+func TestPacketWithHeader(t *testing.T) {
+	packet := packetWithHeader(1667274760504, 1, []byte{0xfc, 0xff, 0xfe})
+	// testdata/header.jsonl の 2 番目のパケットと同じ形式
+	assert.Equal(t, []byte{
+		0x00, 0x05, 0xec, 0x60, 0xa7, 0xd7, 0xc2, 0xc0,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x03,
+		0xfc, 0xff, 0xfe,
+	}, packet)
+}
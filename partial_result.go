@@ -0,0 +1,97 @@
+package suzu
+
+import (
+	"fmt"
+)
+
+const (
+	// 途中結果の送信方法
+	// 毎回、途中結果の全体を送信する
+	partialResultModeFull = "full"
+	// 前回送信した途中結果から変化した部分のみを送信する
+	partialResultModeDiff = "diff"
+)
+
+var (
+	ErrUnsupportedPartialResultMode = fmt.Errorf("UNSUPPORTED-PARTIAL-RESULT-MODE")
+)
+
+// 発話ごとに前回送信した途中結果を保持して、変化した部分を返す
+// 1 つのストリームの結果の受信処理でのみ使用するため排他制御はしない
+type partialResultTracker struct {
+	// チャネルごとに、発話の識別子と前回送信した途中結果を保持する
+	messages map[string]map[string][]rune
+}
+
+func newPartialResultTracker() *partialResultTracker {
+	return &partialResultTracker{
+		messages: make(map[string]map[string][]rune),
+	}
+}
+
+// 前回の途中結果と一致する先頭部分の文字数と、それ以降の変化した部分を返す
+// 前回の途中結果から変化していない場合は false を返す
+func (t *partialResultTracker) Diff(channel, id, message string) (int, string, bool) {
+	messages, ok := t.messages[channel]
+	if !ok {
+		messages = make(map[string][]rune)
+		t.messages[channel] = messages
+	}
+
+	current := []rune(message)
+	previous, ok := messages[id]
+	messages[id] = current
+
+	if !ok {
+		return 0, message, true
+	}
+
+	n := 0
+	for n < len(previous) && n < len(current) && previous[n] == current[n] {
+		n++
+	}
+
+	// 変化していない場合
+	if n == len(previous) && n == len(current) {
+		return n, "", false
+	}
+
+	return n, string(current[n:]), true
+}
+
+// 最終結果を受信したチャネルの途中結果をすべて破棄する
+// 最終結果の後の途中結果は、以前の発話の途中結果と比較しない
+func (t *partialResultTracker) Delete(channel string) {
+	delete(t.messages, channel)
+}
+
+// 途中結果の送信方法に応じて、送信するメッセージを返す
+// diff の場合は、クライアントが置き換える途中結果を判別できるように partial_id に id を指定する
+// id が空の場合は発話を識別できないため、前回の途中結果と比較せずに全体を返す
+// 送信する必要がない場合は false を返す
+func (t *partialResultTracker) Apply(result *TranscriptionResult, config Config, channel, id, message string, isFinal bool) bool {
+	result.ReplaceFrom = nil
+	result.PartialID = ""
+	result.Message = message
+
+	if config.PartialResultMode != partialResultModeDiff || id == "" {
+		return true
+	}
+
+	result.PartialID = id
+
+	if isFinal {
+		// 最終結果は全体を送信する
+		t.Delete(channel)
+		return true
+	}
+
+	replaceFrom, tail, ok := t.Diff(channel, id, message)
+	if !ok {
+		return false
+	}
+
+	result.ReplaceFrom = &replaceFrom
+	result.Message = tail
+	return true
+}
@@ -0,0 +1,120 @@
+package suzu
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartialResultTrackerDiff(t *testing.T) {
+	tracker := newPartialResultTracker()
+
+	testCases := []struct {
+		Name        string
+		Channel     string
+		ID          string
+		Message     string
+		ReplaceFrom int
+		Tail        string
+		Ok          bool
+	}{
+		{Name: "first", Channel: "0", ID: "a", Message: "こんに", ReplaceFrom: 0, Tail: "こんに", Ok: true},
+		{Name: "append", Channel: "0", ID: "a", Message: "こんにちは", ReplaceFrom: 3, Tail: "ちは", Ok: true},
+		{Name: "unchanged", Channel: "0", ID: "a", Message: "こんにちは", ReplaceFrom: 5, Tail: "", Ok: false},
+		{Name: "replace", Channel: "0", ID: "a", Message: "こんばんは", ReplaceFrom: 2, Tail: "ばんは", Ok: true},
+		{Name: "shorten", Channel: "0", ID: "a", Message: "こん", ReplaceFrom: 2, Tail: "", Ok: true},
+		{Name: "other id", Channel: "0", ID: "b", Message: "はい", ReplaceFrom: 0, Tail: "はい", Ok: true},
+		{Name: "other channel", Channel: "1", ID: "a", Message: "はい", ReplaceFrom: 0, Tail: "はい", Ok: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			replaceFrom, tail, ok := tracker.Diff(tc.Channel, tc.ID, tc.Message)
+			assert.Equal(t, tc.ReplaceFrom, replaceFrom)
+			assert.Equal(t, tc.Tail, tail)
+			assert.Equal(t, tc.Ok, ok)
+		})
+	}
+}
+
+func TestPartialResultTrackerApply(t *testing.T) {
+	encode := func(t *testing.T, result AwsResultV2) string {
+		t.Helper()
+
+		b, err := json.Marshal(result)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	t.Run("full", func(t *testing.T) {
+		tracker := newPartialResultTracker()
+		config := Config{PartialResultMode: partialResultModeFull}
+
+		result := NewAwsResultV2()
+		assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "0", "1", "こんに", false))
+		assert.Equal(t, `{"message":"こんに","type":"aws"}`, encode(t, result))
+
+		assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "0", "1", "こんに", false))
+		assert.Equal(t, `{"message":"こんに","type":"aws"}`, encode(t, result))
+	})
+
+	t.Run("diff", func(t *testing.T) {
+		tracker := newPartialResultTracker()
+		config := Config{PartialResultMode: partialResultModeDiff}
+
+		result := NewAwsResultV2()
+		assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "0", "1", "こんに", false))
+		assert.Equal(t, `{"message":"こんに","type":"aws","replace_from":0,"partial_id":"1"}`, encode(t, result))
+
+		assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "0", "1", "こんにちは", false))
+		assert.Equal(t, `{"message":"ちは","type":"aws","replace_from":3,"partial_id":"1"}`, encode(t, result))
+
+		// 変化していない途中結果は送信しない
+		assert.False(t, tracker.Apply(&result.TranscriptionResult, config, "0", "1", "こんにちは", false))
+
+		// 最終結果は全体を送信する
+		assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "0", "1", "こんにちは。", true))
+		assert.Equal(t, `{"message":"こんにちは。","type":"aws","partial_id":"1"}`, encode(t, result))
+
+		// 最終結果の後は新しい発話として扱う
+		assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "0", "1", "はい", false))
+		assert.Equal(t, `{"message":"はい","type":"aws","replace_from":0,"partial_id":"1"}`, encode(t, result))
+	})
+
+	t.Run("final clears channel", func(t *testing.T) {
+		tracker := newPartialResultTracker()
+		config := Config{PartialResultMode: partialResultModeDiff}
+
+		result := NewAwsResultV2()
+		assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "ch_0", "1", "こんに", false))
+		assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "ch_0", "2", "はい", false))
+		assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "ch_1", "1", "もしもし", false))
+
+		// 最終結果を受信したチャネルの途中結果はすべて破棄する
+		assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "ch_0", "1", "こんにちは。", true))
+		assert.NotContains(t, tracker.messages, "ch_0")
+
+		assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "ch_0", "2", "はい", false))
+		assert.Equal(t, `{"message":"はい","type":"aws","replace_from":0,"partial_id":"2"}`, encode(t, result))
+
+		// 他のチャネルの途中結果は破棄しない
+		assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "ch_1", "1", "もしもしは", false))
+		assert.Equal(t, `{"message":"は","type":"aws","replace_from":4,"partial_id":"1"}`, encode(t, result))
+	})
+
+	t.Run("empty id", func(t *testing.T) {
+		tracker := newPartialResultTracker()
+		config := Config{PartialResultMode: partialResultModeDiff}
+
+		// 発話を識別できない場合は毎回全体を送信する
+		result := NewAwsResultV2()
+		assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "0", "", "こんに", false))
+		assert.Equal(t, `{"message":"こんに","type":"aws"}`, encode(t, result))
+
+		assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "0", "", "こんに", false))
+		assert.Equal(t, `{"message":"こんに","type":"aws"}`, encode(t, result))
+		assert.Empty(t, tracker.messages)
+	})
+}
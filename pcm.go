@@ -0,0 +1,72 @@
+package suzu
+
+import (
+	"context"
+	"io"
+)
+
+const (
+	// 1 パケットの最大長 120 ms の 48kHz ステレオのサンプル数
+	maxOpusPacketSamples = 5760 * 2
+)
+
+// 受信した opus データをデコードして、16 bit リトルエンディアンの PCM を読み出す io.ReadCloser を返す
+// 受信した LPCM の音声はサンプリングレートとチャネル数のみを変換する
+func opus2pcm(ctx context.Context, opusCh chan Opus, sampleRate uint32, channelCount uint16) (io.ReadCloser, error) {
+	processor, err := newAudioProcessor(sampleRate, channelCount)
+	if err != nil {
+		return nil, err
+	}
+
+	pcmReader, pcmWriter := io.Pipe()
+
+	// サービスに送信した音声の長さを利用量として記録する
+	usage := sessionUsageFromContext(ctx)
+
+	// コンテキストが閉じられたときに pcmWriter を閉じる
+	closeOnDone(ctx, pcmWriter)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				pcmWriter.CloseWithError(ctx.Err())
+				return
+			case opus, ok := <-opusCh:
+				if !ok {
+					pcmWriter.CloseWithError(io.EOF)
+					return
+				}
+
+				if opus.Err != nil {
+					pcmWriter.CloseWithError(opus.Err)
+					return
+				}
+
+				// 受信した LPCM の音声はデコードせずに変換する
+				if opus.PCM != nil {
+					if _, err := pcmWriter.Write(pcmBytes(processor.ProcessPCM(opus.PCM))); err != nil {
+						pcmWriter.CloseWithError(err)
+						return
+					}
+					usage.AddPCM(opus.PCM)
+					continue
+				}
+
+				samples, err := processor.Process(opus.Payload)
+				if err != nil {
+					pcmWriter.CloseWithError(err)
+					return
+				}
+
+				if _, err := pcmWriter.Write(pcmBytes(samples)); err != nil {
+					pcmWriter.CloseWithError(err)
+					return
+				}
+				usage.AddOpusPacket(opus.Payload)
+			}
+		}
+	}()
+
+	return pcmReader, nil
+}
@@ -0,0 +1,260 @@
+package suzu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	zlog "github.com/rs/zerolog/log"
+	"github.com/shiguredo/suzu/pluginpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// プラグインに送信する音声データの形式
+	pluginAudioFormatOgg  = "ogg"
+	pluginAudioFormatOpus = "opus"
+)
+
+var (
+	ErrPluginUnsupportedAudioFormat = fmt.Errorf("PLUGIN-UNSUPPORTED-AUDIO-FORMAT")
+)
+
+// 別プロセスで起動したプラグインとの gRPC のストリーム
+type PluginStream struct {
+	conn   *grpc.ClientConn
+	stream grpc.BidiStreamingClient[pluginpb.RecognizeRequest, pluginpb.RecognizeResponse]
+}
+
+func (s *PluginStream) Recv() (*pluginpb.RecognizeResponse, error) {
+	return s.stream.Recv()
+}
+
+func (s *PluginStream) Close() error {
+	return s.conn.Close()
+}
+
+type Plugin struct {
+	Address      string
+	AudioFormat  string
+	LanguageCode string
+	SampleRate   uint32
+	ChannelCount uint16
+	Config       Config
+}
+
+func NewPlugin(c Config, languageCode string, sampleRate uint32, channelCount uint16) *Plugin {
+	return &Plugin{
+		Address:      c.PluginAddress,
+		AudioFormat:  c.PluginAudioFormat,
+		LanguageCode: languageCode,
+		SampleRate:   sampleRate,
+		ChannelCount: channelCount,
+		Config:       c,
+	}
+}
+
+func (p *Plugin) audioEncoding() (pluginpb.AudioEncoding, error) {
+	switch p.AudioFormat {
+	case pluginAudioFormatOpus:
+		return pluginpb.AudioEncoding_AUDIO_ENCODING_OPUS, nil
+	case pluginAudioFormatOgg:
+		return pluginpb.AudioEncoding_AUDIO_ENCODING_OGG_OPUS, nil
+	}
+
+	return pluginpb.AudioEncoding_AUDIO_ENCODING_UNSPECIFIED, NewSuzuConfError(fmt.Errorf("%w: %s", ErrPluginUnsupportedAudioFormat, p.AudioFormat))
+}
+
+// プラグインに送信する音声データを読み出す io.ReadCloser を返す
+func (p *Plugin) NewAudioReader(ctx context.Context, opusCh chan Opus, header SoraHeader) (io.ReadCloser, error) {
+	switch p.AudioFormat {
+	case pluginAudioFormatOgg:
+		return opus2ogg(ctx, opusCh, p.SampleRate, p.ChannelCount)
+	case pluginAudioFormatOpus:
+		return opusChannelToIOReadCloser(ctx, opusCh), nil
+	}
+
+	return nil, NewSuzuConfError(fmt.Errorf("%w: %s", ErrPluginUnsupportedAudioFormat, p.AudioFormat))
+}
+
+func (p *Plugin) Start(ctx context.Context, r io.ReadCloser, header SoraHeader, retryCount int) (*PluginStream, error) {
+	encoding, err := p.audioEncoding()
+	if err != nil {
+		return nil, err
+	}
+
+	audioData, err := receiveFirstAudioData(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// プラグインは同じホストで起動することを想定しているため TLS は利用しない
+	conn, err := grpc.NewClient(p.Address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, NewSuzuConfError(err)
+	}
+
+	zlog.Info().
+		Str("channel_id", header.SoraChannelID).
+		Str("connection_id", header.SoraConnectionID).
+		Str("address", p.Address).
+		Msg("Starting plugin stream")
+
+	stream, err := pluginpb.NewSpeechPluginClient(conn).Recognize(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, newPluginStatusError(err)
+	}
+
+	s := &PluginStream{
+		conn:   conn,
+		stream: stream,
+	}
+
+	// 最初にセッションの情報を送信する
+	config := &pluginpb.SessionConfig{
+		ChannelId:     header.SoraChannelID,
+		SessionId:     header.SoraSessionID,
+		ConnectionId:  header.SoraConnectionID,
+		LanguageCode:  p.LanguageCode,
+		SampleRate:    p.SampleRate,
+		ChannelCount:  uint32(p.ChannelCount),
+		AudioEncoding: encoding,
+		RetryCount:    int32(retryCount),
+	}
+	if err := s.sendConfig(config); err != nil {
+		r.Close()
+		s.Close()
+		return nil, newPluginStatusError(err)
+	}
+
+	if err := s.sendAudio(audioData); err != nil {
+		r.Close()
+		s.Close()
+		return nil, newPluginStatusError(err)
+	}
+
+	zlog.Info().
+		Str("channel_id", header.SoraChannelID).
+		Str("connection_id", header.SoraConnectionID).
+		Str("address", p.Address).
+		Msg("Started plugin stream")
+
+	go func() {
+		defer r.Close()
+
+		frame := make([]byte, FrameSize)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+
+			n, err := r.Read(frame)
+			if err != nil {
+				if !errors.Is(err, io.EOF) && !errors.Is(err, context.Canceled) {
+					zlog.Error().Err(err).Send()
+				}
+
+				if err := s.stream.CloseSend(); err != nil {
+					zlog.Error().Err(err).Send()
+				}
+				return
+			}
+			if n > 0 {
+				if err := s.sendAudio(frame[:n]); err != nil {
+					// 送信のエラーの詳細は Recv で受信するため、ここでは終了するだけにする
+					if !errors.Is(err, io.EOF) {
+						zlog.Error().Err(err).Send()
+					}
+					return
+				}
+			}
+		}
+	}()
+
+	return s, nil
+}
+
+func (s *PluginStream) sendConfig(config *pluginpb.SessionConfig) error {
+	return s.stream.Send(&pluginpb.RecognizeRequest{
+		Request: &pluginpb.RecognizeRequest_Config{
+			Config: config,
+		},
+	})
+}
+
+func (s *PluginStream) sendAudio(data []byte) error {
+	// 送信する前に再利用されるバッファを書き換えないように複製する
+	payload := make([]byte, len(data))
+	copy(payload, data)
+
+	return s.stream.Send(&pluginpb.RecognizeRequest{
+		Request: &pluginpb.RecognizeRequest_Audio{
+			Audio: &pluginpb.AudioPacket{
+				Payload: payload,
+			},
+		},
+	})
+}
+
+// 接続時のエラーを gRPC のステータスコードに応じて SuzuError に変換する
+func newPluginStatusError(err error) error {
+	if errors.Is(err, io.EOF) {
+		// ストリームが閉じられた場合は Send が io.EOF を返すため、再接続を試みる
+		return &SuzuError{
+			Code:      http.StatusServiceUnavailable,
+			Message:   err.Error(),
+			Retry:     true,
+			ErrorCode: ErrorCodeProviderDisconnected,
+		}
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	return &SuzuError{
+		Code:      pluginHTTPStatusCode(st.Code()),
+		Message:   st.Message(),
+		Retry:     isPluginRetryCode(st.Code()),
+		ErrorCode: grpcErrorCode(st.Code()),
+	}
+}
+
+func isPluginRetryCode(code codes.Code) bool {
+	switch code {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.Internal:
+		return true
+	}
+	return false
+}
+
+func pluginHTTPStatusCode(code codes.Code) int {
+	switch code {
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,229 @@
+package suzu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+
+	zlog "github.com/rs/zerolog/log"
+	"github.com/shiguredo/suzu/pluginpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type PluginHandler struct {
+	Config Config
+
+	ChannelID    string
+	ConnectionID string
+	SampleRate   uint32
+	ChannelCount uint16
+	LanguageCode string
+	RetryCount   int
+	mu           sync.Mutex
+
+	OnResultFunc OnResultFunc
+}
+
+func NewPluginHandler(config Config, channelID, connectionID string, sampleRate uint32, channelCount uint16, languageCode string, onResultFunc OnResultFunc) ServiceHandler {
+	return &PluginHandler{
+		Config:       config,
+		ChannelID:    channelID,
+		ConnectionID: connectionID,
+		SampleRate:   sampleRate,
+		ChannelCount: channelCount,
+		LanguageCode: languageCode,
+		OnResultFunc: onResultFunc,
+	}
+}
+
+type PluginResult struct {
+	IsFinal  *bool   `json:"is_final,omitempty"`
+	ResultID *string `json:"result_id,omitempty"`
+	TranscriptionResult
+}
+
+func NewPluginResult() PluginResult {
+	return PluginResult{
+		TranscriptionResult: TranscriptionResult{
+			Type: "plugin",
+		},
+	}
+}
+
+func (pr *PluginResult) WithIsFinal(isFinal bool) *PluginResult {
+	pr.IsFinal = &isFinal
+	return pr
+}
+
+func (pr *PluginResult) WithResultID(resultID string) *PluginResult {
+	pr.ResultID = &resultID
+	return pr
+}
+
+func (pr *PluginResult) SetMessage(message string) *PluginResult {
+	pr.Message = message
+	return pr
+}
+
+func (h *PluginHandler) UpdateRetryCount() int {
+	defer h.mu.Unlock()
+	h.mu.Lock()
+	h.RetryCount++
+	return h.RetryCount
+}
+
+func (h *PluginHandler) GetRetryCount() int {
+	return h.RetryCount
+}
+
+func (h *PluginHandler) ResetRetryCount() int {
+	defer h.mu.Unlock()
+	h.mu.Lock()
+	h.RetryCount = 0
+	return h.RetryCount
+}
+
+func (h *PluginHandler) IsRetryTarget(args any) bool {
+	switch err := args.(type) {
+	case error:
+		// プラグインが再起動した場合などは再接続を試みる
+		if st, ok := status.FromError(err); ok && st.Code() != codes.OK {
+			if isPluginRetryCode(st.Code()) {
+				return true
+			}
+
+			if isRetryTargetByConfig(h.Config, st.Code().String()) {
+				return true
+			}
+		}
+
+		// retry_targets に設定されているエラーの場合はリトライする
+		if isRetryTargetByConfig(h.Config, err.Error()) {
+			return true
+		}
+	default:
+	}
+
+	return false
+}
+
+func (h *PluginHandler) Handle(ctx context.Context, opusCh chan Opus, header SoraHeader) (*io.PipeReader, error) {
+	p := NewPlugin(h.Config, h.LanguageCode, h.SampleRate, h.ChannelCount)
+
+	packetReader, err := p.NewAudioReader(ctx, opusCh, header)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := p.Start(ctx, packetReader, header, h.GetRetryCount())
+	if err != nil {
+		return nil, err
+	}
+
+	// リクエストが成功した時点でリトライカウントをリセットする
+	h.ResetRetryCount()
+
+	r, w := io.Pipe()
+
+	go func() {
+		defer stream.Close()
+
+		encoder := json.NewEncoder(w)
+
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				// context がキャンセルされた場合、または、プラグインが正常に終了した場合は終了
+				if ctx.Err() != nil || errors.Is(err, io.EOF) {
+					w.Close()
+					return
+				}
+
+				zlog.Error().
+					Err(err).
+					Str("channel_id", h.ChannelID).
+					Str("connection_id", h.ConnectionID).
+					Int("retry_count", h.GetRetryCount()).
+					Send()
+
+				if ok := h.IsRetryTarget(err); ok {
+					err = errors.Join(err, ErrServerDisconnected)
+				}
+
+				w.CloseWithError(err)
+				return
+			}
+
+			res := resp.GetResult()
+			if res == nil {
+				continue
+			}
+
+			if h.OnResultFunc != nil {
+				if err := h.OnResultFunc(ctx, w, h.ChannelID, h.ConnectionID, h.LanguageCode, ServiceResult{Provider: "plugin", Plugin: res}); err != nil {
+					if err := encoder.Encode(newServiceErrorResponse(err, "plugin", "")); err != nil {
+						zlog.Error().
+							Err(err).
+							Str("channel_id", h.ChannelID).
+							Str("connection_id", h.ConnectionID).
+							Send()
+					}
+					w.CloseWithError(err)
+					return
+				}
+				continue
+			}
+
+			result, ok := buildPluginResult(h.Config, res)
+			if !ok {
+				continue
+			}
+
+			if err := encoder.Encode(result); err != nil {
+				w.CloseWithError(err)
+				return
+			}
+			countResult("plugin", h.LanguageCode, res.GetIsFinal())
+			roomSpeakerFromContext(ctx).AddResult("plugin", h.LanguageCode, result.Message, res.GetIsFinal())
+		}
+	}()
+
+	return r, nil
+}
+
+// プラグインから受信した結果をクライアントに送る結果に変換する
+func buildPluginResult(config Config, res *pluginpb.Result) (PluginResult, bool) {
+	result := NewPluginResult()
+
+	if res.GetMessage() == "" {
+		return result, false
+	}
+
+	if config.FinalResultOnly {
+		if !res.GetIsFinal() {
+			return result, false
+		}
+	}
+
+	// text_processor_rules_file で指定した加工を適用する
+	message, ok := processResultMessage(config, res.GetMessage())
+	if !ok {
+		return result, false
+	}
+
+	if config.PluginResultIsFinal {
+		result.WithIsFinal(res.GetIsFinal())
+	}
+	if config.PluginResultID && res.GetResultId() != "" {
+		result.WithResultID(res.GetResultId())
+	}
+	// 個人情報をマスクする
+	message, redactions := config.Redactor.Redact(message)
+	result.Redactions = redactions
+	result.SetMessage(message)
+
+	return result, true
+}
@@ -0,0 +1,313 @@
+package suzu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/shiguredo/suzu/pluginpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// テスト用のプラグイン
+type fakeSpeechPlugin struct {
+	pluginpb.UnimplementedSpeechPluginServer
+
+	t *testing.T
+	// 音声データを受信したときに返すエラー
+	err error
+}
+
+func (p *fakeSpeechPlugin) Recognize(stream grpc.BidiStreamingServer[pluginpb.RecognizeRequest, pluginpb.RecognizeResponse]) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	config := req.GetConfig()
+	if config == nil {
+		return status.Error(codes.InvalidArgument, "config is required")
+	}
+	assert.Equal(p.t, "test-channel-id", config.GetChannelId())
+	assert.Equal(p.t, "test-connection-id", config.GetConnectionId())
+	assert.Equal(p.t, "ja-JP", config.GetLanguageCode())
+	assert.Equal(p.t, uint32(48000), config.GetSampleRate())
+	assert.Equal(p.t, uint32(1), config.GetChannelCount())
+	assert.Equal(p.t, pluginpb.AudioEncoding_AUDIO_ENCODING_OPUS, config.GetAudioEncoding())
+
+	count := 0
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			// 音声データの終了
+			return stream.Send(newFakePluginResponse("おわり", true, "2"))
+		}
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(p.t, []byte{252, 255, 254}, req.GetAudio().GetPayload())
+
+		if p.err != nil {
+			return p.err
+		}
+
+		count++
+		switch count {
+		case 1:
+			err = stream.Send(newFakePluginResponse("", false, "1"))
+		case 2:
+			err = stream.Send(newFakePluginResponse("こんにち", false, "1"))
+		case 3:
+			err = stream.Send(newFakePluginResponse("こんにちは", true, "1"))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func newFakePluginResponse(message string, isFinal bool, resultID string) *pluginpb.RecognizeResponse {
+	return &pluginpb.RecognizeResponse{
+		Response: &pluginpb.RecognizeResponse_Result{
+			Result: &pluginpb.Result{
+				Message:  message,
+				IsFinal:  isFinal,
+				ResultId: resultID,
+			},
+		},
+	}
+}
+
+func newFakePluginServer(t *testing.T, pluginErr error) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := grpc.NewServer()
+	pluginpb.RegisterSpeechPluginServer(s, &fakeSpeechPlugin{t: t, err: pluginErr})
+	go s.Serve(ln)
+	t.Cleanup(s.Stop)
+
+	return ln.Addr().String()
+}
+
+func TestPluginHandler(t *testing.T) {
+	channelID := "test-channel-id"
+	connectionID := "test-connection-id"
+	sampleRate := uint32(48000)
+	channelCount := uint16(1)
+	languageCode := "ja-JP"
+	header := SoraHeader{
+		SoraChannelID:    channelID,
+		SoraConnectionID: connectionID,
+	}
+	var noResultFunc func(context.Context, io.WriteCloser, string, string, string, ServiceResult) error
+
+	baseConfig := Config{
+		PluginAudioFormat:   "opus",
+		PluginResultIsFinal: true,
+		PluginResultID:      true,
+	}
+
+	t.Run("success", func(t *testing.T) {
+		config := baseConfig
+		config.PluginAddress = newFakePluginServer(t, nil)
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 5)
+
+		h := NewPluginHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		r, err := h.Handle(ctx, opusCh, header)
+		require.NoError(t, err)
+		defer r.Close()
+
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t,
+			`{"is_final":false,"result_id":"1","message":"こんにち","type":"plugin"}`+"\n"+
+				`{"is_final":true,"result_id":"1","message":"こんにちは","type":"plugin"}`+"\n"+
+				`{"is_final":true,"result_id":"2","message":"おわり","type":"plugin"}`+"\n",
+			string(b))
+	})
+
+	t.Run("final result only", func(t *testing.T) {
+		config := baseConfig
+		config.PluginAddress = newFakePluginServer(t, nil)
+		config.PluginResultIsFinal = false
+		config.PluginResultID = false
+		config.FinalResultOnly = true
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 5)
+
+		h := NewPluginHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		r, err := h.Handle(ctx, opusCh, header)
+		require.NoError(t, err)
+		defer r.Close()
+
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, `{"message":"こんにちは","type":"plugin"}`+"\n"+`{"message":"おわり","type":"plugin"}`+"\n", string(b))
+	})
+
+	t.Run("text processors", func(t *testing.T) {
+		config := baseConfig
+		config.PluginAddress = newFakePluginServer(t, nil)
+		config.PluginResultIsFinal = false
+		config.PluginResultID = false
+
+		regex, err := NewRegexTextProcessor(`^おわり$`, "")
+		require.NoError(t, err)
+		config.TextProcessors = TextProcessors{regex, NewDictionaryTextProcessor(map[string]string{"こんにちは": "Hello"})}
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 5)
+
+		h := NewPluginHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		r, err := h.Handle(ctx, opusCh, header)
+		require.NoError(t, err)
+		defer r.Close()
+
+		// 加工後に空になった結果は送らない
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, `{"message":"こんにち","type":"plugin"}`+"\n"+`{"message":"Hello","type":"plugin"}`+"\n", string(b))
+	})
+
+	t.Run("on result func", func(t *testing.T) {
+		config := baseConfig
+		config.PluginAddress = newFakePluginServer(t, nil)
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 5)
+
+		onResultFunc := func(ctx context.Context, w io.WriteCloser, channelID, connectionID, languageCode string, result ServiceResult) error {
+			res := result.Plugin
+			if result.Provider != "plugin" || res == nil {
+				return errors.New("unexpected result")
+			}
+			if res.GetMessage() == "" {
+				return nil
+			}
+			_, err := w.Write([]byte(res.GetMessage() + "\n"))
+			return err
+		}
+
+		h := NewPluginHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, onResultFunc)
+		r, err := h.Handle(ctx, opusCh, header)
+		require.NoError(t, err)
+		defer r.Close()
+
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, "こんにち\nこんにちは\nおわり\n", string(b))
+	})
+
+	t.Run("plugin unavailable", func(t *testing.T) {
+		config := baseConfig
+		config.PluginAddress = newFakePluginServer(t, status.Error(codes.Unavailable, "restarting"))
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 5)
+
+		h := NewPluginHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		r, err := h.Handle(ctx, opusCh, header)
+		require.NoError(t, err)
+		defer r.Close()
+
+		_, err = io.ReadAll(r)
+		assert.ErrorIs(t, err, ErrServerDisconnected)
+	})
+
+	t.Run("plugin invalid argument", func(t *testing.T) {
+		config := baseConfig
+		config.PluginAddress = newFakePluginServer(t, status.Error(codes.InvalidArgument, "invalid audio"))
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 5)
+
+		h := NewPluginHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		r, err := h.Handle(ctx, opusCh, header)
+		require.NoError(t, err)
+		defer r.Close()
+
+		_, err = io.ReadAll(r)
+		if assert.Error(t, err) {
+			assert.NotErrorIs(t, err, ErrServerDisconnected)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		}
+	})
+
+	t.Run("plugin not running", func(t *testing.T) {
+		// 接続できないアドレスを指定する
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		address := ln.Addr().String()
+		ln.Close()
+
+		config := baseConfig
+		config.PluginAddress = address
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 5)
+
+		h := NewPluginHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		_, err = h.Handle(ctx, opusCh, header)
+
+		var suzuErr *SuzuError
+		if assert.ErrorAs(t, err, &suzuErr) {
+			assert.True(t, suzuErr.IsRetry())
+		}
+	})
+
+	t.Run("unsupported audio format", func(t *testing.T) {
+		config := baseConfig
+		config.PluginAddress = "127.0.0.1:0"
+		config.PluginAudioFormat = "pcm"
+
+		ctx := t.Context()
+		opusCh := make(chan Opus)
+		go sendTestOpusPackets(ctx, opusCh, 5)
+
+		h := NewPluginHandler(config, channelID, connectionID, sampleRate, channelCount, languageCode, noResultFunc)
+		_, err := h.Handle(ctx, opusCh, header)
+
+		var suzuConfErr *SuzuConfError
+		if assert.ErrorAs(t, err, &suzuConfErr) {
+			assert.Contains(t, suzuConfErr.Error(), ErrPluginUnsupportedAudioFormat.Error())
+		}
+	})
+}
+
+func TestBuildPluginResult(t *testing.T) {
+	redactor, err := NewRedactor([]string{"phone_number"}, "")
+	require.NoError(t, err)
+
+	config := Config{
+		PluginResultIsFinal: true,
+		Redactor:            redactor,
+	}
+
+	result, ok := buildPluginResult(config, &pluginpb.Result{Message: "090-1234-5678 です", IsFinal: true})
+	require.True(t, ok)
+
+	b, err := json.Marshal(result)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"is_final":true,"message":"************* です","type":"plugin","redactions":[{"type":"phone_number","count":1}]}`, string(b))
+}
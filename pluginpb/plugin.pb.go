@@ -0,0 +1,541 @@
+// Suzu の音声文字変換プラグインの gRPC の定義です
+//
+// プラグインは Suzu とは別のプロセスとして起動し、SpeechPlugin サービスを提供します。
+// Suzu は -service plugin を指定した場合に、config.ini の plugin_address に接続します。
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        v5.29.3
+// source: plugin.proto
+
+package pluginpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type AudioEncoding int32
+
+const (
+	AudioEncoding_AUDIO_ENCODING_UNSPECIFIED AudioEncoding = 0
+	// Opus パケットを 1 パケットずつ送信します
+	AudioEncoding_AUDIO_ENCODING_OPUS AudioEncoding = 1
+	// Ogg/Opus のバイト列を送信します
+	AudioEncoding_AUDIO_ENCODING_OGG_OPUS AudioEncoding = 2
+)
+
+// Enum value maps for AudioEncoding.
+var (
+	AudioEncoding_name = map[int32]string{
+		0: "AUDIO_ENCODING_UNSPECIFIED",
+		1: "AUDIO_ENCODING_OPUS",
+		2: "AUDIO_ENCODING_OGG_OPUS",
+	}
+	AudioEncoding_value = map[string]int32{
+		"AUDIO_ENCODING_UNSPECIFIED": 0,
+		"AUDIO_ENCODING_OPUS":        1,
+		"AUDIO_ENCODING_OGG_OPUS":    2,
+	}
+)
+
+func (x AudioEncoding) Enum() *AudioEncoding {
+	p := new(AudioEncoding)
+	*p = x
+	return p
+}
+
+func (x AudioEncoding) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (AudioEncoding) Descriptor() protoreflect.EnumDescriptor {
+	return file_plugin_proto_enumTypes[0].Descriptor()
+}
+
+func (AudioEncoding) Type() protoreflect.EnumType {
+	return &file_plugin_proto_enumTypes[0]
+}
+
+func (x AudioEncoding) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use AudioEncoding.Descriptor instead.
+func (AudioEncoding) EnumDescriptor() ([]byte, []int) {
+	return file_plugin_proto_rawDescGZIP(), []int{0}
+}
+
+type RecognizeRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Request:
+	//
+	//	*RecognizeRequest_Config
+	//	*RecognizeRequest_Audio
+	Request       isRecognizeRequest_Request `protobuf_oneof:"request"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RecognizeRequest) Reset() {
+	*x = RecognizeRequest{}
+	mi := &file_plugin_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RecognizeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecognizeRequest) ProtoMessage() {}
+
+func (x *RecognizeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_plugin_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecognizeRequest.ProtoReflect.Descriptor instead.
+func (*RecognizeRequest) Descriptor() ([]byte, []int) {
+	return file_plugin_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *RecognizeRequest) GetRequest() isRecognizeRequest_Request {
+	if x != nil {
+		return x.Request
+	}
+	return nil
+}
+
+func (x *RecognizeRequest) GetConfig() *SessionConfig {
+	if x != nil {
+		if x, ok := x.Request.(*RecognizeRequest_Config); ok {
+			return x.Config
+		}
+	}
+	return nil
+}
+
+func (x *RecognizeRequest) GetAudio() *AudioPacket {
+	if x != nil {
+		if x, ok := x.Request.(*RecognizeRequest_Audio); ok {
+			return x.Audio
+		}
+	}
+	return nil
+}
+
+type isRecognizeRequest_Request interface {
+	isRecognizeRequest_Request()
+}
+
+type RecognizeRequest_Config struct {
+	Config *SessionConfig `protobuf:"bytes,1,opt,name=config,proto3,oneof"`
+}
+
+type RecognizeRequest_Audio struct {
+	Audio *AudioPacket `protobuf:"bytes,2,opt,name=audio,proto3,oneof"`
+}
+
+func (*RecognizeRequest_Config) isRecognizeRequest_Request() {}
+
+func (*RecognizeRequest_Audio) isRecognizeRequest_Request() {}
+
+// ストリームの最初に送信するセッションの情報です
+type SessionConfig struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ChannelId     string                 `protobuf:"bytes,1,opt,name=channel_id,json=channelId,proto3" json:"channel_id,omitempty"`
+	SessionId     string                 `protobuf:"bytes,2,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	ConnectionId  string                 `protobuf:"bytes,3,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
+	LanguageCode  string                 `protobuf:"bytes,4,opt,name=language_code,json=languageCode,proto3" json:"language_code,omitempty"`
+	SampleRate    uint32                 `protobuf:"varint,5,opt,name=sample_rate,json=sampleRate,proto3" json:"sample_rate,omitempty"`
+	ChannelCount  uint32                 `protobuf:"varint,6,opt,name=channel_count,json=channelCount,proto3" json:"channel_count,omitempty"`
+	AudioEncoding AudioEncoding          `protobuf:"varint,7,opt,name=audio_encoding,json=audioEncoding,proto3,enum=suzu.plugin.v1.AudioEncoding" json:"audio_encoding,omitempty"`
+	// 再接続の場合は 1 以上になります
+	RetryCount    int32 `protobuf:"varint,8,opt,name=retry_count,json=retryCount,proto3" json:"retry_count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SessionConfig) Reset() {
+	*x = SessionConfig{}
+	mi := &file_plugin_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SessionConfig) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SessionConfig) ProtoMessage() {}
+
+func (x *SessionConfig) ProtoReflect() protoreflect.Message {
+	mi := &file_plugin_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SessionConfig.ProtoReflect.Descriptor instead.
+func (*SessionConfig) Descriptor() ([]byte, []int) {
+	return file_plugin_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SessionConfig) GetChannelId() string {
+	if x != nil {
+		return x.ChannelId
+	}
+	return ""
+}
+
+func (x *SessionConfig) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *SessionConfig) GetConnectionId() string {
+	if x != nil {
+		return x.ConnectionId
+	}
+	return ""
+}
+
+func (x *SessionConfig) GetLanguageCode() string {
+	if x != nil {
+		return x.LanguageCode
+	}
+	return ""
+}
+
+func (x *SessionConfig) GetSampleRate() uint32 {
+	if x != nil {
+		return x.SampleRate
+	}
+	return 0
+}
+
+func (x *SessionConfig) GetChannelCount() uint32 {
+	if x != nil {
+		return x.ChannelCount
+	}
+	return 0
+}
+
+func (x *SessionConfig) GetAudioEncoding() AudioEncoding {
+	if x != nil {
+		return x.AudioEncoding
+	}
+	return AudioEncoding_AUDIO_ENCODING_UNSPECIFIED
+}
+
+func (x *SessionConfig) GetRetryCount() int32 {
+	if x != nil {
+		return x.RetryCount
+	}
+	return 0
+}
+
+type AudioPacket struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Payload       []byte                 `protobuf:"bytes,1,opt,name=payload,proto3" json:"payload,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AudioPacket) Reset() {
+	*x = AudioPacket{}
+	mi := &file_plugin_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AudioPacket) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AudioPacket) ProtoMessage() {}
+
+func (x *AudioPacket) ProtoReflect() protoreflect.Message {
+	mi := &file_plugin_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AudioPacket.ProtoReflect.Descriptor instead.
+func (*AudioPacket) Descriptor() ([]byte, []int) {
+	return file_plugin_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *AudioPacket) GetPayload() []byte {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+type RecognizeResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Response:
+	//
+	//	*RecognizeResponse_Result
+	Response      isRecognizeResponse_Response `protobuf_oneof:"response"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RecognizeResponse) Reset() {
+	*x = RecognizeResponse{}
+	mi := &file_plugin_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RecognizeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecognizeResponse) ProtoMessage() {}
+
+func (x *RecognizeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_plugin_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecognizeResponse.ProtoReflect.Descriptor instead.
+func (*RecognizeResponse) Descriptor() ([]byte, []int) {
+	return file_plugin_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *RecognizeResponse) GetResponse() isRecognizeResponse_Response {
+	if x != nil {
+		return x.Response
+	}
+	return nil
+}
+
+func (x *RecognizeResponse) GetResult() *Result {
+	if x != nil {
+		if x, ok := x.Response.(*RecognizeResponse_Result); ok {
+			return x.Result
+		}
+	}
+	return nil
+}
+
+type isRecognizeResponse_Response interface {
+	isRecognizeResponse_Response()
+}
+
+type RecognizeResponse_Result struct {
+	Result *Result `protobuf:"bytes,1,opt,name=result,proto3,oneof"`
+}
+
+func (*RecognizeResponse_Result) isRecognizeResponse_Response() {}
+
+type Result struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Message string                 `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	// 最終結果の場合は true にします
+	IsFinal bool `protobuf:"varint,2,opt,name=is_final,json=isFinal,proto3" json:"is_final,omitempty"`
+	// 同じ発話の途中結果と最終結果で同じ値にします
+	ResultId      string `protobuf:"bytes,3,opt,name=result_id,json=resultId,proto3" json:"result_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Result) Reset() {
+	*x = Result{}
+	mi := &file_plugin_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Result) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Result) ProtoMessage() {}
+
+func (x *Result) ProtoReflect() protoreflect.Message {
+	mi := &file_plugin_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Result.ProtoReflect.Descriptor instead.
+func (*Result) Descriptor() ([]byte, []int) {
+	return file_plugin_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Result) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *Result) GetIsFinal() bool {
+	if x != nil {
+		return x.IsFinal
+	}
+	return false
+}
+
+func (x *Result) GetResultId() string {
+	if x != nil {
+		return x.ResultId
+	}
+	return ""
+}
+
+var File_plugin_proto protoreflect.FileDescriptor
+
+const file_plugin_proto_rawDesc = "" +
+	"\n" +
+	"\fplugin.proto\x12\x0esuzu.plugin.v1\"\x8b\x01\n" +
+	"\x10RecognizeRequest\x127\n" +
+	"\x06config\x18\x01 \x01(\v2\x1d.suzu.plugin.v1.SessionConfigH\x00R\x06config\x123\n" +
+	"\x05audio\x18\x02 \x01(\v2\x1b.suzu.plugin.v1.AudioPacketH\x00R\x05audioB\t\n" +
+	"\arequest\"\xc4\x02\n" +
+	"\rSessionConfig\x12\x1d\n" +
+	"\n" +
+	"channel_id\x18\x01 \x01(\tR\tchannelId\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x02 \x01(\tR\tsessionId\x12#\n" +
+	"\rconnection_id\x18\x03 \x01(\tR\fconnectionId\x12#\n" +
+	"\rlanguage_code\x18\x04 \x01(\tR\flanguageCode\x12\x1f\n" +
+	"\vsample_rate\x18\x05 \x01(\rR\n" +
+	"sampleRate\x12#\n" +
+	"\rchannel_count\x18\x06 \x01(\rR\fchannelCount\x12D\n" +
+	"\x0eaudio_encoding\x18\a \x01(\x0e2\x1d.suzu.plugin.v1.AudioEncodingR\raudioEncoding\x12\x1f\n" +
+	"\vretry_count\x18\b \x01(\x05R\n" +
+	"retryCount\"'\n" +
+	"\vAudioPacket\x12\x18\n" +
+	"\apayload\x18\x01 \x01(\fR\apayload\"Q\n" +
+	"\x11RecognizeResponse\x120\n" +
+	"\x06result\x18\x01 \x01(\v2\x16.suzu.plugin.v1.ResultH\x00R\x06resultB\n" +
+	"\n" +
+	"\bresponse\"Z\n" +
+	"\x06Result\x12\x18\n" +
+	"\amessage\x18\x01 \x01(\tR\amessage\x12\x19\n" +
+	"\bis_final\x18\x02 \x01(\bR\aisFinal\x12\x1b\n" +
+	"\tresult_id\x18\x03 \x01(\tR\bresultId*e\n" +
+	"\rAudioEncoding\x12\x1e\n" +
+	"\x1aAUDIO_ENCODING_UNSPECIFIED\x10\x00\x12\x17\n" +
+	"\x13AUDIO_ENCODING_OPUS\x10\x01\x12\x1b\n" +
+	"\x17AUDIO_ENCODING_OGG_OPUS\x10\x022d\n" +
+	"\fSpeechPlugin\x12T\n" +
+	"\tRecognize\x12 .suzu.plugin.v1.RecognizeRequest\x1a!.suzu.plugin.v1.RecognizeResponse(\x010\x01B$Z\"github.com/shiguredo/suzu/pluginpbb\x06proto3"
+
+var (
+	file_plugin_proto_rawDescOnce sync.Once
+	file_plugin_proto_rawDescData []byte
+)
+
+func file_plugin_proto_rawDescGZIP() []byte {
+	file_plugin_proto_rawDescOnce.Do(func() {
+		file_plugin_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_plugin_proto_rawDesc), len(file_plugin_proto_rawDesc)))
+	})
+	return file_plugin_proto_rawDescData
+}
+
+var file_plugin_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_plugin_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_plugin_proto_goTypes = []any{
+	(AudioEncoding)(0),        // 0: suzu.plugin.v1.AudioEncoding
+	(*RecognizeRequest)(nil),  // 1: suzu.plugin.v1.RecognizeRequest
+	(*SessionConfig)(nil),     // 2: suzu.plugin.v1.SessionConfig
+	(*AudioPacket)(nil),       // 3: suzu.plugin.v1.AudioPacket
+	(*RecognizeResponse)(nil), // 4: suzu.plugin.v1.RecognizeResponse
+	(*Result)(nil),            // 5: suzu.plugin.v1.Result
+}
+var file_plugin_proto_depIdxs = []int32{
+	2, // 0: suzu.plugin.v1.RecognizeRequest.config:type_name -> suzu.plugin.v1.SessionConfig
+	3, // 1: suzu.plugin.v1.RecognizeRequest.audio:type_name -> suzu.plugin.v1.AudioPacket
+	0, // 2: suzu.plugin.v1.SessionConfig.audio_encoding:type_name -> suzu.plugin.v1.AudioEncoding
+	5, // 3: suzu.plugin.v1.RecognizeResponse.result:type_name -> suzu.plugin.v1.Result
+	1, // 4: suzu.plugin.v1.SpeechPlugin.Recognize:input_type -> suzu.plugin.v1.RecognizeRequest
+	4, // 5: suzu.plugin.v1.SpeechPlugin.Recognize:output_type -> suzu.plugin.v1.RecognizeResponse
+	5, // [5:6] is the sub-list for method output_type
+	4, // [4:5] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_plugin_proto_init() }
+func file_plugin_proto_init() {
+	if File_plugin_proto != nil {
+		return
+	}
+	file_plugin_proto_msgTypes[0].OneofWrappers = []any{
+		(*RecognizeRequest_Config)(nil),
+		(*RecognizeRequest_Audio)(nil),
+	}
+	file_plugin_proto_msgTypes[3].OneofWrappers = []any{
+		(*RecognizeResponse_Result)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_plugin_proto_rawDesc), len(file_plugin_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   5,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_plugin_proto_goTypes,
+		DependencyIndexes: file_plugin_proto_depIdxs,
+		EnumInfos:         file_plugin_proto_enumTypes,
+		MessageInfos:      file_plugin_proto_msgTypes,
+	}.Build()
+	File_plugin_proto = out.File
+	file_plugin_proto_goTypes = nil
+	file_plugin_proto_depIdxs = nil
+}
@@ -0,0 +1,142 @@
+// Suzu の音声文字変換プラグインの gRPC の定義です
+//
+// プラグインは Suzu とは別のプロセスとして起動し、SpeechPlugin サービスを提供します。
+// Suzu は -service plugin を指定した場合に、config.ini の plugin_address に接続します。
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: plugin.proto
+
+package pluginpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	SpeechPlugin_Recognize_FullMethodName = "/suzu.plugin.v1.SpeechPlugin/Recognize"
+)
+
+// SpeechPluginClient is the client API for SpeechPlugin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SpeechPluginClient interface {
+	// 音声データを送信して、変換結果を受信する双方向ストリーミングです
+	//
+	// Suzu は最初に SessionConfig を 1 回だけ送信し、その後に AudioPacket を送信し続けます。
+	// クライアントとの接続が終了した場合、Suzu はストリームの送信側を閉じます。
+	// プラグインは送信側が閉じられた後に残りの結果を返し、ストリームを終了してください。
+	//
+	// プラグインが次のステータスコードでストリームを終了した場合、Suzu は max_retry の範囲で再接続します。
+	//   - UNAVAILABLE
+	//   - RESOURCE_EXHAUSTED
+	//   - ABORTED
+	//   - INTERNAL
+	Recognize(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[RecognizeRequest, RecognizeResponse], error)
+}
+
+type speechPluginClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSpeechPluginClient(cc grpc.ClientConnInterface) SpeechPluginClient {
+	return &speechPluginClient{cc}
+}
+
+func (c *speechPluginClient) Recognize(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[RecognizeRequest, RecognizeResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SpeechPlugin_ServiceDesc.Streams[0], SpeechPlugin_Recognize_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[RecognizeRequest, RecognizeResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SpeechPlugin_RecognizeClient = grpc.BidiStreamingClient[RecognizeRequest, RecognizeResponse]
+
+// SpeechPluginServer is the server API for SpeechPlugin service.
+// All implementations must embed UnimplementedSpeechPluginServer
+// for forward compatibility.
+type SpeechPluginServer interface {
+	// 音声データを送信して、変換結果を受信する双方向ストリーミングです
+	//
+	// Suzu は最初に SessionConfig を 1 回だけ送信し、その後に AudioPacket を送信し続けます。
+	// クライアントとの接続が終了した場合、Suzu はストリームの送信側を閉じます。
+	// プラグインは送信側が閉じられた後に残りの結果を返し、ストリームを終了してください。
+	//
+	// プラグインが次のステータスコードでストリームを終了した場合、Suzu は max_retry の範囲で再接続します。
+	//   - UNAVAILABLE
+	//   - RESOURCE_EXHAUSTED
+	//   - ABORTED
+	//   - INTERNAL
+	Recognize(grpc.BidiStreamingServer[RecognizeRequest, RecognizeResponse]) error
+	mustEmbedUnimplementedSpeechPluginServer()
+}
+
+// UnimplementedSpeechPluginServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedSpeechPluginServer struct{}
+
+func (UnimplementedSpeechPluginServer) Recognize(grpc.BidiStreamingServer[RecognizeRequest, RecognizeResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method Recognize not implemented")
+}
+func (UnimplementedSpeechPluginServer) mustEmbedUnimplementedSpeechPluginServer() {}
+func (UnimplementedSpeechPluginServer) testEmbeddedByValue()                      {}
+
+// UnsafeSpeechPluginServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SpeechPluginServer will
+// result in compilation errors.
+type UnsafeSpeechPluginServer interface {
+	mustEmbedUnimplementedSpeechPluginServer()
+}
+
+func RegisterSpeechPluginServer(s grpc.ServiceRegistrar, srv SpeechPluginServer) {
+	// If the following call pancis, it indicates UnimplementedSpeechPluginServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&SpeechPlugin_ServiceDesc, srv)
+}
+
+func _SpeechPlugin_Recognize_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SpeechPluginServer).Recognize(&grpc.GenericServerStream[RecognizeRequest, RecognizeResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SpeechPlugin_RecognizeServer = grpc.BidiStreamingServer[RecognizeRequest, RecognizeResponse]
+
+// SpeechPlugin_ServiceDesc is the grpc.ServiceDesc for SpeechPlugin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SpeechPlugin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "suzu.plugin.v1.SpeechPlugin",
+	HandlerType: (*SpeechPluginServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Recognize",
+			Handler:       _SpeechPlugin_Recognize_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "plugin.proto",
+}
@@ -0,0 +1,234 @@
+package suzu
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+)
+
+const (
+	// ogg_file_name_template を指定しない場合のファイル名
+	defaultOggFileNameTemplate = "{session_id}-{connection_id}.ogg"
+
+	// storage_retention_days で削除する Ogg ファイルの拡張子
+	oggFileSuffix = ".ogg"
+)
+
+// ogg_file_name_template で使用できる値
+var oggFileNamePlaceholders = []string{
+	"{channel_id}",
+	"{session_id}",
+	"{connection_id}",
+	"{date}",
+	"{time}",
+}
+
+// ogg_file_name_template の値を確認する
+func validateOggFileNameTemplate(template string) error {
+	if strings.ContainsAny(template, `/\`) {
+		return fmt.Errorf("ogg_file_name_template must not contain path separators")
+	}
+
+	rest := template
+	for _, placeholder := range oggFileNamePlaceholders {
+		rest = strings.ReplaceAll(rest, placeholder, "")
+	}
+	if strings.ContainsAny(rest, "{}") {
+		return fmt.Errorf("ogg_file_name_template contains unknown placeholder: %s", template)
+	}
+	return nil
+}
+
+// ogg_file_name_template からファイル名を生成する
+// ヘッダの値にパスの区切り文字が含まれる場合があるためエスケープする
+func oggFileName(template string, h SoraHeader, startedAt time.Time) string {
+	replacer := strings.NewReplacer(
+		"{channel_id}", url.PathEscape(h.SoraChannelID),
+		"{session_id}", url.PathEscape(h.SoraSessionID),
+		"{connection_id}", url.PathEscape(h.SoraConnectionID),
+		"{date}", startedAt.Format("20060102"),
+		"{time}", startedAt.Format("150405"),
+	)
+	return replacer.Replace(template)
+}
+
+// 受信した音声を Ogg ファイルに保存する
+// サービスへの再接続やリトライに関わらず、HTTP のセッションごとに 1 つのファイルに書き込む
+type oggRecorder struct {
+	mu     sync.Mutex
+	name   string
+	file   io.WriteCloser
+	writer *OggWriter
+	closed bool
+
+	// 書き込んだ音声のサンプル数（48kHz）
+	granulePosition uint64
+	// 最後のページに EOS を付与するため、最後に受信したパケットは次のパケットの受信、または、Close まで書き込まない
+	pending         []byte
+	pendingGranule  uint64
+	writeFailedOnce sync.Once
+}
+
+// enable_ogg_file_output が無効な場合は nil を返す
+func newOggRecorder(ctx context.Context, c Config, storage Storage, h SoraHeader, startedAt time.Time) (*oggRecorder, error) {
+	if !c.EnableOggFileOutput {
+		return nil, nil
+	}
+
+	template := c.OggFileNameTemplate
+	if template == "" {
+		template = defaultOggFileNameTemplate
+	}
+
+	name := filepath.Join(c.OggDir, oggFileName(template, h, startedAt))
+	if c.EncryptionKeyFile != "" {
+		name += encryptedFileSuffix
+	}
+
+	file, err := storage.Create(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := newEncryptWriterFromConfig(c, file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return newOggRecorderWith(name, f, uint32(c.SampleRate), uint16(c.ChannelCount))
+}
+
+// f に Ogg ヘッダを書き込んで、f に書き込む oggRecorder を返す
+// 失敗した場合は f を閉じる
+func newOggRecorderWith(name string, f io.WriteCloser, sampleRate uint32, channelCount uint16) (*oggRecorder, error) {
+	writer, err := NewWith(f, sampleRate, channelCount)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &oggRecorder{
+		name:   name,
+		file:   f,
+		writer: writer,
+	}, nil
+}
+
+// 受信した Opus のパケットを書き込む
+func (r *oggRecorder) Write(payload []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// セッションの終了後に受信した音声は保存しない
+	if r.closed || len(payload) == 0 {
+		return nil
+	}
+
+	if err := r.flush(pageHeaderTypeContinuationOfStream); err != nil {
+		return err
+	}
+
+	r.granulePosition += opusPacketSamples(payload)
+	// 受信したデータを再利用する場合があるためコピーする
+	r.pending = append([]byte(nil), payload...)
+	r.pendingGranule = r.granulePosition
+	return nil
+}
+
+// 書き込みを保留しているパケットを書き込む
+func (r *oggRecorder) flush(headerType uint8) error {
+	if r.pending == nil {
+		return nil
+	}
+
+	data := r.writer.createPage(r.pending, headerType, r.pendingGranule, r.writer.pageIndex)
+	r.writer.pageIndex++
+	r.pending = nil
+	return r.writer.writeToStream(data)
+}
+
+// 最後のページに EOS を付与してファイルを閉じる
+// 音声を受信していない場合は空の EOS のページを書き込む
+// nil の場合は何もしない
+func (r *oggRecorder) Close() error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	var err error
+	if r.pending != nil {
+		err = r.flush(pageHeaderTypeEndOfStream)
+	} else {
+		data := r.writer.createPage([]byte{}, pageHeaderTypeEndOfStream, r.granulePosition, r.writer.pageIndex)
+		r.writer.pageIndex++
+		err = r.writer.writeToStream(data)
+	}
+
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// 受信した Opus のパケットを Ogg ファイルに書き込むオプション関数を返す
+// nil の場合は nil を返す
+func optionRecordOgg(r *oggRecorder) packetReaderOption {
+	if r == nil {
+		return nil
+	}
+
+	return func(ctx context.Context, c Config, opusCh chan Opus) chan Opus {
+		ch := make(chan Opus)
+
+		go func() {
+			defer close(ch)
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case req, ok := <-opusCh:
+					if !ok {
+						return
+					}
+
+					// LPCM の音声は Opus に変換できないため保存しない
+					if req.Err == nil && req.PCM == nil {
+						if err := r.Write(req.Payload); err != nil {
+							// 書き込みに失敗した場合もサービスへの送信は継続する
+							r.writeFailedOnce.Do(func() {
+								zlog.Error().
+									Err(err).
+									Str("file", r.name).
+									Msg("OGG-FILE-WRITE-FAILED")
+							})
+						}
+					}
+
+					select {
+					case <-ctx.Done():
+						return
+					case ch <- req:
+					}
+				}
+			}
+		}()
+
+		return ch
+	}
+}
@@ -0,0 +1,289 @@
+package suzu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateOggFileNameTemplate(t *testing.T) {
+	assert.NoError(t, validateOggFileNameTemplate(defaultOggFileNameTemplate))
+	assert.NoError(t, validateOggFileNameTemplate("{channel_id}-{date}-{time}-{connection_id}.ogg"))
+	assert.Error(t, validateOggFileNameTemplate("{unknown}.ogg"))
+	assert.Error(t, validateOggFileNameTemplate("{session_id.ogg"))
+	assert.Error(t, validateOggFileNameTemplate("../{session_id}.ogg"))
+}
+
+func TestOggFileName(t *testing.T) {
+	h := SoraHeader{
+		SoraChannelID:    "sora/room",
+		SoraSessionID:    "C2TFB1QBDS4WD5SX317SWMJ6FM",
+		SoraConnectionID: "1X0Z8JXZAD5A93X68M2S9NTC4G",
+	}
+	startedAt := time.Date(2026, 10, 19, 9, 8, 7, 0, time.UTC)
+
+	assert.Equal(t, "C2TFB1QBDS4WD5SX317SWMJ6FM-1X0Z8JXZAD5A93X68M2S9NTC4G.ogg", oggFileName(defaultOggFileNameTemplate, h, startedAt))
+	// sora-channel-id はエスケープする
+	assert.Equal(t, "sora%2Froom-20261019-090807.ogg", oggFileName("{channel_id}-{date}-{time}.ogg", h, startedAt))
+}
+
+func readOggFileForTest(t *testing.T, filePath string) []oggPageForTest {
+	t.Helper()
+
+	data, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+
+	pages, err := parseOggPagesForTest(data)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(pages), 3)
+
+	assert.Equal(t, []byte("OpusHead"), pages[0].Payload[:8])
+	assert.Equal(t, byte(pageHeaderTypeBeginningOfStream), pages[0].HeaderType)
+	assert.Equal(t, []byte("OpusTags"), pages[1].Payload[:8])
+	// 最後のページのみ EOS を付与する
+	for i, page := range pages {
+		if i == len(pages)-1 {
+			assert.Equal(t, byte(pageHeaderTypeEndOfStream), page.HeaderType)
+		} else {
+			assert.NotEqual(t, byte(pageHeaderTypeEndOfStream), page.HeaderType)
+		}
+	}
+
+	return pages
+}
+
+func TestOggRecorder(t *testing.T) {
+	header := SoraHeader{
+		SoraChannelID:    "ogg-test",
+		SoraSessionID:    "C2TFB1QBDS4WD5SX317SWMJ6FM",
+		SoraConnectionID: "1X0Z8JXZAD5A93X68M2S9NTC4G",
+	}
+	fileName := fmt.Sprintf("%s-%s.ogg", header.SoraSessionID, header.SoraConnectionID)
+
+	t.Run("success", func(t *testing.T) {
+		oggDir := t.TempDir()
+		c := Config{
+			EnableOggFileOutput: true,
+			OggDir:              oggDir,
+			SampleRate:          48000,
+			ChannelCount:        1,
+		}
+
+		r, err := newOggRecorder(t.Context(), c, NewLocalStorage(), header, time.Now())
+		require.NoError(t, err)
+		require.NotNil(t, r)
+
+		for range 3 {
+			require.NoError(t, r.Write(silentPacket()))
+		}
+		// 空のパケットは書き込まない
+		require.NoError(t, r.Write([]byte{}))
+		require.NoError(t, r.Close())
+		// 複数回呼び出した場合は何もしない
+		require.NoError(t, r.Close())
+		// 閉じた後に受信した音声は書き込まない
+		require.NoError(t, r.Write(silentPacket()))
+
+		pages := readOggFileForTest(t, filepath.Join(oggDir, fileName))
+		audioPages := pages[2:]
+		require.Len(t, audioPages, 3)
+		// granule position は 20ms（48kHz で 960 サンプル）ずつ増える
+		for i, page := range audioPages {
+			assert.Equal(t, silentPacket(), page.Payload)
+			assert.Equal(t, uint64(960*(i+1)), page.GranulePosition)
+		}
+
+		// CRC を含めて読み込めることを確認する
+		f, err := os.Open(filepath.Join(oggDir, fileName))
+		require.NoError(t, err)
+		defer f.Close()
+
+		reader := newOggReader(f)
+		for range 3 {
+			packet, err := reader.ReadPacket()
+			require.NoError(t, err)
+			assert.Equal(t, silentPacket(), packet)
+		}
+		_, err = reader.ReadPacket()
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("no audio", func(t *testing.T) {
+		oggDir := t.TempDir()
+		c := Config{
+			EnableOggFileOutput: true,
+			OggDir:              oggDir,
+			SampleRate:          48000,
+			ChannelCount:        1,
+		}
+
+		r, err := newOggRecorder(t.Context(), c, NewLocalStorage(), header, time.Now())
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+
+		pages := readOggFileForTest(t, filepath.Join(oggDir, fileName))
+		require.Len(t, pages, 3)
+		assert.Empty(t, pages[2].Payload)
+		assert.Equal(t, uint64(0), pages[2].GranulePosition)
+	})
+
+	t.Run("disable_ogg_file_output", func(t *testing.T) {
+		oggDir := t.TempDir()
+		c := Config{
+			EnableOggFileOutput: false,
+			OggDir:              oggDir,
+		}
+
+		r, err := newOggRecorder(t.Context(), c, NewLocalStorage(), header, time.Now())
+		assert.NoError(t, err)
+		assert.Nil(t, r)
+		assert.Nil(t, optionRecordOgg(r))
+		assert.NoError(t, r.Close())
+
+		_, err = os.Stat(filepath.Join(oggDir, fileName))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("no permission", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("root はパーミッションに関わらず書き込めるため")
+		}
+
+		oggDir := t.TempDir()
+		// 書き込み権限を剥奪
+		require.NoError(t, os.Chmod(oggDir, 0000))
+		defer func() {
+			require.NoError(t, os.Chmod(oggDir, 0700))
+		}()
+
+		c := Config{
+			EnableOggFileOutput: true,
+			OggDir:              oggDir,
+		}
+
+		r, err := newOggRecorder(t.Context(), c, NewLocalStorage(), header, time.Now())
+		assert.ErrorIs(t, err, os.ErrPermission)
+		assert.Nil(t, r)
+	})
+
+	t.Run("directory does not exist", func(t *testing.T) {
+		c := Config{
+			EnableOggFileOutput: true,
+			OggDir:              filepath.Join(t.TempDir(), "not-found"),
+		}
+
+		r, err := newOggRecorder(t.Context(), c, NewLocalStorage(), header, time.Now())
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		assert.Nil(t, r)
+	})
+}
+
+// 最初の接続では指定したパケット数を受信した後に切断し、再接続後は終了まで受信するハンドラ
+type recordingTestHandler struct {
+	*statusTestHandler
+	packets int
+}
+
+func (h *recordingTestHandler) Handle(ctx context.Context, opusCh chan Opus, header SoraHeader) (*io.PipeReader, error) {
+	h.mu.Lock()
+	h.handled++
+	handled := h.handled
+	h.mu.Unlock()
+
+	r, w := io.Pipe()
+
+	go func() {
+		received := 0
+		for {
+			select {
+			case <-ctx.Done():
+				w.CloseWithError(ctx.Err())
+				return
+			case opus, ok := <-opusCh:
+				if !ok || opus.Err != nil {
+					if err := json.NewEncoder(w).Encode(NewTestResult("", fmt.Sprintf("handled: %d", handled))); err != nil {
+						w.CloseWithError(err)
+						return
+					}
+					w.Close()
+					return
+				}
+
+				received++
+				if handled == 1 && received == h.packets {
+					w.CloseWithError(errors.Join(fmt.Errorf("DISCONNECTED"), ErrServerDisconnected))
+					return
+				}
+			}
+		}
+	}()
+
+	return r, nil
+}
+
+func TestOggRecordingWithSpeechHandler(t *testing.T) {
+	oggDir := t.TempDir()
+	config := Config{
+		ListenAddr:                "127.0.0.1",
+		TimeToWaitForOpusPacketMs: 500,
+		DisableSilentPacket:       true,
+		MaxRetry:                  1,
+		SampleRate:                48000,
+		ChannelCount:              2,
+		EnableOggFileOutput:       true,
+		OggDir:                    oggDir,
+		OggFileNameTemplate:       "{channel_id}-{connection_id}.ogg",
+	}
+
+	serviceHandlers := NewServiceHandlers()
+	serviceHandlers.Register("recording", func(Config, string, string, uint32, uint16, string, OnResultFunc) ServiceHandler {
+		return &recordingTestHandler{statusTestHandler: &statusTestHandler{}, packets: 3}
+	})
+	s, err := NewServerBuilder(&config, "recording").
+		WithServiceHandlers(serviceHandlers).
+		WithLanguageCodeFunc("recording", func(lang string) (string, error) { return lang, nil }).
+		Build()
+	require.NoError(t, err)
+
+	r := readDumpFile(t, "testdata/dump.jsonl", 0)
+	defer r.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/speech", r)
+	req.Header.Set("sora-channel-id", "sora")
+	req.Header.Set("sora-connection-id", "JG6CSF8P6D3PS61FW1S4KGK8FM")
+	req.Header.Set("sora-audio-streaming-language-code", "ja-JP")
+	req.Proto = "HTTP/2.0"
+	req.ProtoMajor = 2
+	req.ProtoMinor = 0
+
+	rec := httptest.NewRecorder()
+	s.echo.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	// 再接続した後の結果を受信する
+	assert.Contains(t, rec.Body.String(), "handled: 2")
+
+	// 再接続の前後の音声を 1 つのファイルに書き込む
+	files, err := os.ReadDir(oggDir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "sora-JG6CSF8P6D3PS61FW1S4KGK8FM.ogg", files[0].Name())
+
+	pages := readOggFileForTest(t, filepath.Join(oggDir, files[0].Name()))
+	audioPages := pages[2:]
+	// testdata/dump.jsonl は 20ms のパケットが 9 個
+	require.Len(t, audioPages, 9)
+	for i, page := range audioPages {
+		assert.Equal(t, uint64(960*(i+1)), page.GranulePosition)
+	}
+}
@@ -0,0 +1,352 @@
+package suzu
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// 組み込みのマスク対象の種類
+	redactionTypePhoneNumber = "phone_number"
+	redactionTypeEmail       = "email"
+	redactionTypeCreditCard  = "credit_card"
+	redactionTypeMyNumber    = "my_number"
+
+	defaultRedactionMask = "*"
+)
+
+var (
+	ErrUnsupportedRedactionType = fmt.Errorf("UNSUPPORTED-REDACTION-TYPE")
+	ErrInvalidRedactionRule     = fmt.Errorf("INVALID-REDACTION-RULE")
+
+	// 全角数字を含む数字
+	redactionDigit = `[0-9０-９]`
+	// 数字の区切り文字
+	redactionSeparator = `[-‐−ー ]`
+
+	emailPattern       = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)+`)
+	creditCardPattern  = regexp.MustCompile(redactionDigit + `(?:` + redactionSeparator + `?` + redactionDigit + `){12,18}`)
+	myNumberPattern    = regexp.MustCompile(redactionDigit + `{4}` + redactionSeparator + `?` + redactionDigit + `{4}` + redactionSeparator + `?` + redactionDigit + `{4}`)
+	phoneNumberPattern = regexp.MustCompile(`(?:\+81` + redactionSeparator + `?|[0０])` + redactionDigit + `{1,4}[-‐−ー(（)） ]{0,2}` + redactionDigit + `{1,4}[-‐−ー(（)） ]{0,2}` + redactionDigit + `{3,4}`)
+)
+
+// マスクした内容の種類と件数
+type Redaction struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+type redactionRule struct {
+	Type    string
+	Pattern *regexp.Regexp
+	// 一致した文字列がマスク対象かどうかを判定する
+	Validate func(string) bool
+	// 前後に数字が続く場合はマスクしない
+	DigitBoundary bool
+}
+
+// 変換結果に含まれる個人情報をマスクする
+type Redactor struct {
+	Mask  string
+	rules []redactionRule
+}
+
+// types には組み込みのマスク対象の種類を指定する
+func NewRedactor(types []string, mask string) (*Redactor, error) {
+	if mask == "" {
+		mask = defaultRedactionMask
+	}
+
+	r := &Redactor{
+		Mask: mask,
+	}
+
+	// 区切り文字を含む長い数字から順に判定するため、指定順ではなく固定の順で適用する
+	builtinRules := []redactionRule{
+		{Type: redactionTypeEmail, Pattern: emailPattern},
+		{Type: redactionTypeCreditCard, Pattern: creditCardPattern, Validate: isValidCreditCardNumber, DigitBoundary: true},
+		{Type: redactionTypeMyNumber, Pattern: myNumberPattern, Validate: isValidMyNumber, DigitBoundary: true},
+		{Type: redactionTypePhoneNumber, Pattern: phoneNumberPattern, Validate: isValidPhoneNumber, DigitBoundary: true},
+	}
+
+	for _, t := range types {
+		found := false
+		for _, rule := range builtinRules {
+			if rule.Type == t {
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedRedactionType, t)
+		}
+	}
+
+	for _, rule := range builtinRules {
+		for _, t := range types {
+			if rule.Type == t {
+				r.rules = append(r.rules, rule)
+				break
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// 正規表現に一致した部分をマスクするルールを追加する
+func (r *Redactor) AddPattern(redactionType, pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidRedactionRule, err)
+	}
+
+	r.rules = append(r.rules, redactionRule{
+		Type:    redactionType,
+		Pattern: re,
+	})
+	return nil
+}
+
+// 辞書に含まれる語句をマスクするルールを追加する
+func (r *Redactor) AddWords(redactionType string, words []string) {
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		if word == "" {
+			continue
+		}
+		quoted = append(quoted, regexp.QuoteMeta(word))
+	}
+	if len(quoted) == 0 {
+		return
+	}
+
+	// 長い語句を優先する
+	sort.Slice(quoted, func(i, j int) bool {
+		return len(quoted[i]) > len(quoted[j])
+	})
+
+	r.rules = append(r.rules, redactionRule{
+		Type:    redactionType,
+		Pattern: regexp.MustCompile(strings.Join(quoted, "|")),
+	})
+}
+
+// マスクした文字列と、マスクした内容の種類ごとの件数を返す
+func (r *Redactor) Redact(text string) (string, []Redaction) {
+	if r == nil || text == "" {
+		return text, nil
+	}
+
+	var redactions []Redaction
+	for _, rule := range r.rules {
+		var (
+			b     strings.Builder
+			last  int
+			count int
+		)
+		for _, loc := range rule.Pattern.FindAllStringIndex(text, -1) {
+			start, end := loc[0], loc[1]
+			match := text[start:end]
+
+			if rule.DigitBoundary && !isDigitBoundary(text, start, end) {
+				continue
+			}
+			if rule.Validate != nil && !rule.Validate(match) {
+				continue
+			}
+
+			b.WriteString(text[last:start])
+			b.WriteString(strings.Repeat(r.Mask, utf8.RuneCountInString(match)))
+			last = end
+			count++
+		}
+
+		if count > 0 {
+			b.WriteString(text[last:])
+			text = b.String()
+			redactions = appendRedaction(redactions, rule.Type, count)
+		}
+	}
+
+	return text, redactions
+}
+
+func appendRedaction(redactions []Redaction, redactionType string, count int) []Redaction {
+	for i := range redactions {
+		if redactions[i].Type == redactionType {
+			redactions[i].Count += count
+			return redactions
+		}
+	}
+	return append(redactions, Redaction{Type: redactionType, Count: count})
+}
+
+// ルールファイルの 1 件分の定義
+type redactionRuleDefinition struct {
+	Type    string   `json:"type"`
+	Pattern string   `json:"pattern,omitempty"`
+	Words   []string `json:"words,omitempty"`
+}
+
+// JSON のルールファイルを読み込んでルールを追加する
+func (r *Redactor) LoadRules(filename string) error {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	var definitions []redactionRuleDefinition
+	if err := json.Unmarshal(b, &definitions); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidRedactionRule, err)
+	}
+
+	for i, d := range definitions {
+		if d.Type == "" {
+			return fmt.Errorf("%w: type is required (index: %d)", ErrInvalidRedactionRule, i)
+		}
+
+		switch {
+		case d.Pattern != "":
+			if err := r.AddPattern(d.Type, d.Pattern); err != nil {
+				return fmt.Errorf("%w (index: %d)", err, i)
+			}
+		case len(d.Words) > 0:
+			r.AddWords(d.Type, d.Words)
+		default:
+			return fmt.Errorf("%w: pattern or words is required (index: %d)", ErrInvalidRedactionRule, i)
+		}
+	}
+
+	return nil
+}
+
+// ログに出力する文字列の個人情報をマスクする
+func redactLog(c Config, s string) string {
+	redacted, _ := c.Redactor.Redact(s)
+	return redacted
+}
+
+// 設定に応じた Redactor を返す
+// マスクが無効な場合は nil を返す
+func NewRedactorFromConfig(c Config) (*Redactor, error) {
+	if len(c.RedactionTypes) == 0 && c.RedactionRulesFile == "" {
+		return nil, nil
+	}
+
+	r, err := NewRedactor(c.RedactionTypes, c.RedactionMask)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.RedactionRulesFile != "" {
+		if err := r.LoadRules(c.RedactionRulesFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// text[start:end] の前後が数字ではないかどうかを判定する
+func isDigitBoundary(text string, start, end int) bool {
+	if start > 0 {
+		r, _ := utf8.DecodeLastRuneInString(text[:start])
+		if isRedactionDigit(r) {
+			return false
+		}
+	}
+	if end < len(text) {
+		r, _ := utf8.DecodeRuneInString(text[end:])
+		if isRedactionDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isRedactionDigit(r rune) bool {
+	return ('0' <= r && r <= '9') || ('０' <= r && r <= '９')
+}
+
+// 数字以外を取り除き、全角数字を半角数字に変換する
+func redactionDigits(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch {
+		case '0' <= c && c <= '9':
+			b.WriteRune(c)
+		case '０' <= c && c <= '９':
+			b.WriteRune(c - '０' + '0')
+		}
+	}
+	return b.String()
+}
+
+// Luhn アルゴリズムでクレジットカード番号のチェックディジットを検証する
+func isValidCreditCardNumber(s string) bool {
+	digits := redactionDigits(s)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		n := int(digits[i] - '0')
+		if double {
+			n *= 2
+			if n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+		double = !double
+	}
+
+	return sum%10 == 0
+}
+
+// 個人番号（マイナンバー）のチェックディジットを検証する
+func isValidMyNumber(s string) bool {
+	digits := redactionDigits(s)
+	if len(digits) != 12 {
+		return false
+	}
+
+	sum := 0
+	for n := 1; n <= 11; n++ {
+		// P(n) は検査用数字を除いた右から n 桁目
+		p := int(digits[11-n] - '0')
+		q := n + 1
+		if n >= 7 {
+			q = n - 5
+		}
+		sum += p * q
+	}
+
+	remainder := sum % 11
+	checkDigit := 0
+	if remainder > 1 {
+		checkDigit = 11 - remainder
+	}
+
+	return int(digits[11]-'0') == checkDigit
+}
+
+// 日本の電話番号の桁数かどうかを判定する
+func isValidPhoneNumber(s string) bool {
+	digits := redactionDigits(s)
+
+	if strings.HasPrefix(strings.TrimSpace(s), "+81") {
+		// 国番号を除いた桁数
+		n := len(digits) - 2
+		return n == 9 || n == 10
+	}
+
+	return len(digits) == 10 || len(digits) == 11
+}
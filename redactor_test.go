@@ -0,0 +1,158 @@
+package suzu
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactor(t *testing.T) {
+	r, err := NewRedactor([]string{"phone_number", "email", "credit_card", "my_number"}, "")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		Name       string
+		Text       string
+		Expect     string
+		Redactions []Redaction
+	}{
+		{
+			Name:   "no pii",
+			Text:   "今日は 2024 年です",
+			Expect: "今日は 2024 年です",
+		},
+		{
+			Name:       "phone number",
+			Text:       "電話番号は090-1234-5678です",
+			Expect:     "電話番号は*************です",
+			Redactions: []Redaction{{Type: "phone_number", Count: 1}},
+		},
+		{
+			Name:       "full width phone number",
+			Text:       "０３１２３４５６７８に",
+			Expect:     "**********に",
+			Redactions: []Redaction{{Type: "phone_number", Count: 1}},
+		},
+		{
+			Name:       "international phone number",
+			Text:       "+81 90 1234 5678",
+			Expect:     "****************",
+			Redactions: []Redaction{{Type: "phone_number", Count: 1}},
+		},
+		{
+			Name:   "short number",
+			Text:   "0120",
+			Expect: "0120",
+		},
+		{
+			Name:       "email",
+			Text:       "メールは suzu@example.com まで",
+			Expect:     "メールは **************** まで",
+			Redactions: []Redaction{{Type: "email", Count: 1}},
+		},
+		{
+			Name:       "credit card",
+			Text:       "カード番号 4111 1111 1111 1111",
+			Expect:     "カード番号 *******************",
+			Redactions: []Redaction{{Type: "credit_card", Count: 1}},
+		},
+		{
+			Name:   "invalid credit card",
+			Text:   "4111111111111112",
+			Expect: "4111111111111112",
+		},
+		{
+			Name:       "my number",
+			Text:       "マイナンバーは1234-5678-9018",
+			Expect:     "マイナンバーは**************",
+			Redactions: []Redaction{{Type: "my_number", Count: 1}},
+		},
+		{
+			Name:   "invalid my number",
+			Text:   "1234-5678-9012",
+			Expect: "1234-5678-9012",
+		},
+		{
+			Name:   "multiple",
+			Text:   "a@example.jp と b@example.jp と 090-1234-5678",
+			Expect: "************ と ************ と *************",
+			Redactions: []Redaction{
+				{Type: "email", Count: 2},
+				{Type: "phone_number", Count: 1},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			text, redactions := r.Redact(tc.Text)
+			assert.Equal(t, tc.Expect, text)
+			assert.Equal(t, tc.Redactions, redactions)
+		})
+	}
+
+	t.Run("nil redactor", func(t *testing.T) {
+		var r *Redactor
+		text, redactions := r.Redact("090-1234-5678")
+		assert.Equal(t, "090-1234-5678", text)
+		assert.Nil(t, redactions)
+	})
+
+	t.Run("unsupported type", func(t *testing.T) {
+		_, err := NewRedactor([]string{"address"}, "")
+		assert.ErrorIs(t, err, ErrUnsupportedRedactionType)
+	})
+}
+
+func TestRedactorLoadRules(t *testing.T) {
+	writeRules := func(t *testing.T, rules string) string {
+		t.Helper()
+
+		filename := filepath.Join(t.TempDir(), "rules.json")
+		require.NoError(t, os.WriteFile(filename, []byte(rules), 0644))
+		return filename
+	}
+
+	t.Run("success", func(t *testing.T) {
+		c := Config{
+			RedactionRulesFile: writeRules(t, `[
+				{"type": "customer_id", "pattern": "C[0-9]{6}"},
+				{"type": "name", "words": ["山田", "山田太郎"]}
+			]`),
+			RedactionMask: "＊",
+		}
+
+		r, err := NewRedactorFromConfig(c)
+		require.NoError(t, err)
+
+		text, redactions := r.Redact("山田太郎さんの会員番号は C123456 です")
+		assert.Equal(t, "＊＊＊＊さんの会員番号は ＊＊＊＊＊＊＊ です", text)
+		assert.Equal(t, []Redaction{{Type: "customer_id", Count: 1}, {Type: "name", Count: 1}}, redactions)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		r, err := NewRedactorFromConfig(Config{})
+		require.NoError(t, err)
+		assert.Nil(t, r)
+	})
+
+	testCases := []struct {
+		Name  string
+		Rules string
+	}{
+		{Name: "invalid json", Rules: `{}`},
+		{Name: "missing type", Rules: `[{"pattern": "a"}]`},
+		{Name: "missing pattern and words", Rules: `[{"type": "a"}]`},
+		{Name: "invalid pattern", Rules: `[{"type": "a", "pattern": "("}]`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := NewRedactorFromConfig(Config{RedactionRulesFile: writeRules(t, tc.Rules)})
+			assert.ErrorIs(t, err, ErrInvalidRedactionRule)
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package suzu
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrInvalidRetryRule = fmt.Errorf("INVALID-RETRY-RULE")
+)
+
+// エラーごとのリトライの指定
+type RetryRule struct {
+	// サービス名、空の場合はすべてのサービスに適用する
+	Provider string
+	// エラーの種類を表すコード、retryCodes の値のいずれかと一致した場合に適用する
+	Code string
+	// エラーメッセージに一致する正規表現
+	Pattern *regexp.Regexp
+	// false の場合はリトライしない
+	Retry bool
+	// 最大リトライ回数、0 の場合は max_retry を使用する
+	MaxAttempts int
+	// 初回のリトライ間隔、0 の場合は retry_interval_ms を使用する
+	Backoff time.Duration
+	// リトライ間隔の上限、指定した場合はリトライごとにリトライ間隔を 2 倍にする
+	MaxBackoff time.Duration
+}
+
+// 先頭から順に判定し、最初に一致したルールを適用する
+type RetryRules []RetryRule
+
+func (r RetryRule) match(provider string, err error) bool {
+	if r.Provider != "" && r.Provider != provider {
+		return false
+	}
+
+	if r.Code != "" {
+		found := false
+		for _, code := range retryCodes(err) {
+			if code == r.Code {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if r.Pattern != nil && !r.Pattern.MatchString(err.Error()) {
+		return false
+	}
+
+	return true
+}
+
+// provider で発生した err に一致するルールを返す
+func (rules RetryRules) Match(provider string, err error) (RetryRule, bool) {
+	if err == nil {
+		return RetryRule{}, false
+	}
+
+	for _, rule := range rules {
+		if rule.match(provider, err) {
+			return rule, true
+		}
+	}
+
+	return RetryRule{}, false
+}
+
+// attempt 回目のリトライまでの待ち時間を返す
+func (r RetryRule) Interval(c Config, attempt int) time.Duration {
+	interval := r.Backoff
+	if interval == 0 {
+		interval = time.Duration(c.RetryIntervalMs) * time.Millisecond
+	}
+
+	if r.MaxBackoff == 0 {
+		return interval
+	}
+
+	for i := 1; i < attempt; i++ {
+		interval *= 2
+		if interval >= r.MaxBackoff {
+			return r.MaxBackoff
+		}
+	}
+	return min(interval, r.MaxBackoff)
+}
+
+// エラーに応じたリトライするかどうか、最大リトライ回数、リトライ間隔
+type retryPolicy struct {
+	Retry    bool
+	MaxRetry int
+
+	config Config
+	// 一致したルール、一致しない場合は nil
+	rule *RetryRule
+}
+
+// provider で発生した err のリトライの指定を返す
+// ルールに一致しない場合は defaultRetry と max_retry、retry_interval_ms を使用する
+func newRetryPolicy(c Config, provider string, err error, defaultRetry bool) retryPolicy {
+	p := retryPolicy{
+		Retry:    defaultRetry,
+		MaxRetry: c.MaxRetry,
+		config:   c,
+	}
+
+	rule, ok := c.RetryRules.Match(provider, err)
+	if !ok {
+		return p
+	}
+
+	p.rule = &rule
+	p.Retry = rule.Retry
+	if rule.MaxAttempts > 0 {
+		p.MaxRetry = rule.MaxAttempts
+	}
+
+	return p
+}
+
+// retryCount 回リトライした後に、さらにリトライできるかどうか
+func (p retryPolicy) CanRetry(retryCount int) bool {
+	return p.Retry && p.MaxRetry > retryCount
+}
+
+// attempt 回目のリトライまでの待ち時間を返す
+func (p retryPolicy) Interval(attempt int) time.Duration {
+	if p.rule == nil {
+		return time.Duration(p.config.RetryIntervalMs) * time.Millisecond
+	}
+	return p.rule.Interval(p.config, attempt)
+}
+
+// ルールでリトライ間隔が指定されているかどうか
+func (p retryPolicy) HasBackoff() bool {
+	return p.rule != nil && (p.rule.Backoff > 0 || p.rule.MaxBackoff > 0)
+}
+
+// エラーからリトライの判定に使用するコードを取り出す
+// gRPC のステータスコード、AWS のエラーコード、HTTP のステータスコード、WebSocket のクローズコード、ErrorCode を返す
+func retryCodes(err error) []string {
+	var result []string
+
+	var suzuErr *SuzuError
+	if errors.As(err, &suzuErr) && suzuErr.Code > 0 {
+		result = append(result, strconv.Itoa(suzuErr.Code))
+	}
+
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		result = append(result, strconv.Itoa(respErr.HTTPStatusCode()))
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		result = append(result, apiErr.ErrorCode())
+	}
+
+	if st, ok := status.FromError(err); ok && st.Code() != codes.OK {
+		result = append(result, st.Code().String())
+	}
+
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		result = append(result, strconv.Itoa(closeErr.Code))
+	}
+
+	result = append(result, string(ErrorCodeOf(err)))
+
+	return result
+}
+
+// ルールファイルの 1 件分の定義
+type retryRuleDefinition struct {
+	Provider     string `json:"provider,omitempty"`
+	Code         string `json:"code,omitempty"`
+	Pattern      string `json:"pattern,omitempty"`
+	Retry        *bool  `json:"retry,omitempty"`
+	MaxAttempts  int    `json:"max_attempts,omitempty"`
+	BackoffMs    int    `json:"backoff_ms,omitempty"`
+	MaxBackoffMs int    `json:"max_backoff_ms,omitempty"`
+}
+
+// JSON のルールファイルからリトライのルールを読み込む
+func LoadRetryRules(filename string) (RetryRules, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var definitions []retryRuleDefinition
+	if err := json.Unmarshal(b, &definitions); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRetryRule, err)
+	}
+
+	rules := make(RetryRules, 0, len(definitions))
+	for i, d := range definitions {
+		if d.Code == "" && d.Pattern == "" {
+			return nil, fmt.Errorf("%w: code or pattern is required (index: %d)", ErrInvalidRetryRule, i)
+		}
+		if d.MaxAttempts < 0 || d.BackoffMs < 0 || d.MaxBackoffMs < 0 {
+			return nil, fmt.Errorf("%w: max_attempts, backoff_ms and max_backoff_ms must be greater than or equal to 0 (index: %d)", ErrInvalidRetryRule, i)
+		}
+
+		rule := RetryRule{
+			Provider:    d.Provider,
+			Code:        d.Code,
+			Retry:       true,
+			MaxAttempts: d.MaxAttempts,
+			Backoff:     time.Duration(d.BackoffMs) * time.Millisecond,
+			MaxBackoff:  time.Duration(d.MaxBackoffMs) * time.Millisecond,
+		}
+		if d.Retry != nil {
+			rule.Retry = *d.Retry
+		}
+		if d.Pattern != "" {
+			re, err := regexp.Compile(d.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s (index: %d)", ErrInvalidRetryRule, err, i)
+			}
+			rule.Pattern = re
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,276 @@
+package suzu
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/transcribestreaming/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetryRules(t *testing.T) {
+	rules, err := LoadRetryRules("testdata/retry_rules.json")
+	require.NoError(t, err)
+	require.Len(t, rules, 5)
+
+	testCases := []struct {
+		Name        string
+		Provider    string
+		Error       error
+		Match       bool
+		Retry       bool
+		MaxAttempts int
+	}{
+		{
+			Name:        "aws service unavailable",
+			Provider:    "aws",
+			Error:       errors.Join(fmt.Errorf("%w (session_id: xxx)", &types.ServiceUnavailableException{}), ErrServerDisconnected),
+			Match:       true,
+			Retry:       true,
+			MaxAttempts: 5,
+		},
+		{
+			Name:     "aws bad request",
+			Provider: "aws",
+			Error:    &types.BadRequestException{},
+			Match:    true,
+			Retry:    false,
+		},
+		{
+			Name:     "aws rule does not match other provider",
+			Provider: "azure",
+			Error:    &types.BadRequestException{},
+			Match:    false,
+		},
+		{
+			Name:        "gcp unavailable",
+			Provider:    "gcp",
+			Error:       status.Error(codes.Unavailable, "unavailable"),
+			Match:       true,
+			Retry:       true,
+			MaxAttempts: 3,
+		},
+		{
+			Name:     "gcp message is not matched as code",
+			Provider: "gcp",
+			Error:    errors.New("code = Unavailable"),
+			Match:    false,
+		},
+		{
+			Name:        "http status code",
+			Provider:    "azure",
+			Error:       &SuzuError{Code: http.StatusServiceUnavailable},
+			Match:       true,
+			Retry:       true,
+			MaxAttempts: 2,
+		},
+		{
+			Name:     "pattern",
+			Provider: "local",
+			Error:    errors.New("read tcp: connection reset by peer"),
+			Match:    true,
+			Retry:    true,
+		},
+		{
+			Name:     "mismatch",
+			Provider: "local",
+			Error:    errors.New("UNEXPECTED-ERROR"),
+			Match:    false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			rule, ok := rules.Match(tc.Provider, tc.Error)
+			assert.Equal(t, tc.Match, ok)
+			if ok {
+				assert.Equal(t, tc.Retry, rule.Retry)
+				assert.Equal(t, tc.MaxAttempts, rule.MaxAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryRuleInterval(t *testing.T) {
+	config := Config{RetryIntervalMs: 100}
+
+	t.Run("default", func(t *testing.T) {
+		rule := RetryRule{}
+		assert.Equal(t, 100*time.Millisecond, rule.Interval(config, 1))
+		assert.Equal(t, 100*time.Millisecond, rule.Interval(config, 3))
+	})
+
+	t.Run("backoff", func(t *testing.T) {
+		rule := RetryRule{Backoff: 200 * time.Millisecond}
+		assert.Equal(t, 200*time.Millisecond, rule.Interval(config, 1))
+		assert.Equal(t, 200*time.Millisecond, rule.Interval(config, 3))
+	})
+
+	t.Run("exponential backoff", func(t *testing.T) {
+		rule := RetryRule{Backoff: 200 * time.Millisecond, MaxBackoff: time.Second}
+		assert.Equal(t, 200*time.Millisecond, rule.Interval(config, 1))
+		assert.Equal(t, 400*time.Millisecond, rule.Interval(config, 2))
+		assert.Equal(t, 800*time.Millisecond, rule.Interval(config, 3))
+		assert.Equal(t, time.Second, rule.Interval(config, 4))
+		assert.Equal(t, time.Second, rule.Interval(config, 10))
+	})
+}
+
+func TestRetryPolicy(t *testing.T) {
+	config := Config{
+		MaxRetry:        1,
+		RetryIntervalMs: 100,
+		RetryRules: RetryRules{
+			{Provider: "aws", Code: "ServiceUnavailableException", Retry: true, MaxAttempts: 5},
+			{Provider: "aws", Code: "BadRequestException", Retry: false},
+		},
+	}
+
+	t.Run("no rule", func(t *testing.T) {
+		p := newRetryPolicy(config, "aws", errors.Join(errors.New("GOAWAY"), ErrServerDisconnected), true)
+		assert.True(t, p.CanRetry(0))
+		assert.False(t, p.CanRetry(1))
+		assert.Equal(t, 100*time.Millisecond, p.Interval(1))
+		assert.False(t, p.HasBackoff())
+	})
+
+	t.Run("max attempts", func(t *testing.T) {
+		p := newRetryPolicy(config, "aws", &types.ServiceUnavailableException{}, false)
+		assert.True(t, p.CanRetry(4))
+		assert.False(t, p.CanRetry(5))
+	})
+
+	t.Run("never retry", func(t *testing.T) {
+		p := newRetryPolicy(config, "aws", errors.Join(&types.BadRequestException{}, ErrServerDisconnected), true)
+		assert.False(t, p.Retry)
+		assert.False(t, p.CanRetry(0))
+	})
+}
+
+func TestRetryTargetsWithRetryRules(t *testing.T) {
+	// handler.go と同じく、サービスのリトライ判定の結果をルールに一致しない場合の指定にする
+	policy := func(t *testing.T, config Config, err error) retryPolicy {
+		t.Helper()
+
+		serviceHandler, e := getServiceHandler(NewDefaultServiceHandlers(), "aws", config, "test-channel-id", "test-connection-id", 48000, 1, "ja-JP", nil)
+		require.NoError(t, e)
+
+		if serviceHandler.IsRetryTarget(err) {
+			err = errors.Join(err, ErrServerDisconnected)
+		}
+		return newRetryPolicy(config, "aws", err, errors.Is(err, ErrServerDisconnected))
+	}
+
+	err := &types.BadRequestException{Message: aws.String("bad request")}
+
+	testCases := []struct {
+		Name         string
+		RetryTargets []string
+		RetryRules   RetryRules
+		Retry        bool
+		MaxRetry     int
+	}{
+		{Name: "no retry targets", Retry: false, MaxRetry: 1},
+		{Name: "retry targets", RetryTargets: []string{"BadRequestException"}, Retry: true, MaxRetry: 1},
+		{
+			Name:         "rule overrides retry targets",
+			RetryTargets: []string{"BadRequestException"},
+			RetryRules:   RetryRules{{Provider: "aws", Code: "BadRequestException", Retry: false}},
+			Retry:        false,
+			MaxRetry:     1,
+		},
+		{
+			Name:       "rule without retry targets",
+			RetryRules: RetryRules{{Provider: "aws", Pattern: regexp.MustCompile("bad request"), Retry: true, MaxAttempts: 3}},
+			Retry:      true,
+			MaxRetry:   3,
+		},
+		{
+			Name:         "rule for other provider",
+			RetryTargets: []string{"BadRequestException"},
+			RetryRules:   RetryRules{{Provider: "gcp", Code: "BadRequestException", Retry: false}},
+			Retry:        true,
+			MaxRetry:     1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			config := Config{
+				MaxRetry:     1,
+				RetryTargets: tc.RetryTargets,
+				RetryRules:   tc.RetryRules,
+			}
+
+			p := policy(t, config, err)
+			assert.Equal(t, tc.Retry, p.Retry)
+			assert.Equal(t, tc.MaxRetry, p.MaxRetry)
+		})
+	}
+}
+
+func TestLoadRetryRulesError(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Rules string
+	}{
+		{Name: "invalid json", Rules: `{}`},
+		{Name: "missing code and pattern", Rules: `[{"provider": "aws"}]`},
+		{Name: "invalid pattern", Rules: `[{"pattern": "("}]`},
+		{Name: "negative max attempts", Rules: `[{"code": "503", "max_attempts": -1}]`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "rules.json")
+			require.NoError(t, os.WriteFile(filename, []byte(tc.Rules), 0644))
+
+			_, err := LoadRetryRules(filename)
+			assert.ErrorIs(t, err, ErrInvalidRetryRule)
+		})
+	}
+}
+
+func TestRetryRulesWithSpeechHandler(t *testing.T) {
+	type message struct {
+		Type   string `json:"type"`
+		Status string `json:"status"`
+		Code   string `json:"code"`
+	}
+
+	config := Config{
+		ListenAddr:                "127.0.0.1",
+		TimeToWaitForOpusPacketMs: 500,
+		MaxRetry:                  1,
+		EnableStatusEvent:         true,
+		RetryRules: RetryRules{
+			// ErrServerDisconnected でもリトライしない
+			{Provider: "primary", Pattern: regexp.MustCompile("^DISCONNECTED"), Retry: false},
+		},
+	}
+
+	serviceHandlers := NewServiceHandlers()
+	serviceHandlers.Register("primary", newStatusTestHandlerFactory(1, "primary"))
+
+	s, err := NewServerBuilder(&config, "primary").
+		WithServiceHandlers(serviceHandlers).
+		WithLanguageCodeFunc("primary", func(lang string) (string, error) { return lang, nil }).
+		Build()
+	require.NoError(t, err)
+
+	messages := serveSpeech[message](t, s, "/speech")
+	assert.Equal(t, []message{
+		{Type: "status", Status: statusConnected},
+		{Type: "error", Code: string(ErrorCodeProviderDisconnected)},
+	}, messages)
+}
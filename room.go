@@ -0,0 +1,199 @@
+package suzu
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+)
+
+const (
+	// storage_retention_days で削除するルームの文字起こしの拡張子
+	roomTranscriptSuffix = ".jsonl"
+)
+
+// ルームの文字起こしに書き込む最終結果
+// sora-channel-id が同じ接続の最終結果を、受信した順に 1 行ずつ書き込む
+type RoomTranscriptRecord struct {
+	ChannelID string `json:"channel_id"`
+	// ルーム内の通し番号
+	Sequence uint64 `json:"sequence"`
+	// 発話したクライアントの connection_id
+	ConnectionID string `json:"connection_id"`
+	// enable_channel_split が有効な場合のチャネルの番号
+	ChannelIndex *int      `json:"channel_index,omitempty"`
+	Provider     string    `json:"provider"`
+	LanguageCode string    `json:"language_code"`
+	Message      string    `json:"message"`
+	Time         time.Time `json:"time"`
+}
+
+// sora-channel-id ごとのルームを管理する
+type roomRegistry struct {
+	dir     string
+	storage Storage
+	now     func() time.Time
+
+	mu    sync.Mutex
+	rooms map[string]*room
+}
+
+// enable_room_mode が無効な場合は nil を返す
+func newRoomRegistry(c Config, storage Storage) *roomRegistry {
+	if !c.EnableRoomMode {
+		return nil
+	}
+
+	return &roomRegistry{
+		dir:     c.RoomTranscriptDir,
+		storage: storage,
+		now:     time.Now,
+		rooms:   make(map[string]*room),
+	}
+}
+
+// sora-channel-id が同じ接続のルームに参加する
+// 最初の接続の場合は room_transcript_dir に <sora-channel-id>-<開始日時>-<sora-connection-id>.jsonl を作成する
+// nil の場合は何もしない
+func (r *roomRegistry) Join(channelID, connectionID string) (*room, error) {
+	if r == nil {
+		return nil, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rm, ok := r.rooms[channelID]
+	if !ok {
+		// ヘッダの値にパスの区切り文字が含まれる場合があるためエスケープする
+		// オブジェクトストレージには追記できないため、ルームを開始するごとに最初の接続の connection_id を付与した別のファイルにする
+		fileName := fmt.Sprintf("%s-%s-%s%s", url.PathEscape(channelID), r.now().Format("20060102-150405"), url.PathEscape(connectionID), roomTranscriptSuffix)
+		f, err := r.storage.Create(context.Background(), filepath.Join(r.dir, fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		rm = &room{
+			channelID: channelID,
+			now:       r.now,
+			file:      f,
+			encoder:   json.NewEncoder(f),
+		}
+		r.rooms[channelID] = rm
+	}
+	rm.members++
+	return rm, nil
+}
+
+// ルームから退出する
+// 最後の接続の場合はファイルを閉じる
+func (r *roomRegistry) Leave(rm *room) error {
+	if r == nil || rm == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rm.members--
+	if rm.members > 0 {
+		return nil
+	}
+
+	delete(r.rooms, rm.channelID)
+	return rm.Close()
+}
+
+// sora-channel-id が同じ接続の文字起こし
+type room struct {
+	channelID string
+	now       func() time.Time
+
+	// roomRegistry の mu で排他する
+	members int
+
+	mu       sync.Mutex
+	file     io.WriteCloser
+	encoder  *json.Encoder
+	sequence uint64
+}
+
+// 最終結果を書き込む
+func (rm *room) Write(record RoomTranscriptRecord) error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	rm.sequence++
+	record.ChannelID = rm.channelID
+	record.Sequence = rm.sequence
+	record.Time = rm.now()
+	return rm.encoder.Encode(record)
+}
+
+func (rm *room) Close() error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	return rm.file.Close()
+}
+
+// ルームの文字起こしに書き込む発話者
+type roomSpeaker struct {
+	room         *room
+	connectionID string
+	channelIndex *int
+}
+
+func newRoomSpeaker(rm *room, connectionID string, channelIndex *int) *roomSpeaker {
+	if rm == nil {
+		return nil
+	}
+
+	return &roomSpeaker{
+		room:         rm,
+		connectionID: connectionID,
+		channelIndex: channelIndex,
+	}
+}
+
+// サービスの結果のうち、最終結果のみをルームの文字起こしに書き込む
+// nil の場合は何もしない
+func (s *roomSpeaker) AddResult(provider, languageCode, message string, isFinal bool) {
+	if s == nil || !isFinal || message == "" {
+		return
+	}
+
+	record := RoomTranscriptRecord{
+		ConnectionID: s.connectionID,
+		ChannelIndex: s.channelIndex,
+		Provider:     provider,
+		LanguageCode: languageCode,
+		Message:      message,
+	}
+	if err := s.room.Write(record); err != nil {
+		zlog.Error().
+			Err(err).
+			Str("channel_id", s.room.channelID).
+			Str("connection_id", s.connectionID).
+			Msg("ROOM-TRANSCRIPT-WRITE-FAILED")
+	}
+}
+
+type roomSpeakerKey struct{}
+
+// サービスのハンドラで最終結果をルームの文字起こしに書き込むため、context に roomSpeaker を格納する
+func withRoomSpeaker(ctx context.Context, s *roomSpeaker) context.Context {
+	return context.WithValue(ctx, roomSpeakerKey{}, s)
+}
+
+// context に格納した roomSpeaker を返す、格納していない場合は nil を返す
+func roomSpeakerFromContext(ctx context.Context) *roomSpeaker {
+	s, _ := ctx.Value(roomSpeakerKey{}).(*roomSpeaker)
+	return s
+}
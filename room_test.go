@@ -0,0 +1,158 @@
+package suzu
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readRoomTranscript(t *testing.T, path string) []RoomTranscriptRecord {
+	t.Helper()
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	records := []RoomTranscriptRecord{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record RoomTranscriptRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.NoError(t, scanner.Err())
+	return records
+}
+
+func TestRoomRegistry(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		registry := newRoomRegistry(Config{}, NewLocalStorage())
+		assert.Nil(t, registry)
+
+		rm, err := registry.Join("sora", "conn-1")
+		assert.NoError(t, err)
+		assert.Nil(t, rm)
+		assert.NoError(t, registry.Leave(rm))
+
+		// nil の場合は何もしない
+		speaker := newRoomSpeaker(rm, "conn-1", nil)
+		assert.Nil(t, speaker)
+		speaker.AddResult("aws", "ja-JP", "こんにちは", true)
+		roomSpeakerFromContext(context.Background()).AddResult("aws", "ja-JP", "こんにちは", true)
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		dir := t.TempDir()
+		registry := newRoomRegistry(Config{EnableRoomMode: true, RoomTranscriptDir: dir}, NewLocalStorage())
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		registry.now = func() time.Time { return now }
+
+		rm1, err := registry.Join("sora/room", "conn-1")
+		require.NoError(t, err)
+		rm2, err := registry.Join("sora/room", "conn-2")
+		require.NoError(t, err)
+		// 同じ sora-channel-id の接続は同じルームに参加する
+		assert.Same(t, rm1, rm2)
+
+		channelIndex := 1
+		speaker1 := newRoomSpeaker(rm1, "conn-1", nil)
+		speaker2 := newRoomSpeaker(rm2, "conn-2", &channelIndex)
+
+		speaker1.AddResult("aws", "ja-JP", "こんにち", false)
+		speaker1.AddResult("aws", "ja-JP", "こんにちは", true)
+		speaker2.AddResult("gcp", "en-US", "hello", true)
+		// 空の最終結果は書き込まない
+		speaker2.AddResult("gcp", "en-US", "", true)
+
+		require.NoError(t, registry.Leave(rm1))
+		// 参加している接続がある間はファイルを閉じない
+		speaker2.AddResult("gcp", "en-US", "bye", true)
+		require.NoError(t, registry.Leave(rm2))
+		assert.Empty(t, registry.rooms)
+
+		// sora-channel-id はエスケープし、ルームの開始日時を付与してファイル名にする
+		records := readRoomTranscript(t, filepath.Join(dir, "sora%2Froom-20240101-000000-conn-1.jsonl"))
+		assert.Equal(t, []RoomTranscriptRecord{
+			{ChannelID: "sora/room", Sequence: 1, ConnectionID: "conn-1", Provider: "aws", LanguageCode: "ja-JP", Message: "こんにちは", Time: now},
+			{ChannelID: "sora/room", Sequence: 2, ConnectionID: "conn-2", ChannelIndex: &channelIndex, Provider: "gcp", LanguageCode: "en-US", Message: "hello", Time: now},
+			{ChannelID: "sora/room", Sequence: 3, ConnectionID: "conn-2", ChannelIndex: &channelIndex, Provider: "gcp", LanguageCode: "en-US", Message: "bye", Time: now},
+		}, records)
+
+		// すべての接続が終了した後に再度参加した場合は別のファイルに書き込む
+		rm, err := registry.Join("sora/room", "conn-3")
+		require.NoError(t, err)
+		newRoomSpeaker(rm, "conn-3", nil).AddResult("aws", "ja-JP", "またね", true)
+		require.NoError(t, registry.Leave(rm))
+
+		records = readRoomTranscript(t, filepath.Join(dir, "sora%2Froom-20240101-000000-conn-3.jsonl"))
+		require.Len(t, records, 1)
+		assert.Equal(t, "conn-3", records[0].ConnectionID)
+		assert.Equal(t, uint64(1), records[0].Sequence)
+	})
+
+	t.Run("open failed", func(t *testing.T) {
+		registry := newRoomRegistry(Config{EnableRoomMode: true, RoomTranscriptDir: filepath.Join(t.TempDir(), "not-found")}, NewLocalStorage())
+		_, err := registry.Join("sora", "conn-1")
+		assert.Error(t, err)
+		assert.Empty(t, registry.rooms)
+	})
+}
+
+func TestRoomModeWithSpeechHandler(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		ListenAddr:                "127.0.0.1",
+		TimeToWaitForOpusPacketMs: 500,
+		SampleRate:                16000,
+		ChannelCount:              1,
+		EnableRoomMode:            true,
+		RoomTranscriptDir:         dir,
+	}
+	s, err := NewServer(&config, "test")
+	require.NoError(t, err)
+
+	for _, connectionID := range []string{"conn-1", "conn-2"} {
+		// 16kHz モノラルの 20ms
+		req := httptest.NewRequest(http.MethodPost, "/speech", bytes.NewReader(make([]byte, 320*2)))
+		req.Header.Set("sora-channel-id", "sora")
+		req.Header.Set("sora-connection-id", connectionID)
+		req.Header.Set("sora-audio-streaming-language-code", "ja-JP")
+		req.Header.Set(echo.HeaderContentType, "audio/pcm; rate=16000; channels=1")
+		req.Proto = "HTTP/2.0"
+		req.ProtoMajor = 2
+		req.ProtoMinor = 0
+
+		rec := httptest.NewRecorder()
+		s.echo.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "sora-*.jsonl"))
+	require.NoError(t, err)
+	// 接続ごとにルームを開始するため、別のファイルに書き込む
+	require.Len(t, files, 2)
+
+	records := []RoomTranscriptRecord{}
+	for _, file := range files {
+		records = append(records, readRoomTranscript(t, file)...)
+	}
+	require.Len(t, records, 2)
+	for i, connectionID := range []string{"conn-1", "conn-2"} {
+		assert.Equal(t, "sora", records[i].ChannelID)
+		assert.Equal(t, connectionID, records[i].ConnectionID)
+		assert.Equal(t, "test", records[i].Provider)
+		assert.Equal(t, "ja-JP", records[i].LanguageCode)
+		assert.Equal(t, "n: 640", records[i].Message)
+	}
+}
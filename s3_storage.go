@@ -0,0 +1,333 @@
+package suzu
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	zlog "github.com/rs/zerolog/log"
+)
+
+const (
+	// マルチパートアップロードのパートの最小サイズ
+	s3MinPartSizeMB = 5
+
+	// アップロードを待つパートの数、超えた場合はアップロードを失敗させる
+	s3PartQueueSize = 4
+)
+
+var (
+	ErrS3PartQueueFull = fmt.Errorf("S3-PART-QUEUE-FULL")
+)
+
+// S3 互換のオブジェクトストレージに保存するストレージ
+// name の ogg_dir と room_transcript_dir はキーのプレフィックスとして扱う
+type S3Storage struct {
+	client *s3.Client
+	bucket string
+
+	partSize             int
+	serverSideEncryption types.ServerSideEncryption
+	sseKMSKeyID          string
+}
+
+func NewS3Storage(c Config) (*S3Storage, error) {
+	loadOptions := []func(*config.LoadOptions) error{
+		config.WithRegion(c.S3Region),
+	}
+	if c.S3AccessKeyID != "" {
+		loadOptions = append(loadOptions, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(c.S3AccessKeyID, c.S3SecretAccessKey, ""),
+		))
+	} else if c.AwsProfile != "" {
+		if c.AwsCredentialFile != "" {
+			loadOptions = append(loadOptions, config.WithSharedCredentialsFiles([]string{c.AwsCredentialFile}))
+		}
+		loadOptions = append(loadOptions, config.WithSharedConfigProfile(c.AwsProfile))
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.TODO(), loadOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if c.S3Endpoint != "" {
+			o.BaseEndpoint = aws.String(c.S3Endpoint)
+		}
+		o.UsePathStyle = c.S3UsePathStyle
+		// S3 互換のストレージはチェックサムのヘッダに対応していない場合があるため、必須の場合のみ付与する
+		o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+		o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+	})
+
+	partSizeMB := c.S3PartSizeMB
+	if partSizeMB < s3MinPartSizeMB {
+		partSizeMB = s3MinPartSizeMB
+	}
+
+	return &S3Storage{
+		client:               client,
+		bucket:               c.S3Bucket,
+		partSize:             partSizeMB * 1024 * 1024,
+		serverSideEncryption: types.ServerSideEncryption(c.S3ServerSideEncryption),
+		sseKMSKeyID:          c.S3SSEKMSKeyID,
+	}, nil
+}
+
+// s3_sse_kms_key_id を指定しない場合は nil を返す
+func (s *S3Storage) sseKMSKeyIDOrNil() *string {
+	if s.sseKMSKeyID == "" {
+		return nil
+	}
+	return aws.String(s.sseKMSKeyID)
+}
+
+// ファイルのパスをオブジェクトのキーに変換する
+func s3ObjectKey(name string) string {
+	key := path.Clean(filepath.ToSlash(name))
+	if key == "." {
+		return ""
+	}
+	return strings.TrimPrefix(key, "/")
+}
+
+// パートのサイズに達するまでは送信しないため、短いセッションは PutObject で保存する
+// パートのサイズを超えた場合は、セッションの終了を待たずにマルチパートアップロードでパートごとに送信する
+func (s *S3Storage) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	return &s3Writer{
+		// セッションの終了後もアップロードを完了するため、キャンセルを引き継がない
+		ctx:     context.WithoutCancel(ctx),
+		storage: s,
+		key:     s3ObjectKey(name),
+	}, nil
+}
+
+func (s *S3Storage) DeleteBefore(ctx context.Context, dir, suffix string, before time.Time) (int, error) {
+	prefix := s3ObjectKey(dir)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+		// dir の直下のみを対象にする
+		Delimiter: aws.String("/"),
+	})
+
+	deleted := 0
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return deleted, err
+		}
+
+		for _, object := range page.Contents {
+			key := aws.ToString(object.Key)
+			if !strings.HasSuffix(key, suffix) || !aws.ToTime(object.LastModified).Before(before) {
+				continue
+			}
+
+			if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(s.bucket),
+				Key:    aws.String(key),
+			}); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+type s3Part struct {
+	number int32
+	data   []byte
+}
+
+// S3 互換のストレージに書き込む io.WriteCloser
+type s3Writer struct {
+	ctx     context.Context
+	storage *S3Storage
+	key     string
+
+	buf        []byte
+	partNumber int32
+	closed     bool
+
+	// マルチパートアップロードを開始した場合のみ使用する
+	partCh   chan s3Part
+	uploadID *string
+	parts    []types.CompletedPart
+	wg       sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errFileNotOpened
+	}
+	// アップロードに失敗した場合は以降の書き込みも失敗させる
+	if err := w.uploadErr(); err != nil {
+		return 0, err
+	}
+
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= w.storage.partSize {
+		if w.partCh == nil {
+			w.startMultipartUpload()
+		}
+		w.partNumber++
+		select {
+		case w.partCh <- s3Part{number: w.partNumber, data: w.buf[:w.storage.partSize]}:
+		default:
+			// 送信が遅れている場合に音声の受信処理を止めないため、待たずにアップロードを失敗させる
+			w.setUploadErr(ErrS3PartQueueFull)
+			w.buf = nil
+			return 0, ErrS3PartQueueFull
+		}
+		w.buf = append([]byte(nil), w.buf[w.storage.partSize:]...)
+	}
+	return len(p), nil
+}
+
+func (w *s3Writer) uploadErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *s3Writer) setUploadErr(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+// パートを順番に送信する goroutine を開始する
+func (w *s3Writer) startMultipartUpload() {
+	w.partCh = make(chan s3Part, s3PartQueueSize)
+	w.wg.Add(1)
+
+	go func() {
+		defer w.wg.Done()
+
+		for part := range w.partCh {
+			// 失敗した後のパートは破棄する
+			if w.uploadErr() != nil {
+				continue
+			}
+
+			if w.uploadID == nil {
+				out, err := w.storage.client.CreateMultipartUpload(w.ctx, &s3.CreateMultipartUploadInput{
+					Bucket:               aws.String(w.storage.bucket),
+					Key:                  aws.String(w.key),
+					ServerSideEncryption: w.storage.serverSideEncryption,
+					SSEKMSKeyId:          w.storage.sseKMSKeyIDOrNil(),
+				})
+				if err != nil {
+					w.setUploadErr(err)
+					continue
+				}
+				w.uploadID = out.UploadId
+			}
+
+			out, err := w.storage.client.UploadPart(w.ctx, &s3.UploadPartInput{
+				Bucket:     aws.String(w.storage.bucket),
+				Key:        aws.String(w.key),
+				UploadId:   w.uploadID,
+				PartNumber: aws.Int32(part.number),
+				Body:       bytes.NewReader(part.data),
+			})
+			if err != nil {
+				w.setUploadErr(err)
+				continue
+			}
+			w.parts = append(w.parts, types.CompletedPart{
+				ETag:       out.ETag,
+				PartNumber: aws.Int32(part.number),
+			})
+		}
+	}()
+}
+
+// 残りのデータを送信して保存を完了する
+func (w *s3Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if w.partCh == nil {
+		_, err := w.storage.client.PutObject(w.ctx, &s3.PutObjectInput{
+			Bucket:               aws.String(w.storage.bucket),
+			Key:                  aws.String(w.key),
+			Body:                 bytes.NewReader(w.buf),
+			ServerSideEncryption: w.storage.serverSideEncryption,
+			SSEKMSKeyId:          w.storage.sseKMSKeyIDOrNil(),
+		})
+		return err
+	}
+
+	// 最後のパートはパートのサイズ未満でもよい
+	// 失敗している場合は送信しない
+	if len(w.buf) > 0 && w.uploadErr() == nil {
+		w.partNumber++
+		w.partCh <- s3Part{number: w.partNumber, data: w.buf}
+		w.buf = nil
+	}
+	close(w.partCh)
+	w.wg.Wait()
+
+	if err := w.uploadErr(); err != nil {
+		w.abort()
+		return err
+	}
+
+	_, err := w.storage.client.CompleteMultipartUpload(w.ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(w.storage.bucket),
+		Key:      aws.String(w.key),
+		UploadId: w.uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{
+			Parts: w.parts,
+		},
+	})
+	if err != nil {
+		w.abort()
+		return err
+	}
+	return nil
+}
+
+// 送信したパートを破棄する
+func (w *s3Writer) abort() {
+	if w.uploadID == nil {
+		return
+	}
+
+	if _, err := w.storage.client.AbortMultipartUpload(w.ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(w.storage.bucket),
+		Key:      aws.String(w.key),
+		UploadId: w.uploadID,
+	}); err != nil {
+		zlog.Warn().
+			Err(err).
+			Str("key", w.key).
+			Msg("S3-ABORT-MULTIPART-UPLOAD-FAILED")
+	}
+}
@@ -23,12 +23,129 @@ import (
 )
 
 type Server struct {
-	config       *Config
-	echo         *echo.Echo
-	echoExporter *echo.Echo
+	config          *Config
+	serviceHandlers ServiceHandlers
+	// サービスごとの言語コードの変換関数
+	languageCodeFuncs map[string]func(string) (string, error)
+	echo              *echo.Echo
+	echoExporter      *echo.Echo
+	// 同時に接続するセッション数の制限
+	sessionLimiter *sessionLimiter
+	// 利用量の記録とチャネルごとの利用量の上限
+	usageLedger *usageLedger
+	// Ogg ファイルとルームの文字起こしの保存先
+	storage Storage
+	// sora-channel-id ごとのルームの文字起こし
+	rooms *roomRegistry
+}
+
+type route struct {
+	method      string
+	path        string
+	handler     echo.HandlerFunc
+	middlewares []echo.MiddlewareFunc
+}
+
+type speechRoute struct {
+	path         string
+	service      string
+	onResultFunc OnResultFunc
+}
+
+// Suzu を Go のプログラムに組み込む場合に Server を生成する
+type ServerBuilder struct {
+	config            *Config
+	service           string
+	serviceHandlers   ServiceHandlers
+	languageCodeFuncs map[string]func(string) (string, error)
+	onResultFunc      OnResultFunc
+	speechRoutes      []speechRoute
+	routes            []route
+	middlewares       []echo.MiddlewareFunc
+	storage           Storage
+}
+
+// service は /speech で利用するサービス名
+func NewServerBuilder(c *Config, service string) *ServerBuilder {
+	return &ServerBuilder{
+		config:            c,
+		service:           service,
+		serviceHandlers:   NewDefaultServiceHandlers(),
+		languageCodeFuncs: make(map[string]func(string) (string, error)),
+	}
+}
+
+// 利用するサービスを指定する
+// 指定しない場合は NewDefaultServiceHandlers() を利用する
+func (b *ServerBuilder) WithServiceHandlers(serviceHandlers ServiceHandlers) *ServerBuilder {
+	b.serviceHandlers = serviceHandlers
+	return b
+}
+
+// sora-audio-streaming-language-code ヘッダの値を検証して、サービスに渡す言語コードを返す関数を指定する
+// Suzu に組み込まれていないサービスを利用する場合は指定する
+func (b *ServerBuilder) WithLanguageCodeFunc(service string, f func(string) (string, error)) *ServerBuilder {
+	b.languageCodeFuncs[service] = f
+	return b
+}
+
+// /speech で受信した結果を処理するコールバックを指定する
+func (b *ServerBuilder) WithOnResultFunc(onResultFunc OnResultFunc) *ServerBuilder {
+	b.onResultFunc = onResultFunc
+	return b
+}
+
+// /speech とは別のサービスで音声データを受け付けるパスを追加する
+func (b *ServerBuilder) WithSpeechRoute(path, service string, onResultFunc OnResultFunc) *ServerBuilder {
+	b.speechRoutes = append(b.speechRoutes, speechRoute{
+		path:         path,
+		service:      service,
+		onResultFunc: onResultFunc,
+	})
+	return b
+}
+
+// 任意のハンドラを追加する
+func (b *ServerBuilder) WithRoute(method, path string, handler echo.HandlerFunc, middlewares ...echo.MiddlewareFunc) *ServerBuilder {
+	b.routes = append(b.routes, route{
+		method:      method,
+		path:        path,
+		handler:     handler,
+		middlewares: middlewares,
+	})
+	return b
+}
+
+// すべてのパスに適用するミドルウェアを追加する
+func (b *ServerBuilder) WithMiddleware(middlewares ...echo.MiddlewareFunc) *ServerBuilder {
+	b.middlewares = append(b.middlewares, middlewares...)
+	return b
+}
+
+// Ogg ファイルとルームの文字起こしの保存先を指定する
+// 指定しない場合は storage_type に従う
+func (b *ServerBuilder) WithStorage(storage Storage) *ServerBuilder {
+	b.storage = storage
+	return b
 }
 
 func NewServer(c *Config, service string) (*Server, error) {
+	return NewServerBuilder(c, service).Build()
+}
+
+func (b *ServerBuilder) Build() (*Server, error) {
+	c := b.config
+	service := b.service
+
+	if _, err := b.serviceHandlers.Get(service); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, service)
+	}
+	for _, r := range b.speechRoutes {
+		if _, err := b.serviceHandlers.Get(r.service); err != nil {
+			return nil, fmt.Errorf("%w: %s", err, r.service)
+		}
+	}
+
 	h2s := &http2.Server{
 		MaxConcurrentStreams: c.HTTP2MaxConcurrentStreams,
 		MaxReadFrameSize:     c.HTTP2MaxReadFrameSize,
@@ -42,8 +159,32 @@ func NewServer(c *Config, service string) (*Server, error) {
 
 	e := echo.New()
 
+	sessionLimiter, err := newSessionLimiter(*c)
+	if err != nil {
+		return nil, err
+	}
+
+	usageLedger, err := newUsageLedger(*c)
+	if err != nil {
+		return nil, err
+	}
+
+	storage := b.storage
+	if storage == nil {
+		storage, err = newStorage(*c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	s := &Server{
-		config: c,
+		config:            c,
+		serviceHandlers:   b.serviceHandlers,
+		languageCodeFuncs: b.languageCodeFuncs,
+		sessionLimiter:    sessionLimiter,
+		usageLedger:       usageLedger,
+		storage:           storage,
+		rooms:             newRoomRegistry(*c, storage),
 	}
 
 	e.Server = &http.Server{
@@ -106,13 +247,26 @@ func NewServer(c *Config, service string) (*Server, error) {
 	}))
 
 	e.Use(middleware.Recover())
+	e.Use(b.middlewares...)
 
 	// LB からのヘルスチェック専用 API
 	e.GET("/.ok", s.healthcheckHandler)
 
-	e.POST("/speech", s.createSpeechHandler(service, nil))
-	e.POST("/test", s.createSpeechHandler("test", nil))
-	e.POST("/dump", s.createSpeechHandler("dump", nil))
+	e.POST("/speech", s.createSpeechHandler(service, b.onResultFunc))
+	// デバッグ用のサービスは登録されている場合のみ追加する
+	for _, name := range []string{"test", "dump"} {
+		if _, err := b.serviceHandlers.Get(name); err == nil {
+			e.POST("/"+name, s.createSpeechHandler(name, nil))
+		}
+	}
+
+	for _, r := range b.speechRoutes {
+		e.POST(r.path, s.createSpeechHandler(r.service, r.onResultFunc))
+	}
+
+	for _, r := range b.routes {
+		e.Add(r.method, r.path, r.handler, r.middlewares...)
+	}
 
 	echoExporter := echo.New()
 	echoExporter.HideBanner = true
@@ -131,6 +285,9 @@ func NewServer(c *Config, service string) (*Server, error) {
 }
 
 func (s *Server) Start(ctx context.Context) error {
+	// storage_retention_days を過ぎた録音と文字起こしを削除する
+	go runStorageRetention(ctx, *s.config, s.storage)
+
 	ch := make(chan error)
 	go func() {
 		defer close(ch)
@@ -0,0 +1,114 @@
+package suzu
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerBuilder(t *testing.T) {
+	config := Config{
+		ListenAddr:                "127.0.0.1",
+		ListenPort:                48080,
+		TimeToWaitForOpusPacketMs: 500,
+	}
+
+	newSpeechRequest := func(t *testing.T, path string) *http.Request {
+		t.Helper()
+
+		r := readDumpFile(t, "testdata/dump.jsonl", 0)
+		t.Cleanup(func() { r.Close() })
+
+		req := httptest.NewRequest(http.MethodPost, path, r)
+		req.Header.Set("sora-channel-id", "test-channel-id")
+		req.Header.Set("sora-audio-streaming-language-code", "ja-JP")
+		req.Proto = "HTTP/2.0"
+		req.ProtoMajor = 2
+		req.ProtoMinor = 0
+		return req
+	}
+
+	t.Run("custom route", func(t *testing.T) {
+		s, err := NewServerBuilder(&config, "test").
+			WithRoute(http.MethodGet, "/custom", func(c echo.Context) error {
+				return c.String(http.StatusOK, "custom")
+			}).
+			Build()
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.echo.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/custom", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "custom", rec.Body.String())
+	})
+
+	t.Run("custom service and on result func", func(t *testing.T) {
+		var called atomic.Int64
+		onResultFunc := func(ctx context.Context, w io.WriteCloser, channelID, connectionID, languageCode string, result ServiceResult) error {
+			assert.Equal(t, "test-channel-id", channelID)
+			assert.Equal(t, "ja-JP", languageCode)
+			assert.Equal(t, "test", result.Provider)
+			assert.NotNil(t, result.Test)
+			called.Add(1)
+			return nil
+		}
+
+		serviceHandlers := NewServiceHandlers()
+		serviceHandlers.Register("custom", NewTestHandler)
+
+		s, err := NewServerBuilder(&config, "custom").
+			WithServiceHandlers(serviceHandlers).
+			WithLanguageCodeFunc("custom", func(lang string) (string, error) { return lang, nil }).
+			WithOnResultFunc(onResultFunc).
+			WithSpeechRoute("/custom", "custom", nil).
+			Build()
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		s.echo.ServeHTTP(rec, newSpeechRequest(t, "/speech"))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Body.String())
+		assert.Positive(t, called.Load())
+
+		// コールバックを指定しない場合は結果をそのまま返す
+		rec = httptest.NewRecorder()
+		s.echo.ServeHTTP(rec, newSpeechRequest(t, "/custom"))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"type":"test"`)
+
+		// 登録していないデバッグ用のサービスのパスは追加しない
+		rec = httptest.NewRecorder()
+		s.echo.ServeHTTP(rec, newSpeechRequest(t, "/test"))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("service not found", func(t *testing.T) {
+		_, err := NewServerBuilder(&config, "unknown").Build()
+		assert.ErrorIs(t, err, ErrServiceNotFound)
+
+		_, err = NewServerBuilder(&config, "test").
+			WithSpeechRoute("/unknown", "unknown", nil).
+			Build()
+		assert.ErrorIs(t, err, ErrServiceNotFound)
+	})
+}
+
+func TestNewServiceHandlerWithNilOnResultFunc(t *testing.T) {
+	// 型のない nil を渡しても panic しない
+	for _, name := range NewDefaultServiceHandlers().GetNames(nil) {
+		t.Run(name, func(t *testing.T) {
+			assert.NotPanics(t, func() {
+				h, err := getServiceHandler(NewDefaultServiceHandlers(), name, Config{}, "", "", 48000, 1, "ja-JP", nil)
+				assert.NoError(t, err)
+				assert.NotNil(t, h)
+			})
+		})
+	}
+}
@@ -6,42 +6,95 @@ import (
 	"io"
 	"strings"
 
+	"cloud.google.com/go/speech/apiv1/speechpb"
+	"github.com/aws/aws-sdk-go-v2/service/transcribestreaming/types"
+	"github.com/shiguredo/suzu/pluginpb"
 	"golang.org/x/exp/slices"
 )
 
 var (
-	NewServiceHandlerFuncs = make(newServiceHandlerFuncs)
-
 	ErrServiceNotFound = fmt.Errorf("SERVICE-NOT-FOUND")
 )
 
-type serviceHandlerInterface interface {
-	Handle(context.Context, chan opus, soraHeader) (*io.PipeReader, error)
+// 音声文字変換サービスから受信した結果を処理するコールバック
+type OnResultFunc func(ctx context.Context, w io.WriteCloser, channelID, connectionID, languageCode string, result ServiceResult) error
+
+// OnResultFunc に渡す、音声文字変換サービスから受信した結果
+// Provider のサービスのフィールドにのみ、受信した結果をそのまま格納する
+type ServiceResult struct {
+	// 結果を受信したサービス（aws, gcp, azure, local, plugin, test, dump）
+	Provider string
+
+	Aws        []types.Result
+	Gcp        []*speechpb.StreamingRecognitionResult
+	Azure      *AzureSpeechResult
+	Local      map[string]any
+	Plugin     *pluginpb.Result
+	Test       *TestResult
+	PacketDump *PacketDumpResult
+}
+
+// 音声文字変換サービスのハンドラ
+type ServiceHandler interface {
+	// 音声データを送信して、クライアントに返す結果を読み出す io.PipeReader を返す
+	// 再接続が必要なエラーの場合は ErrServerDisconnected を含むエラーで閉じる
+	Handle(context.Context, chan Opus, SoraHeader) (*io.PipeReader, error)
 	UpdateRetryCount() int
 	GetRetryCount() int
 	ResetRetryCount() int
 	IsRetryTarget(any) bool
 }
 
-type newServiceHandlerFunc func(Config, string, string, uint32, uint16, string, any) serviceHandlerInterface
+// 外部サービス側のセッション ID を取得できる ServiceHandler
+// 実装している場合は、接続時の type: status のメッセージに session_id を含める
+type SessionIDGetter interface {
+	GetSessionID() string
+}
+
+type NewServiceHandlerFunc func(config Config, channelID, connectionID string, sampleRate uint32, channelCount uint16, languageCode string, onResultFunc OnResultFunc) ServiceHandler
 
-type newServiceHandlerFuncs map[string]newServiceHandlerFunc
+// サービス名と ServiceHandler の生成関数の対応
+type ServiceHandlers map[string]NewServiceHandlerFunc
+
+// 何も登録されていない ServiceHandlers を返す
+func NewServiceHandlers() ServiceHandlers {
+	return make(ServiceHandlers)
+}
+
+// Suzu に組み込まれているサービスを登録した ServiceHandlers を返す
+func NewDefaultServiceHandlers() ServiceHandlers {
+	sh := NewServiceHandlers()
+
+	sh.Register("aws", NewAmazonTranscribeV2Handler)
+	// aws と awsv2 は同じハンドラを使用する
+	// awsv1 と明示的に区別するために awsv2 を追加したため、awsv1 廃止時に不要になったが、後方互換性のために残す
+	sh.Register("awsv2", NewAmazonTranscribeV2Handler)
+	sh.Register("gcp", NewSpeechToTextHandler)
+	sh.Register("azure", NewAzureSpeechHandler)
+	sh.Register("local", NewLocalASRHandler)
+	sh.Register("plugin", NewPluginHandler)
+	sh.Register("test", NewTestHandler)
+	sh.Register("dump", NewPacketDumpHandler)
+
+	return sh
+}
 
-func (sh *newServiceHandlerFuncs) register(name string, f newServiceHandlerFunc) {
-	(*sh)[name] = f
+// 同じ名前のサービスが登録されている場合は上書きする
+func (sh ServiceHandlers) Register(name string, f NewServiceHandlerFunc) {
+	sh[name] = f
 }
 
-func (sh *newServiceHandlerFuncs) get(name string) (*newServiceHandlerFunc, error) {
-	h, ok := (*sh)[name]
+func (sh ServiceHandlers) Get(name string) (NewServiceHandlerFunc, error) {
+	h, ok := sh[name]
 	if !ok {
 		return nil, ErrServiceNotFound
 	}
-	return &h, nil
+	return h, nil
 }
 
-func (sh *newServiceHandlerFuncs) GetNames(exclude []string) []string {
-	names := make([]string, 0, len(*sh))
-	for name := range *sh {
+func (sh ServiceHandlers) GetNames(exclude []string) []string {
+	names := make([]string, 0, len(sh))
+	for name := range sh {
 		if slices.Contains(exclude, name) {
 			continue
 		}
@@ -52,6 +105,8 @@ func (sh *newServiceHandlerFuncs) GetNames(exclude []string) []string {
 }
 
 // message が retry_targets に含まれているかどうかを判定する
+// 各サービスの IsRetryTarget の判定に使用し、その結果が retry_rules_file のルールに一致しない場合のリトライの指定になる
+// ルールに一致した場合は retry_targets に関わらずルールの指定を使用する
 func isRetryTargetByConfig(config Config, message string) bool {
 	// retry_targets が設定されていない場合は固定のエラー判定処理へ
 	retryTargets := config.RetryTargets
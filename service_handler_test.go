@@ -15,7 +15,7 @@ func TestIsRetryTarget(t *testing.T) {
 	sampleRate := uint32(48000)
 	channelCount := uint16(2)
 	languageCode := "ja-JP"
-	onResultFunc := func(context.Context, io.WriteCloser, string, string, string, any) error { return nil }
+	onResultFunc := func(context.Context, io.WriteCloser, string, string, string, ServiceResult) error { return nil }
 
 	testCases := []struct {
 		Name         string
@@ -67,7 +67,7 @@ func TestIsRetryTarget(t *testing.T) {
 				RetryTargets: tc.RetryTargets,
 			}
 
-			serviceHandler, err := getServiceHandler("aws", config, channelID, connectionID, sampleRate, channelCount, languageCode, onResultFunc)
+			serviceHandler, err := getServiceHandler(NewDefaultServiceHandlers(), "aws", config, channelID, connectionID, sampleRate, channelCount, languageCode, onResultFunc)
 			assert.NoError(t, err)
 
 			assert.Equal(t, tc.Expect, serviceHandler.IsRetryTarget(tc.Error))
@@ -29,7 +29,7 @@ func NewSpeechToText(config Config, languageCode string, sampleRate, channelCoun
 	}
 }
 
-func (stt SpeechToText) Start(ctx context.Context, r io.ReadCloser, header soraHeader) (speechpb.Speech_StreamingRecognizeClient, error) {
+func (stt SpeechToText) Start(ctx context.Context, r io.ReadCloser, header SoraHeader) (speechpb.Speech_StreamingRecognizeClient, error) {
 	config := stt.Config
 
 	audioData, err := receiveFirstAudioData(r)
@@ -60,8 +60,9 @@ func (stt SpeechToText) Start(ctx context.Context, r io.ReadCloser, header soraH
 	if err != nil {
 		return nil, &SuzuError{
 			// TODO: 適切な StatusCode に変更する
-			Code:    500,
-			Message: err.Error(),
+			Code:      500,
+			Message:   err.Error(),
+			ErrorCode: ErrorCodeOf(err),
 		}
 	}
 
@@ -75,8 +76,9 @@ func (stt SpeechToText) Start(ctx context.Context, r io.ReadCloser, header soraH
 	}); err != nil {
 		return nil, &SuzuError{
 			// TODO: 適切な StatusCode に変更する
-			Code:    500,
-			Message: err.Error(),
+			Code:      500,
+			Message:   err.Error(),
+			ErrorCode: ErrorCodeOf(err),
 		}
 	}
 
@@ -151,8 +153,16 @@ type RecognitionConfig struct {
 }
 
 func NewRecognitionConfig(c Config, languageCode string, sampleRate, channelCount int32) RecognitionConfig {
+	encoding := speechpb.RecognitionConfig_OGG_OPUS
+	// PCM の場合は変換後のサンプリングレートとチャネル数を指定する
+	if c.GcpAudioFormat == audioFormatPCM {
+		encoding = speechpb.RecognitionConfig_LINEAR16
+		sampleRate = int32(c.PCMSampleRate)
+		channelCount = int32(c.PCMChannelCount)
+	}
+
 	return RecognitionConfig{
-		Encoding:                            speechpb.RecognitionConfig_OGG_OPUS,
+		Encoding:                            encoding,
 		SampleRateHertz:                     sampleRate,
 		AudioChannelCount:                   channelCount,
 		EnableSeparateRecognitionPerChannel: c.GcpEnableSeparateRecognitionPerChannel,
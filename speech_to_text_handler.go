@@ -6,18 +6,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"strings"
+	"strconv"
 	"sync"
 
+	"cloud.google.com/go/speech/apiv1/speechpb"
 	zlog "github.com/rs/zerolog/log"
 
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-func init() {
-	NewServiceHandlerFuncs.register("gcp", NewSpeechToTextHandler)
-}
-
 type SpeechToTextHandler struct {
 	Config Config
 
@@ -29,10 +27,10 @@ type SpeechToTextHandler struct {
 	RetryCount   int
 	mu           sync.Mutex
 
-	OnResultFunc func(context.Context, io.WriteCloser, string, string, string, any) error
+	OnResultFunc OnResultFunc
 }
 
-func NewSpeechToTextHandler(config Config, channelID, connectionID string, sampleRate uint32, channelCount uint16, languageCode string, onResultFunc any) serviceHandlerInterface {
+func NewSpeechToTextHandler(config Config, channelID, connectionID string, sampleRate uint32, channelCount uint16, languageCode string, onResultFunc OnResultFunc) ServiceHandler {
 	return &SpeechToTextHandler{
 		Config:       config,
 		ChannelID:    channelID,
@@ -40,10 +38,24 @@ func NewSpeechToTextHandler(config Config, channelID, connectionID string, sampl
 		SampleRate:   sampleRate,
 		ChannelCount: channelCount,
 		LanguageCode: languageCode,
-		OnResultFunc: onResultFunc.(func(context.Context, io.WriteCloser, string, string, string, any) error),
+		OnResultFunc: onResultFunc,
 	}
 }
 
+// Speech-to-Text の結果に含まれるエラー
+// エラーメッセージは変更せずに、gRPC のステータスコードで判定できるようにする
+type gcpResultError struct {
+	status *status.Status
+}
+
+func (e *gcpResultError) Error() string {
+	return e.status.Message()
+}
+
+func (e *gcpResultError) GRPCStatus() *status.Status {
+	return e.status
+}
+
 type GcpResult struct {
 	IsFinal   *bool    `json:"is_final,omitempty"`
 	Stability *float32 `json:"stability,omitempty"`
@@ -94,9 +106,10 @@ func (h *SpeechToTextHandler) ResetRetryCount() int {
 func (h *SpeechToTextHandler) IsRetryTarget(args any) bool {
 	switch err := args.(type) {
 	case error:
-		if (strings.Contains(err.Error(), "code = OutOfRange")) ||
-			(strings.Contains(err.Error(), "code = InvalidArgument")) ||
-			(strings.Contains(err.Error(), "code = ResourceExhausted")) {
+		// gRPC のステータスコードで判定する
+		if code := status.Code(err); code == codes.OutOfRange ||
+			code == codes.InvalidArgument ||
+			code == codes.ResourceExhausted {
 			return true
 		}
 
@@ -122,10 +135,10 @@ func (h *SpeechToTextHandler) IsRetryTarget(args any) bool {
 	return false
 }
 
-func (h *SpeechToTextHandler) Handle(ctx context.Context, opusCh chan opus, header soraHeader) (*io.PipeReader, error) {
+func (h *SpeechToTextHandler) Handle(ctx context.Context, opusCh chan Opus, header SoraHeader) (*io.PipeReader, error) {
 	stt := NewSpeechToText(h.Config, h.LanguageCode, int32(h.SampleRate), int32(h.ChannelCount))
 
-	packetReader, err := opus2ogg(ctx, opusCh, h.SampleRate, h.ChannelCount, h.Config, header)
+	packetReader, err := newAudioReader(ctx, opusCh, h.Config.GcpAudioFormat, h.SampleRate, h.ChannelCount, h.Config, header)
 	if err != nil {
 		return nil, err
 	}
@@ -141,6 +154,7 @@ func (h *SpeechToTextHandler) Handle(ctx context.Context, opusCh chan opus, head
 
 	go func() {
 		encoder := json.NewEncoder(w)
+		partialResults := newPartialResultTracker()
 
 		for {
 			select {
@@ -162,7 +176,7 @@ func (h *SpeechToTextHandler) Handle(ctx context.Context, opusCh chan opus, head
 					err = errors.Join(err, ErrServerDisconnected)
 				}
 
-				if err := encoder.Encode(NewSuzuErrorResponse(err)); err != nil {
+				if err := encoder.Encode(newServiceErrorResponse(err, "gcp", "")); err != nil {
 					zlog.Error().
 						Err(err).
 						Str("channel_id", h.ChannelID).
@@ -174,16 +188,16 @@ func (h *SpeechToTextHandler) Handle(ctx context.Context, opusCh chan opus, head
 				return
 			}
 
-			if status := resp.Error; status != nil {
+			if st := resp.Error; st != nil {
 				// 音声の長さの上限値に達した場合
-				err := fmt.Errorf("%s", status.GetMessage())
-				code := codes.Code(status.GetCode())
+				code := codes.Code(st.GetCode())
+				var err error = &gcpResultError{status: status.FromProto(st)}
 
 				zlog.Error().
 					Err(err).
 					Str("channel_id", h.ChannelID).
 					Str("connection_id", h.ConnectionID).
-					Int32("code", status.GetCode()).
+					Int32("code", st.GetCode()).
 					Send()
 
 				if h.IsRetryTarget(code) {
@@ -197,8 +211,8 @@ func (h *SpeechToTextHandler) Handle(ctx context.Context, opusCh chan opus, head
 			}
 
 			if h.OnResultFunc != nil {
-				if err := h.OnResultFunc(ctx, w, h.ChannelID, h.ConnectionID, h.LanguageCode, resp.Results); err != nil {
-					if err := encoder.Encode(NewSuzuErrorResponse(err)); err != nil {
+				if err := h.OnResultFunc(ctx, w, h.ChannelID, h.ConnectionID, h.LanguageCode, ServiceResult{Provider: "gcp", Gcp: resp.Results}); err != nil {
+					if err := encoder.Encode(newServiceErrorResponse(err, "gcp", "")); err != nil {
 						zlog.Error().
 							Err(err).
 							Str("channel_id", h.ChannelID).
@@ -209,7 +223,7 @@ func (h *SpeechToTextHandler) Handle(ctx context.Context, opusCh chan opus, head
 					return
 				}
 			} else {
-				for _, res := range resp.Results {
+				for i, res := range resp.Results {
 					if stt.Config.FinalResultOnly {
 						if !res.IsFinal {
 							continue
@@ -224,25 +238,30 @@ func (h *SpeechToTextHandler) Handle(ctx context.Context, opusCh chan opus, head
 						result.WithStability(res.Stability)
 					}
 
-					for _, alternative := range res.Alternatives {
+					for j, alternative := range res.Alternatives {
 						if h.Config.GcpEnableWordConfidence {
-							for _, word := range alternative.Words {
-								zlog.Debug().
-									Str("channel_id", h.ChannelID).
-									Str("connection_id", h.ConnectionID).
-									Str("wrod", word.Word).
-									Float32("confidence", word.Confidence).
-									Str("start_time", word.StartTime.String()).
-									Str("end_time", word.EndTime.String()).
-									Send()
-							}
+							logGcpWords(h.Config, h.ChannelID, h.ConnectionID, alternative)
+						}
+						// text_processor_rules_file で指定した加工を適用する
+						transcript, ok := processResultMessage(stt.Config, alternative.Transcript)
+						if !ok {
+							continue
 						}
-						transcript := alternative.Transcript
-						result.SetMessage(transcript)
+						// 個人情報をマスクする
+						transcript, redactions := stt.Config.Redactor.Redact(transcript)
+						result.Redactions = redactions
+
+						// partial_result_mode が diff の場合は途中結果の変化した部分のみを送信する
+						if ok := partialResults.Apply(&result.TranscriptionResult, stt.Config, strconv.Itoa(int(res.ChannelTag)), gcpPartialResultID(res.ChannelTag, i, j), transcript, res.IsFinal); !ok {
+							continue
+						}
+
 						if err := encoder.Encode(result); err != nil {
 							w.CloseWithError(err)
 							return
 						}
+						countResult("gcp", h.LanguageCode, res.IsFinal)
+						roomSpeakerFromContext(ctx).AddResult("gcp", h.LanguageCode, result.Message, res.IsFinal)
 					}
 				}
 			}
@@ -251,3 +270,38 @@ func (h *SpeechToTextHandler) Handle(ctx context.Context, opusCh chan opus, head
 
 	return r, nil
 }
+
+// partial_result_mode が diff の場合に、前回の途中結果を識別する値
+// Google Speech to Text は発話 ID を返さず、途中結果を安定度の異なる複数の結果に分けて返すため、
+// チャネル、レスポンス内の結果の位置、候補の位置で識別する
+// 前方の結果が確定すると位置がずれるため、チャネルの最終結果を受信した時点で、そのチャネルの途中結果はすべて破棄する
+func gcpPartialResultID(channelTag int32, resultIndex, alternativeIndex int) string {
+	return fmt.Sprintf("%d-%d-%d", channelTag, resultIndex, alternativeIndex)
+}
+
+// 単語ごとの信頼度をログに出力する
+// 電話番号などの個人情報は複数の単語に分かれるため、単語ごとにはマスクできない
+// マスクが有効な場合は単語を出力せず、マスクした発話の全体を出力する
+func logGcpWords(c Config, channelID, connectionID string, alternative *speechpb.SpeechRecognitionAlternative) {
+	redaction := c.Redactor != nil
+	if redaction {
+		zlog.Debug().
+			Str("channel_id", channelID).
+			Str("connection_id", connectionID).
+			Str("transcript", redactLog(c, alternative.Transcript)).
+			Send()
+	}
+
+	for _, word := range alternative.Words {
+		e := zlog.Debug().
+			Str("channel_id", channelID).
+			Str("connection_id", connectionID)
+		if !redaction {
+			e = e.Str("wrod", word.Word)
+		}
+		e.Float32("confidence", word.Confidence).
+			Str("start_time", word.StartTime.String()).
+			Str("end_time", word.EndTime.String()).
+			Send()
+	}
+}
@@ -1,13 +1,19 @@
 package suzu
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"io"
 	"testing"
 
+	"cloud.google.com/go/speech/apiv1/speechpb"
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestIsRetryTargetForSpeechToText(t *testing.T) {
@@ -16,7 +22,7 @@ func TestIsRetryTargetForSpeechToText(t *testing.T) {
 	sampleRate := uint32(48000)
 	channelCount := uint16(2)
 	languageCode := "ja-JP"
-	onResultFunc := func(context.Context, io.WriteCloser, string, string, string, any) error { return nil }
+	onResultFunc := func(context.Context, io.WriteCloser, string, string, string, ServiceResult) error { return nil }
 
 	testCases := []struct {
 		Name         string
@@ -37,23 +43,30 @@ func TestIsRetryTargetForSpeechToText(t *testing.T) {
 			Expect:       false,
 		},
 		{
-			Name:         "code = OutOfRange",
+			Name:         "status.Error(codes.OutOfRange)",
 			RetryTargets: []string{"UNEXPECTED-ERROR"},
-			Error:        errors.New("code = OutOfRange"),
+			Error:        status.Error(codes.OutOfRange, "OutOfRange"),
 			Expect:       true,
 		},
 		{
-			Name:         "code = InvalidArgument",
+			Name:         "status.Error(codes.InvalidArgument)",
 			RetryTargets: []string{"UNEXPECTED-ERROR"},
-			Error:        errors.New("code = InvalidArgument"),
+			Error:        status.Error(codes.InvalidArgument, "InvalidArgument"),
 			Expect:       true,
 		},
 		{
-			Name:         "code = ResourceExhausted",
+			Name:         "status.Error(codes.ResourceExhausted)",
 			RetryTargets: []string{"UNEXPECTED-ERROR"},
-			Error:        errors.New("code = ResourceExhausted"),
+			Error:        status.Error(codes.ResourceExhausted, "ResourceExhausted"),
 			Expect:       true,
 		},
+		{
+			// エラーメッセージの文字列では判定しない
+			Name:         "code = OutOfRange",
+			RetryTargets: []string{"UNEXPECTED-ERROR"},
+			Error:        errors.New("code = OutOfRange"),
+			Expect:       false,
+		},
 		{
 			Name:         "codes.OutOfRange",
 			RetryTargets: []string{"UNEXPECTED-ERROR"},
@@ -86,10 +99,100 @@ func TestIsRetryTargetForSpeechToText(t *testing.T) {
 				RetryTargets: tc.RetryTargets,
 			}
 
-			serviceHandler, err := getServiceHandler("gcp", config, channelID, connectionID, sampleRate, channelCount, languageCode, onResultFunc)
+			serviceHandler, err := getServiceHandler(NewDefaultServiceHandlers(), "gcp", config, channelID, connectionID, sampleRate, channelCount, languageCode, onResultFunc)
 			assert.NoError(t, err)
 
 			assert.Equal(t, tc.Expect, serviceHandler.IsRetryTarget(tc.Error))
 		})
 	}
 }
+
+func TestGcpPartialResultID(t *testing.T) {
+	tracker := newPartialResultTracker()
+	config := Config{PartialResultMode: partialResultModeDiff}
+	result := NewGcpResult()
+
+	// チャネルと結果の位置ごとに前回の途中結果と比較する
+	assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "1", gcpPartialResultID(1, 0, 0), "こんに", false))
+	assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "2", gcpPartialResultID(2, 0, 0), "はい", false))
+	assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "1", gcpPartialResultID(1, 1, 0), "今日", false))
+	assert.Equal(t, 0, *result.ReplaceFrom)
+	assert.Equal(t, "1-1-0", result.PartialID)
+
+	assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "1", gcpPartialResultID(1, 0, 0), "こんにちは", false))
+	assert.Equal(t, 3, *result.ReplaceFrom)
+	assert.Equal(t, "ちは", result.Message)
+	assert.Equal(t, "1-0-0", result.PartialID)
+
+	// gcp_max_alternatives を指定した場合、候補どうしでは比較しない
+	assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "1", gcpPartialResultID(1, 0, 1), "今日は", false))
+	assert.Equal(t, 0, *result.ReplaceFrom)
+	assert.Equal(t, "今日は", result.Message)
+	assert.Equal(t, "1-0-1", result.PartialID)
+
+	// 最終結果を受信した場合は、そのチャネルの途中結果をすべて破棄する
+	assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "1", gcpPartialResultID(1, 0, 0), "こんにちは。", true))
+	assert.Nil(t, result.ReplaceFrom)
+	assert.Equal(t, "1-0-0", result.PartialID)
+
+	// 位置がずれた後続の発話は、以前の発話の途中結果と比較しない
+	assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "1", gcpPartialResultID(1, 0, 0), "今日は", false))
+	assert.Equal(t, 0, *result.ReplaceFrom)
+	assert.Equal(t, "今日は", result.Message)
+	assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "1", gcpPartialResultID(1, 1, 0), "いい", false))
+	assert.Equal(t, 0, *result.ReplaceFrom)
+	assert.Equal(t, "いい", result.Message)
+
+	// 他のチャネルの途中結果は破棄しない
+	assert.True(t, tracker.Apply(&result.TranscriptionResult, config, "2", gcpPartialResultID(2, 0, 0), "はいはい", false))
+	assert.Equal(t, 2, *result.ReplaceFrom)
+}
+
+func TestLogGcpWords(t *testing.T) {
+	// 電話番号が複数の単語に分かれた場合
+	alternative := &speechpb.SpeechRecognitionAlternative{
+		Transcript: "090 1234 5678",
+		Words: []*speechpb.WordInfo{
+			{Word: "090", Confidence: 0.9},
+			{Word: "1234", Confidence: 0.8},
+			{Word: "5678", Confidence: 0.7},
+		},
+	}
+
+	logWords := func(t *testing.T, c Config) string {
+		t.Helper()
+
+		var logs bytes.Buffer
+		orgLogger := zlog.Logger
+		orgLevel := zerolog.GlobalLevel()
+		zlog.Logger = zerolog.New(&logs)
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+		t.Cleanup(func() {
+			zlog.Logger = orgLogger
+			zerolog.SetGlobalLevel(orgLevel)
+		})
+
+		logGcpWords(c, "test-channel-id", "test-connection-id", alternative)
+		return logs.String()
+	}
+
+	t.Run("without redaction", func(t *testing.T) {
+		logs := logWords(t, Config{})
+		assert.Contains(t, logs, `"wrod":"1234"`)
+		assert.Equal(t, 3, bytes.Count([]byte(logs), []byte("\n")))
+	})
+
+	t.Run("with redaction", func(t *testing.T) {
+		redactor, err := NewRedactor([]string{"phone_number"}, "")
+		require.NoError(t, err)
+
+		logs := logWords(t, Config{Redactor: redactor})
+		assert.NotContains(t, logs, "090")
+		assert.NotContains(t, logs, "1234")
+		assert.NotContains(t, logs, "5678")
+		assert.NotContains(t, logs, "wrod")
+		assert.Contains(t, logs, `"transcript":"`)
+		// 単語ごとの信頼度は出力する
+		assert.Contains(t, logs, `"confidence":0.8`)
+	})
+}
@@ -0,0 +1,279 @@
+package suzu
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	// ハンドラの終了後に書き込もうとした
+	errResponseWriterClosed = fmt.Errorf("RESPONSE-WRITER-CLOSED")
+)
+
+const (
+	// type: status のメッセージの status
+	// 外部サービスへの接続に成功した
+	statusConnected = "connected"
+	// 外部サービスへ再接続する
+	statusReconnecting = "reconnecting"
+	// 外部サービス側からストリームが終了された
+	statusEndOfStream = "end_of_stream"
+	// max_session_duration または max_idle_duration を超えたためセッションを終了した
+	statusSessionTimeout = "session_timeout"
+)
+
+// 接続状態をクライアントに通知するメッセージ
+type StatusResult struct {
+	Status string `json:"status"`
+	// 接続先のサービス名
+	Service string `json:"service,omitempty"`
+	// 外部サービス側のセッション ID
+	SessionID string `json:"session_id,omitempty"`
+	// 再接続の試行回数
+	Attempt int `json:"attempt,omitempty"`
+	// enable_channel_split が有効な場合のチャネルの番号
+	ChannelIndex *int `json:"channel_index,omitempty"`
+	TranscriptionResult
+}
+
+func NewStatusResult(status, service string) StatusResult {
+	return StatusResult{
+		Status:  status,
+		Service: service,
+		TranscriptionResult: TranscriptionResult{
+			Type: "status",
+		},
+	}
+}
+
+func (sr *StatusResult) WithSessionID(sessionID string) *StatusResult {
+	sr.SessionID = sessionID
+	return sr
+}
+
+func (sr *StatusResult) WithAttempt(attempt int) *StatusResult {
+	sr.Attempt = attempt
+	return sr
+}
+
+func (sr *StatusResult) WithReason(reason string) *StatusResult {
+	sr.Reason = reason
+	return sr
+}
+
+func (sr *StatusResult) WithChannelIndex(channelIndex *int) *StatusResult {
+	sr.ChannelIndex = channelIndex
+	return sr
+}
+
+// ストリームが生きていることをクライアントに通知するメッセージ
+type HeartbeatResult struct {
+	// これまでに受信した音声の長さ（秒）
+	AudioSeconds float64 `json:"audio_seconds"`
+	TranscriptionResult
+}
+
+func NewHeartbeatResult(audioSeconds float64) HeartbeatResult {
+	return HeartbeatResult{
+		AudioSeconds: audioSeconds,
+		TranscriptionResult: TranscriptionResult{
+			Type: "heartbeat",
+		},
+	}
+}
+
+// 結果の送信とハートビートの送信が並行して行われるため、クライアントへの書き込みを排他制御する
+type responseWriter struct {
+	mu  sync.Mutex
+	res *echo.Response
+	// ハンドラの終了後に書き込まないように、Close 後は書き込みを拒否する
+	closed bool
+}
+
+func newResponseWriter(res *echo.Response) *responseWriter {
+	return &responseWriter{
+		res: res,
+	}
+}
+
+// 書き込んだ後に Flush してクライアントに送信する
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, errResponseWriterClosed
+	}
+
+	n, err := w.res.Write(b)
+	if err != nil {
+		return n, err
+	}
+	w.res.Flush()
+	return n, nil
+}
+
+// ヘッダを送信する
+// 以降は結果の送信とハートビートの送信が並行して行われるため、ここで Committed にしておく
+func (w *responseWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return
+	}
+
+	if !w.res.Committed {
+		w.res.WriteHeader(http.StatusOK)
+	}
+	w.res.Flush()
+}
+
+// 以降の書き込みを拒否する
+func (w *responseWriter) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closed = true
+}
+
+// ヘッダを送信していない場合のみステータスコードを返す
+// チャネルを分割する場合は並行して呼び出すため、書き込みと排他する
+func (w *responseWriter) NoContent(code int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.res.Committed {
+		w.res.WriteHeader(code)
+	}
+	return nil
+}
+
+func (w *responseWriter) Committed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.res.Committed
+}
+
+func (w *responseWriter) WriteJSON(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	// 各サービスの結果と同じく、1 行に 1 つのメッセージを送信する
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// クライアントから受信した音声の長さを数える
+type audioCounter struct {
+	duration atomic.Int64
+	// 最初に音声を受信した時刻（UnixNano）
+	firstReceivedAt atomic.Int64
+	// 最後に音声を受信した時刻（UnixNano）
+	lastReceivedAt atomic.Int64
+
+	// nil の場合はメトリクスに記録しない
+	metrics *sessionMetrics
+}
+
+func (a *audioCounter) Add(opus Opus) {
+	d := opus.Duration()
+	a.duration.Add(int64(d))
+	now := time.Now().UnixNano()
+	a.firstReceivedAt.CompareAndSwap(0, now)
+	a.lastReceivedAt.Store(now)
+
+	if a.metrics != nil {
+		a.metrics.AddAudio(opus.Size(), d)
+	}
+}
+
+// 最初に音声を受信した時刻を返す、未受信の場合は false を返す
+func (a *audioCounter) FirstReceivedAt() (time.Time, bool) {
+	t := a.firstReceivedAt.Load()
+	if t == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, t), true
+}
+
+// 最後に音声を受信した時刻を返す、未受信の場合は false を返す
+func (a *audioCounter) LastReceivedAt() (time.Time, bool) {
+	t := a.lastReceivedAt.Load()
+	if t == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, t), true
+}
+
+func (a *audioCounter) Seconds() float64 {
+	return time.Duration(a.duration.Load()).Seconds()
+}
+
+// 受信したパケットの音声の長さを数えるオプション関数を返す
+// 無音パケットは受信した音声に含めないため、無音パケットを挿入する前に適用する
+func optionCountAudio(counter *audioCounter) packetReaderOption {
+	return func(ctx context.Context, c Config, opusCh chan Opus) chan Opus {
+		ch := make(chan Opus)
+
+		go func() {
+			defer close(ch)
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case req, ok := <-opusCh:
+					if !ok {
+						return
+					}
+
+					if req.Err == nil {
+						counter.Add(req)
+					}
+
+					select {
+					case <-ctx.Done():
+						return
+					case ch <- req:
+					}
+				}
+			}
+		}()
+
+		return ch
+	}
+}
+
+// Opus パケットの音声の長さを返す
+func opusPacketDuration(payload []byte) time.Duration {
+	return time.Duration(opusPacketSamples(payload)) * time.Second / opusGranuleRate
+}
+
+// heartbeat_interval_ms の間隔で type: heartbeat のメッセージを送信する
+// ctx が閉じられるか、送信に失敗した場合は終了する
+func sendHeartbeat(ctx context.Context, w *responseWriter, counter *audioCounter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.WriteJSON(NewHeartbeatResult(counter.Seconds())); err != nil {
+				return
+			}
+		}
+	}
+}
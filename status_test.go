@@ -0,0 +1,320 @@
+package suzu
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testdata/dump.jsonl の音声データを送信し、レスポンスを返す
+func serveSpeechResponse(t *testing.T, s *Server, path string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := readDumpFile(t, "testdata/dump.jsonl", 0)
+	t.Cleanup(func() { r.Close() })
+
+	req := httptest.NewRequest(http.MethodPost, path, r)
+	req.Header.Set("sora-audio-streaming-language-code", "ja-JP")
+	req.Proto = "HTTP/2.0"
+	req.ProtoMajor = 2
+	req.ProtoMinor = 0
+
+	rec := httptest.NewRecorder()
+	s.echo.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	return rec
+}
+
+// testdata/dump.jsonl の音声データを送信し、受信したメッセージを返す
+func serveSpeech[T any](t *testing.T, s *Server, path string) []T {
+	t.Helper()
+
+	rec := serveSpeechResponse(t, s, path)
+
+	var messages []T
+	decoder := json.NewDecoder(bytes.NewReader(rec.Body.Bytes()))
+	for decoder.More() {
+		var m T
+		require.NoError(t, decoder.Decode(&m))
+		messages = append(messages, m)
+	}
+	return messages
+}
+
+func TestOpusPacketDuration(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Payload  []byte
+		Duration time.Duration
+	}{
+		{Name: "empty", Payload: []byte{}, Duration: 0},
+		// config 1 (SILK 20ms), code 0
+		{Name: "silk 20ms", Payload: []byte{1 << 3}, Duration: 20 * time.Millisecond},
+		// config 3 (SILK 60ms), code 1
+		{Name: "silk 60ms 2 frames", Payload: []byte{3<<3 | 1}, Duration: 120 * time.Millisecond},
+		// config 13 (Hybrid 20ms), code 0
+		{Name: "hybrid 20ms", Payload: []byte{13 << 3}, Duration: 20 * time.Millisecond},
+		// config 16 (CELT 2.5ms), code 0
+		{Name: "celt 2.5ms", Payload: []byte{16 << 3}, Duration: 2500 * time.Microsecond},
+		// config 31 (CELT 20ms), code 3, 3 frames
+		{Name: "celt 20ms 3 frames", Payload: []byte{31<<3 | 3, 3}, Duration: 60 * time.Millisecond},
+		{Name: "code 3 without frame count", Payload: []byte{31<<3 | 3}, Duration: 0},
+		{Name: "silent packet", Payload: silentPacket(), Duration: 20 * time.Millisecond},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.Equal(t, tc.Duration, opusPacketDuration(tc.Payload))
+		})
+	}
+}
+
+// 指定した回数だけ ErrServerDisconnected で切断した後に結果を返すハンドラ
+type statusTestHandler struct {
+	disconnects int
+	message     string
+
+	mu         sync.Mutex
+	handled    int
+	retryCount int
+}
+
+func newStatusTestHandlerFactory(disconnects int, message string) NewServiceHandlerFunc {
+	return func(Config, string, string, uint32, uint16, string, OnResultFunc) ServiceHandler {
+		return &statusTestHandler{
+			disconnects: disconnects,
+			message:     message,
+		}
+	}
+}
+
+func (h *statusTestHandler) Handle(ctx context.Context, opusCh chan Opus, header SoraHeader) (*io.PipeReader, error) {
+	h.mu.Lock()
+	h.handled++
+	handled := h.handled
+	h.mu.Unlock()
+
+	r, w := io.Pipe()
+
+	go func() {
+		if handled <= h.disconnects {
+			w.CloseWithError(errors.Join(fmt.Errorf("DISCONNECTED"), ErrServerDisconnected))
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(NewTestResult("", h.message)); err != nil {
+			w.CloseWithError(err)
+			return
+		}
+
+		// heartbeat を送信するまで待つ
+		select {
+		case <-ctx.Done():
+		case <-time.After(100 * time.Millisecond):
+		}
+		w.Close()
+	}()
+
+	return r, nil
+}
+
+func (h *statusTestHandler) UpdateRetryCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.retryCount++
+	return h.retryCount
+}
+
+func (h *statusTestHandler) GetRetryCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.retryCount
+}
+
+func (h *statusTestHandler) ResetRetryCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.retryCount = 0
+	return h.retryCount
+}
+
+func (h *statusTestHandler) IsRetryTarget(any) bool { return false }
+
+func (h *statusTestHandler) GetSessionID() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return fmt.Sprintf("session-%d", h.handled)
+}
+
+func TestStatusEvent(t *testing.T) {
+	type message struct {
+		Type         string  `json:"type"`
+		Status       string  `json:"status"`
+		Service      string  `json:"service"`
+		SessionID    string  `json:"session_id"`
+		Attempt      int     `json:"attempt"`
+		Message      string  `json:"message"`
+		AudioSeconds float64 `json:"audio_seconds"`
+	}
+
+	serve := func(t *testing.T, config Config, serviceHandlers ServiceHandlers) []message {
+		t.Helper()
+
+		builder := NewServerBuilder(&config, "primary").WithServiceHandlers(serviceHandlers)
+		for name := range serviceHandlers {
+			builder.WithLanguageCodeFunc(name, func(lang string) (string, error) { return lang, nil })
+		}
+		s, err := builder.Build()
+		require.NoError(t, err)
+
+		return serveSpeech[message](t, s, "/speech")
+	}
+
+	// heartbeat の送信回数はタイミングに依存するため、heartbeat 以外のメッセージを返す
+	withoutHeartbeat := func(messages []message) []message {
+		var result []message
+		for _, m := range messages {
+			if m.Type != "heartbeat" {
+				result = append(result, m)
+			}
+		}
+		return result
+	}
+
+	t.Run("reconnecting", func(t *testing.T) {
+		config := Config{
+			ListenAddr:                "127.0.0.1",
+			TimeToWaitForOpusPacketMs: 500,
+			MaxRetry:                  1,
+			EnableStatusEvent:         true,
+			HeartbeatIntervalMs:       10,
+		}
+
+		serviceHandlers := NewServiceHandlers()
+		serviceHandlers.Register("primary", newStatusTestHandlerFactory(1, "primary"))
+
+		messages := serve(t, config, serviceHandlers)
+		assert.Equal(t, []message{
+			{Type: "status", Status: statusConnected, Service: "primary", SessionID: "session-1"},
+			{Type: "status", Status: statusReconnecting, Service: "primary", Attempt: 1},
+			{Type: "status", Status: statusConnected, Service: "primary", SessionID: "session-2"},
+			{Type: "test", Message: "primary"},
+			{Type: "status", Status: statusEndOfStream, Service: "primary"},
+		}, withoutHeartbeat(messages))
+
+		var heartbeats int
+		for _, m := range messages {
+			if m.Type == "heartbeat" {
+				heartbeats++
+				assert.GreaterOrEqual(t, m.AudioSeconds, 0.0)
+			}
+		}
+		assert.Positive(t, heartbeats)
+	})
+
+	t.Run("heartbeat stops with handler", func(t *testing.T) {
+		config := Config{
+			ListenAddr:                "127.0.0.1",
+			TimeToWaitForOpusPacketMs: 500,
+			HeartbeatIntervalMs:       1,
+		}
+
+		serviceHandlers := NewServiceHandlers()
+		serviceHandlers.Register("primary", newStatusTestHandlerFactory(0, "primary"))
+
+		s, err := NewServerBuilder(&config, "primary").
+			WithServiceHandlers(serviceHandlers).
+			WithLanguageCodeFunc("primary", func(lang string) (string, error) { return lang, nil }).
+			Build()
+		require.NoError(t, err)
+
+		rec := serveSpeechResponse(t, s, "/speech")
+
+		// ハンドラの終了後は heartbeat を書き込まない
+		n := rec.Body.Len()
+		time.Sleep(20 * time.Millisecond)
+		assert.Equal(t, n, rec.Body.Len())
+	})
+
+	t.Run("one message per line", func(t *testing.T) {
+		config := Config{
+			ListenAddr:                "127.0.0.1",
+			TimeToWaitForOpusPacketMs: 500,
+			MaxRetry:                  1,
+			EnableStatusEvent:         true,
+			HeartbeatIntervalMs:       10,
+		}
+
+		serviceHandlers := NewServiceHandlers()
+		serviceHandlers.Register("primary", newStatusTestHandlerFactory(1, "primary"))
+
+		s, err := NewServerBuilder(&config, "primary").
+			WithServiceHandlers(serviceHandlers).
+			WithLanguageCodeFunc("primary", func(lang string) (string, error) { return lang, nil }).
+			Build()
+		require.NoError(t, err)
+
+		rec := serveSpeechResponse(t, s, "/speech")
+
+		// 行ごとに読み込むクライアントのため、status と heartbeat も 1 行に 1 つのメッセージで送信する
+		body := rec.Body.String()
+		require.True(t, strings.HasSuffix(body, "\n"))
+
+		types := map[string]int{}
+		for line := range strings.Lines(body) {
+			var m message
+			require.NoError(t, json.Unmarshal([]byte(line), &m), line)
+			types[m.Type]++
+		}
+		assert.Equal(t, 4, types["status"])
+		assert.Equal(t, 1, types["test"])
+		assert.Positive(t, types["heartbeat"])
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		config := Config{
+			ListenAddr:                "127.0.0.1",
+			TimeToWaitForOpusPacketMs: 500,
+			MaxRetry:                  1,
+		}
+
+		serviceHandlers := NewServiceHandlers()
+		serviceHandlers.Register("primary", newStatusTestHandlerFactory(1, "primary"))
+
+		messages := serve(t, config, serviceHandlers)
+		assert.Equal(t, []message{
+			{Type: "test", Message: "primary"},
+		}, messages)
+	})
+}
+
+func TestResponseWriter(t *testing.T) {
+	t.Run("closed", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := newResponseWriter(echo.NewResponse(rec, echo.New()))
+
+		_, err := w.Write([]byte("before"))
+		require.NoError(t, err)
+
+		w.Close()
+		_, err = w.Write([]byte("after"))
+		assert.ErrorIs(t, err, errResponseWriterClosed)
+		assert.ErrorIs(t, w.WriteJSON(NewHeartbeatResult(1)), errResponseWriterClosed)
+		w.Flush()
+
+		assert.Equal(t, "before", rec.Body.String())
+	})
+}
@@ -0,0 +1,189 @@
+package suzu
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	zlog "github.com/rs/zerolog/log"
+)
+
+const (
+	// 録音と文字起こしの保存先
+	storageTypeLocal = "local"
+	storageTypeS3    = "s3"
+
+	// storage_retention_days を過ぎた録音と文字起こしを削除する間隔
+	storageRetentionInterval = time.Hour
+)
+
+// 録音と文字起こしを保存するストレージ
+// name は ogg_dir または room_transcript_dir とファイル名を結合した値
+// Suzu を Go のプログラムに組み込む場合は ServerBuilder.WithStorage で独自のストレージを指定できる
+type Storage interface {
+	// name に書き込む io.WriteCloser を返す
+	// Close で保存を完了する、同じ name がある場合は上書きする
+	Create(ctx context.Context, name string) (io.WriteCloser, error)
+	// dir の直下にある suffix で終わる名前のうち、before より前に更新したものを削除して、削除した数を返す
+	DeleteBefore(ctx context.Context, dir, suffix string, before time.Time) (int, error)
+}
+
+// storage_type に従ってストレージを生成する
+func newStorage(c Config) (Storage, error) {
+	switch c.StorageType {
+	case "", storageTypeLocal:
+		return NewLocalStorage(), nil
+	case storageTypeS3:
+		return NewS3Storage(c)
+	}
+	return nil, fmt.Errorf("unknown storage_type: %s", c.StorageType)
+}
+
+// storage_type と s3_* の値を確認する
+func validateStorageConfig(c *Config) error {
+	if c.StorageRetentionDays < 0 {
+		return fmt.Errorf("storage_retention_days must be greater than or equal to 0")
+	}
+
+	switch c.StorageType {
+	case storageTypeLocal:
+		return nil
+	case storageTypeS3:
+	default:
+		return fmt.Errorf("storage_type must be %s or %s", storageTypeLocal, storageTypeS3)
+	}
+
+	if c.S3Bucket == "" {
+		return fmt.Errorf("s3_bucket is required when storage_type is %s", storageTypeS3)
+	}
+	if c.S3Region == "" {
+		return fmt.Errorf("s3_region is required when storage_type is %s", storageTypeS3)
+	}
+	if c.S3PartSizeMB < s3MinPartSizeMB {
+		return fmt.Errorf("s3_part_size_mb must be greater than or equal to %d", s3MinPartSizeMB)
+	}
+	if (c.S3AccessKeyID == "") != (c.S3SecretAccessKey == "") {
+		return fmt.Errorf("s3_access_key_id and s3_secret_access_key must be specified together")
+	}
+
+	switch types.ServerSideEncryption(c.S3ServerSideEncryption) {
+	case "", types.ServerSideEncryptionAes256, types.ServerSideEncryptionAwsKms:
+	default:
+		return fmt.Errorf("s3_server_side_encryption must be %s or %s", types.ServerSideEncryptionAes256, types.ServerSideEncryptionAwsKms)
+	}
+	if c.S3SSEKMSKeyID != "" && types.ServerSideEncryption(c.S3ServerSideEncryption) != types.ServerSideEncryptionAwsKms {
+		return fmt.Errorf("s3_sse_kms_key_id requires s3_server_side_encryption = %s", types.ServerSideEncryptionAwsKms)
+	}
+
+	return nil
+}
+
+// ローカルのファイルシステムに保存するストレージ
+type LocalStorage struct{}
+
+func NewLocalStorage() *LocalStorage {
+	return &LocalStorage{}
+}
+
+func (s *LocalStorage) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	return os.Create(name)
+}
+
+func (s *LocalStorage) DeleteBefore(ctx context.Context, dir, suffix string, before time.Time) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasSuffix(entry.Name(), suffix) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			// 削除済みの場合は無視する
+			if os.IsNotExist(err) {
+				continue
+			}
+			return deleted, err
+		}
+		if !info.ModTime().Before(before) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// storage_retention_days の削除の対象
+type storageRetentionTarget struct {
+	dir    string
+	suffix string
+}
+
+func newStorageRetentionTargets(c Config) []storageRetentionTarget {
+	targets := []storageRetentionTarget{}
+	if c.EnableOggFileOutput {
+		// encryption_key_file の指定を変更した場合も削除するため、暗号化の有無に関わらず両方を対象にする
+		targets = append(targets,
+			storageRetentionTarget{dir: c.OggDir, suffix: oggFileSuffix},
+			storageRetentionTarget{dir: c.OggDir, suffix: oggFileSuffix + encryptedFileSuffix},
+		)
+	}
+	if c.EnableRoomMode {
+		targets = append(targets, storageRetentionTarget{dir: c.RoomTranscriptDir, suffix: roomTranscriptSuffix})
+	}
+	return targets
+}
+
+// storage_retention_days を過ぎた録音と文字起こしを storageRetentionInterval ごとに削除する
+// 0 の場合は削除しない
+func runStorageRetention(ctx context.Context, c Config, storage Storage) {
+	if c.StorageRetentionDays <= 0 {
+		return
+	}
+
+	targets := newStorageRetentionTargets(c)
+	if len(targets) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(storageRetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		before := time.Now().AddDate(0, 0, -c.StorageRetentionDays)
+		for _, target := range targets {
+			deleted, err := storage.DeleteBefore(ctx, target.dir, target.suffix, before)
+			if err != nil {
+				zlog.Error().
+					Err(err).
+					Str("dir", target.dir).
+					Msg("STORAGE-RETENTION-FAILED")
+			}
+			if deleted > 0 {
+				zlog.Info().
+					Str("dir", target.dir).
+					Int("deleted", deleted).
+					Msg("STORAGE-RETENTION-DELETED")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
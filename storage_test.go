@@ -0,0 +1,513 @@
+package suzu
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeS3Object struct {
+	data         []byte
+	lastModified time.Time
+	sse          string
+	sseKMSKeyID  string
+}
+
+type fakeS3Upload struct {
+	key   string
+	sse   string
+	parts map[int][]byte
+}
+
+// テスト用の S3 互換のストレージ
+// パス形式のリクエストで、テストで使用する API のみに対応する
+type fakeS3Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	objects  map[string]*fakeS3Object
+	uploads  map[string]*fakeS3Upload
+	uploadID int
+	aborted  int
+	// UploadPart で返すステータスコード、0 の場合は成功する
+	uploadPartStatus int
+	// 指定した場合は閉じるまで UploadPart の応答を待たせる
+	uploadPartBlock chan struct{}
+}
+
+func newFakeS3Server(t *testing.T) *fakeS3Server {
+	t.Helper()
+
+	s := &fakeS3Server{
+		objects: make(map[string]*fakeS3Object),
+		uploads: make(map[string]*fakeS3Upload),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeS3Server) handle(w http.ResponseWriter, r *http.Request) {
+	if s.uploadPartBlock != nil && r.Method == http.MethodPut && r.URL.Query().Has("uploadId") {
+		<-s.uploadPartBlock
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// /<bucket>/<key>
+	bucketKey := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	key := ""
+	if len(bucketKey) == 2 {
+		key = bucketKey[1]
+	}
+	query := r.URL.Query()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		if s.uploadPartStatus != 0 {
+			w.WriteHeader(s.uploadPartStatus)
+			return
+		}
+		upload, ok := s.uploads[query.Get("uploadId")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		upload.parts[partNumber] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, partNumber))
+	case r.Method == http.MethodPut:
+		s.objects[key] = &fakeS3Object{
+			data:         body,
+			lastModified: time.Now(),
+			sse:          r.Header.Get("x-amz-server-side-encryption"),
+			sseKMSKeyID:  r.Header.Get("x-amz-server-side-encryption-aws-kms-key-id"),
+		}
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.uploadID++
+		uploadID := strconv.Itoa(s.uploadID)
+		s.uploads[uploadID] = &fakeS3Upload{
+			key:   key,
+			sse:   r.Header.Get("x-amz-server-side-encryption"),
+			parts: make(map[int][]byte),
+		}
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, bucketKey[0], key, uploadID)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		upload, ok := s.uploads[query.Get("uploadId")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var complete struct {
+			Parts []struct {
+				PartNumber int
+			} `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data := []byte{}
+		for _, part := range complete.Parts {
+			data = append(data, upload.parts[part.PartNumber]...)
+		}
+		s.objects[upload.key] = &fakeS3Object{data: data, lastModified: time.Now(), sse: upload.sse}
+		delete(s.uploads, query.Get("uploadId"))
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>"complete"</ETag></CompleteMultipartUploadResult>`, bucketKey[0], upload.key)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(s.uploads, query.Get("uploadId"))
+		s.aborted++
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && query.Get("list-type") == "2":
+		s.list(w, bucketKey[0], query.Get("prefix"), query.Get("delimiter"))
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (s *fakeS3Server) list(w http.ResponseWriter, bucket, prefix, delimiter string) {
+	type contents struct {
+		Key          string
+		LastModified string
+		Size         int
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		IsTruncated bool
+		Contents    []contents
+	}{Name: bucket, Prefix: prefix}
+
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if delimiter != "" && strings.Contains(key[len(prefix):], delimiter) {
+			continue
+		}
+		object := s.objects[key]
+		result.Contents = append(result.Contents, contents{
+			Key:          key,
+			LastModified: object.lastModified.UTC().Format(time.RFC3339),
+			Size:         len(object.data),
+		})
+	}
+	result.KeyCount = len(result.Contents)
+
+	if err := xml.NewEncoder(w).Encode(result); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (s *fakeS3Server) object(key string) (*fakeS3Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok := s.objects[key]
+	return object, ok
+}
+
+func (s *fakeS3Server) putObject(key string, lastModified time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = &fakeS3Object{data: []byte(key), lastModified: lastModified}
+}
+
+func newS3ConfigForTest(endpoint string) Config {
+	return Config{
+		StorageType:       storageTypeS3,
+		S3Endpoint:        endpoint,
+		S3Region:          "ap-northeast-1",
+		S3Bucket:          "suzu",
+		S3UsePathStyle:    true,
+		S3AccessKeyID:     "access-key-id",
+		S3SecretAccessKey: "secret-access-key",
+		S3PartSizeMB:      s3MinPartSizeMB,
+	}
+}
+
+func TestValidateStorageConfig(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Config Config
+		Valid  bool
+	}{
+		{Name: "local", Config: Config{StorageType: storageTypeLocal}, Valid: true},
+		{Name: "unknown", Config: Config{StorageType: "gcs"}, Valid: false},
+		{Name: "negative retention", Config: Config{StorageType: storageTypeLocal, StorageRetentionDays: -1}, Valid: false},
+		{Name: "s3", Config: newS3ConfigForTest(""), Valid: true},
+		{Name: "s3 without bucket", Config: func() Config { c := newS3ConfigForTest(""); c.S3Bucket = ""; return c }(), Valid: false},
+		{Name: "s3 without region", Config: func() Config { c := newS3ConfigForTest(""); c.S3Region = ""; return c }(), Valid: false},
+		{Name: "s3 small part size", Config: func() Config { c := newS3ConfigForTest(""); c.S3PartSizeMB = 1; return c }(), Valid: false},
+		{Name: "s3 without secret", Config: func() Config { c := newS3ConfigForTest(""); c.S3SecretAccessKey = ""; return c }(), Valid: false},
+		{Name: "s3 sse aes256", Config: func() Config { c := newS3ConfigForTest(""); c.S3ServerSideEncryption = "AES256"; return c }(), Valid: true},
+		{Name: "s3 sse kms", Config: func() Config {
+			c := newS3ConfigForTest("")
+			c.S3ServerSideEncryption = "aws:kms"
+			c.S3SSEKMSKeyID = "key-id"
+			return c
+		}(), Valid: true},
+		{Name: "s3 sse unknown", Config: func() Config { c := newS3ConfigForTest(""); c.S3ServerSideEncryption = "aes"; return c }(), Valid: false},
+		{Name: "s3 kms key without kms", Config: func() Config {
+			c := newS3ConfigForTest("")
+			c.S3ServerSideEncryption = "AES256"
+			c.S3SSEKMSKeyID = "key-id"
+			return c
+		}(), Valid: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := validateStorageConfig(&tc.Config)
+			if tc.Valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestS3ObjectKey(t *testing.T) {
+	assert.Equal(t, "a.ogg", s3ObjectKey(filepath.Join(".", "a.ogg")))
+	assert.Equal(t, "ogg/a.ogg", s3ObjectKey(filepath.Join("ogg", "a.ogg")))
+	assert.Equal(t, "var/ogg/a.ogg", s3ObjectKey(filepath.Join("/var/ogg", "a.ogg")))
+	assert.Equal(t, "", s3ObjectKey("."))
+}
+
+func TestLocalStorage(t *testing.T) {
+	dir := t.TempDir()
+	storage := NewLocalStorage()
+
+	w, err := storage.Create(t.Context(), filepath.Join(dir, "new.ogg"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("new"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	old := time.Now().Add(-48 * time.Hour)
+	for _, name := range []string{"old.ogg", "old.jsonl"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644))
+		require.NoError(t, os.Chtimes(filepath.Join(dir, name), old, old))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "old-dir.ogg"), 0o755))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "old-dir.ogg"), old, old))
+
+	deleted, err := storage.DeleteBefore(t.Context(), dir, oggFileSuffix, time.Now().Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	// 拡張子が異なるファイルとディレクトリは削除しない
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.ElementsMatch(t, []string{"new.ogg", "old.jsonl", "old-dir.ogg"}, names)
+
+	_, err = storage.DeleteBefore(t.Context(), filepath.Join(dir, "not-found"), oggFileSuffix, time.Now())
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestS3Storage(t *testing.T) {
+	t.Run("put object", func(t *testing.T) {
+		server := newFakeS3Server(t)
+		c := newS3ConfigForTest(server.URL)
+		c.S3ServerSideEncryption = "aws:kms"
+		c.S3SSEKMSKeyID = "key-id"
+		storage, err := NewS3Storage(c)
+		require.NoError(t, err)
+
+		w, err := storage.Create(t.Context(), filepath.Join("ogg", "a.ogg"))
+		require.NoError(t, err)
+		_, err = w.Write([]byte("hello"))
+		require.NoError(t, err)
+
+		// Close までは送信しない
+		_, ok := server.object("ogg/a.ogg")
+		assert.False(t, ok)
+
+		require.NoError(t, w.Close())
+
+		object, ok := server.object("ogg/a.ogg")
+		require.True(t, ok)
+		assert.Equal(t, []byte("hello"), object.data)
+		assert.Equal(t, "aws:kms", object.sse)
+		assert.Equal(t, "key-id", object.sseKMSKeyID)
+	})
+
+	t.Run("multipart upload", func(t *testing.T) {
+		server := newFakeS3Server(t)
+		c := newS3ConfigForTest(server.URL)
+		c.S3ServerSideEncryption = "AES256"
+		storage, err := NewS3Storage(c)
+		require.NoError(t, err)
+		// テストではパートのサイズを小さくする
+		storage.partSize = 8
+
+		w, err := storage.Create(t.Context(), "a.ogg")
+		require.NoError(t, err)
+
+		data := []byte("0123456789abcdefghij")
+		for i := 0; i < len(data); i += 3 {
+			_, err := w.Write(data[i:min(i+3, len(data))])
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Close())
+
+		object, ok := server.object("a.ogg")
+		require.True(t, ok)
+		assert.Equal(t, data, object.data)
+		assert.Equal(t, "AES256", object.sse)
+		assert.Empty(t, server.uploads)
+	})
+
+	t.Run("multipart upload failed", func(t *testing.T) {
+		server := newFakeS3Server(t)
+		// リトライしないステータスコードを返す
+		server.uploadPartStatus = http.StatusForbidden
+		storage, err := NewS3Storage(newS3ConfigForTest(server.URL))
+		require.NoError(t, err)
+		storage.partSize = 8
+
+		w, err := storage.Create(t.Context(), "a.ogg")
+		require.NoError(t, err)
+		_, err = w.Write([]byte("0123456789"))
+		require.NoError(t, err)
+		assert.Error(t, w.Close())
+
+		// 送信したパートを破棄する
+		_, ok := server.object("a.ogg")
+		assert.False(t, ok)
+		assert.Equal(t, 1, server.aborted)
+	})
+
+	t.Run("slow upload", func(t *testing.T) {
+		server := newFakeS3Server(t)
+		server.uploadPartBlock = make(chan struct{})
+		storage, err := NewS3Storage(newS3ConfigForTest(server.URL))
+		require.NoError(t, err)
+		storage.partSize = 8
+
+		w, err := storage.Create(t.Context(), "a.ogg")
+		require.NoError(t, err)
+
+		// 送信待ちのパートが上限を超えた場合は、送信を待たずに書き込みを失敗させる
+		startedAt := time.Now()
+		for i := 0; i < s3PartQueueSize+2 && err == nil; i++ {
+			_, err = w.Write([]byte("01234567"))
+		}
+		assert.ErrorIs(t, err, ErrS3PartQueueFull)
+		assert.Less(t, time.Since(startedAt), time.Second)
+
+		// 以降の書き込みも失敗する
+		_, err = w.Write([]byte("01234567"))
+		assert.ErrorIs(t, err, ErrS3PartQueueFull)
+
+		close(server.uploadPartBlock)
+		assert.ErrorIs(t, w.Close(), ErrS3PartQueueFull)
+
+		// 送信したパートを破棄する
+		_, ok := server.object("a.ogg")
+		assert.False(t, ok)
+		assert.Empty(t, server.uploads)
+	})
+
+	t.Run("delete before", func(t *testing.T) {
+		server := newFakeS3Server(t)
+		storage, err := NewS3Storage(newS3ConfigForTest(server.URL))
+		require.NoError(t, err)
+
+		now := time.Now()
+		old := now.Add(-48 * time.Hour)
+		server.putObject("ogg/old.ogg", old)
+		server.putObject("ogg/new.ogg", now)
+		server.putObject("ogg/old.jsonl", old)
+		server.putObject("ogg/nested/old.ogg", old)
+		server.putObject("old.ogg", old)
+
+		deleted, err := storage.DeleteBefore(t.Context(), "ogg", oggFileSuffix, now.Add(-24*time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+
+		_, ok := server.object("ogg/old.ogg")
+		assert.False(t, ok)
+		// 拡張子が異なるオブジェクト、プレフィックスの直下ではないオブジェクトは削除しない
+		for _, key := range []string{"ogg/new.ogg", "ogg/old.jsonl", "ogg/nested/old.ogg", "old.ogg"} {
+			_, ok := server.object(key)
+			assert.True(t, ok, key)
+		}
+	})
+}
+
+func TestOggRecordingWithS3Storage(t *testing.T) {
+	server := newFakeS3Server(t)
+	storage, err := NewS3Storage(newS3ConfigForTest(server.URL))
+	require.NoError(t, err)
+
+	config := Config{
+		ListenAddr:                "127.0.0.1",
+		TimeToWaitForOpusPacketMs: 500,
+		SampleRate:                48000,
+		ChannelCount:              2,
+		EnableOggFileOutput:       true,
+		OggDir:                    "ogg",
+		OggFileNameTemplate:       "{channel_id}-{connection_id}.ogg",
+	}
+	s, err := NewServerBuilder(&config, "test").WithStorage(storage).Build()
+	require.NoError(t, err)
+
+	r := readDumpFile(t, "testdata/dump.jsonl", 0)
+	defer r.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/speech", r)
+	req.Header.Set("sora-channel-id", "sora")
+	req.Header.Set("sora-connection-id", "JG6CSF8P6D3PS61FW1S4KGK8FM")
+	req.Header.Set("sora-audio-streaming-language-code", "ja-JP")
+	req.Proto = "HTTP/2.0"
+	req.ProtoMajor = 2
+	req.ProtoMinor = 0
+
+	rec := httptest.NewRecorder()
+	s.echo.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// セッションの終了時に Ogg ファイルを保存する
+	object, ok := server.object("ogg/sora-JG6CSF8P6D3PS61FW1S4KGK8FM.ogg")
+	require.True(t, ok)
+
+	reader := newOggReader(bytes.NewReader(object.data))
+	packets := 0
+	for {
+		_, err := reader.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		packets++
+	}
+	// testdata/dump.jsonl は 9 パケット
+	assert.Equal(t, 9, packets)
+}
+
+func TestRunStorageRetention(t *testing.T) {
+	oggDir := t.TempDir()
+	roomDir := t.TempDir()
+
+	old := time.Now().AddDate(0, 0, -2)
+	for _, name := range []string{filepath.Join(oggDir, "old.ogg"), filepath.Join(roomDir, "old.jsonl")} {
+		require.NoError(t, os.WriteFile(name, []byte{}, 0o644))
+		require.NoError(t, os.Chtimes(name, old, old))
+	}
+
+	c := Config{
+		EnableOggFileOutput:  true,
+		OggDir:               oggDir,
+		EnableRoomMode:       true,
+		RoomTranscriptDir:    roomDir,
+		StorageRetentionDays: 1,
+	}
+
+	// 最初の削除の後に終了する
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	runStorageRetention(ctx, c, NewLocalStorage())
+
+	for _, name := range []string{filepath.Join(oggDir, "old.ogg"), filepath.Join(roomDir, "old.jsonl")} {
+		_, err := os.Stat(name)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	}
+}
@@ -10,10 +10,6 @@ import (
 	zlog "github.com/rs/zerolog/log"
 )
 
-func init() {
-	NewServiceHandlerFuncs.register("test", NewTestHandler)
-}
-
 type TestHandler struct {
 	Config Config
 
@@ -25,10 +21,10 @@ type TestHandler struct {
 	RetryCount   int
 	mu           sync.Mutex
 
-	OnResultFunc func(context.Context, io.WriteCloser, string, string, string, any) error
+	OnResultFunc OnResultFunc
 }
 
-func NewTestHandler(config Config, channelID, connectionID string, sampleRate uint32, channelCount uint16, languageCode string, onResultFunc any) serviceHandlerInterface {
+func NewTestHandler(config Config, channelID, connectionID string, sampleRate uint32, channelCount uint16, languageCode string, onResultFunc OnResultFunc) ServiceHandler {
 	return &TestHandler{
 		Config:       config,
 		ChannelID:    channelID,
@@ -36,7 +32,7 @@ func NewTestHandler(config Config, channelID, connectionID string, sampleRate ui
 		SampleRate:   sampleRate,
 		ChannelCount: channelCount,
 		LanguageCode: languageCode,
-		OnResultFunc: onResultFunc.(func(context.Context, io.WriteCloser, string, string, string, any) error),
+		OnResultFunc: onResultFunc,
 	}
 }
 
@@ -78,7 +74,7 @@ func (h *TestHandler) IsRetryTarget(any) bool {
 	return false
 }
 
-func (h *TestHandler) Handle(ctx context.Context, opusCh chan opus, header soraHeader) (*io.PipeReader, error) {
+func (h *TestHandler) Handle(ctx context.Context, opusCh chan Opus, header SoraHeader) (*io.PipeReader, error) {
 	r, w := io.Pipe()
 
 	reader := opusChannelToIOReadCloser(ctx, opusCh)
@@ -91,7 +87,7 @@ func (h *TestHandler) Handle(ctx context.Context, opusCh chan opus, header soraH
 			n, err := reader.Read(buf)
 			if err != nil {
 				if err != io.EOF {
-					if err := encoder.Encode(NewSuzuErrorResponse(err)); err != nil {
+					if err := encoder.Encode(newServiceErrorResponse(err, "test", "")); err != nil {
 						zlog.Error().
 							Err(err).
 							Str("channel_id", h.ChannelID).
@@ -109,8 +105,8 @@ func (h *TestHandler) Handle(ctx context.Context, opusCh chan opus, header soraH
 				result := NewTestResult(*channelID, message)
 
 				if h.OnResultFunc != nil {
-					if err := h.OnResultFunc(ctx, w, h.ChannelID, h.ConnectionID, h.LanguageCode, result); err != nil {
-						if err := encoder.Encode(NewSuzuErrorResponse(err)); err != nil {
+					if err := h.OnResultFunc(ctx, w, h.ChannelID, h.ConnectionID, h.LanguageCode, ServiceResult{Provider: "test", Test: &result}); err != nil {
+						if err := encoder.Encode(newServiceErrorResponse(err, "test", "")); err != nil {
 							zlog.Error().
 								Err(err).
 								Str("channel_id", h.ChannelID).
@@ -126,6 +122,8 @@ func (h *TestHandler) Handle(ctx context.Context, opusCh chan opus, header soraH
 						return
 					}
 				}
+				// test は途中結果を返さないため、すべて最終結果として扱う
+				roomSpeakerFromContext(ctx).AddResult("test", h.LanguageCode, message, true)
 			}
 		}
 	}()
@@ -335,7 +335,7 @@ func TestSpeechHandler(t *testing.T) {
 		rec := httptest.NewRecorder()
 		c := e.NewContext(req, rec)
 
-		h := s.createSpeechHandler(serviceType, func(ctx context.Context, w io.WriteCloser, chnanelID, connectionID, languageCode string, results any) error {
+		h := s.createSpeechHandler(serviceType, func(ctx context.Context, w io.WriteCloser, chnanelID, connectionID, languageCode string, results ServiceResult) error {
 			return fmt.Errorf("ON-RESULT-ERROR")
 		})
 		err := h(c)
@@ -358,7 +358,7 @@ func TestSpeechHandler(t *testing.T) {
 		rec := httptest.NewRecorder()
 		c := e.NewContext(req, rec)
 
-		h := s.createSpeechHandler(serviceType, func(ctx context.Context, w io.WriteCloser, chnanelID, connectionID, languageCode string, results any) error {
+		h := s.createSpeechHandler(serviceType, func(ctx context.Context, w io.WriteCloser, chnanelID, connectionID, languageCode string, results ServiceResult) error {
 			go func() {
 				defer w.Close()
 
@@ -400,8 +400,8 @@ func TestSpeechHandler(t *testing.T) {
 	t.Run("connect error returns suzu error status code", func(t *testing.T) {
 		// テストのみで使用するステータスコード
 		expectedCode := 499
-		NewServiceHandlerFuncs.register("test", NewConnectErrorTestHandlerFactory(expectedCode, "CONNECT-ERROR"))
-		defer NewServiceHandlerFuncs.register("test", NewTestHandler)
+		s.serviceHandlers.Register("test", NewConnectErrorTestHandlerFactory(expectedCode, "CONNECT-ERROR"))
+		defer s.serviceHandlers.Register("test", NewTestHandler)
 
 		r := readDumpFile(t, "testdata/dump.jsonl", 0)
 		defer r.Close()
@@ -424,8 +424,8 @@ func TestSpeechHandler(t *testing.T) {
 	})
 
 	t.Run("connect error with invalid suzu error status code returns 500", func(t *testing.T) {
-		NewServiceHandlerFuncs.register("test", NewConnectErrorTestHandlerFactory(0, "CONNECT-ERROR-WITH-INVALID-STATUS"))
-		defer NewServiceHandlerFuncs.register("test", NewTestHandler)
+		s.serviceHandlers.Register("test", NewConnectErrorTestHandlerFactory(0, "CONNECT-ERROR-WITH-INVALID-STATUS"))
+		defer s.serviceHandlers.Register("test", NewTestHandler)
 
 		r := readDumpFile(t, "testdata/dump.jsonl", 0)
 		defer r.Close()
@@ -453,7 +453,7 @@ func TestSpeechHandler(t *testing.T) {
 		sampleRate := uint32(48000)
 		channelCount := uint16(2)
 		languageCode := "ja-JP"
-		onResultFunc := func(context.Context, io.WriteCloser, string, string, string, any) error { return nil }
+		onResultFunc := func(context.Context, io.WriteCloser, string, string, string, ServiceResult) error { return nil }
 
 		// このハンドラではリトライしないため、常に false を返す
 		testCases := []struct {
@@ -488,7 +488,7 @@ func TestSpeechHandler(t *testing.T) {
 					RetryTargets: tc.RetryTargets,
 				}
 
-				serviceHandler, err := getServiceHandler(serviceType, config, channelID, connectionID, sampleRate, channelCount, languageCode, onResultFunc)
+				serviceHandler, err := getServiceHandler(NewDefaultServiceHandlers(), serviceType, config, channelID, connectionID, sampleRate, channelCount, languageCode, onResultFunc)
 				assert.NoError(t, err)
 
 				assert.Equal(t, tc.Expect, serviceHandler.IsRetryTarget(tc.Error))
@@ -502,8 +502,8 @@ type ConnectErrorTestHandler struct {
 	message string
 }
 
-func NewConnectErrorTestHandlerFactory(code int, message string) newServiceHandlerFunc {
-	return func(Config, string, string, uint32, uint16, string, any) serviceHandlerInterface {
+func NewConnectErrorTestHandlerFactory(code int, message string) NewServiceHandlerFunc {
+	return func(Config, string, string, uint32, uint16, string, OnResultFunc) ServiceHandler {
 		return &ConnectErrorTestHandler{
 			code:    code,
 			message: message,
@@ -511,7 +511,7 @@ func NewConnectErrorTestHandlerFactory(code int, message string) newServiceHandl
 	}
 }
 
-func (h *ConnectErrorTestHandler) Handle(context.Context, chan opus, soraHeader) (*io.PipeReader, error) {
+func (h *ConnectErrorTestHandler) Handle(context.Context, chan Opus, SoraHeader) (*io.PipeReader, error) {
 	return nil, &SuzuError{
 		Code:    h.code,
 		Message: h.message,
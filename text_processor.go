@@ -0,0 +1,190 @@
+package suzu
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// テキスト処理の種類
+	textProcessorTypeRegex                = "regex"
+	textProcessorTypeDictionary           = "dictionary"
+	textProcessorTypeRemoveJapaneseSpaces = "remove_japanese_spaces"
+	textProcessorTypeNormalizeNumerals    = "normalize_numerals"
+)
+
+var (
+	ErrUnsupportedTextProcessorType = fmt.Errorf("UNSUPPORTED-TEXT-PROCESSOR-TYPE")
+	ErrInvalidTextProcessorRule     = fmt.Errorf("INVALID-TEXT-PROCESSOR-RULE")
+
+	// 前後が日本語の文字の空白
+	japaneseSpacePattern = regexp.MustCompile(`([\p{Han}\p{Hiragana}\p{Katakana}ー、。！？「」])[\s　]+([\p{Han}\p{Hiragana}\p{Katakana}ー、。！？「」])`)
+
+	fullWidthDigitReplacer = strings.NewReplacer(
+		"０", "0", "１", "1", "２", "2", "３", "3", "４", "4",
+		"５", "5", "６", "6", "７", "7", "８", "8", "９", "9",
+	)
+)
+
+// 変換結果のテキストを加工する
+type TextProcessor interface {
+	Process(string) string
+}
+
+// 先頭から順に適用するテキスト処理
+type TextProcessors []TextProcessor
+
+func (tps TextProcessors) Process(text string) string {
+	for _, tp := range tps {
+		text = tp.Process(text)
+	}
+	return text
+}
+
+// text_processor_rules_file で指定した加工を結果のテキストに適用する
+// 加工によって空になった結果はクライアントに送らないため false を返す
+// 加工する前から空の結果は、加工しない場合と同じく送る
+func processResultMessage(config Config, message string) (string, bool) {
+	processed := config.TextProcessors.Process(message)
+	if processed == "" && message != "" {
+		return "", false
+	}
+	return processed, true
+}
+
+// 正規表現に一致した部分を置換する
+type RegexTextProcessor struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+func NewRegexTextProcessor(pattern, replacement string) (*RegexTextProcessor, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RegexTextProcessor{
+		Pattern:     re,
+		Replacement: replacement,
+	}, nil
+}
+
+func (p *RegexTextProcessor) Process(text string) string {
+	return p.Pattern.ReplaceAllString(text, p.Replacement)
+}
+
+// 辞書に含まれる語句を置換する
+type DictionaryTextProcessor struct {
+	replacer *strings.Replacer
+}
+
+func NewDictionaryTextProcessor(entries map[string]string) *DictionaryTextProcessor {
+	words := make([]string, 0, len(entries))
+	for word := range entries {
+		if word == "" {
+			continue
+		}
+		words = append(words, word)
+	}
+
+	// 同じ位置で複数の語句に一致する場合は長い語句を優先する
+	sort.Slice(words, func(i, j int) bool {
+		if len(words[i]) != len(words[j]) {
+			return len(words[i]) > len(words[j])
+		}
+		return words[i] < words[j]
+	})
+
+	oldnew := make([]string, 0, len(words)*2)
+	for _, word := range words {
+		oldnew = append(oldnew, word, entries[word])
+	}
+
+	return &DictionaryTextProcessor{
+		replacer: strings.NewReplacer(oldnew...),
+	}
+}
+
+func (p *DictionaryTextProcessor) Process(text string) string {
+	return p.replacer.Replace(text)
+}
+
+// 日本語の文字の間の空白を削除する
+type RemoveJapaneseSpacesTextProcessor struct{}
+
+func (p RemoveJapaneseSpacesTextProcessor) Process(text string) string {
+	// 1 文字の間に空白が連続する場合は 1 回の置換で削除しきれないため、変化がなくなるまで繰り返す
+	for {
+		replaced := japaneseSpacePattern.ReplaceAllString(text, "$1$2")
+		if replaced == text {
+			return replaced
+		}
+		text = replaced
+	}
+}
+
+// 全角数字を半角数字に変換する
+type NormalizeNumeralsTextProcessor struct{}
+
+func (p NormalizeNumeralsTextProcessor) Process(text string) string {
+	return fullWidthDigitReplacer.Replace(text)
+}
+
+// ルールファイルの 1 件分の定義
+type textProcessorRule struct {
+	Type        string            `json:"type"`
+	Pattern     string            `json:"pattern,omitempty"`
+	Replacement string            `json:"replacement,omitempty"`
+	Entries     map[string]string `json:"entries,omitempty"`
+}
+
+// JSON のルールファイルを読み込んで TextProcessors を返す
+func LoadTextProcessors(filename string) (TextProcessors, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []textProcessorRule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidTextProcessorRule, err)
+	}
+
+	tps := make(TextProcessors, 0, len(rules))
+	for i, rule := range rules {
+		tp, err := newTextProcessor(rule)
+		if err != nil {
+			return nil, fmt.Errorf("%w (index: %d)", err, i)
+		}
+		tps = append(tps, tp)
+	}
+
+	return tps, nil
+}
+
+func newTextProcessor(rule textProcessorRule) (TextProcessor, error) {
+	switch rule.Type {
+	case textProcessorTypeRegex:
+		if rule.Pattern == "" {
+			return nil, fmt.Errorf("%w: pattern is required", ErrInvalidTextProcessorRule)
+		}
+		tp, err := NewRegexTextProcessor(rule.Pattern, rule.Replacement)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidTextProcessorRule, err)
+		}
+		return tp, nil
+	case textProcessorTypeDictionary:
+		return NewDictionaryTextProcessor(rule.Entries), nil
+	case textProcessorTypeRemoveJapaneseSpaces:
+		return RemoveJapaneseSpacesTextProcessor{}, nil
+	case textProcessorTypeNormalizeNumerals:
+		return NormalizeNumeralsTextProcessor{}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedTextProcessorType, rule.Type)
+}
@@ -0,0 +1,155 @@
+package suzu
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTextProcessors(t *testing.T) {
+	regex, err := NewRegexTextProcessor(`(えー|あのー?)、?`, "")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		Name       string
+		Processors TextProcessors
+		Text       string
+		Expect     string
+	}{
+		{
+			Name:       "empty",
+			Processors: nil,
+			Text:       "こんにちは",
+			Expect:     "こんにちは",
+		},
+		{
+			Name:       "remove japanese spaces",
+			Processors: TextProcessors{RemoveJapaneseSpacesTextProcessor{}},
+			Text:       "今日 は い い 天気 です。 Hello World",
+			Expect:     "今日はいい天気です。 Hello World",
+		},
+		{
+			Name:       "normalize numerals",
+			Processors: TextProcessors{NormalizeNumeralsTextProcessor{}},
+			Text:       "２０２４年１２月",
+			Expect:     "2024年12月",
+		},
+		{
+			Name:       "regex",
+			Processors: TextProcessors{regex},
+			Text:       "えー、あのー今日は",
+			Expect:     "今日は",
+		},
+		{
+			Name:       "dictionary longest match",
+			Processors: TextProcessors{NewDictionaryTextProcessor(map[string]string{"すず": "Suzu", "すずか": "鈴鹿"})},
+			Text:       "すずかですずを使う",
+			Expect:     "鈴鹿でSuzuを使う",
+		},
+		{
+			Name:       "filler only",
+			Processors: TextProcessors{regex},
+			Text:       "えー、",
+			Expect:     "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.Equal(t, tc.Expect, tc.Processors.Process(tc.Text))
+		})
+	}
+}
+
+func TestProcessResultMessage(t *testing.T) {
+	regex, err := NewRegexTextProcessor(`(えー|あのー?)、?`, "")
+	require.NoError(t, err)
+
+	config := Config{
+		TextProcessors: TextProcessors{regex},
+	}
+
+	t.Run("processed", func(t *testing.T) {
+		message, ok := processResultMessage(config, "えー、今日は")
+		assert.True(t, ok)
+		assert.Equal(t, "今日は", message)
+	})
+
+	t.Run("empty after processing", func(t *testing.T) {
+		message, ok := processResultMessage(config, "えー、")
+		assert.False(t, ok)
+		assert.Empty(t, message)
+	})
+
+	t.Run("empty before processing", func(t *testing.T) {
+		message, ok := processResultMessage(config, "")
+		assert.True(t, ok)
+		assert.Empty(t, message)
+	})
+
+	t.Run("empty without rules", func(t *testing.T) {
+		// text_processor_rules_file を指定しない場合は、空の結果もそのまま送る
+		message, ok := processResultMessage(Config{}, "")
+		assert.True(t, ok)
+		assert.Empty(t, message)
+
+		message, ok = processResultMessage(Config{}, "こんにちは")
+		assert.True(t, ok)
+		assert.Equal(t, "こんにちは", message)
+	})
+}
+
+func TestLoadTextProcessors(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		tps, err := LoadTextProcessors("testdata/text_processor_rules.json")
+		require.NoError(t, err)
+		assert.Len(t, tps, 4)
+
+		assert.Equal(t, "2024年にSuzuを使う", tps.Process("えー、２０２４年 に すず を 使う"))
+	})
+
+	testCases := []struct {
+		Name   string
+		Rules  string
+		Expect error
+	}{
+		{
+			Name:   "invalid json",
+			Rules:  `{"type": "regex"}`,
+			Expect: ErrInvalidTextProcessorRule,
+		},
+		{
+			Name:   "unsupported type",
+			Rules:  `[{"type": "unknown"}]`,
+			Expect: ErrUnsupportedTextProcessorType,
+		},
+		{
+			Name:   "missing pattern",
+			Rules:  `[{"type": "regex"}]`,
+			Expect: ErrInvalidTextProcessorRule,
+		},
+		{
+			Name:   "invalid pattern",
+			Rules:  `[{"type": "regex", "pattern": "("}]`,
+			Expect: ErrInvalidTextProcessorRule,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "rules.json")
+			require.NoError(t, os.WriteFile(filename, []byte(tc.Rules), 0644))
+
+			_, err := LoadTextProcessors(filename)
+			assert.ErrorIs(t, err, tc.Expect)
+		})
+	}
+
+	t.Run("file not found", func(t *testing.T) {
+		_, err := LoadTextProcessors("testdata/not_found.json")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
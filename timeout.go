@@ -0,0 +1,120 @@
+package suzu
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// セッションを終了した理由、type: status のメッセージの reason に使用する
+	sessionTimeoutMaxSessionDuration = "max_session_duration"
+	sessionTimeoutMaxIdleDuration    = "max_idle_duration"
+)
+
+// max_session_duration と max_idle_duration を超えた場合にセッションを終了する
+// 0 の場合は制限しない
+type sessionTimeout struct {
+	maxSessionDuration time.Duration
+	maxIdleDuration    time.Duration
+
+	startedAt time.Time
+	// 最後に音声を受信した時刻を取得する、無音パケットは含まない
+	counter *audioCounter
+
+	mu     sync.Mutex
+	reason string
+}
+
+func newSessionTimeout(c Config, startedAt time.Time, counter *audioCounter) *sessionTimeout {
+	return &sessionTimeout{
+		maxSessionDuration: c.MaxSessionDuration,
+		maxIdleDuration:    c.MaxIdleDuration,
+		startedAt:          startedAt,
+		counter:            counter,
+	}
+}
+
+// 上限を超えてセッションを終了した場合に、その理由を返す
+func (t *sessionTimeout) Reason() (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.reason, t.reason != ""
+}
+
+func (t *sessionTimeout) expire(reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reason = reason
+}
+
+// 最後に音声を受信してからの経過時間を基に、idle と判定するまでの残り時間を返す
+// 音声を受信していない場合はセッションの開始時刻を基にする
+func (t *sessionTimeout) idleRemaining(now time.Time) time.Duration {
+	last, ok := t.counter.LastReceivedAt()
+	if !ok {
+		last = t.startedAt
+	}
+	return t.maxIdleDuration - now.Sub(last)
+}
+
+// 上限を超えた場合に channel を閉じるオプション関数を返す
+// channel を閉じるとサービス側のストリームが終了し、最後の結果を受信した後に io.EOF になる
+// 無音パケットを idle に含めるため、無音パケットを挿入した後に適用する
+func optionSessionTimeout(t *sessionTimeout) packetReaderOption {
+	return func(ctx context.Context, c Config, opusCh chan Opus) chan Opus {
+		if t.maxSessionDuration <= 0 && t.maxIdleDuration <= 0 {
+			return opusCh
+		}
+
+		ch := make(chan Opus)
+
+		go func() {
+			defer close(ch)
+
+			// 0 の場合は発火しないタイマーにする
+			var sessionTimerC, idleTimerC <-chan time.Time
+			if t.maxSessionDuration > 0 {
+				timer := time.NewTimer(time.Until(t.startedAt.Add(t.maxSessionDuration)))
+				defer timer.Stop()
+				sessionTimerC = timer.C
+			}
+			var idleTimer *time.Timer
+			if t.maxIdleDuration > 0 {
+				idleTimer = time.NewTimer(t.idleRemaining(time.Now()))
+				defer idleTimer.Stop()
+				idleTimerC = idleTimer.C
+			}
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-sessionTimerC:
+					t.expire(sessionTimeoutMaxSessionDuration)
+					return
+				case <-idleTimerC:
+					// タイマーの開始後に音声を受信していた場合は、残り時間でタイマーを再開する
+					if d := t.idleRemaining(time.Now()); d > 0 {
+						idleTimer.Reset(d)
+						continue
+					}
+					t.expire(sessionTimeoutMaxIdleDuration)
+					return
+				case req, ok := <-opusCh:
+					if !ok {
+						return
+					}
+
+					select {
+					case <-ctx.Done():
+						return
+					case ch <- req:
+					}
+				}
+			}
+		}()
+
+		return ch
+	}
+}
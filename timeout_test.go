@@ -0,0 +1,128 @@
+package suzu
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionTimeout(t *testing.T) {
+	type message struct {
+		Type    string `json:"type"`
+		Status  string `json:"status"`
+		Service string `json:"service"`
+		Reason  string `json:"reason"`
+	}
+
+	// クライアントが POST のボディを閉じずに interval ごとに音声を送信する状態で、受信したメッセージを返す
+	// interval が 0 の場合は最初の音声のみを送信する
+	serve := func(t *testing.T, config Config, interval time.Duration) []message {
+		t.Helper()
+
+		s, err := NewServer(&config, "test")
+		require.NoError(t, err)
+
+		r, w := io.Pipe()
+		t.Cleanup(func() { w.Close() })
+
+		go func() {
+			for {
+				if _, err := w.Write([]byte{31 << 3, 0}); err != nil {
+					return
+				}
+				if interval == 0 {
+					return
+				}
+				time.Sleep(interval)
+			}
+		}()
+
+		req := httptest.NewRequest(http.MethodPost, "/speech", r)
+		req.Header.Set("sora-audio-streaming-language-code", "ja-JP")
+		req.Proto = "HTTP/2.0"
+		req.ProtoMajor = 2
+		req.ProtoMinor = 0
+
+		rec := httptest.NewRecorder()
+		s.echo.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var messages []message
+		decoder := json.NewDecoder(bytes.NewReader(rec.Body.Bytes()))
+		for decoder.More() {
+			var m message
+			require.NoError(t, decoder.Decode(&m))
+			messages = append(messages, m)
+		}
+		return messages
+	}
+
+	t.Run("max idle duration", func(t *testing.T) {
+		config := Config{
+			ListenAddr:                "127.0.0.1",
+			TimeToWaitForOpusPacketMs: 20,
+			MaxIdleDuration:           200 * time.Millisecond,
+		}
+
+		start := time.Now()
+		messages := serve(t, config, 0)
+		assert.GreaterOrEqual(t, time.Since(start), config.MaxIdleDuration)
+
+		require.NotEmpty(t, messages)
+		// 無音パケットの結果を送信した後に終了した理由を通知する
+		assert.Equal(t, "test", messages[0].Type)
+		assert.Equal(t, message{
+			Type:    "status",
+			Status:  statusSessionTimeout,
+			Service: "test",
+			Reason:  sessionTimeoutMaxIdleDuration,
+		}, messages[len(messages)-1])
+	})
+
+	t.Run("max session duration", func(t *testing.T) {
+		config := Config{
+			ListenAddr:                "127.0.0.1",
+			TimeToWaitForOpusPacketMs: 500,
+			MaxSessionDuration:        300 * time.Millisecond,
+			// 音声を受信するごとに idle の判定をやり直す
+			MaxIdleDuration: 100 * time.Millisecond,
+		}
+
+		messages := serve(t, config, 20*time.Millisecond)
+
+		require.NotEmpty(t, messages)
+		assert.Equal(t, message{
+			Type:    "status",
+			Status:  statusSessionTimeout,
+			Service: "test",
+			Reason:  sessionTimeoutMaxSessionDuration,
+		}, messages[len(messages)-1])
+	})
+
+	t.Run("status event", func(t *testing.T) {
+		config := Config{
+			ListenAddr:                "127.0.0.1",
+			TimeToWaitForOpusPacketMs: 500,
+			MaxSessionDuration:        100 * time.Millisecond,
+			EnableStatusEvent:         true,
+		}
+
+		messages := serve(t, config, 20*time.Millisecond)
+
+		// end_of_stream の代わりに session_timeout を通知する
+		var statuses []string
+		for _, m := range messages {
+			if m.Type == "status" {
+				statuses = append(statuses, m.Status)
+			}
+		}
+		assert.Equal(t, []string{statusConnected, statusSessionTimeout}, statuses)
+	})
+}
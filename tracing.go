@@ -0,0 +1,113 @@
+package suzu
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.30.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName = "github.com/shiguredo/suzu"
+)
+
+var (
+	// InitTracer を呼び出す前に取得した場合も、設定後の TracerProvider を使用する
+	tracer = otel.Tracer(tracerName)
+
+	// Sora から受信する traceparent ヘッダを読み込む
+	// otlp_endpoint を指定しない場合もログにトレース ID を出力するため、グローバルの設定は使用しない
+	tracePropagator = propagation.TraceContext{}
+)
+
+// otlp_endpoint が指定されている場合は、OTLP でトレースを送信する TracerProvider を設定する
+// 戻り値の関数は終了時に呼び出し、送信していないトレースを送信する
+func InitTracer(ctx context.Context, c *Config) (func(context.Context) error, error) {
+	if c.OTLPEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	options := []otlptracegrpc.Option{
+		otlptracegrpc.WithEndpoint(c.OTLPEndpoint),
+	}
+	if !c.OTLPTLS {
+		options = append(options, otlptracegrpc.WithInsecure())
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName("suzu"),
+			semconv.ServiceVersion(Version),
+		)),
+	)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(tracePropagator)
+
+	return tp.Shutdown, nil
+}
+
+// start から現在までのスパンを記録する
+func recordSpan(ctx context.Context, name string, start time.Time, attrs ...attribute.KeyValue) {
+	_, span := tracer.Start(ctx, name, trace.WithTimestamp(start), trace.WithAttributes(attrs...))
+	span.End()
+}
+
+// スパンにエラーを記録する
+func recordSpanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	span.SetAttributes(attribute.String("suzu.error_code", string(ErrorCodeOf(err))))
+}
+
+// 最初のパケットをサービスに渡したときに f を呼び出すオプション関数を返す
+// 無音パケットも含めるため、無音パケットを挿入した後に適用する
+func optionOnFirstPacket(f func()) packetReaderOption {
+	return func(ctx context.Context, c Config, opusCh chan Opus) chan Opus {
+		ch := make(chan Opus)
+
+		go func() {
+			defer close(ch)
+
+			called := false
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case req, ok := <-opusCh:
+					if !ok {
+						return
+					}
+
+					select {
+					case <-ctx.Done():
+						return
+					case ch <- req:
+					}
+
+					if !called && req.Err == nil {
+						called = true
+						f()
+					}
+				}
+			}
+		}()
+
+		return ch
+	}
+}
@@ -0,0 +1,164 @@
+package suzu
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	spanRecorderOnce sync.Once
+	spanRecorder     *tracetest.SpanRecorder
+)
+
+// グローバルの TracerProvider は一度しか差し替えられないため、テスト間で SpanRecorder を共有する
+// テストごとに異なるトレース ID を使用してスパンを区別する
+func setupSpanRecorder(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	spanRecorderOnce.Do(func() {
+		spanRecorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+	})
+	return spanRecorder
+}
+
+// traceID のトレースに含まれる終了したスパンを名前ごとに返す
+func endedSpans(sr *tracetest.SpanRecorder, traceID string) map[string]sdktrace.ReadOnlySpan {
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range sr.Ended() {
+		if s.SpanContext().TraceID().String() == traceID {
+			spans[s.Name()] = s
+		}
+	}
+	return spans
+}
+
+func TestTracing(t *testing.T) {
+	sr := setupSpanRecorder(t)
+
+	t.Run("speech", func(t *testing.T) {
+		const (
+			traceID      = "4bf92f3577b34da6a3ce929d0e0e4736"
+			parentSpanID = "00f067aa0ba902b7"
+		)
+
+		// ログにトレース ID が含まれることを確認する
+		var logs bytes.Buffer
+		orgLogger := zlog.Logger
+		zlog.Logger = zerolog.New(&logs)
+		t.Cleanup(func() { zlog.Logger = orgLogger })
+
+		config := Config{
+			ListenAddr:                "127.0.0.1",
+			TimeToWaitForOpusPacketMs: 500,
+		}
+		s, err := NewServer(&config, "test")
+		require.NoError(t, err)
+
+		r := readDumpFile(t, "testdata/dump.jsonl", 0)
+		defer r.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/speech", r)
+		req.Header.Set("sora-audio-streaming-language-code", "ja-JP")
+		req.Header.Set("traceparent", "00-"+traceID+"-"+parentSpanID+"-01")
+		req.Proto = "HTTP/2.0"
+		req.ProtoMajor = 2
+		req.ProtoMinor = 0
+
+		rec := httptest.NewRecorder()
+		s.echo.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		spans := endedSpans(sr, traceID)
+
+		session, ok := spans["session"]
+		require.True(t, ok)
+		assert.Equal(t, parentSpanID, session.Parent().SpanID().String())
+		assert.Equal(t, trace.SpanKindServer, session.SpanKind())
+
+		for _, name := range []string{"provider.connect", "first_audio_sent", "first_result"} {
+			span, ok := spans[name]
+			if assert.True(t, ok, name) {
+				assert.Equal(t, session.SpanContext().SpanID(), span.Parent().SpanID(), name)
+			}
+		}
+
+		assert.Contains(t, logs.String(), `"trace_id":"`+traceID+`"`)
+	})
+
+	t.Run("ogg conversion", func(t *testing.T) {
+		c := Config{
+			DisableSilentPacket: true,
+		}
+
+		r := readDumpFile(t, "testdata/000_long.jsonl", 0)
+		defer r.Close()
+
+		ctx, parent := otel.Tracer(tracerName).Start(t.Context(), "parent")
+		defer parent.End()
+		traceID := parent.SpanContext().TraceID().String()
+
+		opusCh := newOpusChannel(ctx, c, r, newPacketReaderOptions(c))
+
+		reader, err := opus2ogg(ctx, opusCh, 48000, 1)
+		require.NoError(t, err)
+		defer reader.Close()
+
+		_, err = io.ReadAll(reader)
+		require.NoError(t, err)
+
+		span, ok := endedSpans(sr, traceID)["ogg_conversion.start"]
+		require.True(t, ok)
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+	})
+
+	t.Run("without traceparent", func(t *testing.T) {
+		config := Config{
+			ListenAddr:                "127.0.0.1",
+			TimeToWaitForOpusPacketMs: 500,
+		}
+		s, err := NewServer(&config, "test")
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(t.Context())
+		defer cancel()
+
+		req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/speech", strings.NewReader(""))
+		req.Header.Set("sora-audio-streaming-language-code", "ja-JP")
+		req.Header.Set("sora-channel-id", "tracing-without-traceparent")
+		req.Proto = "HTTP/2.0"
+		req.ProtoMajor = 2
+		req.ProtoMinor = 0
+
+		rec := httptest.NewRecorder()
+		s.echo.ServeHTTP(rec, req)
+
+		// 新しいトレースを開始する
+		var session sdktrace.ReadOnlySpan
+		for _, span := range sr.Ended() {
+			for _, attr := range span.Attributes() {
+				if attr.Key == "suzu.channel_id" && attr.Value.AsString() == "tracing-without-traceparent" {
+					session = span
+				}
+			}
+		}
+		require.NotNil(t, session)
+		assert.True(t, session.SpanContext().HasTraceID())
+		assert.False(t, session.Parent().IsValid())
+	})
+}
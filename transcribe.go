@@ -0,0 +1,328 @@
+package suzu
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// suzu transcribe の出力の形式
+	TranscribeFormatJSONL  = "jsonl"
+	TranscribeFormatWebVTT = "vtt"
+)
+
+var (
+	ErrUnsupportedTranscribeFormat = fmt.Errorf("UNSUPPORTED-TRANSCRIBE-FORMAT")
+)
+
+// Ogg/Opus のファイルから読み込んだ音声
+type OggOpus struct {
+	// OpusHead のチャネル数
+	ChannelCount int
+	Packets      [][]byte
+}
+
+// Ogg/Opus のファイルを読み込む
+func ReadOggOpus(r io.Reader) (*OggOpus, error) {
+	reader := newOggReader(r)
+
+	packets := [][]byte{}
+	for {
+		packet, err := reader.ReadPacket()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		// 音声を受信していないセッションの Ogg ファイルは最後のページが空になる
+		if len(packet) == 0 {
+			continue
+		}
+		packets = append(packets, packet)
+	}
+
+	if reader.channelCount == 0 {
+		return nil, fmt.Errorf("%w: OpusHead not found", ErrInvalidOggPage)
+	}
+
+	return &OggOpus{
+		ChannelCount: reader.channelCount,
+		Packets:      packets,
+	}, nil
+}
+
+// suzu transcribe の指定
+type TranscribeOptions struct {
+	// sora-channel-id などのヘッダ
+	Header http.Header
+	// 音声の長さに対する送信の速度、0 の場合は待たずに送信する
+	Speed float64
+	// 出力の形式（jsonl, vtt）
+	Format string
+}
+
+// suzu transcribe の jsonl の出力
+type TranscribeResult struct {
+	// 前の最終結果を受信した時点の音声の位置（ミリ秒）
+	StartMs int64 `json:"start_ms"`
+	// 結果を受信した時点の音声の位置（ミリ秒）
+	EndMs   int64 `json:"end_ms"`
+	IsFinal bool  `json:"is_final"`
+	// サーバが送信した結果
+	Result json.RawMessage `json:"result"`
+}
+
+// 結果の種類と最終結果かどうかを判定するための値
+type transcribeResponse struct {
+	Type      string `json:"type"`
+	Message   string `json:"message"`
+	Reason    string `json:"reason"`
+	Code      string `json:"code"`
+	IsPartial *bool  `json:"is_partial"`
+	IsFinal   *bool  `json:"is_final"`
+}
+
+// aws は is_partial、その他のサービスは is_final で判定する
+// どちらもない場合は途中結果を返さないサービスのため、最終結果として扱う
+func (r transcribeResponse) isFinal() bool {
+	if r.IsPartial != nil {
+		return !*r.IsPartial
+	}
+	if r.IsFinal != nil {
+		return *r.IsFinal
+	}
+	return true
+}
+
+func (r transcribeResponse) isTranscript() bool {
+	switch r.Type {
+	case "error", "status", "heartbeat":
+		return false
+	}
+	return true
+}
+
+// Ogg/Opus の音声を /speech と同じ処理で文字起こしして、結果を w に書き込む
+// サーバを起動せずに、/speech のハンドラに音声のパケットを 1 つずつ送信する
+// 結果の位置は結果を受信した時点で送信済みの音声の長さのため、Speed が 1 以外の場合は実際の発話の位置とずれる
+func (s *Server) Transcribe(ctx context.Context, packets [][]byte, options TranscribeOptions, w io.Writer) error {
+	writer, err := newTranscribeWriter(options.Format, w)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	body, bodyWriter := io.Pipe()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/speech", body)
+	if err != nil {
+		return err
+	}
+	// /speech は HTTP/2 のみ受け付ける
+	req.Proto = "HTTP/2.0"
+	req.ProtoMajor = 2
+	req.ProtoMinor = 0
+	req.RemoteAddr = "127.0.0.1:0"
+	for name, values := range options.Header {
+		req.Header[name] = values
+	}
+
+	// 送信済みの音声の長さ（48kHz のサンプル数）
+	var sentSamples atomic.Uint64
+	go func() {
+		bodyWriter.CloseWithError(writeTranscribePackets(ctx, bodyWriter, packets, options.Speed, s.config.AudioStreamingHeader, &sentSamples))
+	}()
+
+	responseReader, responseWriter := io.Pipe()
+	// 途中で終了した場合にハンドラの書き込みを終了させる
+	defer responseReader.Close()
+	rw := &transcribeResponseWriter{header: http.Header{}, w: responseWriter}
+	go func() {
+		defer responseWriter.Close()
+		s.echo.ServeHTTP(rw, req)
+	}()
+
+	var lastErr error
+	var lastFinalMs int64
+	// 結果の長さに上限を設けないため、bufio.Scanner ではなく json.Decoder で 1 つずつ読み込む
+	decoder := json.NewDecoder(responseReader)
+	for {
+		var line json.RawMessage
+		if err := decoder.Decode(&line); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+
+		var response transcribeResponse
+		if err := json.Unmarshal(line, &response); err != nil {
+			return err
+		}
+
+		if response.Type == "error" {
+			lastErr = fmt.Errorf("%s: %s", response.Code, response.Reason)
+		}
+
+		endMs := int64(sentSamples.Load() * 1000 / opusGranuleRate)
+		result := TranscribeResult{
+			StartMs: lastFinalMs,
+			EndMs:   endMs,
+			IsFinal: response.isFinal(),
+			Result:  line,
+		}
+		if err := writer.Write(result, response); err != nil {
+			return err
+		}
+
+		if response.isTranscript() && result.IsFinal {
+			lastFinalMs = endMs
+		}
+	}
+	if rw.status != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d: %s", rw.status, strings.TrimSpace(rw.body.String()))
+	}
+	return lastErr
+}
+
+// パケットを音声の長さの間隔で 1 つずつ書き込む
+// audio_streaming_header が有効な場合は、クライアントと同じようにヘッダを付与する
+func writeTranscribePackets(ctx context.Context, w io.Writer, packets [][]byte, speed float64, audioStreamingHeader bool, sentSamples *atomic.Uint64) error {
+	startedAt := time.Now()
+	var samples uint64
+	for i, packet := range packets {
+		if speed > 0 {
+			elapsed := time.Duration(float64(time.Duration(samples)*time.Second/opusGranuleRate) / speed)
+			timer := time.NewTimer(time.Until(startedAt.Add(elapsed)))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		payload := packet
+		if audioStreamingHeader {
+			timestampMs := startedAt.UnixMilli() + int64(samples*1000/opusGranuleRate)
+			payload = packetWithHeader(timestampMs, uint64(i), packet)
+		}
+		if _, err := w.Write(payload); err != nil {
+			return err
+		}
+		samples += opusPacketSamples(packet)
+		sentSamples.Store(samples)
+	}
+	return nil
+}
+
+// /speech のハンドラのレスポンスを受け取る http.ResponseWriter
+// 200 の場合は結果を順番に読み込むため w に書き込み、200 以外の場合はエラーのメッセージとして body に書き込む
+type transcribeResponseWriter struct {
+	header http.Header
+	w      io.Writer
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *transcribeResponseWriter) Header() http.Header {
+	return rw.header
+}
+
+func (rw *transcribeResponseWriter) WriteHeader(statusCode int) {
+	if rw.status == 0 {
+		rw.status = statusCode
+	}
+}
+
+func (rw *transcribeResponseWriter) Write(p []byte) (int, error) {
+	rw.WriteHeader(http.StatusOK)
+	if rw.status != http.StatusOK {
+		return rw.body.Write(p)
+	}
+	return rw.w.Write(p)
+}
+
+func (rw *transcribeResponseWriter) Flush() {}
+
+// suzu transcribe の結果を書き込む
+type transcribeWriter interface {
+	Write(result TranscribeResult, response transcribeResponse) error
+}
+
+func newTranscribeWriter(format string, w io.Writer) (transcribeWriter, error) {
+	switch format {
+	case "", TranscribeFormatJSONL:
+		return &transcribeJSONLWriter{encoder: json.NewEncoder(w)}, nil
+	case TranscribeFormatWebVTT:
+		if _, err := io.WriteString(w, "WEBVTT\n\n"); err != nil {
+			return nil, err
+		}
+		return &transcribeWebVTTWriter{w: w}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedTranscribeFormat, format)
+}
+
+// サーバが送信したすべての結果を書き込む
+type transcribeJSONLWriter struct {
+	encoder *json.Encoder
+}
+
+func (t *transcribeJSONLWriter) Write(result TranscribeResult, response transcribeResponse) error {
+	return t.encoder.Encode(result)
+}
+
+// 最終結果のみを WebVTT のキューとして書き込む
+// https://www.w3.org/TR/webvtt1/
+type transcribeWebVTTWriter struct {
+	w    io.Writer
+	cues int
+}
+
+func (t *transcribeWebVTTWriter) Write(result TranscribeResult, response transcribeResponse) error {
+	if !response.isTranscript() || !result.IsFinal || response.Message == "" {
+		return nil
+	}
+
+	// キューの終了時刻は開始時刻より後にする必要があるため、同じパケットの送信中に受信した場合は 1ms ずらす
+	endMs := max(result.EndMs, result.StartMs+1)
+
+	t.cues++
+	_, err := fmt.Fprintf(t.w, "%d\n%s --> %s\n%s\n\n",
+		t.cues,
+		formatWebVTTTimestamp(result.StartMs),
+		formatWebVTTTimestamp(endMs),
+		webVTTCueText(response.Message),
+	)
+	return err
+}
+
+// hh:mm:ss.ttt
+func formatWebVTTTimestamp(ms int64) string {
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// キューのテキストには空行と --> を含められないため、空行を取り除いてエスケープする
+func webVTTCueText(message string) string {
+	lines := []string{}
+	for _, line := range strings.Split(message, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	replacer := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	return replacer.Replace(strings.Join(lines, "\n"))
+}
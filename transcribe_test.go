@@ -0,0 +1,210 @@
+package suzu
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadOggOpus(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		var buf bytes.Buffer
+		r, err := newOggRecorderWith("", nopWriteCloser{&buf}, 48000, 2)
+		require.NoError(t, err)
+		for range 3 {
+			require.NoError(t, r.Write(silentPacket()))
+		}
+		require.NoError(t, r.Close())
+
+		ogg, err := ReadOggOpus(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, 2, ogg.ChannelCount)
+		assert.Equal(t, [][]byte{silentPacket(), silentPacket(), silentPacket()}, ogg.Packets)
+	})
+
+	t.Run("no audio", func(t *testing.T) {
+		var buf bytes.Buffer
+		r, err := newOggRecorderWith("", nopWriteCloser{&buf}, 48000, 1)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+
+		// 最後の空のページは返さない
+		ogg, err := ReadOggOpus(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, 1, ogg.ChannelCount)
+		assert.Empty(t, ogg.Packets)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := ReadOggOpus(strings.NewReader("OggS"))
+		assert.Error(t, err)
+
+		_, err = ReadOggOpus(strings.NewReader(""))
+		assert.ErrorIs(t, err, ErrInvalidOggPage)
+	})
+}
+
+func TestTranscribe(t *testing.T) {
+	dumps := readPacketDumpForTest(t, "testdata/dump.jsonl")
+	packets := [][]byte{}
+	for _, dump := range dumps {
+		packets = append(packets, dump.Payload)
+	}
+
+	newTestServer := func(t *testing.T, audioStreamingHeader bool) *Server {
+		t.Helper()
+
+		config := Config{
+			ListenAddr:                "127.0.0.1",
+			TimeToWaitForOpusPacketMs: 500,
+			DisableSilentPacket:       true,
+			SampleRate:                48000,
+			ChannelCount:              2,
+			AudioStreamingHeader:      audioStreamingHeader,
+		}
+		s, err := NewServer(&config, "test")
+		require.NoError(t, err)
+		return s
+	}
+
+	header := http.Header{}
+	header.Set("sora-channel-id", "sora")
+	header.Set("sora-connection-id", "JG6CSF8P6D3PS61FW1S4KGK8FM")
+	header.Set("sora-audio-streaming-language-code", "ja-JP")
+
+	t.Run("jsonl", func(t *testing.T) {
+		var out bytes.Buffer
+		startedAt := time.Now()
+		err := newTestServer(t, false).Transcribe(t.Context(), packets, TranscribeOptions{
+			Header: header,
+			Speed:  1,
+			Format: TranscribeFormatJSONL,
+		}, &out)
+		require.NoError(t, err)
+
+		// 音声の長さの間隔で送信する（testdata/dump.jsonl は 20ms のパケットが 9 個）
+		assert.GreaterOrEqual(t, time.Since(startedAt), 160*time.Millisecond)
+
+		decoder := json.NewDecoder(&out)
+		var lastEndMs int64
+		for range dumps {
+			var result TranscribeResult
+			require.NoError(t, decoder.Decode(&result))
+			assert.True(t, result.IsFinal)
+			// 前の最終結果の位置から、受信した時点の位置まで
+			assert.Equal(t, lastEndMs, result.StartMs)
+			assert.GreaterOrEqual(t, result.EndMs, result.StartMs)
+			assert.LessOrEqual(t, result.EndMs, int64(180))
+			lastEndMs = result.EndMs
+
+			var testResult TestResult
+			require.NoError(t, json.Unmarshal(result.Result, &testResult))
+			assert.Equal(t, "test", testResult.Type)
+			assert.Equal(t, "n: 3", testResult.Message)
+		}
+		assert.False(t, decoder.More())
+	})
+
+	t.Run("vtt", func(t *testing.T) {
+		var out bytes.Buffer
+		err := newTestServer(t, false).Transcribe(t.Context(), packets, TranscribeOptions{
+			Header: header,
+			Format: TranscribeFormatWebVTT,
+		}, &out)
+		require.NoError(t, err)
+
+		vtt := out.String()
+		assert.True(t, strings.HasPrefix(vtt, "WEBVTT\n\n1\n00:00:00.000 --> "))
+		assert.Equal(t, len(dumps), strings.Count(vtt, "\nn: 3\n"))
+	})
+
+	t.Run("audio streaming header", func(t *testing.T) {
+		var out bytes.Buffer
+		err := newTestServer(t, true).Transcribe(t.Context(), packets, TranscribeOptions{
+			Header: header,
+			Speed:  0,
+		}, &out)
+		require.NoError(t, err)
+
+		// ヘッダを取り除いた Opus のパケットのみをサービスに送信する
+		var result TranscribeResult
+		require.NoError(t, json.NewDecoder(&out).Decode(&result))
+		assert.Contains(t, string(result.Result), `"message":"n: 3"`)
+	})
+
+	t.Run("large result and status events", func(t *testing.T) {
+		// bufio.Scanner の既定の上限（64 KiB）を超える結果
+		message := strings.Repeat("あ", 30000)
+
+		config := Config{
+			ListenAddr:                "127.0.0.1",
+			TimeToWaitForOpusPacketMs: 500,
+			DisableSilentPacket:       true,
+			EnableStatusEvent:         true,
+			HeartbeatIntervalMs:       10,
+		}
+		serviceHandlers := NewServiceHandlers()
+		serviceHandlers.Register("primary", newStatusTestHandlerFactory(0, message))
+		s, err := NewServerBuilder(&config, "primary").
+			WithServiceHandlers(serviceHandlers).
+			WithLanguageCodeFunc("primary", func(lang string) (string, error) { return lang, nil }).
+			Build()
+		require.NoError(t, err)
+
+		var out bytes.Buffer
+		err = s.Transcribe(t.Context(), packets, TranscribeOptions{
+			Header: header,
+			Format: TranscribeFormatJSONL,
+		}, &out)
+		require.NoError(t, err)
+
+		types := map[string]int{}
+		decoder := json.NewDecoder(&out)
+		for decoder.More() {
+			var result TranscribeResult
+			require.NoError(t, decoder.Decode(&result))
+
+			var testResult TestResult
+			require.NoError(t, json.Unmarshal(result.Result, &testResult))
+			types[testResult.Type]++
+			if testResult.Type == "test" {
+				assert.Equal(t, message, testResult.Message)
+			}
+		}
+		assert.Equal(t, 1, types["test"])
+		assert.Equal(t, 2, types["status"])
+	})
+
+	t.Run("unsupported format", func(t *testing.T) {
+		err := newTestServer(t, false).Transcribe(t.Context(), packets, TranscribeOptions{
+			Header: header,
+			Format: "srt",
+		}, &bytes.Buffer{})
+		assert.ErrorIs(t, err, ErrUnsupportedTranscribeFormat)
+	})
+
+	t.Run("missing language code", func(t *testing.T) {
+		h := header.Clone()
+		h.Del("sora-audio-streaming-language-code")
+
+		var out bytes.Buffer
+		err := newTestServer(t, false).Transcribe(t.Context(), packets, TranscribeOptions{
+			Header: h,
+		}, &out)
+		assert.ErrorContains(t, err, "unexpected status code: 500")
+		assert.Empty(t, out.String())
+	})
+}
+
+func TestWebVTT(t *testing.T) {
+	assert.Equal(t, "00:00:00.000", formatWebVTTTimestamp(0))
+	assert.Equal(t, "01:02:03.045", formatWebVTTTimestamp(3723045))
+
+	assert.Equal(t, "a\nb --&gt; c &lt;d&gt; &amp;", webVTTCueText("a\n\n b --> c <d> &"))
+}
@@ -0,0 +1,335 @@
+package suzu
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+)
+
+var (
+	ErrUsageQuotaExceeded = fmt.Errorf("USAGE-QUOTA-EXCEEDED")
+)
+
+const (
+	// Opus の granule position は常に 48kHz のサンプル数
+	opusGranuleRate = 48000
+
+	usagePeriodDaily   = "daily"
+	usagePeriodMonthly = "monthly"
+)
+
+// usage_ledger_file に書き込む利用量の記録
+// セッションごと、サービスごとに 1 行書き込む
+type UsageRecord struct {
+	ChannelID    string    `json:"channel_id"`
+	SessionID    string    `json:"session_id"`
+	ConnectionID string    `json:"connection_id"`
+	Provider     string    `json:"provider"`
+	LanguageCode string    `json:"language_code"`
+	AudioSeconds float64   `json:"audio_seconds"`
+	StartedAt    time.Time `json:"started_at"`
+	EndedAt      time.Time `json:"ended_at"`
+}
+
+// チャネルごとの当日と当月の利用量（秒）
+type channelUsage struct {
+	day     string
+	daily   float64
+	month   string
+	monthly float64
+}
+
+// 利用量の記録とチャネルごとの利用量の上限を管理する
+type usageLedger struct {
+	// 0 の場合は制限しない
+	dailyQuota   float64
+	monthlyQuota float64
+
+	now func() time.Time
+
+	mu   sync.Mutex
+	file *os.File
+	// 上限を指定した場合のみ集計する
+	channels map[string]*channelUsage
+}
+
+func newUsageLedger(c Config) (*usageLedger, error) {
+	l := &usageLedger{
+		dailyQuota:   float64(c.ChannelDailyQuotaSec),
+		monthlyQuota: float64(c.ChannelMonthlyQuotaSec),
+		now:          time.Now,
+		channels:     make(map[string]*channelUsage),
+	}
+
+	if c.UsageLedgerFile == "" {
+		return l, nil
+	}
+
+	f, err := os.OpenFile(c.UsageLedgerFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	// 再起動した場合も上限を引き継ぐため、記録済みの利用量を集計する
+	if l.hasQuota() {
+		if err := l.load(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	l.file = f
+	return l, nil
+}
+
+func (l *usageLedger) hasQuota() bool {
+	return l.dailyQuota > 0 || l.monthlyQuota > 0
+}
+
+// 記録済みの利用量を当日と当月の利用量に加算する
+// 記録の終了時刻の日付で集計する
+func (l *usageLedger) load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		var record UsageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// 書き込み中に停止した場合は最後の行が壊れている可能性があるため、読み飛ばす
+			zlog.Warn().
+				Err(err).
+				Int("line", line).
+				Msg("INVALID-USAGE-RECORD")
+			continue
+		}
+
+		l.addAt(record.ChannelID, record.AudioSeconds, record.EndedAt.In(l.now().Location()))
+	}
+	return scanner.Err()
+}
+
+// 上限に達している場合は ErrUsageQuotaExceeded を返す
+func (l *usageLedger) CheckQuota(channelID string) error {
+	if !l.hasQuota() || channelID == "" {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.check(l.current(channelID, l.now()))
+}
+
+func (l *usageLedger) check(u *channelUsage) error {
+	if l.dailyQuota > 0 && u.daily >= l.dailyQuota {
+		return fmt.Errorf("%w: %s", ErrUsageQuotaExceeded, usagePeriodDaily)
+	}
+	if l.monthlyQuota > 0 && u.monthly >= l.monthlyQuota {
+		return fmt.Errorf("%w: %s", ErrUsageQuotaExceeded, usagePeriodMonthly)
+	}
+	return nil
+}
+
+// 利用量を加算し、上限に達した場合は ErrUsageQuotaExceeded を返す
+func (l *usageLedger) Add(channelID string, seconds float64) error {
+	if !l.hasQuota() || channelID == "" {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.check(l.addAt(channelID, seconds, l.now()))
+}
+
+// mu を取得した状態で呼び出す
+func (l *usageLedger) addAt(channelID string, seconds float64, t time.Time) *channelUsage {
+	u := l.current(channelID, l.now())
+
+	if t.Format(time.DateOnly) == u.day {
+		u.daily += seconds
+	}
+	if t.Format("2006-01") == u.month {
+		u.monthly += seconds
+	}
+	return u
+}
+
+// 日付や月が変わった場合はリセットした利用量を返す
+// mu を取得した状態で呼び出す
+func (l *usageLedger) current(channelID string, now time.Time) *channelUsage {
+	day := now.Format(time.DateOnly)
+	month := now.Format("2006-01")
+
+	u, ok := l.channels[channelID]
+	if !ok {
+		u = &channelUsage{day: day, month: month}
+		l.channels[channelID] = u
+	}
+
+	if u.day != day {
+		u.day = day
+		u.daily = 0
+	}
+	if u.month != month {
+		u.month = month
+		u.monthly = 0
+	}
+	return u
+}
+
+// usage_ledger_file に記録を書き込む
+func (l *usageLedger) Write(records ...UsageRecord) error {
+	if l.file == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	encoder := json.NewEncoder(l.file)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// セッション単位の利用量
+// サービスに送信した音声のサンプル数をサービスごとに集計する
+type sessionUsage struct {
+	ledger       *usageLedger
+	channelID    string
+	sessionID    string
+	connectionID string
+	languageCode string
+	startedAt    time.Time
+
+	mu       sync.Mutex
+	provider string
+	// サービスごとのサンプル数（48kHz）
+	samples   map[string]uint64
+	providers []string
+
+	// 上限に達した場合に閉じる
+	exceeded     chan struct{}
+	exceededOnce sync.Once
+	exceededErr  error
+}
+
+func newSessionUsage(ledger *usageLedger, header SoraHeader, provider, languageCode string) *sessionUsage {
+	return &sessionUsage{
+		ledger:       ledger,
+		channelID:    header.SoraChannelID,
+		sessionID:    header.SoraSessionID,
+		connectionID: header.SoraConnectionID,
+		languageCode: languageCode,
+		startedAt:    ledger.now(),
+		provider:     provider,
+		samples:      make(map[string]uint64),
+		exceeded:     make(chan struct{}),
+	}
+}
+
+// サービスに送信した音声のサンプル数（48kHz）を加算する
+// nil の場合は何もしない
+func (u *sessionUsage) AddSamples(samples uint64) {
+	if u == nil || samples == 0 {
+		return
+	}
+
+	u.mu.Lock()
+	if _, ok := u.samples[u.provider]; !ok {
+		u.providers = append(u.providers, u.provider)
+	}
+	u.samples[u.provider] += samples
+	u.mu.Unlock()
+
+	if err := u.ledger.Add(u.channelID, float64(samples)/opusGranuleRate); err != nil {
+		u.exceededOnce.Do(func() {
+			u.exceededErr = err
+			close(u.exceeded)
+		})
+	}
+}
+
+// Ogg に変換せずにサービスに送信した Opus パケットのサンプル数を加算する
+func (u *sessionUsage) AddOpusPacket(payload []byte) {
+	if u == nil {
+		return
+	}
+	u.AddSamples(opusPacketSamples(payload))
+}
+
+// サービスに送信した LPCM の音声のサンプル数を、Opus のサンプリングレートに換算して加算する
+func (u *sessionUsage) AddPCM(frame *PCMFrame) {
+	if u == nil {
+		return
+	}
+	frames := len(frame.Samples) / frame.ChannelCount
+	u.AddSamples(uint64(frames) * opusGranuleRate / uint64(frame.SampleRate))
+}
+
+// チャネルの利用量が上限に達した場合に閉じる channel を返す
+func (u *sessionUsage) Exceeded() <-chan struct{} {
+	return u.exceeded
+}
+
+// 上限に達した場合は ErrUsageQuotaExceeded を返す
+func (u *sessionUsage) Err() error {
+	select {
+	case <-u.exceeded:
+		return u.exceededErr
+	default:
+		return nil
+	}
+}
+
+// サービスごとの利用量を返す
+func (u *sessionUsage) Records() []UsageRecord {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	endedAt := u.ledger.now()
+	records := make([]UsageRecord, 0, len(u.providers))
+	for _, provider := range u.providers {
+		records = append(records, UsageRecord{
+			ChannelID:    u.channelID,
+			SessionID:    u.sessionID,
+			ConnectionID: u.connectionID,
+			Provider:     provider,
+			LanguageCode: u.languageCode,
+			AudioSeconds: float64(u.samples[provider]) / opusGranuleRate,
+			StartedAt:    u.startedAt,
+			EndedAt:      endedAt,
+		})
+	}
+	return records
+}
+
+// セッションの終了時に利用量を usage_ledger_file に書き込む
+func (u *sessionUsage) Close() error {
+	return u.ledger.Write(u.Records()...)
+}
+
+type sessionUsageKey struct{}
+
+// サービスのハンドラで利用量を記録するため、context に sessionUsage を格納する
+func withSessionUsage(ctx context.Context, u *sessionUsage) context.Context {
+	return context.WithValue(ctx, sessionUsageKey{}, u)
+}
+
+// context に格納した sessionUsage を返す、格納していない場合は nil を返す
+func sessionUsageFromContext(ctx context.Context) *sessionUsage {
+	u, _ := ctx.Value(sessionUsageKey{}).(*sessionUsage)
+	return u
+}
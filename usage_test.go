@@ -0,0 +1,240 @@
+package suzu
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp/codecs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpusPacketSamples(t *testing.T) {
+	testCases := []struct {
+		Name    string
+		Payload []byte
+		Samples uint64
+	}{
+		{Name: "empty", Payload: []byte{}, Samples: 0},
+		// config 31 (CELT 20ms), code 0
+		{Name: "celt 20ms", Payload: []byte{31 << 3}, Samples: 960},
+		// config 3 (SILK 60ms), code 1
+		{Name: "silk 60ms 2 frames", Payload: []byte{3<<3 | 1}, Samples: 5760},
+		// config 16 (CELT 2.5ms), code 3, 4 frames
+		{Name: "celt 2.5ms 4 frames", Payload: []byte{16<<3 | 3, 4}, Samples: 480},
+		{Name: "code 3 without frame count", Payload: []byte{31<<3 | 3}, Samples: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.Equal(t, tc.Samples, opusPacketSamples(tc.Payload))
+		})
+	}
+
+	t.Run("ogg writer", func(t *testing.T) {
+		o, err := NewWithoutHeader(&bytes.Buffer{}, 48000, 1)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(0), o.Samples())
+
+		require.NoError(t, o.Write(&codecs.OpusPacket{Payload: []byte{31 << 3, 0}}))
+		require.NoError(t, o.Write(&codecs.OpusPacket{Payload: []byte{3<<3 | 1, 0}}))
+		assert.Equal(t, uint64(960+5760), o.Samples())
+	})
+}
+
+func TestUsageLedger(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	newLedger := func(t *testing.T, c Config) *usageLedger {
+		t.Helper()
+
+		l, err := newUsageLedger(c)
+		require.NoError(t, err)
+		l.now = func() time.Time { return now }
+		return l
+	}
+
+	t.Run("daily", func(t *testing.T) {
+		now = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+		l := newLedger(t, Config{ChannelDailyQuotaSec: 10})
+
+		assert.NoError(t, l.Add("ch1", 6))
+		assert.ErrorIs(t, l.Add("ch1", 4), ErrUsageQuotaExceeded)
+		assert.ErrorIs(t, l.CheckQuota("ch1"), ErrUsageQuotaExceeded)
+
+		// 他のチャネルは制限しない
+		assert.NoError(t, l.CheckQuota("ch2"))
+		// sora-channel-id ヘッダが無い場合は制限しない
+		assert.NoError(t, l.Add("", 100))
+
+		// 日付が変わった場合はリセットする
+		now = now.Add(24 * time.Hour)
+		assert.NoError(t, l.CheckQuota("ch1"))
+	})
+
+	t.Run("monthly", func(t *testing.T) {
+		now = time.Date(2026, 10, 30, 12, 0, 0, 0, time.UTC)
+		l := newLedger(t, Config{ChannelDailyQuotaSec: 10, ChannelMonthlyQuotaSec: 15})
+
+		assert.NoError(t, l.Add("ch1", 9))
+
+		now = time.Date(2026, 10, 31, 12, 0, 0, 0, time.UTC)
+		assert.NoError(t, l.Add("ch1", 5))
+		err := l.Add("ch1", 1)
+		assert.ErrorIs(t, err, ErrUsageQuotaExceeded)
+		assert.ErrorContains(t, err, usagePeriodMonthly)
+
+		// 月が変わった場合はリセットする
+		now = time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+		assert.NoError(t, l.CheckQuota("ch1"))
+	})
+
+	t.Run("without quota", func(t *testing.T) {
+		l := newLedger(t, Config{})
+
+		assert.NoError(t, l.Add("ch1", 1000))
+		assert.NoError(t, l.CheckQuota("ch1"))
+		assert.Empty(t, l.channels)
+	})
+
+	t.Run("load", func(t *testing.T) {
+		now = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+		l := newLedger(t, Config{ChannelDailyQuotaSec: 10, ChannelMonthlyQuotaSec: 100})
+
+		records := []UsageRecord{
+			{ChannelID: "ch1", Provider: "aws", AudioSeconds: 4, EndedAt: now.Add(-time.Hour)},
+			// 前日の記録は当月の利用量のみに加算する
+			{ChannelID: "ch1", Provider: "gcp", AudioSeconds: 3, EndedAt: now.Add(-24 * time.Hour)},
+			// 前月の記録は加算しない
+			{ChannelID: "ch1", Provider: "aws", AudioSeconds: 100, EndedAt: now.AddDate(0, -1, 0)},
+		}
+		var buf bytes.Buffer
+		for _, r := range records {
+			require.NoError(t, json.NewEncoder(&buf).Encode(r))
+		}
+		// 書き込み中に停止した場合の壊れた行は読み飛ばす
+		buf.WriteString(`{"channel_id":"ch1",`)
+
+		require.NoError(t, l.load(&buf))
+		assert.Equal(t, 4.0, l.channels["ch1"].daily)
+		assert.Equal(t, 7.0, l.channels["ch1"].monthly)
+	})
+}
+
+func TestUsageWithSpeechHandler(t *testing.T) {
+	readRecords := func(t *testing.T, filename string) []UsageRecord {
+		t.Helper()
+
+		f, err := os.Open(filename)
+		require.NoError(t, err)
+		defer f.Close()
+
+		var records []UsageRecord
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var r UsageRecord
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+			records = append(records, r)
+		}
+		return records
+	}
+
+	serve := func(t *testing.T, s *Server, channelID string) []map[string]any {
+		t.Helper()
+
+		r := readDumpFile(t, "testdata/dump.jsonl", 0)
+		defer r.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/speech", r)
+		req.Header.Set("sora-audio-streaming-language-code", "ja-JP")
+		req.Header.Set("sora-channel-id", channelID)
+		req.Header.Set("sora-session-id", "usage-session")
+		req.Header.Set("sora-connection-id", "usage-connection")
+		req.Proto = "HTTP/2.0"
+		req.ProtoMajor = 2
+		req.ProtoMinor = 0
+
+		rec := httptest.NewRecorder()
+		s.echo.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var messages []map[string]any
+		decoder := json.NewDecoder(bytes.NewReader(rec.Body.Bytes()))
+		for decoder.More() {
+			var m map[string]any
+			require.NoError(t, decoder.Decode(&m))
+			messages = append(messages, m)
+		}
+		return messages
+	}
+
+	t.Run("record", func(t *testing.T) {
+		filename := filepath.Join(t.TempDir(), "usage.jsonl")
+		config := Config{
+			ListenAddr:                "127.0.0.1",
+			TimeToWaitForOpusPacketMs: 500,
+			UsageLedgerFile:           filename,
+		}
+		s, err := NewServer(&config, "test")
+		require.NoError(t, err)
+
+		serve(t, s, "usage-ch")
+
+		records := readRecords(t, filename)
+		require.Len(t, records, 1)
+		assert.Equal(t, "usage-ch", records[0].ChannelID)
+		assert.Equal(t, "usage-session", records[0].SessionID)
+		assert.Equal(t, "usage-connection", records[0].ConnectionID)
+		assert.Equal(t, "test", records[0].Provider)
+		assert.Equal(t, "ja-JP", records[0].LanguageCode)
+		// testdata/dump.jsonl は 20ms のパケットが 9 個
+		assert.InDelta(t, 0.18, records[0].AudioSeconds, 0.0001)
+		assert.False(t, records[0].EndedAt.Before(records[0].StartedAt))
+	})
+
+	t.Run("quota exceeded", func(t *testing.T) {
+		filename := filepath.Join(t.TempDir(), "usage.jsonl")
+
+		// 上限の直前まで利用した状態にする
+		b, err := json.Marshal(UsageRecord{ChannelID: "usage-ch", Provider: "test", AudioSeconds: 0.95, EndedAt: time.Now()})
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filename, append(b, '\n'), 0644))
+
+		config := Config{
+			ListenAddr:                "127.0.0.1",
+			TimeToWaitForOpusPacketMs: 500,
+			UsageLedgerFile:           filename,
+			ChannelDailyQuotaSec:      1,
+		}
+		s, err := NewServer(&config, "test")
+		require.NoError(t, err)
+
+		messages := serve(t, s, "usage-ch")
+		require.NotEmpty(t, messages)
+		last := messages[len(messages)-1]
+		assert.Equal(t, "error", last["type"])
+		assert.Equal(t, string(ErrorCodeUsageQuotaExceeded), last["code"])
+		assert.Equal(t, false, last["retryable"])
+
+		// 上限に達した後はサービスに接続しない
+		messages = serve(t, s, "usage-ch")
+		require.Len(t, messages, 1)
+		assert.Equal(t, string(ErrorCodeUsageQuotaExceeded), messages[0]["code"])
+
+		// 他のチャネルは制限しない
+		messages = serve(t, s, "usage-other")
+		for _, m := range messages {
+			assert.NotEqual(t, "error", m["type"])
+		}
+
+		records := readRecords(t, filename)
+		assert.Len(t, records, 3)
+	})
+}
@@ -0,0 +1,202 @@
+package suzu
+
+import (
+	"context"
+	"math"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+)
+
+const (
+	// 音声の大きさの判定に使用するデコード後のサンプリングレート
+	vadSampleRate = 16000
+
+	// DTX の場合に送信されるパケットの最大長
+	// https://datatracker.ietf.org/doc/html/rfc6716#section-3.2.1
+	opusDTXPacketMaxLength = 2
+)
+
+// 無音が続く間は音声をサービスに送信しない
+// 送信を停止している間は無音パケットの送信によってサービスとの接続を維持する
+type voiceActivityDetector struct {
+	// この値（dBFS）未満の音声を無音と判定する
+	thresholdDB float64
+	// 無音がこの長さ続いた場合に送信を停止する
+	silenceDuration time.Duration
+	// 発話の開始時に、直前のこの長さの音声を送信する
+	preRollDuration time.Duration
+
+	processor *audioProcessor
+	// 発話かどうかを判定する関数、テストで差し替える
+	detect func(opus Opus) bool
+
+	// 送信を停止している状態
+	paused bool
+	// 最後に発話と判定してからの無音の長さ
+	silence time.Duration
+	// 送信を停止している間の直前の音声
+	preRoll       []Opus
+	preRollLength time.Duration
+
+	metrics *sessionMetrics
+}
+
+func newVoiceActivityDetector(c Config, metrics *sessionMetrics) (*voiceActivityDetector, error) {
+	processor, err := newAudioProcessor(vadSampleRate, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &voiceActivityDetector{
+		thresholdDB:     c.VADThresholdDB,
+		silenceDuration: time.Duration(c.VADSilenceDurationMs) * time.Millisecond,
+		preRollDuration: time.Duration(c.VADPreRollMs) * time.Millisecond,
+		processor:       processor,
+		metrics:         metrics,
+	}
+	v.detect = v.isSpeech
+	return v, nil
+}
+
+// 受信したパケットを判定し、サービスに送信するパケットを返す
+func (v *voiceActivityDetector) Process(opus Opus) []Opus {
+	d := opus.Duration()
+
+	speech := v.detect(opus)
+	if speech {
+		v.silence = 0
+	} else {
+		v.silence += d
+	}
+
+	if !v.paused {
+		if speech || v.silence < v.silenceDuration {
+			return []Opus{opus}
+		}
+		v.paused = true
+	}
+
+	// 発話を検出した場合は、直前の音声と合わせて送信を再開する
+	if speech {
+		packets := append(v.preRoll, opus)
+		v.paused = false
+		v.preRoll = nil
+		v.preRollLength = 0
+		return packets
+	}
+
+	v.preRoll = append(v.preRoll, opus)
+	v.preRollLength += d
+	// pre-roll を超えた古い音声は送信しない
+	for len(v.preRoll) > 0 && v.preRollLength > v.preRollDuration {
+		dropped := v.preRoll[0].Duration()
+		v.preRoll = v.preRoll[1:]
+		v.preRollLength -= dropped
+		v.suppress(dropped)
+	}
+	return nil
+}
+
+// セッションの終了時に、送信しなかった直前の音声をメトリクスに記録する
+func (v *voiceActivityDetector) Close() {
+	v.suppress(v.preRollLength)
+	v.preRoll = nil
+	v.preRollLength = 0
+}
+
+func (v *voiceActivityDetector) suppress(d time.Duration) {
+	if v.metrics != nil {
+		v.metrics.AddSuppressedAudio(d)
+	}
+}
+
+// DTX のパケット、または、デコードした音声の大きさが閾値未満の場合は無音と判定する
+// LPCM の音声はデコードせずに大きさを判定する
+func (v *voiceActivityDetector) isSpeech(opus Opus) bool {
+	if opus.PCM != nil {
+		return energyDB(opus.PCM.Samples) >= v.thresholdDB
+	}
+
+	payload := opus.Payload
+	if len(payload) <= opusDTXPacketMaxLength {
+		return false
+	}
+
+	samples, err := v.processor.Process(payload)
+	if err != nil {
+		// 判定できない場合は音声を失わないように発話として扱う
+		zlog.Debug().
+			Err(err).
+			Msg("VAD-DECODE-FAILED")
+		return true
+	}
+
+	return energyDB(samples) >= v.thresholdDB
+}
+
+// 音声の大きさ（RMS）を dBFS で返す、無音の場合は -Inf を返す
+func energyDB(samples []int16) float64 {
+	if len(samples) == 0 {
+		return math.Inf(-1)
+	}
+
+	var sum float64
+	for _, s := range samples {
+		f := float64(s)
+		sum += f * f
+	}
+	rms := math.Sqrt(sum / float64(len(samples)))
+	if rms == 0 {
+		return math.Inf(-1)
+	}
+	return 20 * math.Log10(rms/math.MaxInt16)
+}
+
+// 無音が続く間はパケットをサービスに送信しないオプション関数を返す
+// 送信しない間に無音パケットを挿入するため、無音パケットを挿入する前に適用する
+func optionVoiceActivityDetection(metrics *sessionMetrics) packetReaderOption {
+	return func(ctx context.Context, c Config, opusCh chan Opus) chan Opus {
+		v, err := newVoiceActivityDetector(c, metrics)
+		if err != nil {
+			// 判定できない場合はすべての音声を送信する
+			zlog.Warn().
+				Err(err).
+				Msg("VAD-DISABLED")
+			return opusCh
+		}
+
+		ch := make(chan Opus)
+
+		go func() {
+			defer close(ch)
+			defer v.Close()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case req, ok := <-opusCh:
+					if !ok {
+						return
+					}
+
+					packets := []Opus{req}
+					if req.Err == nil {
+						packets = v.Process(req)
+					}
+
+					for _, packet := range packets {
+						select {
+						case <-ctx.Done():
+							return
+						case ch <- packet:
+						}
+					}
+				}
+			}
+		}()
+
+		return ch
+	}
+}
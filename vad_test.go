@@ -0,0 +1,132 @@
+package suzu
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnergyDB(t *testing.T) {
+	assert.True(t, math.IsInf(energyDB(nil), -1))
+	assert.True(t, math.IsInf(energyDB(make([]int16, 160)), -1))
+
+	// 最大振幅の矩形波は 0 dBFS
+	full := make([]int16, 160)
+	for i := range full {
+		full[i] = math.MaxInt16
+		if i%2 == 1 {
+			full[i] = -math.MaxInt16
+		}
+	}
+	assert.InDelta(t, 0, energyDB(full), 0.001)
+
+	// 振幅が 1/10 の場合は -20 dBFS
+	quiet := make([]int16, 160)
+	for i := range quiet {
+		quiet[i] = full[i] / 10
+	}
+	assert.InDelta(t, -20, energyDB(quiet), 0.01)
+}
+
+func TestVoiceActivityDetector(t *testing.T) {
+	// config 31 (CELT 20ms) のパケット、2 バイト目で発話かどうかを表す
+	speech := Opus{Payload: []byte{31 << 3, 1, 0}}
+	silence := Opus{Payload: []byte{31 << 3, 0, 0}}
+
+	newDetector := func(t *testing.T, silenceMs, preRollMs int) *voiceActivityDetector {
+		t.Helper()
+
+		c := Config{VADThresholdDB: -50, VADSilenceDurationMs: silenceMs, VADPreRollMs: preRollMs}
+		v, err := newVoiceActivityDetector(c, newSessionMetrics("vad-test", "ja-JP"))
+		require.NoError(t, err)
+		v.detect = func(opus Opus) bool { return opus.Payload[1] == 1 }
+		return v
+	}
+
+	t.Run("pause and resume", func(t *testing.T) {
+		v := newDetector(t, 40, 20)
+		suppressed := vadSuppressedSeconds.WithLabelValues("vad-test", "ja-JP")
+		before := testutil.ToFloat64(suppressed)
+
+		assert.Equal(t, []Opus{speech}, v.Process(speech))
+		// vad_silence_duration_ms に達するまでは送信する
+		assert.Equal(t, []Opus{silence}, v.Process(silence))
+		// 無音が 40ms 続いたため送信を停止する
+		assert.Empty(t, v.Process(silence))
+		assert.Empty(t, v.Process(silence))
+		assert.Empty(t, v.Process(silence))
+
+		// 直前の 20ms の音声と合わせて送信を再開する
+		assert.Equal(t, []Opus{silence, speech}, v.Process(speech))
+		assert.Equal(t, []Opus{speech}, v.Process(speech))
+
+		// 送信しなかった 40ms を記録する
+		assert.InDelta(t, 0.04, testutil.ToFloat64(suppressed)-before, 0.0001)
+	})
+
+	t.Run("close", func(t *testing.T) {
+		v := newDetector(t, 20, 300)
+		suppressed := vadSuppressedSeconds.WithLabelValues("vad-test", "ja-JP")
+		before := testutil.ToFloat64(suppressed)
+
+		assert.Empty(t, v.Process(silence))
+		assert.Empty(t, v.Process(silence))
+
+		// 終了時に送信しなかった pre-roll の音声を記録する
+		v.Close()
+		assert.InDelta(t, 0.04, testutil.ToFloat64(suppressed)-before, 0.0001)
+	})
+
+	t.Run("dtx", func(t *testing.T) {
+		c := Config{VADThresholdDB: -50}
+		v, err := newVoiceActivityDetector(c, nil)
+		require.NoError(t, err)
+
+		assert.False(t, v.isSpeech(Opus{Payload: []byte{31 << 3}}))
+		assert.False(t, v.isSpeech(Opus{Payload: []byte{31 << 3, 0}}))
+	})
+
+	t.Run("lpcm", func(t *testing.T) {
+		c := Config{VADThresholdDB: -50}
+		v, err := newVoiceActivityDetector(c, nil)
+		require.NoError(t, err)
+
+		// LPCM の音声はデコードせずに判定する
+		samples := make([]int16, 320)
+		assert.False(t, v.isSpeech(Opus{PCM: &PCMFrame{Samples: samples, SampleRate: 16000, ChannelCount: 1}}))
+		for i := range samples {
+			samples[i] = 1000
+		}
+		assert.True(t, v.isSpeech(Opus{PCM: &PCMFrame{Samples: samples, SampleRate: 16000, ChannelCount: 1}}))
+	})
+}
+
+func TestVoiceActivityDetectionWithSpeechHandler(t *testing.T) {
+	config := Config{
+		ListenAddr:                "127.0.0.1",
+		TimeToWaitForOpusPacketMs: 500,
+		EnableVAD:                 true,
+		VADThresholdDB:            -50,
+		VADSilenceDurationMs:      40,
+		VADPreRollMs:              20,
+	}
+	s, err := NewServer(&config, "test")
+	require.NoError(t, err)
+
+	suppressed := vadSuppressedSeconds.WithLabelValues("test", "ja-JP")
+	before := testutil.ToFloat64(suppressed)
+
+	// testdata/dump.jsonl は 20ms の無音のパケットが 9 個
+	messages := serveSpeech[map[string]any](t, s, "/speech")
+
+	// 無音が 40ms に達する前の 1 個のパケットのみを送信する
+	assert.Len(t, messages, 1)
+	// 残りの 8 個は送信しない、pre-roll の音声はセッションの終了時に記録する
+	assert.Eventually(t, func() bool {
+		return math.Abs(testutil.ToFloat64(suppressed)-before-0.16) < 0.0001
+	}, time.Second, 10*time.Millisecond)
+}